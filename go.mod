@@ -3,8 +3,13 @@ module github.com/Manzo48/loadBalancer
 go 1.21
 
 require (
+	github.com/fsnotify/fsnotify v1.7.0
+	github.com/oschwald/maxminddb-golang v1.13.1
 	go.uber.org/zap v1.27.0
 	gopkg.in/yaml.v2 v2.4.0
 )
 
-require go.uber.org/multierr v1.10.0 // indirect
+require (
+	go.uber.org/multierr v1.10.0 // indirect
+	golang.org/x/sys v0.21.0 // indirect
+)
@@ -6,38 +6,86 @@ import (
     "log"
     "os"
     "os/signal"
+    "sync"
     "syscall"
 
     "github.com/Manzo48/loadBalancer/internal/config"
+    applog "github.com/Manzo48/loadBalancer/internal/log"
     "github.com/Manzo48/loadBalancer/internal/proxy"
-    "go.uber.org/zap"
 )
 
 func Run() {
     configPath := flag.String("config", "config.yaml", "path to configuration file")
     flag.Parse()
 
-    logger, err := zap.NewProduction()
+    // До загрузки конфигурации ошибки пишет логгер по умолчанию
+    sugar, err := applog.New(config.LogConfig{})
     if err != nil {
         log.Fatalf("failed to initialize logger: %v", err)
     }
-    defer logger.Sync()
-    sugar := logger.Sugar()
 
     cfg, err := config.Load(*configPath)
     if err != nil {
         sugar.Fatalf("failed to load config: %v", err)
     }
+    if sugar, err = applog.New(cfg.Log); err != nil {
+        log.Fatalf("failed to initialize logger: %v", err)
+    }
+    defer sugar.Sync()
 
     lb := proxy.NewProxyServer(cfg, sugar)
+    if cfg.FailFast && !lb.HasBackends() {
+        sugar.Fatal("fail_fast: no valid backends configured, exiting")
+    }
+
+    // Порт занимается синхронно: при ошибке привязки процесс сразу завершается с понятным сообщением
+    addr := cfg.Listen
+    if addr == "" {
+        addr = fmt.Sprintf(":%d", cfg.Port)
+    }
+    listener, err := lb.Listen(addr)
+    if err != nil {
+        sugar.Fatalf("failed to start proxy: %v", err)
+    }
 
     go func() {
-        addr := fmt.Sprintf(":%d", cfg.Port)
-        if err := lb.Start(addr); err != nil {
+        if err := lb.Serve(listener); err != nil {
             sugar.Fatalf("server failed: %v", err)
         }
     }()
 
+    // SIGHUP и изменение файла (config_watch) перечитывают конфигурацию;
+    // ошибка разбора оставляет прежнюю конфигурацию
+    var reloadMu sync.Mutex
+    reloadConfig := func(reason string) {
+        reloadMu.Lock()
+        defer reloadMu.Unlock()
+        sugar.Infof("%s, reloading config from %s", reason, *configPath)
+        newCfg, err := config.Load(*configPath)
+        if err != nil {
+            sugar.Errorf("failed to reload config, keeping the current one: %v", err)
+            return
+        }
+        lb.Reload(newCfg)
+    }
+
+    reload := make(chan os.Signal, 1)
+    signal.Notify(reload, syscall.SIGHUP)
+    go func() {
+        for range reload {
+            reloadConfig("received SIGHUP")
+        }
+    }()
+
+    if cfg.ConfigWatch.Enabled {
+        watcher, err := watchConfig(*configPath, cfg.ConfigWatch.Debounce, func() { reloadConfig("config file changed") }, sugar)
+        if err != nil {
+            sugar.Errorf("failed to watch config file, only SIGHUP reloads it: %v", err)
+        } else {
+            defer watcher.Close()
+        }
+    }
+
     quit := make(chan os.Signal, 1)
     signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
     <-quit
@@ -0,0 +1,80 @@
+package app
+
+import (
+    "path/filepath"
+    "time"
+
+    "github.com/fsnotify/fsnotify"
+    "go.uber.org/zap"
+)
+
+const defaultWatchDebounce = 500 * time.Millisecond
+
+// watchConfig следит за файлом конфигурации и вызывает reload после его изменения.
+// Следит за каталогом, а не за самим файлом: редакторы заменяют файл переименованием,
+// и наблюдение за старым inode потерялось бы. В Kubernetes файл ConfigMap — симлинк
+// через ..data, и обновление подменяет сам ..data, а не файл; поэтому на любое создание,
+// переименование или удаление в каталоге сверяется, куда теперь ведет путь
+// (filepath.EvalSymlinks). Серия событий (редакторы часто пишут файл дважды) сводится
+// к одному вызову через debounce после последнего события.
+func watchConfig(path string, debounce time.Duration, reload func(), logger *zap.SugaredLogger) (*fsnotify.Watcher, error) {
+    if debounce <= 0 {
+        debounce = defaultWatchDebounce
+    }
+    watcher, err := fsnotify.NewWatcher()
+    if err != nil {
+        return nil, err
+    }
+    if err := watcher.Add(filepath.Dir(path)); err != nil {
+        watcher.Close()
+        return nil, err
+    }
+
+    name := filepath.Clean(path)
+    target, _ := filepath.EvalSymlinks(path)
+    go func() {
+        var timer *time.Timer
+        for {
+            select {
+            case event, ok := <-watcher.Events:
+                if !ok {
+                    return
+                }
+                if !configChanged(event, name, path, &target) {
+                    continue
+                }
+                if timer == nil {
+                    timer = time.AfterFunc(debounce, reload)
+                } else {
+                    timer.Reset(debounce)
+                }
+            case err, ok := <-watcher.Errors:
+                if !ok {
+                    return
+                }
+                logger.Warnf("config watcher error: %v", err)
+            }
+        }
+    }()
+    return watcher, nil
+}
+
+// configChanged сообщает, затрагивает ли событие в каталоге файл конфигурации, и
+// запоминает в target, куда сейчас ведет путь.
+func configChanged(event fsnotify.Event, name, path string, target *string) bool {
+    // Замена файла переименованием приходит как Create под его именем
+    if filepath.Clean(event.Name) == name && event.Op&(fsnotify.Write|fsnotify.Create) != 0 {
+        *target, _ = filepath.EvalSymlinks(path)
+        return true
+    }
+    if event.Op&(fsnotify.Create|fsnotify.Rename|fsnotify.Remove) == 0 {
+        return false
+    }
+    // Подмена симлинка в цепочке пути (..data у ConfigMap)
+    resolved, err := filepath.EvalSymlinks(path)
+    if err != nil || resolved == *target {
+        return false
+    }
+    *target = resolved
+    return true
+}
@@ -0,0 +1,85 @@
+package app
+
+import (
+    "os"
+    "path/filepath"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "go.uber.org/zap"
+)
+
+// startWatch запускает watchConfig и возвращает счетчик вызовов reload.
+func startWatch(t *testing.T, path string, debounce time.Duration) *atomic.Int32 {
+    t.Helper()
+    var reloads atomic.Int32
+    watcher, err := watchConfig(path, debounce, func() { reloads.Add(1) }, zap.NewNop().Sugar())
+    if err != nil {
+        t.Fatalf("watchConfig: %v", err)
+    }
+    t.Cleanup(func() { watcher.Close() })
+    return &reloads
+}
+
+func TestWatchConfig_DebouncesWritesIntoOneReload(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "config.yaml")
+    if err := os.WriteFile(path, []byte("port: 8080\n"), 0o600); err != nil {
+        t.Fatal(err)
+    }
+    reloads := startWatch(t, path, 200*time.Millisecond)
+
+    // Другой файл в том же каталоге не вызывает reload
+    if err := os.WriteFile(filepath.Join(filepath.Dir(path), "other.yaml"), []byte("x"), 0o600); err != nil {
+        t.Fatal(err)
+    }
+    for _, content := range []string{"port: 8081\n", "port: 8082\n"} {
+        if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+            t.Fatal(err)
+        }
+        time.Sleep(50 * time.Millisecond)
+    }
+    time.Sleep(500 * time.Millisecond)
+    if got := reloads.Load(); got != 1 {
+        t.Errorf("Expected exactly one reload for two writes within debounce, got %d", got)
+    }
+}
+
+func TestWatchConfig_ReloadsOnConfigMapSymlinkSwap(t *testing.T) {
+    // Раскладка тома ConfigMap: config.yaml -> ..data/config.yaml, ..data -> каталог версии
+    dir := t.TempDir()
+    writeVersion := func(version, content string) {
+        if err := os.MkdirAll(filepath.Join(dir, version), 0o700); err != nil {
+            t.Fatal(err)
+        }
+        if err := os.WriteFile(filepath.Join(dir, version, "config.yaml"), []byte(content), 0o600); err != nil {
+            t.Fatal(err)
+        }
+    }
+    writeVersion("..v1", "port: 8080\n")
+    if err := os.Symlink("..v1", filepath.Join(dir, "..data")); err != nil {
+        t.Fatal(err)
+    }
+    path := filepath.Join(dir, "config.yaml")
+    if err := os.Symlink(filepath.Join("..data", "config.yaml"), path); err != nil {
+        t.Fatal(err)
+    }
+    reloads := startWatch(t, path, 50*time.Millisecond)
+
+    // Kubelet создает новый симлинк и атомарно переименовывает его поверх ..data
+    writeVersion("..v2", "port: 8081\n")
+    if err := os.Symlink("..v2", filepath.Join(dir, "..data_tmp")); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")); err != nil {
+        t.Fatal(err)
+    }
+
+    deadline := time.Now().Add(2 * time.Second)
+    for reloads.Load() == 0 {
+        if time.Now().After(deadline) {
+            t.Fatal("Expected a reload after the ..data symlink swap")
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+}
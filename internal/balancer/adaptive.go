@@ -0,0 +1,135 @@
+package balancer
+
+import (
+    "math"
+    "time"
+
+    "github.com/Manzo48/loadBalancer/internal/config"
+)
+
+const (
+    defaultAdaptiveInterval = 5 * time.Second
+    adaptiveWeightScale     = 100 // Базовый вес умножается на масштаб, чтобы снижение было плавным
+    latencyEWMAAlpha        = 0.2 // Вклад нового наблюдения в EWMA задержки
+)
+
+// BackendSignals — сигналы нагрузки backend'а, по которым пересчитывается его вес.
+type BackendSignals struct {
+    ActiveConnections int64         `json:"active_connections"`
+    ErrorRate         float64       `json:"error_rate"`    // Доля ошибок за последний интервал (0..1)
+    Latency           time.Duration `json:"latency"`       // EWMA времени до заголовков ответа
+    ReportedLoad      float64       `json:"reported_load"` // Нагрузка из заголовка ответа backend'а (load_header)
+}
+
+// WeightFunc вычисляет эффективный вес backend'а по базовому (заданному) весу и сигналам.
+// Результат должен быть положительным: вес 0 задается только явно через конфигурацию или admin API.
+type WeightFunc func(base int64, signals BackendSignals) int64
+
+// LinearWeightFunc снижает вес обратно пропорционально взвешенной сумме сигналов:
+// base * 100 / (1 + Σ коэффициент × сигнал). Задержка учитывается в секундах.
+func LinearWeightFunc(c config.AdaptiveCoefficients) WeightFunc {
+    return func(base int64, s BackendSignals) int64 {
+        penalty := c.ActiveConnections*float64(s.ActiveConnections) +
+            c.ErrorRate*s.ErrorRate +
+            c.Latency*s.Latency.Seconds() +
+            c.ReportedLoad*s.ReportedLoad
+        weight := int64(math.Round(float64(base*adaptiveWeightScale) / (1 + penalty)))
+        return max(weight, 1)
+    }
+}
+
+// EffectiveWeight возвращает вес, используемый при выборе: заданный вес или,
+// если включены адаптивные веса, вычисленный контроллером.
+func (b *Backend) EffectiveWeight() int64 {
+    weight := b.Weight.Load()
+    if adaptive := b.adaptiveWeight.Load(); adaptive > 0 && weight > 0 {
+        return adaptive
+    }
+    return weight
+}
+
+// Signals возвращает сигналы, использованные при последнем пересчете веса.
+func (b *Backend) Signals() (BackendSignals, bool) {
+    signals := b.signals.Load()
+    if signals == nil {
+        return BackendSignals{}, false
+    }
+    return *signals, true
+}
+
+// ObserveResponse учитывает ответ backend'а: задержку до заголовков и, если backend
+// ее сообщил, его собственную оценку нагрузки (load < 0 — не сообщил).
+func (b *Backend) ObserveResponse(latency time.Duration, load float64) {
+    alpha := math.Float64frombits(b.latencyDecay.Load())
+    if alpha <= 0 {
+        alpha = latencyEWMAAlpha
+    }
+    for {
+        old := b.latencyEWMA.Load()
+        next := float64(latency)
+        if old != 0 {
+            next = alpha*next + (1-alpha)*math.Float64frombits(old)
+        }
+        if b.latencyEWMA.CompareAndSwap(old, math.Float64bits(next)) {
+            break
+        }
+    }
+    if load >= 0 {
+        b.reportedLoad.Store(math.Float64bits(load))
+    }
+}
+
+// ConfigureAdaptiveWeights запускает контроллер, который каждые interval пересчитывает
+// эффективные веса backend'ов функцией fn (nil — LinearWeightFunc с коэффициентами из конфигурации).
+func (p *Pool) ConfigureAdaptiveWeights(cfg config.AdaptiveWeightsConfig, fn WeightFunc) {
+    if !cfg.Enabled {
+        return
+    }
+    if fn == nil {
+        fn = LinearWeightFunc(cfg.Coefficients)
+    }
+    interval := cfg.Interval
+    if interval <= 0 {
+        interval = defaultAdaptiveInterval
+    }
+    go p.runAdaptiveWeights(fn, interval)
+}
+
+// runAdaptiveWeights периодически пересчитывает веса всех backend'ов.
+func (p *Pool) runAdaptiveWeights(fn WeightFunc, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for range ticker.C {
+        changed := false
+        for _, backend := range p.allBackends() {
+            if p.recomputeWeight(backend, fn) {
+                changed = true
+            }
+        }
+        if changed {
+            p.weightsVersion.Add(1)
+        }
+    }
+}
+
+// recomputeWeight пересчитывает эффективный вес одного backend'а. Возвращает true, если вес изменился.
+// Вызывается только из горутины контроллера.
+func (p *Pool) recomputeWeight(b *Backend, fn WeightFunc) bool {
+    total, failed := b.TotalRequests.Load(), b.FailedRequests.Load()
+    signals := BackendSignals{
+        ActiveConnections: b.ActiveConnections.Load(),
+        Latency:           time.Duration(math.Float64frombits(b.latencyEWMA.Load())),
+        ReportedLoad:      math.Float64frombits(b.reportedLoad.Load()),
+    }
+    if requests := total - b.lastTotal; requests > 0 {
+        signals.ErrorRate = float64(failed-b.lastFailed) / float64(requests)
+    }
+    b.lastTotal, b.lastFailed = total, failed
+    b.signals.Store(&signals)
+
+    weight := fn(b.Weight.Load(), signals)
+    changed := b.adaptiveWeight.Swap(weight) != weight
+    p.metricsSink().Set("lb_backend_effective_weight", float64(b.EffectiveWeight()), "backend", b.Address.String())
+    return changed
+}
@@ -1,117 +1,170 @@
 package balancer
 
 import (
+    "math/rand"
     "net/http"
+    "net/http/httputil"
     "net/url"
+    "sync"
     "sync/atomic"
     "time"
 
+    "github.com/Manzo48/loadBalancer/internal/config"
+    "github.com/Manzo48/loadBalancer/internal/metrics"
     "go.uber.org/zap"
 )
 
 // Backend представляет один сервер, обрабатывающий клиентские запросы.
 type Backend struct {
-    Address *url.URL    // Адрес backend-сервера
-    IsAlive atomic.Bool // Флаг доступности (жив ли сервер)
+    Address      *url.URL     // Адрес backend-сервера
+    IsAlive      atomic.Bool  // Флаг доступности (жив ли сервер)
+    SignRequests bool         // Подписывать запросы к backend'у HMAC-заголовком
+    Region       string       // Регион backend'а (для выбора по близости)
+    Weight       atomic.Int64 // Заданный вес backend'а; 0 — новые запросы не направляются
+
+    SensitiveHeaders *config.SensitiveHeadersConfig // Политика для Authorization и др. (nil — общая)
+    Transport        http.RoundTripper              // Транспорт с собственными настройками TLS (nil — стандартный)
+
+    config          config.BackendConfig // Исходная конфигурация backend'а
+    healthCheckType string               // Тип health-check backend'а (пусто — общий)
+    healthCheckPath string               // Путь health-check backend'а (пусто — общий)
+    healthyStatuses map[int]bool         // Коды ответа health-check backend'а (nil — общие)
+
+    currentWeight int64           // Состояние smooth weighted round-robin (под мьютексом стратегии)
+    breaker       *CircuitBreaker // Circuit breaker (nil, если выключен)
+    outlier       *outlierTracker // Счетчики outlier detection (nil, если выключен)
+    slots         chan struct{}   // Семафор max_concurrent (nil — без ограничения)
+
+    proxyOnce sync.Once              // Создание обратного прокси к backend'у
+    proxy     *httputil.ReverseProxy // Обратный прокси, общий для всех запросов к backend'у
+
+    draining      atomic.Bool                 // Backend выводится из ротации (admin API)
+    probeInFlight atomic.Bool                 // Health-check этого backend'а еще выполняется
+    healthySince  atomic.Int64                // Последний переход в живое состояние (UnixNano, 0 — не восстанавливался)
+    certNotAfter  atomic.Int64                // Срок действия TLS-сертификата (UnixNano, 0 — неизвестен)
+    lastProbe     atomic.Pointer[probeResult] // Итоги health-check'ов (nil — еще не проверялся)
+
+    adaptiveWeight atomic.Int64                   // Вес, вычисленный адаптивным контроллером (0 — не вычислялся)
+    signals        atomic.Pointer[BackendSignals] // Сигналы последнего пересчета веса
+    latencyEWMA    atomic.Uint64                  // EWMA задержки ответа (float64-биты наносекунд)
+    latencyDecay   atomic.Uint64                  // Вклад нового замера в EWMA (float64-биты, 0 — latencyEWMAAlpha)
+    reportedLoad   atomic.Uint64                  // Последняя нагрузка, сообщенная backend'ом (float64-биты)
+    lastTotal      uint64                         // Счетчики на момент прошлого пересчета (только горутина контроллера)
+    lastFailed     uint64
+
+    ActiveConnections atomic.Int64  // Количество запросов, обрабатываемых прямо сейчас
+    TotalRequests     atomic.Uint64 // Всего проксированных запросов
+    FailedRequests    atomic.Uint64 // Запросов, завершившихся ошибкой проксирования
 }
 
-// LoadBalancer описывает поведение балансировщика.
+// Selection — контекст выбора backend'а для конкретного запроса.
+type Selection struct {
+    ClientIP string            // IP клиента (может быть пустым)
+    Request  *http.Request     // Исходный запрос (может быть nil)
+    Tried    map[*Backend]bool // Backend'ы, уже опробованные для этого запроса
+    URLKey   string            // Путь и канонический query string (см. query_canonicalization)
+}
+
+// LoadBalancer описывает поведение балансировщика и служит точкой расширения для
+// собственных стратегий (см. Register). Проще всего встроить *Pool — он реализует все,
+// кроме выбора backend'а, — и написать NextAvailableBackend, NextAvailableBackendExcluding
+// и Select через Pool.Pick, передав ему функцию выбора среди доступных кандидатов.
 type LoadBalancer interface {
     NextAvailableBackend() *Backend
+    NextAvailableBackendExcluding(tried map[*Backend]bool) *Backend
+    Select(sel Selection) *Backend
     MarkBackendUnhealthy(target *url.URL)
+    ReplaceBackends(backends []config.BackendConfig) error
+    AddBackend(backend config.BackendConfig) error
+    RemoveBackend(address string) error
+    RecoveryEstimate() (time.Duration, bool)
+    ConfigureStandby(cfg config.StandbyConfig, m metrics.Metrics)
+    SetBackendWeight(address string, weight int) error
+    SetBackendDraining(address string, draining bool) error
+    Backends() []*Backend
+    PrimaryBackends() []*Backend
+    SetMetrics(m metrics.Metrics)
+    ConfigureCircuitBreaker(cfg config.CircuitBreakerConfig)
+    ConfigureOutlierDetection(cfg config.OutlierDetectionConfig)
+    ConfigureAdaptiveWeights(cfg config.AdaptiveWeightsConfig, fn WeightFunc)
+    Snapshot() []BackendStatus
 }
 
-// RoundRobinLoadBalancer реализует интерфейс LoadBalancer по алгоритму Round-Robin.
-type RoundRobinLoadBalancer struct {
-    backends       []*Backend         // Список всех backend-серверов
-    currentIndex   uint32             // Текущий индекс для round-robin
-    logger         *zap.SugaredLogger // Логгер
-
-    healthCheckInterval time.Duration // Интервал между health-check запросами
-    healthCheckTimeout  time.Duration // Таймаут запроса health-check
+// Config возвращает текущую конфигурацию backend'а: исходную с учетом изменений на лету (вес).
+func (b *Backend) Config() config.BackendConfig {
+    cfg := b.config
+    if weight := int(b.Weight.Load()); cfg.Weight != nil || weight != cfg.EffectiveWeight() {
+        cfg.Weight = &weight
+    }
+    return cfg
 }
 
-// NewRoundRobinLoadBalancer создает новый RoundRobinLoadBalancer и запускает цикл health-check.
-func NewRoundRobinLoadBalancer(backendURLs []string, logger *zap.SugaredLogger) *RoundRobinLoadBalancer {
-    loadBalancer := &RoundRobinLoadBalancer{
-        backends:             make([]*Backend, 0, len(backendURLs)),
-        logger:               logger,
-        healthCheckInterval:  10 * time.Second,
-        healthCheckTimeout:   2 * time.Second,
-    }
+// Timeout возвращает таймаут попытки к этому backend'у (0 — общий retry.per_try_timeout).
+func (b *Backend) Timeout() time.Duration {
+    return b.config.Timeout
+}
 
-    for _, rawURL := range backendURLs {
-        parsedURL, err := url.Parse(rawURL)
-        if err != nil {
-            logger.Warnf("Invalid backend URL %s: %v", rawURL, err)
-            continue
-        }
+// ReverseProxy возвращает обратный прокси к backend'у, создавая его через build при первом
+// обращении. Прокси один на backend и удаляется вместе с ним, поэтому build не должен
+// сохранять в нем состояние отдельного запроса.
+func (b *Backend) ReverseProxy(build func(*Backend) *httputil.ReverseProxy) *httputil.ReverseProxy {
+    b.proxyOnce.Do(func() { b.proxy = build(b) })
+    return b.proxy
+}
 
-        backend := &Backend{Address: parsedURL}
-        backend.IsAlive.Store(true) // Считаем, что backend жив на старте
-        loadBalancer.backends = append(loadBalancer.backends, backend)
+// RoundRobinLoadBalancer реализует интерфейс LoadBalancer по алгоритму Round-Robin.
+type RoundRobinLoadBalancer struct {
+    *Pool
+    currentIndex uint32 // Текущий индекс для round-robin
+}
 
-        logger.Infof("Backend registered: %s", parsedURL.String())
+// NewRoundRobinLoadBalancer создает новый RoundRobinLoadBalancer и запускает цикл health-check.
+// Ротация начинается со случайного backend'а, чтобы одновременно запущенные экземпляры
+// прокси не отправляли первые запросы на один и тот же backend.
+func NewRoundRobinLoadBalancer(backendConfigs []config.BackendConfig, healthCheck config.HealthCheckConfig, logger *zap.SugaredLogger) *RoundRobinLoadBalancer {
+    return &RoundRobinLoadBalancer{
+        Pool:         NewPool(backendConfigs, healthCheck, logger),
+        currentIndex: startOffset(),
     }
+}
 
-    go loadBalancer.runHealthCheckLoop()
+var (
+    offsetMu     sync.Mutex
+    offsetSource = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
 
-    return loadBalancer
+// SeedStartOffsets делает начальные смещения ротации детерминированными (для тестов).
+// Влияет на балансировщики, созданные после вызова.
+func SeedStartOffsets(seed int64) {
+    offsetMu.Lock()
+    defer offsetMu.Unlock()
+    offsetSource = rand.New(rand.NewSource(seed))
 }
 
-// runHealthCheckLoop периодически проверяет доступность всех backend'ов.
-func (lb *RoundRobinLoadBalancer) runHealthCheckLoop() {
-    client := &http.Client{Timeout: lb.healthCheckTimeout}
-    ticker := time.NewTicker(lb.healthCheckInterval)
-    defer ticker.Stop()
-
-    for range ticker.C {
-        for _, backend := range lb.backends {
-            go func(b *Backend) {
-                healthCheckURL := b.Address.String() + "/health"
-                response, err := client.Get(healthCheckURL)
-
-                isHealthy := err == nil && response.StatusCode == http.StatusOK
-                b.IsAlive.Store(isHealthy)
-
-                if isHealthy {
-                    lb.logger.Debugf("Health check passed: %s", b.Address)
-                } else {
-                    lb.logger.Warnf("Health check failed: %s (error: %v)", b.Address, err)
-                }
-
-                if response != nil {
-                    response.Body.Close()
-                }
-            }(backend)
-        }
-    }
+// startOffset возвращает случайное начальное смещение ротации.
+func startOffset() uint32 {
+    offsetMu.Lock()
+    defer offsetMu.Unlock()
+    return offsetSource.Uint32()
 }
 
 // NextAvailableBackend возвращает следующий доступный backend по алгоритму Round-Robin.
 func (lb *RoundRobinLoadBalancer) NextAvailableBackend() *Backend {
-    total := len(lb.backends)
-    for attempt := 0; attempt < total; attempt++ {
-        index := atomic.AddUint32(&lb.currentIndex, 1) % uint32(total)
-        candidate := lb.backends[index]
-
-        if candidate.IsAlive.Load() {
-            lb.logger.Debugf("Backend selected: %s", candidate.Address)
-            return candidate
-        }
-    }
+    return lb.Select(Selection{})
+}
 
-    lb.logger.Warn("No healthy backends available")
-    return nil
+// NextAvailableBackendExcluding возвращает следующий доступный backend, пропуская уже
+// опробованные для текущего запроса (используется при повторных попытках).
+func (lb *RoundRobinLoadBalancer) NextAvailableBackendExcluding(tried map[*Backend]bool) *Backend {
+    return lb.Select(Selection{Tried: tried})
 }
 
-// MarkBackendUnhealthy помечает указанный backend как недоступный.
-func (lb *RoundRobinLoadBalancer) MarkBackendUnhealthy(target *url.URL) {
-    for _, backend := range lb.backends {
-        if backend.Address.String() == target.String() {
-            backend.IsAlive.Store(false)
-            lb.logger.Warnf("Backend marked as unhealthy: %s", target)
-            return
-        }
-    }
+// Select возвращает следующий доступный backend по кругу.
+func (lb *RoundRobinLoadBalancer) Select(sel Selection) *Backend {
+    return lb.Pick(sel, lb.choose)
+}
+
+func (lb *RoundRobinLoadBalancer) choose(candidates []*Backend, _ Selection) *Backend {
+    index := atomic.AddUint32(&lb.currentIndex, 1) % uint32(len(candidates))
+    return candidates[index]
 }
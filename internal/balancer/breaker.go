@@ -0,0 +1,186 @@
+package balancer
+
+import (
+    "sync"
+    "time"
+
+    "github.com/Manzo48/loadBalancer/internal/config"
+)
+
+const (
+    defaultBreakerThreshold = 5
+    defaultBreakerCooldown  = 30 * time.Second
+)
+
+// BreakerState — состояние circuit breaker'а backend'а.
+type BreakerState string
+
+const (
+    BreakerClosed   BreakerState = "closed"    // Запросы идут как обычно
+    BreakerOpen     BreakerState = "open"      // Backend исключен из выбора до конца cooldown
+    BreakerHalfOpen BreakerState = "half_open" // Пропускается один пробный запрос
+)
+
+// CircuitBreaker исключает backend из выбора после серии ошибок подряд. По истечении
+// cooldown пропускается один пробный запрос: успех закрывает breaker, ошибка снова открывает.
+// Методы nil-безопасны: nil означает, что circuit breaker выключен.
+type CircuitBreaker struct {
+    threshold int
+    cooldown  time.Duration
+
+    mu       sync.Mutex
+    state    BreakerState
+    failures int       // Ошибок подряд в состоянии closed
+    openedAt time.Time // Когда breaker открылся в последний раз
+    probing  bool      // Пробный запрос half-open уже выполняется
+}
+
+// newCircuitBreaker создает breaker с порогами из конфигурации (или значениями по умолчанию).
+func newCircuitBreaker(cfg config.CircuitBreakerConfig) *CircuitBreaker {
+    cb := &CircuitBreaker{
+        threshold: cfg.FailureThreshold,
+        cooldown:  cfg.Cooldown,
+        state:     BreakerClosed,
+    }
+    if cb.threshold <= 0 {
+        cb.threshold = defaultBreakerThreshold
+    }
+    if cb.cooldown <= 0 {
+        cb.cooldown = defaultBreakerCooldown
+    }
+    return cb
+}
+
+// State возвращает текущее состояние. Открытый breaker с истекшим cooldown
+// считается half-open, даже если пробный запрос еще не отправлялся.
+func (cb *CircuitBreaker) State() BreakerState {
+    if cb == nil {
+        return BreakerClosed
+    }
+    cb.mu.Lock()
+    defer cb.mu.Unlock()
+    if cb.state == BreakerOpen && time.Since(cb.openedAt) >= cb.cooldown {
+        return BreakerHalfOpen
+    }
+    return cb.state
+}
+
+// ready сообщает, может ли backend сейчас получить запрос (без изменения состояния).
+func (cb *CircuitBreaker) ready() bool {
+    switch cb.State() {
+    case BreakerClosed:
+        return true
+    case BreakerHalfOpen:
+        cb.mu.Lock()
+        defer cb.mu.Unlock()
+        return !cb.probing
+    default:
+        return false
+    }
+}
+
+// acquire резервирует запрос к backend'у. В half-open пропускается только один запрос;
+// остальные получают false и должны выбрать другой backend.
+func (cb *CircuitBreaker) acquire() bool {
+    if cb == nil {
+        return true
+    }
+    cb.mu.Lock()
+    defer cb.mu.Unlock()
+
+    switch cb.state {
+    case BreakerClosed:
+        return true
+    case BreakerOpen:
+        if time.Since(cb.openedAt) < cb.cooldown {
+            return false
+        }
+        cb.state = BreakerHalfOpen
+    }
+    if cb.probing {
+        return false
+    }
+    cb.probing = true
+    return true
+}
+
+// Success учитывает успешный запрос. Возвращает true, если breaker закрылся.
+func (cb *CircuitBreaker) Success() bool {
+    if cb == nil {
+        return false
+    }
+    cb.mu.Lock()
+    defer cb.mu.Unlock()
+
+    cb.failures = 0
+    if cb.state != BreakerHalfOpen {
+        return false
+    }
+    cb.state = BreakerClosed
+    cb.probing = false
+    return true
+}
+
+// Failure учитывает ошибку запроса. Возвращает true, если breaker открылся.
+func (cb *CircuitBreaker) Failure() bool {
+    if cb == nil {
+        return false
+    }
+    cb.mu.Lock()
+    defer cb.mu.Unlock()
+
+    switch cb.state {
+    case BreakerHalfOpen:
+        cb.probing = false
+    case BreakerClosed:
+        cb.failures++
+        if cb.failures < cb.threshold {
+            return false
+        }
+    default:
+        return false
+    }
+    cb.state = BreakerOpen
+    cb.openedAt = time.Now()
+    cb.failures = 0
+    return true
+}
+
+// Release снимает резерв пробного запроса, завершившегося без результата
+// (например, клиент отключился): backend не виноват, но и не подтвердил работоспособность.
+func (cb *CircuitBreaker) Release() {
+    if cb == nil {
+        return
+    }
+    cb.mu.Lock()
+    defer cb.mu.Unlock()
+    cb.probing = false
+}
+
+// Breaker возвращает circuit breaker backend'а (nil, если выключен).
+func (b *Backend) Breaker() *CircuitBreaker {
+    return b.breaker
+}
+
+// ConfigureCircuitBreaker включает circuit breaker для всех backend'ов пула,
+// в том числе добавленных позже (замена набора, резервный пул).
+func (p *Pool) ConfigureCircuitBreaker(cfg config.CircuitBreakerConfig) {
+    if !cfg.Enabled {
+        return
+    }
+    p.breakerCfg.Store(&cfg)
+    p.attachBreakers(p.allBackends())
+}
+
+// attachBreakers создает breaker'ы для backend'ов, если circuit breaker включен.
+func (p *Pool) attachBreakers(backends []*Backend) {
+    cfg := p.breakerCfg.Load()
+    if cfg == nil {
+        return
+    }
+    for _, backend := range backends {
+        if backend.breaker == nil {
+            backend.breaker = newCircuitBreaker(*cfg)
+        }
+    }
+}
@@ -0,0 +1,95 @@
+package balancer
+
+import (
+    "crypto/x509"
+    "errors"
+    "net/http"
+    "time"
+
+    "github.com/Manzo48/loadBalancer/internal/metrics"
+)
+
+// CertExpiry возвращает момент истечения сертификата backend'а, увиденного последним
+// health-check'ом (самый ранний NotAfter в цепочке). false — сертификат еще не проверялся
+// или backend не https.
+func (b *Backend) CertExpiry() (time.Time, bool) {
+    notAfter := b.certNotAfter.Load()
+    if notAfter == 0 {
+        return time.Time{}, false
+    }
+    return time.Unix(0, notAfter), true
+}
+
+// recordCertExpiry запоминает срок действия сертификата из ответа health-check'а или,
+// если TLS-рукопожатие не удалось из-за истекшего сертификата, из ошибки проверки.
+func (b *Backend) recordCertExpiry(response *http.Response, err error) {
+    var chain []*x509.Certificate
+    var invalid x509.CertificateInvalidError
+    switch {
+    case response != nil && response.TLS != nil:
+        chain = response.TLS.PeerCertificates
+    case errors.As(err, &invalid) && invalid.Cert != nil:
+        chain = []*x509.Certificate{invalid.Cert}
+    default:
+        return
+    }
+
+    var earliest time.Time
+    for _, cert := range chain {
+        if earliest.IsZero() || cert.NotAfter.Before(earliest) {
+            earliest = cert.NotAfter
+        }
+    }
+    if !earliest.IsZero() {
+        b.certNotAfter.Store(earliest.UnixNano())
+    }
+}
+
+// checkCertExpiry сравнивает срок действия сертификата с порогами cert_expiry.
+// Возвращает false, если backend нужно считать недоступным.
+func (p *Pool) checkCertExpiry(b *Backend) bool {
+    expiresAt, ok := b.CertExpiry()
+    if !ok {
+        return true
+    }
+
+    remaining := time.Until(expiresAt)
+    p.metricsSink().Set("lb_backend_cert_expiry_seconds", remaining.Seconds(), "backend", b.Address.String())
+
+    if p.certFailBefore > 0 && remaining < p.certFailBefore {
+        p.logger.Errorf("TLS certificate of %s expires at %s (in %s), marking backend unhealthy",
+            b.Address, expiresAt.Format(time.RFC3339), remaining.Round(time.Second))
+        return false
+    }
+    if p.certWarnBefore > 0 && remaining < p.certWarnBefore {
+        p.logger.Warnf("TLS certificate of %s expires at %s (in %s)",
+            b.Address, expiresAt.Format(time.RFC3339), remaining.Round(time.Second))
+    }
+    return true
+}
+
+// SetMetrics задает получателя метрик пула (health-check'и и т.п.). Можно вызывать
+// после старта: цикл health-check читает значение атомарно.
+func (p *Pool) SetMetrics(m metrics.Metrics) {
+    p.metrics.Store(&m)
+    for _, backend := range p.allBackends() {
+        p.reportAlive(backend)
+    }
+}
+
+// reportAlive экспортирует состояние backend'а gauge'ем lb_backend_up (1 — жив).
+func (p *Pool) reportAlive(b *Backend) {
+    up := 0.0
+    if b.IsAlive.Load() {
+        up = 1
+    }
+    p.metricsSink().Set("lb_backend_up", up, "backend", b.Address.String())
+}
+
+// metricsSink возвращает получателя метрик или no-op, если он не задан.
+func (p *Pool) metricsSink() metrics.Metrics {
+    if m := p.metrics.Load(); m != nil {
+        return *m
+    }
+    return metrics.Nop{}
+}
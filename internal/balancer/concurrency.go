@@ -0,0 +1,52 @@
+package balancer
+
+import (
+    "context"
+    "time"
+)
+
+// TryAcquireSlot занимает слот max_concurrent backend'а, не дожидаясь освобождения.
+// Backend без ограничения всегда доступен.
+func (b *Backend) TryAcquireSlot() bool {
+    if b.slots == nil {
+        return true
+    }
+    select {
+    case b.slots <- struct{}{}:
+        return true
+    default:
+        return false
+    }
+}
+
+// AcquireSlot ждет свободный слот backend'а не дольше timeout или до отмены ctx.
+func (b *Backend) AcquireSlot(ctx context.Context, timeout time.Duration) bool {
+    if b.TryAcquireSlot() {
+        return true
+    }
+    if timeout <= 0 {
+        return false
+    }
+    timer := time.NewTimer(timeout)
+    defer timer.Stop()
+    select {
+    case b.slots <- struct{}{}:
+        return true
+    case <-timer.C:
+        return false
+    case <-ctx.Done():
+        return false
+    }
+}
+
+// ReleaseSlot освобождает слот, занятый TryAcquireSlot или AcquireSlot.
+func (b *Backend) ReleaseSlot() {
+    if b.slots != nil {
+        <-b.slots
+    }
+}
+
+// MaxConcurrent возвращает предел одновременных запросов к backend'у (0 — без ограничения).
+func (b *Backend) MaxConcurrent() int {
+    return cap(b.slots)
+}
@@ -0,0 +1,174 @@
+package balancer
+
+import (
+    "hash/fnv"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+
+    "github.com/Manzo48/loadBalancer/internal/config"
+    "go.uber.org/zap"
+)
+
+// Источники ключа consistent_hash.key
+const (
+    HashKeyURL    = "url"     // Путь и канонический query string (по умолчанию)
+    HashKeyPath   = "path"    // Только путь
+    HashKeyIP     = "ip"      // IP клиента
+    HashKeyHeader = "header:" // header:X-Cache-Key; без заголовка — url
+)
+
+// DefaultVirtualNodes — число точек backend'а на кольце по умолчанию.
+const DefaultVirtualNodes = 160
+
+// ConsistentHashLoadBalancer направляет запросы с одинаковым ключом на один и тот же backend
+// (cache affinity). Backend'ы размещаются на кольце хешей виртуальными узлами, поэтому при
+// добавлении или удалении backend'а переезжает только его доля ключей. Если backend недоступен,
+// его ключи уходят на следующий по кольцу доступный backend и возвращаются после восстановления.
+type ConsistentHashLoadBalancer struct {
+    *Pool
+    mu           sync.Mutex
+    key          string // Источник ключа (см. HashKey*)
+    virtualNodes int    // Точек на кольце у каждого backend'а
+    ring         *hashRing
+}
+
+// hashRing — кольцо для конкретного набора backend'ов; перестраивается при его замене.
+type hashRing struct {
+    primary *[]*Backend // Наборы, по которым построено кольцо
+    standby *[]*Backend
+    points  []ringPoint // Отсортированы по hash
+}
+
+type ringPoint struct {
+    hash    uint64
+    backend *Backend
+}
+
+// NewConsistentHashLoadBalancer создает балансировщик с консистентным хешированием по URL
+// и DefaultVirtualNodes точками на backend (см. Configure).
+func NewConsistentHashLoadBalancer(backendConfigs []config.BackendConfig, healthCheck config.HealthCheckConfig, logger *zap.SugaredLogger) *ConsistentHashLoadBalancer {
+    return &ConsistentHashLoadBalancer{
+        Pool:         NewPool(backendConfigs, healthCheck, logger),
+        key:          HashKeyURL,
+        virtualNodes: DefaultVirtualNodes,
+    }
+}
+
+// Configure задает источник ключа и число виртуальных узлов. Вызывается до начала
+// обработки запросов; пустые значения оставляют значения по умолчанию.
+func (lb *ConsistentHashLoadBalancer) Configure(cfg config.ConsistentHashConfig) {
+    lb.mu.Lock()
+    defer lb.mu.Unlock()
+    if cfg.Key != "" {
+        lb.key = cfg.Key
+    }
+    if cfg.VirtualNodes > 0 {
+        lb.virtualNodes = cfg.VirtualNodes
+    }
+    lb.ring = nil
+}
+
+// NextAvailableBackend возвращает backend для пустого ключа.
+func (lb *ConsistentHashLoadBalancer) NextAvailableBackend() *Backend {
+    return lb.Select(Selection{})
+}
+
+// NextAvailableBackendExcluding возвращает backend, пропуская уже опробованные.
+func (lb *ConsistentHashLoadBalancer) NextAvailableBackendExcluding(tried map[*Backend]bool) *Backend {
+    return lb.Select(Selection{Tried: tried})
+}
+
+// Select возвращает backend, отвечающий за ключ запроса.
+func (lb *ConsistentHashLoadBalancer) Select(sel Selection) *Backend {
+    return lb.Pick(sel, lb.choose)
+}
+
+func (lb *ConsistentHashLoadBalancer) choose(candidates []*Backend, sel Selection) *Backend {
+    available := make(map[*Backend]bool, len(candidates))
+    for _, backend := range candidates {
+        available[backend] = true
+    }
+
+    points := lb.currentRing().points
+    if len(points) == 0 {
+        return candidates[0]
+    }
+    hash := hashKey(lb.selectionKey(sel))
+    start := sort.Search(len(points), func(i int) bool { return points[i].hash >= hash })
+    // Кольцо строится по всем backend'ам, а не по доступным кандидатам: иначе выход
+    // одного backend'а из ротации перераспределил бы ключи остальных
+    for i := range points {
+        if backend := points[(start+i)%len(points)].backend; available[backend] {
+            return backend
+        }
+    }
+    return candidates[0]
+}
+
+// selectionKey возвращает ключ запроса согласно consistent_hash.key.
+func (lb *ConsistentHashLoadBalancer) selectionKey(sel Selection) string {
+    lb.mu.Lock()
+    key := lb.key
+    lb.mu.Unlock()
+
+    switch {
+    case key == HashKeyPath:
+        if sel.Request != nil {
+            return sel.Request.URL.Path
+        }
+    case key == HashKeyIP:
+        return sel.ClientIP
+    case strings.HasPrefix(key, HashKeyHeader):
+        if sel.Request != nil {
+            if value := sel.Request.Header.Get(strings.TrimSpace(strings.TrimPrefix(key, HashKeyHeader))); value != "" {
+                return value
+            }
+        }
+    }
+    if sel.URLKey == "" && sel.Request != nil {
+        return sel.Request.URL.RequestURI()
+    }
+    return sel.URLKey
+}
+
+// currentRing возвращает кольцо для текущего набора backend'ов, перестраивая его после замены набора.
+func (lb *ConsistentHashLoadBalancer) currentRing() *hashRing {
+    primary, standby := lb.backends.Load(), lb.standby.Load()
+    lb.mu.Lock()
+    defer lb.mu.Unlock()
+    if lb.ring != nil && lb.ring.primary == primary && lb.ring.standby == standby {
+        return lb.ring
+    }
+
+    ring := &hashRing{primary: primary, standby: standby}
+    members := *primary
+    if standby != nil {
+        members = append(append([]*Backend(nil), members...), *standby...)
+    }
+    ring.points = make([]ringPoint, 0, len(members)*lb.virtualNodes)
+    for _, backend := range members {
+        address := backend.Address.String()
+        for i := 0; i < lb.virtualNodes; i++ {
+            ring.points = append(ring.points, ringPoint{hash: hashKey(address + "#" + strconv.Itoa(i)), backend: backend})
+        }
+    }
+    sort.Slice(ring.points, func(i, j int) bool { return ring.points[i].hash < ring.points[j].hash })
+    lb.ring = ring
+    return ring
+}
+
+// hashKey — FNV-1a с финальным перемешиванием splitmix64: у похожих строк
+// (адрес#1, адрес#2) точки равномерно расходятся по кольцу.
+func hashKey(key string) uint64 {
+    hash := fnv.New64a()
+    hash.Write([]byte(key))
+    x := hash.Sum64()
+    x ^= x >> 30
+    x *= 0xbf58476d1ce4e5b9
+    x ^= x >> 27
+    x *= 0x94d049bb133111eb
+    x ^= x >> 31
+    return x
+}
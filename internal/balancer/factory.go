@@ -0,0 +1,39 @@
+package balancer
+
+import (
+    "fmt"
+
+    "github.com/Manzo48/loadBalancer/internal/config"
+    "go.uber.org/zap"
+)
+
+// New создает балансировщик для стратегии из конфигурации (strategy).
+// Сначала проверяются стратегии, зарегистрированные через Register, затем встроенные.
+// Пустая стратегия означает round_robin — поведение до появления настройки.
+func New(strategy string, backendConfigs []config.BackendConfig, healthCheck config.HealthCheckConfig, logger *zap.SugaredLogger) (LoadBalancer, error) {
+    if constructor, ok := registered(strategy); ok {
+        return constructor(backendConfigs, healthCheck, logger), nil
+    }
+    switch strategy {
+    case "", "round_robin":
+        return NewRoundRobinLoadBalancer(backendConfigs, healthCheck, logger), nil
+    case "weighted_round_robin":
+        return NewWeightedRoundRobinLoadBalancer(backendConfigs, healthCheck, logger), nil
+    case "least_connections":
+        return NewLeastConnectionsLoadBalancer(backendConfigs, healthCheck, logger), nil
+    case "weighted_least_connections":
+        return NewWeightedLeastConnectionsLoadBalancer(backendConfigs, healthCheck, logger), nil
+    case "ip_hash":
+        return NewIPHashLoadBalancer(backendConfigs, healthCheck, logger), nil
+    case "random":
+        return NewRandomLoadBalancer(backendConfigs, healthCheck, logger), nil
+    case "p2c":
+        return NewP2CLoadBalancer(backendConfigs, healthCheck, logger), nil
+    case "consistent_hash":
+        return NewConsistentHashLoadBalancer(backendConfigs, healthCheck, logger), nil
+    case "least_response_time":
+        return NewLeastResponseTimeLoadBalancer(backendConfigs, healthCheck, logger), nil
+    default:
+        return nil, fmt.Errorf("unknown balancing strategy %q", strategy)
+    }
+}
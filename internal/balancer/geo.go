@@ -0,0 +1,133 @@
+package balancer
+
+import (
+    "fmt"
+    "net"
+    "sync/atomic"
+
+    "github.com/Manzo48/loadBalancer/internal/config"
+    "github.com/oschwald/maxminddb-golang"
+    "go.uber.org/zap"
+)
+
+// RegionResolver определяет регион backend'ов, ближайший к IP клиента.
+type RegionResolver interface {
+    Region(ip net.IP) (string, bool)
+}
+
+// GeoIPResolver определяет регион по базе MaxMind: сначала по коду страны, затем по коду континента.
+type GeoIPResolver struct {
+    reader  *maxminddb.Reader
+    regions map[string]string
+}
+
+// geoRecord — поля базы MaxMind, нужные для определения региона.
+type geoRecord struct {
+    Country struct {
+        ISOCode string `maxminddb:"iso_code"`
+    } `maxminddb:"country"`
+    Continent struct {
+        Code string `maxminddb:"code"`
+    } `maxminddb:"continent"`
+}
+
+// NewGeoIPResolver открывает базу MaxMind (GeoIP2/GeoLite2 City или Country).
+func NewGeoIPResolver(path string, regions map[string]string) (*GeoIPResolver, error) {
+    reader, err := maxminddb.Open(path)
+    if err != nil {
+        return nil, fmt.Errorf("open GeoIP database: %w", err)
+    }
+    return &GeoIPResolver{reader: reader, regions: regions}, nil
+}
+
+// Region возвращает регион клиента; false — IP не найден в базе или не сопоставлен региону.
+func (r *GeoIPResolver) Region(ip net.IP) (string, bool) {
+    var record geoRecord
+    if err := r.reader.Lookup(ip, &record); err != nil {
+        return "", false
+    }
+    if region, ok := r.regions[record.Country.ISOCode]; ok && record.Country.ISOCode != "" {
+        return region, true
+    }
+    if region, ok := r.regions[record.Continent.Code]; ok && record.Continent.Code != "" {
+        return region, true
+    }
+    return "", false
+}
+
+// Close закрывает базу.
+func (r *GeoIPResolver) Close() error {
+    return r.reader.Close()
+}
+
+// GeoLoadBalancer выбирает backend из региона клиента, при его недоступности — из запасных
+// регионов в заданном порядке, и в последнюю очередь — любой доступный backend.
+// Внутри региона backend'ы чередуются по кругу.
+type GeoLoadBalancer struct {
+    *Pool
+    resolver      RegionResolver
+    fallback      map[string][]string
+    defaultRegion string
+    currentIndex  uint32
+}
+
+// NewGeoLoadBalancer создает балансировщик по близости.
+func NewGeoLoadBalancer(backendConfigs []config.BackendConfig, healthCheck config.HealthCheckConfig, geo config.GeoConfig, resolver RegionResolver, logger *zap.SugaredLogger) *GeoLoadBalancer {
+    return &GeoLoadBalancer{
+        Pool:          NewPool(backendConfigs, healthCheck, logger),
+        resolver:      resolver,
+        fallback:      geo.Fallback,
+        defaultRegion: geo.DefaultRegion,
+        currentIndex:  startOffset(),
+    }
+}
+
+// NextAvailableBackend возвращает backend без учета региона клиента (регион по умолчанию).
+func (lb *GeoLoadBalancer) NextAvailableBackend() *Backend {
+    return lb.Select(Selection{})
+}
+
+// NextAvailableBackendExcluding возвращает backend, пропуская уже опробованные.
+func (lb *GeoLoadBalancer) NextAvailableBackendExcluding(tried map[*Backend]bool) *Backend {
+    return lb.Select(Selection{Tried: tried})
+}
+
+// Select возвращает ближайший к клиенту доступный backend.
+func (lb *GeoLoadBalancer) Select(sel Selection) *Backend {
+    return lb.Pick(sel, lb.choose)
+}
+
+func (lb *GeoLoadBalancer) choose(candidates []*Backend, sel Selection) *Backend {
+    for _, region := range lb.regionOrder(sel.ClientIP) {
+        inRegion := make([]*Backend, 0, len(candidates))
+        for _, backend := range candidates {
+            if backend.Region == region {
+                inRegion = append(inRegion, backend)
+            }
+        }
+        if len(inRegion) > 0 {
+            return lb.next(inRegion)
+        }
+    }
+    return lb.next(candidates)
+}
+
+// regionOrder возвращает регионы в порядке предпочтения для клиента.
+// IP, не найденные в базе, обслуживаются как клиенты региона по умолчанию.
+func (lb *GeoLoadBalancer) regionOrder(clientIP string) []string {
+    region := lb.defaultRegion
+    if ip := net.ParseIP(clientIP); ip != nil && lb.resolver != nil {
+        if resolved, ok := lb.resolver.Region(ip); ok {
+            region = resolved
+        }
+    }
+    if region == "" {
+        return nil
+    }
+    return append([]string{region}, lb.fallback[region]...)
+}
+
+func (lb *GeoLoadBalancer) next(backends []*Backend) *Backend {
+    index := atomic.AddUint32(&lb.currentIndex, 1) % uint32(len(backends))
+    return backends[index]
+}
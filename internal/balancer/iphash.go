@@ -0,0 +1,56 @@
+package balancer
+
+import (
+    "hash/fnv"
+
+    "github.com/Manzo48/loadBalancer/internal/config"
+    "go.uber.org/zap"
+)
+
+// IPHashLoadBalancer закрепляет клиента за backend'ом по хешу его IP (session affinity).
+// Если закрепленный backend недоступен, выбирается следующий доступный в порядке списка,
+// поэтому клиенты одного выбывшего backend'а не разбрасываются по всему пулу,
+// а после его возвращения снова попадают на него.
+type IPHashLoadBalancer struct {
+    *Pool
+}
+
+// NewIPHashLoadBalancer создает балансировщик с привязкой клиента по IP.
+func NewIPHashLoadBalancer(backendConfigs []config.BackendConfig, healthCheck config.HealthCheckConfig, logger *zap.SugaredLogger) *IPHashLoadBalancer {
+    return &IPHashLoadBalancer{Pool: NewPool(backendConfigs, healthCheck, logger)}
+}
+
+// NextAvailableBackend возвращает backend для клиента без IP.
+func (lb *IPHashLoadBalancer) NextAvailableBackend() *Backend {
+    return lb.Select(Selection{})
+}
+
+// NextAvailableBackendExcluding возвращает backend, пропуская уже опробованные.
+func (lb *IPHashLoadBalancer) NextAvailableBackendExcluding(tried map[*Backend]bool) *Backend {
+    return lb.Select(Selection{Tried: tried})
+}
+
+// Select возвращает backend, закрепленный за IP клиента.
+func (lb *IPHashLoadBalancer) Select(sel Selection) *Backend {
+    return lb.Pick(sel, lb.choose)
+}
+
+func (lb *IPHashLoadBalancer) choose(candidates []*Backend, sel Selection) *Backend {
+    available := make(map[*Backend]bool, len(candidates))
+    for _, backend := range candidates {
+        available[backend] = true
+    }
+
+    // Хеш берется по всему набору, а не по доступным кандидатам: иначе выход
+    // одного backend'а из ротации перераспределил бы всех клиентов
+    backends := lb.Backends()
+    hash := fnv.New32a()
+    hash.Write([]byte(sel.ClientIP))
+    start := int(hash.Sum32() % uint32(len(backends)))
+    for i := range backends {
+        if backend := backends[(start+i)%len(backends)]; available[backend] {
+            return backend
+        }
+    }
+    return candidates[0]
+}
@@ -0,0 +1,99 @@
+package balancer
+
+import (
+    "sync/atomic"
+
+    "github.com/Manzo48/loadBalancer/internal/config"
+    "go.uber.org/zap"
+)
+
+// LeastConnectionsLoadBalancer направляет запрос на backend с наименьшим числом
+// активных запросов. При равенстве backend'ы чередуются по кругу.
+type LeastConnectionsLoadBalancer struct {
+    *Pool
+    currentIndex uint32 // Позиция, с которой начинается просмотр кандидатов
+}
+
+// NewLeastConnectionsLoadBalancer создает балансировщик по наименьшему числу соединений.
+func NewLeastConnectionsLoadBalancer(backendConfigs []config.BackendConfig, healthCheck config.HealthCheckConfig, logger *zap.SugaredLogger) *LeastConnectionsLoadBalancer {
+    return &LeastConnectionsLoadBalancer{
+        Pool:         NewPool(backendConfigs, healthCheck, logger),
+        currentIndex: startOffset(),
+    }
+}
+
+// NextAvailableBackend возвращает наименее загруженный backend.
+func (lb *LeastConnectionsLoadBalancer) NextAvailableBackend() *Backend {
+    return lb.Select(Selection{})
+}
+
+// NextAvailableBackendExcluding возвращает наименее загруженный backend, пропуская уже опробованные.
+func (lb *LeastConnectionsLoadBalancer) NextAvailableBackendExcluding(tried map[*Backend]bool) *Backend {
+    return lb.Select(Selection{Tried: tried})
+}
+
+// Select возвращает наименее загруженный backend.
+func (lb *LeastConnectionsLoadBalancer) Select(sel Selection) *Backend {
+    return lb.Pick(sel, lb.choose)
+}
+
+func (lb *LeastConnectionsLoadBalancer) choose(candidates []*Backend, _ Selection) *Backend {
+    start := atomic.AddUint32(&lb.currentIndex, 1) % uint32(len(candidates))
+    var best *Backend
+    for i := range candidates {
+        backend := candidates[(int(start)+i)%len(candidates)]
+        if best == nil || backend.ActiveConnections.Load() < best.ActiveConnections.Load() {
+            best = backend
+        }
+    }
+    return best
+}
+
+// WeightedLeastConnectionsLoadBalancer направляет запрос на backend с наименьшим числом
+// активных запросов на единицу веса, поэтому под постоянной нагрузкой backend'ы держат
+// число соединений пропорционально весам. При равенстве предпочитается backend с большим
+// весом, а при равных весах backend'ы чередуются по кругу. С adaptive_weights используются
+// эффективные веса (см. EffectiveWeight).
+type WeightedLeastConnectionsLoadBalancer struct {
+    *Pool
+    currentIndex uint32 // Позиция, с которой начинается просмотр кандидатов
+}
+
+// NewWeightedLeastConnectionsLoadBalancer создает балансировщик по наименьшему числу соединений на единицу веса.
+func NewWeightedLeastConnectionsLoadBalancer(backendConfigs []config.BackendConfig, healthCheck config.HealthCheckConfig, logger *zap.SugaredLogger) *WeightedLeastConnectionsLoadBalancer {
+    return &WeightedLeastConnectionsLoadBalancer{
+        Pool:         NewPool(backendConfigs, healthCheck, logger),
+        currentIndex: startOffset(),
+    }
+}
+
+// NextAvailableBackend возвращает наименее загруженный с учетом веса backend.
+func (lb *WeightedLeastConnectionsLoadBalancer) NextAvailableBackend() *Backend {
+    return lb.Select(Selection{})
+}
+
+// NextAvailableBackendExcluding возвращает наименее загруженный с учетом веса backend, пропуская уже опробованные.
+func (lb *WeightedLeastConnectionsLoadBalancer) NextAvailableBackendExcluding(tried map[*Backend]bool) *Backend {
+    return lb.Select(Selection{Tried: tried})
+}
+
+// Select возвращает наименее загруженный с учетом веса backend.
+func (lb *WeightedLeastConnectionsLoadBalancer) Select(sel Selection) *Backend {
+    return lb.Pick(sel, lb.choose)
+}
+
+func (lb *WeightedLeastConnectionsLoadBalancer) choose(candidates []*Backend, _ Selection) *Backend {
+    start := atomic.AddUint32(&lb.currentIndex, 1) % uint32(len(candidates))
+    var best *Backend
+    var bestActive, bestWeight int64
+    for i := range candidates {
+        backend := candidates[(int(start)+i)%len(candidates)]
+        active, weight := backend.ActiveConnections.Load(), backend.EffectiveWeight()
+        // active/weight < bestActive/bestWeight без деления
+        if best == nil || active*bestWeight < bestActive*weight ||
+            (active*bestWeight == bestActive*weight && weight > bestWeight) {
+            best, bestActive, bestWeight = backend, active, weight
+        }
+    }
+    return best
+}
@@ -0,0 +1,81 @@
+package balancer
+
+import (
+    "math"
+    "sync/atomic"
+    "time"
+
+    "github.com/Manzo48/loadBalancer/internal/config"
+    "go.uber.org/zap"
+)
+
+// LeastResponseTimeLoadBalancer направляет запрос на backend с наименьшей оценкой
+// EWMA задержки × (активные запросы + 1): быстрый, но перегруженный backend
+// уступает более медленному и свободному. Backend без замеров получает оценку 0,
+// поэтому каждый новый backend сначала пробуется. При равенстве backend'ы чередуются по кругу.
+type LeastResponseTimeLoadBalancer struct {
+    *Pool
+    currentIndex uint32 // Позиция, с которой начинается просмотр кандидатов
+}
+
+// NewLeastResponseTimeLoadBalancer создает балансировщик по наименьшему времени ответа.
+func NewLeastResponseTimeLoadBalancer(backendConfigs []config.BackendConfig, healthCheck config.HealthCheckConfig, logger *zap.SugaredLogger) *LeastResponseTimeLoadBalancer {
+    return &LeastResponseTimeLoadBalancer{
+        Pool:         NewPool(backendConfigs, healthCheck, logger),
+        currentIndex: startOffset(),
+    }
+}
+
+// Configure применяет настройки least_response_time.
+func (lb *LeastResponseTimeLoadBalancer) Configure(cfg config.LeastResponseTimeConfig) {
+    lb.SetLatencyDecay(cfg.Decay)
+}
+
+// NextAvailableBackend возвращает backend с наименьшим временем ответа.
+func (lb *LeastResponseTimeLoadBalancer) NextAvailableBackend() *Backend {
+    return lb.Select(Selection{})
+}
+
+// NextAvailableBackendExcluding возвращает backend с наименьшим временем ответа, пропуская уже опробованные.
+func (lb *LeastResponseTimeLoadBalancer) NextAvailableBackendExcluding(tried map[*Backend]bool) *Backend {
+    return lb.Select(Selection{Tried: tried})
+}
+
+// Select возвращает backend с наименьшим временем ответа.
+func (lb *LeastResponseTimeLoadBalancer) Select(sel Selection) *Backend {
+    return lb.Pick(sel, lb.choose)
+}
+
+func (lb *LeastResponseTimeLoadBalancer) choose(candidates []*Backend, _ Selection) *Backend {
+    start := atomic.AddUint32(&lb.currentIndex, 1) % uint32(len(candidates))
+    var best *Backend
+    bestScore := math.Inf(1)
+    for i := range candidates {
+        backend := candidates[(int(start)+i)%len(candidates)]
+        score := float64(backend.LatencyEWMA()) * float64(backend.ActiveConnections.Load()+1)
+        if score < bestScore {
+            best, bestScore = backend, score
+        }
+    }
+    return best
+}
+
+// LatencyEWMA возвращает сглаженную задержку ответа backend'а (0 — замеров еще не было).
+func (b *Backend) LatencyEWMA() time.Duration {
+    return time.Duration(math.Float64frombits(b.latencyEWMA.Load()))
+}
+
+// SetLatencyDecay задает вклад нового замера в EWMA задержки backend'ов пула
+// (0 — значение по умолчанию 0.2). Действует и на backend'ы, добавленные позже.
+func (p *Pool) SetLatencyDecay(decay float64) {
+    p.latencyDecay.Store(math.Float64bits(decay))
+    p.applyLatencyDecay(p.allBackends())
+}
+
+// applyLatencyDecay передает backend'ам коэффициент сглаживания задержки пула.
+func (p *Pool) applyLatencyDecay(backends []*Backend) {
+    decay := p.latencyDecay.Load()
+    for _, backend := range backends {
+        backend.latencyDecay.Store(decay)
+    }
+}
@@ -0,0 +1,214 @@
+package balancer
+
+import (
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "github.com/Manzo48/loadBalancer/internal/config"
+)
+
+const (
+    defaultOutlierInterval      = 10 * time.Second
+    defaultOutlierConsecutive   = 5
+    defaultOutlierMinRequests   = 10
+    defaultOutlierEjectionTime  = 30 * time.Second
+    defaultOutlierMaxEjectedPct = 50
+)
+
+// Причины исключения backend'а (метка reason метрики lb_outlier_ejections_total).
+const (
+    outlierConsecutive5xx = "consecutive_5xx"
+    outlierSuccessRate    = "success_rate"
+    outlierLatency        = "latency"
+)
+
+// outlierDetector исключает из выбора backend'ы, отвечающие заметно хуже остальных
+// backend'ов пула. Один детектор на пул; у каждого backend'а свой outlierTracker.
+type outlierDetector struct {
+    cfg  config.OutlierDetectionConfig // С подставленными значениями по умолчанию
+    pool *Pool
+    mu   sync.Mutex // Сериализует решения об исключении (предел max_ejection_percent)
+}
+
+// outlierTracker — счетчики одного backend'а. Методы nil-безопасны: nil означает,
+// что outlier detection выключен.
+type outlierTracker struct {
+    detector     *outlierDetector
+    consecutive  atomic.Int64 // Ошибок и 5xx подряд
+    requests     atomic.Int64 // Ответов за текущий интервал анализа
+    failures     atomic.Int64 // Из них ошибок и 5xx
+    ejectedUntil atomic.Int64 // Конец исключения (UnixNano, 0 — не исключен)
+}
+
+// ConfigureOutlierDetection включает outlier detection для всех backend'ов пула,
+// в том числе добавленных позже, и запускает периодический анализ.
+func (p *Pool) ConfigureOutlierDetection(cfg config.OutlierDetectionConfig) {
+    if !cfg.Enabled {
+        return
+    }
+    if cfg.Interval <= 0 {
+        cfg.Interval = defaultOutlierInterval
+    }
+    if cfg.Consecutive5xx <= 0 {
+        cfg.Consecutive5xx = defaultOutlierConsecutive
+    }
+    if cfg.MinRequests <= 0 {
+        cfg.MinRequests = defaultOutlierMinRequests
+    }
+    if cfg.EjectionTime <= 0 {
+        cfg.EjectionTime = defaultOutlierEjectionTime
+    }
+    if cfg.MaxEjectionPercent <= 0 {
+        cfg.MaxEjectionPercent = defaultOutlierMaxEjectedPct
+    }
+    detector := &outlierDetector{cfg: cfg, pool: p}
+    p.outlier.Store(detector)
+    p.attachOutlierTrackers(p.allBackends())
+    go detector.run()
+}
+
+// attachOutlierTrackers создает счетчики outlier detection для backend'ов, если он включен.
+func (p *Pool) attachOutlierTrackers(backends []*Backend) {
+    detector := p.outlier.Load()
+    if detector == nil {
+        return
+    }
+    for _, backend := range backends {
+        if backend.outlier == nil {
+            backend.outlier = &outlierTracker{detector: detector}
+        }
+    }
+}
+
+// ObserveOutcome учитывает результат запроса к backend'у для outlier detection:
+// ok — ответ получен и его код меньше 500. Серия ошибок длиной consecutive_5xx
+// исключает backend сразу, не дожидаясь периодического анализа.
+func (b *Backend) ObserveOutcome(ok bool) {
+    tracker := b.outlier
+    if tracker == nil {
+        return
+    }
+    tracker.requests.Add(1)
+    if ok {
+        tracker.consecutive.Store(0)
+        return
+    }
+    tracker.failures.Add(1)
+    if tracker.consecutive.Add(1) >= int64(tracker.detector.cfg.Consecutive5xx) {
+        tracker.detector.eject(b, outlierConsecutive5xx)
+    }
+}
+
+// Ejected сообщает, исключен ли backend outlier detection'ом, и до какого времени.
+func (b *Backend) Ejected() (time.Time, bool) {
+    if b.outlier == nil {
+        return time.Time{}, false
+    }
+    until := b.outlier.ejectedUntil.Load()
+    if until == 0 || time.Now().UnixNano() >= until {
+        return time.Time{}, false
+    }
+    return time.Unix(0, until), true
+}
+
+// ejected — Ejected без времени, для Pick.
+func (t *outlierTracker) ejected() bool {
+    if t == nil {
+        return false
+    }
+    until := t.ejectedUntil.Load()
+    return until != 0 && time.Now().UnixNano() < until
+}
+
+// eject исключает backend на ejection_time, если пул не превысит max_ejection_percent.
+func (d *outlierDetector) eject(b *Backend, reason string) {
+    d.mu.Lock()
+    defer d.mu.Unlock()
+
+    if b.outlier.ejected() {
+        return
+    }
+    backends := d.pool.PrimaryBackends()
+    ejected := 0
+    for _, backend := range backends {
+        if backend.outlier.ejected() {
+            ejected++
+        }
+    }
+    if (ejected+1)*100 > d.cfg.MaxEjectionPercent*len(backends) {
+        d.pool.logger.Warnf("Outlier detection: backend %s is an outlier (%s), but %d of %d backends are already ejected",
+            b.Address, reason, ejected, len(backends))
+        return
+    }
+
+    b.outlier.ejectedUntil.Store(time.Now().Add(d.cfg.EjectionTime).UnixNano())
+    b.outlier.consecutive.Store(0)
+    d.pool.logger.Warnf("Outlier detection: ejecting backend %s for %s (%s)", b.Address, d.cfg.EjectionTime, reason)
+    m := d.pool.metricsSink()
+    m.Inc("lb_outlier_ejections_total", "backend", b.Address.String(), "reason", reason)
+    m.Set("lb_outlier_ejected", 1, "backend", b.Address.String())
+}
+
+// run каждые interval сравнивает успешность и задержку backend'ов с остальными backend'ами пула.
+func (d *outlierDetector) run() {
+    ticker := time.NewTicker(d.cfg.Interval)
+    defer ticker.Stop()
+
+    for range ticker.C {
+        d.analyze()
+    }
+}
+
+// outlierSample — показатели backend'а за интервал анализа.
+type outlierSample struct {
+    backend     *Backend
+    successRate float64
+    latency     float64
+}
+
+// analyze сравнивает показатели каждого backend'а, получившего не меньше min_requests
+// запросов за интервал, со средними по остальным таким backend'ам.
+func (d *outlierDetector) analyze() {
+    var samples []outlierSample
+    for _, backend := range d.pool.PrimaryBackends() {
+        tracker := backend.outlier
+        if tracker == nil {
+            continue
+        }
+        if !tracker.ejected() && tracker.ejectedUntil.Swap(0) != 0 {
+            d.pool.logger.Infof("Outlier detection: backend %s returned to rotation", backend.Address)
+            d.pool.metricsSink().Set("lb_outlier_ejected", 0, "backend", backend.Address.String())
+        }
+        requests, failures := tracker.requests.Swap(0), tracker.failures.Swap(0)
+        if requests < int64(d.cfg.MinRequests) || tracker.ejected() {
+            continue
+        }
+        samples = append(samples, outlierSample{
+            backend:     backend,
+            successRate: 1 - float64(failures)/float64(requests),
+            latency:     float64(backend.LatencyEWMA()),
+        })
+    }
+    if len(samples) < 2 {
+        // Сравнивать не с чем
+        return
+    }
+
+    var totalSuccess, totalLatency float64
+    for _, sample := range samples {
+        totalSuccess += sample.successRate
+        totalLatency += sample.latency
+    }
+    others := float64(len(samples) - 1)
+    for _, sample := range samples {
+        otherSuccess := (totalSuccess - sample.successRate) / others
+        otherLatency := (totalLatency - sample.latency) / others
+        switch {
+        case d.cfg.SuccessRateThreshold > 0 && sample.successRate < otherSuccess*d.cfg.SuccessRateThreshold:
+            d.eject(sample.backend, outlierSuccessRate)
+        case d.cfg.LatencyFactor > 0 && otherLatency > 0 && sample.latency > otherLatency*d.cfg.LatencyFactor:
+            d.eject(sample.backend, outlierLatency)
+        }
+    }
+}
@@ -0,0 +1,613 @@
+package balancer
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "math"
+    "net/http"
+    "net/url"
+    "regexp"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "github.com/Manzo48/loadBalancer/internal/config"
+    "github.com/Manzo48/loadBalancer/internal/metrics"
+    "go.uber.org/zap"
+)
+
+// Ошибки изменения набора backend'ов на лету.
+var (
+    ErrInvalidBackend  = errors.New("invalid backend")
+    ErrBackendExists   = errors.New("backend already exists")
+    ErrBackendNotFound = errors.New("backend not found")
+    ErrLastBackend     = errors.New("last backend")
+)
+
+// Pool — общая часть всех балансировщиков: набор backend'ов, health-check'и,
+// атомарная замена набора и резервный пул. Стратегии встраивают *Pool и реализуют
+// только выбор backend'а среди кандидатов (см. Pick).
+type Pool struct {
+    backends  atomic.Pointer[[]*Backend] // Список backend-серверов (неизменяемый, заменяется целиком)
+    logger    *zap.SugaredLogger         // Логгер
+    replaceMu sync.Mutex                 // Сериализует замены набора backend'ов
+
+    healthCheckInterval time.Duration                   // Интервал между health-check запросами (0 — проверки выключены)
+    healthCheckTimeout  time.Duration                   // Таймаут запроса health-check
+    healthCheckType     string                          // http | tcp
+    healthCheckPath     string                          // Путь health-check запроса
+    healthCheckAbsolute bool                            // Путь задан от корня хоста, а не от базового пути backend'а
+    healthyStatuses     map[int]bool                    // Коды ответа, при которых backend жив
+    expectedBody        *regexp.Regexp                  // Ожидаемое содержимое тела ответа (nil — тело не проверяется)
+    nextHealthCheck     atomic.Int64                    // Время следующего цикла health-check (UnixNano)
+    probeSlots          chan struct{}                   // Семафор одновременных probe (nil — без ограничения)
+    startUnhealthy      bool                            // Новые backend'ы недоступны до первой успешной проверки
+    backoff             config.HealthCheckBackoffConfig // Пауза между проверками недоступного backend'а (Max 0 — выключена)
+    slowStart           time.Duration                   // Разгон трафика восстановившегося backend'а (0 — выключен)
+
+    standby       atomic.Pointer[[]*Backend] // Резервный пул, включаемый только под высокой нагрузкой
+    standbyActive atomic.Bool                // Участвует ли резервный пул в ротации
+
+    weightsVersion atomic.Uint64 // Увеличивается при каждом изменении веса backend'а
+
+    certWarnBefore time.Duration                   // Предупреждать, если сертификат истекает раньше
+    certFailBefore time.Duration                   // Считать backend недоступным, если сертификат истекает раньше
+    metrics        atomic.Pointer[metrics.Metrics] // Получатель метрик (nil — no-op)
+
+    breakerCfg   atomic.Pointer[config.CircuitBreakerConfig] // Настройки circuit breaker (nil — выключен)
+    outlier      atomic.Pointer[outlierDetector]             // Outlier detection (nil — выключен)
+    latencyDecay atomic.Uint64                               // Вклад нового замера в EWMA задержки (float64-биты, 0 — по умолчанию)
+}
+
+// NewPool создает пул backend'ов и запускает цикл health-check.
+func NewPool(backendConfigs []config.BackendConfig, healthCheck config.HealthCheckConfig, logger *zap.SugaredLogger) *Pool {
+    pool := &Pool{
+        logger:               logger,
+        healthCheckInterval:  healthCheck.EffectiveInterval(),
+        healthCheckTimeout:   config.DefaultHealthCheckTimeout,
+        healthCheckPath:      healthCheck.Path,
+        healthCheckAbsolute:  healthCheck.AbsolutePath,
+        healthCheckType:      healthCheck.Type,
+    }
+    if pool.healthCheckPath == "" {
+        pool.healthCheckPath = "/health"
+    }
+    pool.healthyStatuses = statusSet(healthCheck.HealthyStatuses)
+    switch {
+    case healthCheck.ExpectedBodyRegex != "":
+        // Выражение уже проверено в config.Load; некорректное в конфигурации из кода отключает проверку тела
+        if re, err := regexp.Compile(healthCheck.ExpectedBodyRegex); err == nil {
+            pool.expectedBody = re
+        } else {
+            logger.Errorf("Invalid health_check.expected_body_regex, body is not checked: %v", err)
+        }
+    case healthCheck.ExpectedBody != "":
+        pool.expectedBody = regexp.MustCompile(regexp.QuoteMeta(healthCheck.ExpectedBody))
+    }
+    if healthCheck.Timeout > 0 {
+        pool.healthCheckTimeout = healthCheck.Timeout
+    }
+    pool.certWarnBefore = healthCheck.CertExpiry.WarnBefore
+    pool.certFailBefore = healthCheck.CertExpiry.FailBefore
+    if healthCheck.MaxConcurrent > 0 {
+        pool.probeSlots = make(chan struct{}, healthCheck.MaxConcurrent)
+    }
+
+    pool.startUnhealthy = healthCheck.InitialState == "unhealthy" && pool.healthCheckInterval > 0
+    pool.backoff = healthCheck.Backoff
+    pool.slowStart = healthCheck.SlowStart
+    if pool.backoff.Base <= 0 {
+        pool.backoff.Base = pool.healthCheckInterval
+    }
+    if pool.backoff.Multiplier < 1 {
+        pool.backoff.Multiplier = 2
+    }
+
+    backends := parseBackends(backendConfigs, logger)
+    pool.applyInitialState(backends)
+    pool.backends.Store(&backends)
+
+    if pool.healthCheckInterval > 0 {
+        go pool.runHealthCheckLoop()
+    } else {
+        logger.Warn("Health checks are disabled: backends stay in rotation regardless of failures")
+    }
+
+    return pool
+}
+
+// Backends возвращает текущий набор backend'ов в ротации (основной пул и, если активен, резервный).
+func (p *Pool) Backends() []*Backend {
+    if p.standbyActive.Load() {
+        return p.allBackends()
+    }
+    return *p.backends.Load()
+}
+
+// PrimaryBackends возвращает основной пул без резервного.
+func (p *Pool) PrimaryBackends() []*Backend {
+    return *p.backends.Load()
+}
+
+// Pick отбирает доступных кандидатов (живые, не выводимые из ротации, с ненулевым весом,
+// с незакрытым для запросов circuit breaker'ом, не исключенные outlier detection'ом
+// и еще не опробованные для запроса), отсеивает часть восстанавливающихся (slow start)
+// и передает их функции выбора стратегии.
+func (p *Pool) Pick(sel Selection, choose func(candidates []*Backend, sel Selection) *Backend) *Backend {
+    backends := p.Backends()
+    candidates := make([]*Backend, 0, len(backends))
+    for _, backend := range backends {
+        if backend.IsAlive.Load() && !backend.draining.Load() && backend.Weight.Load() > 0 &&
+            !sel.Tried[backend] && backend.breaker.ready() && !backend.outlier.ejected() {
+            candidates = append(candidates, backend)
+        }
+    }
+    candidates = p.applySlowStart(candidates)
+
+    for len(candidates) > 0 {
+        selected := choose(candidates, sel)
+        if selected == nil {
+            return nil
+        }
+        if selected.breaker.acquire() {
+            p.logger.Debugf("Backend selected: %s", selected.Address)
+            return selected
+        }
+        // Пробный запрос half-open уже занят другим запросом — выбираем среди остальных
+        candidates = without(candidates, selected)
+    }
+
+    p.logger.Warn("No healthy backends available")
+    return nil
+}
+
+// without возвращает копию списка без указанного backend'а.
+func without(backends []*Backend, excluded *Backend) []*Backend {
+    rest := make([]*Backend, 0, len(backends))
+    for _, backend := range backends {
+        if backend != excluded {
+            rest = append(rest, backend)
+        }
+    }
+    return rest
+}
+
+// parseBackends разбирает список backend'ов, пропуская некорректные URL.
+func parseBackends(backendConfigs []config.BackendConfig, logger *zap.SugaredLogger) []*Backend {
+    backends := make([]*Backend, 0, len(backendConfigs))
+    for _, backendConfig := range backendConfigs {
+        parsedURL, err := url.Parse(backendConfig.URL)
+        if err != nil {
+            logger.Warnf("Invalid backend URL %s: %v", backendConfig.URL, err)
+            continue
+        }
+
+        backend := &Backend{
+            Address:      parsedURL,
+            SignRequests: backendConfig.SignRequests,
+            Region:       backendConfig.Region,
+
+            SensitiveHeaders: backendConfig.SensitiveHeaders,
+            config:           backendConfig,
+        }
+        if backendConfig.TLS != nil {
+            if backend.Transport, err = newUpstreamTransport(backendConfig.TLS); err != nil {
+                logger.Warnf("Invalid TLS settings for backend %s: %v", backendConfig.URL, err)
+                continue
+            }
+        }
+        if hc := backendConfig.HealthCheck; hc != nil {
+            backend.healthCheckType = hc.Type
+            backend.healthCheckPath = hc.Path
+            if len(hc.HealthyStatuses) > 0 {
+                backend.healthyStatuses = statusSet(hc.HealthyStatuses)
+            }
+        }
+        if backendConfig.MaxConcurrent > 0 {
+            backend.slots = make(chan struct{}, backendConfig.MaxConcurrent)
+        }
+        backend.IsAlive.Store(true) // Считаем, что backend жив на старте
+        backend.Weight.Store(int64(backendConfig.EffectiveWeight()))
+        backends = append(backends, backend)
+
+        logger.Infof("Backend registered: %s", parsedURL.String())
+    }
+    return backends
+}
+
+// applyInitialState помечает новые backend'ы недоступными, если они должны
+// пройти проверку до первого запроса (health_check.initial_state: unhealthy).
+func (p *Pool) applyInitialState(backends []*Backend) {
+    if !p.startUnhealthy {
+        return
+    }
+    for _, backend := range backends {
+        backend.IsAlive.Store(false)
+    }
+}
+
+// runHealthCheckLoop периодически проверяет доступность всех backend'ов.
+// Если backend'ы стартуют недоступными, первый цикл выполняется сразу, не дожидаясь интервала.
+func (p *Pool) runHealthCheckLoop() {
+    client := &http.Client{Timeout: p.healthCheckTimeout}
+    ticker := time.NewTicker(p.healthCheckInterval)
+    defer ticker.Stop()
+
+    p.nextHealthCheck.Store(time.Now().Add(p.healthCheckInterval).UnixNano())
+    if p.startUnhealthy {
+        p.runHealthCheckCycle(client)
+    }
+    for range ticker.C {
+        p.nextHealthCheck.Store(time.Now().Add(p.healthCheckInterval).UnixNano())
+        p.runHealthCheckCycle(client)
+    }
+}
+
+// runHealthCheckCycle запускает probe для всех backend'ов, кроме отложенных backoff'ом.
+// Проверки выполняются на тиках интервала, поэтому срок, наступающий в пределах
+// половины интервала, считается наступившим.
+func (p *Pool) runHealthCheckCycle(client *http.Client) {
+    dueBy := time.Now().Add(p.healthCheckInterval / 2)
+    for _, backend := range p.allBackends() {
+        if probe := backend.lastProbe.Load(); probe != nil && probe.next.After(dueBy) {
+            continue
+        }
+        // Не накладываем probe друг на друга, если предыдущий еще не завершился
+        if !backend.probeInFlight.CompareAndSwap(false, true) {
+            p.logger.Warnf("Skipping health check for %s: previous probe still in flight", backend.Address)
+            continue
+        }
+        go p.runProbe(client, backend)
+    }
+}
+
+// runProbe выполняет плановый health-check, соблюдая глобальный предел одновременных probe.
+func (p *Pool) runProbe(client *http.Client, b *Backend) {
+    defer b.probeInFlight.Store(false)
+
+    if p.probeSlots != nil {
+        p.probeSlots <- struct{}{}
+        defer func() { <-p.probeSlots }()
+    }
+    p.checkBackend(client, b)
+}
+
+// checkBackend выполняет один health-check (HTTP или TCP, см. health_check.type)
+// и обновляет состояние backend'а. Контекст с таймаутом покрывает весь probe — DNS,
+// установку соединения и ответ, — поэтому зависший резолвинг не держит горутину дольше таймаута.
+func (p *Pool) checkBackend(client *http.Client, b *Backend) bool {
+    ctx, cancel := context.WithTimeout(context.Background(), p.healthCheckTimeout)
+    defer cancel()
+
+    var reason string
+    if p.checkType(b) == config.HealthCheckTCP {
+        reason = probeTCP(ctx, b)
+    } else {
+        reason = p.probeHTTP(ctx, client, b)
+    }
+    isHealthy := reason == ""
+    b.SetAlive(isHealthy)
+    b.recordProbe(isHealthy, reason, p.backoffDelay)
+    p.reportAlive(b)
+
+    if isHealthy {
+        p.logger.Debugf("Health check passed: %s", b.Address)
+    } else {
+        p.logger.Warnf("Health check failed: %s (%s)", b.Address, reason)
+    }
+    return isHealthy
+}
+
+// backoffDelay возвращает паузу до следующей проверки после failures неудач подряд
+// (0 — следующий цикл, backoff выключен).
+func (p *Pool) backoffDelay(failures int) time.Duration {
+    if p.backoff.Max <= 0 || failures <= 0 {
+        return 0
+    }
+    delay := float64(p.backoff.Base) * math.Pow(p.backoff.Multiplier, float64(failures-1))
+    if delay >= float64(p.backoff.Max) {
+        return p.backoff.Max
+    }
+    return time.Duration(delay)
+}
+
+// checkType возвращает тип health-check'а backend'а: собственный или общий.
+func (p *Pool) checkType(b *Backend) string {
+    if b.healthCheckType != "" {
+        return b.healthCheckType
+    }
+    return p.healthCheckType
+}
+
+// probeHTTP выполняет GET по пути проверки и возвращает причину неудачи ("" — backend жив).
+func (p *Pool) probeHTTP(ctx context.Context, client *http.Client, b *Backend) string {
+    if b.Transport != nil {
+        client = &http.Client{Transport: b.Transport, Timeout: client.Timeout}
+    }
+
+    var response *http.Response
+    request, err := http.NewRequestWithContext(ctx, http.MethodGet, p.healthCheckURL(b), nil)
+    if err == nil {
+        response, err = client.Do(request)
+    }
+    if response != nil {
+        defer response.Body.Close()
+    }
+
+    b.recordCertExpiry(response, err)
+    switch {
+    case err != nil:
+        return err.Error()
+    case !p.healthyStatus(b, response.StatusCode):
+        return fmt.Sprintf("unexpected status %d", response.StatusCode)
+    case p.expectedBody != nil && !p.bodyMatches(response):
+        return "response body does not match expected_body"
+    case !p.checkCertExpiry(b):
+        return "certificate expires too soon"
+    }
+    return ""
+}
+
+// maxHealthCheckBody — сколько байт тела ответа health-check читается для expected_body.
+const maxHealthCheckBody = 64 << 10
+
+// bodyMatches проверяет первые maxHealthCheckBody байт тела ответа на expected_body.
+func (p *Pool) bodyMatches(response *http.Response) bool {
+    body, err := io.ReadAll(io.LimitReader(response.Body, maxHealthCheckBody))
+    if err != nil {
+        return false
+    }
+    return p.expectedBody.Match(body)
+}
+
+// healthCheckURL строит адрес проверки. Backend'ы одного хоста с разными базовыми путями
+// (http://app/service-a, http://app/service-b) по умолчанию проверяются по своему пути
+// (/service-a/health), а с absolute_path — по общему пути от корня хоста.
+func (p *Pool) healthCheckURL(b *Backend) string {
+    target := *b.Address
+    target.RawQuery = ""
+    target.Fragment = ""
+    path := p.healthCheckPath
+    if b.healthCheckPath != "" {
+        path = b.healthCheckPath
+    }
+    if p.healthCheckAbsolute {
+        target.Path = path
+    } else {
+        target.Path = strings.TrimSuffix(target.Path, "/") + path
+    }
+    target.RawPath = ""
+    return target.String()
+}
+
+// healthyStatus проверяет код ответа health-check по списку backend'а или общему списку.
+func (p *Pool) healthyStatus(b *Backend, status int) bool {
+    if b.healthyStatuses != nil {
+        return b.healthyStatuses[status]
+    }
+    return p.healthyStatuses[status]
+}
+
+// statusSet строит множество кодов ответа; пустой список означает только 200.
+func statusSet(statuses []int) map[int]bool {
+    if len(statuses) == 0 {
+        return map[int]bool{http.StatusOK: true}
+    }
+    set := make(map[int]bool, len(statuses))
+    for _, status := range statuses {
+        set[status] = true
+    }
+    return set
+}
+
+// MarkBackendUnhealthy помечает указанный backend как недоступный. Без health-check'ов
+// вернуть backend в ротацию было бы некому, поэтому тогда пометка не выполняется.
+func (p *Pool) MarkBackendUnhealthy(target *url.URL) {
+    if p.healthCheckInterval == 0 {
+        return
+    }
+    for _, backend := range p.allBackends() {
+        if backend.Address.String() == target.String() {
+            backend.IsAlive.Store(false)
+            p.reportAlive(backend)
+            p.logger.Warnf("Backend marked as unhealthy: %s", target)
+            return
+        }
+    }
+}
+
+// RecoveryEstimate оценивает, через сколько недоступные backend'ы могут вернуться в ротацию:
+// ближайший цикл health-check (с учетом backoff'а) плюс время на сам probe. false — оценки нет.
+func (p *Pool) RecoveryEstimate() (time.Duration, bool) {
+    next := p.nextHealthCheck.Load()
+    if next == 0 {
+        return 0, false
+    }
+    // Backoff откладывает проверки недоступных backend'ов: считаем по самой ранней из них
+    earliest := int64(0)
+    for _, backend := range p.allBackends() {
+        if backend.IsAlive.Load() {
+            continue
+        }
+        due := next
+        if probe := backend.lastProbe.Load(); probe != nil && probe.next.UnixNano() > due {
+            due = probe.next.UnixNano()
+        }
+        if earliest == 0 || due < earliest {
+            earliest = due
+        }
+    }
+    if earliest > next {
+        next = earliest
+    }
+    wait := time.Until(time.Unix(0, next))
+    if wait < 0 {
+        return 0, false
+    }
+    return wait + p.healthCheckTimeout, true
+}
+
+// ReplaceBackends атомарно заменяет весь основной пул новым набором (blue-green).
+// Новый набор сначала проходит health-check; если ни один backend не здоров, замена отменяется.
+// Старые backend'ы сразу перестают получать новые запросы, а уже начатые запросы дорабатывают.
+func (p *Pool) ReplaceBackends(backendConfigs []config.BackendConfig) error {
+    p.replaceMu.Lock()
+    defer p.replaceMu.Unlock()
+
+    backends := parseBackends(backendConfigs, p.logger)
+    if len(backends) == 0 {
+        return fmt.Errorf("no valid backends in the new set")
+    }
+    p.attachBreakers(backends)
+    p.attachOutlierTrackers(backends)
+    p.applyLatencyDecay(backends)
+
+    client := &http.Client{Timeout: p.healthCheckTimeout}
+    var wg sync.WaitGroup
+    var healthy atomic.Int32
+    for _, backend := range backends {
+        if p.healthCheckInterval == 0 {
+            // Проверки выключены: failed probe некому было бы исправить
+            healthy.Add(1)
+            continue
+        }
+        wg.Add(1)
+        go func(b *Backend) {
+            defer wg.Done()
+            if p.checkBackend(client, b) {
+                healthy.Add(1)
+            }
+        }(backend)
+    }
+    wg.Wait()
+
+    if healthy.Load() == 0 {
+        return fmt.Errorf("none of the %d new backends passed the initial health check", len(backends))
+    }
+
+    old := p.backends.Swap(&backends)
+    p.logger.Infof("Backend set replaced: %d backends (%d healthy), %d previous backends draining",
+        len(backends), healthy.Load(), len(*old))
+
+    go p.waitForDrain(*old)
+    return nil
+}
+
+// AddBackend добавляет backend в основной пул на лету. Новый backend сразу участвует
+// в ротации (считается живым, если не задан initial_state: unhealthy) и сразу же проходит
+// health-check, не дожидаясь следующего цикла.
+func (p *Pool) AddBackend(backendConfig config.BackendConfig) error {
+    p.replaceMu.Lock()
+    defer p.replaceMu.Unlock()
+
+    parsed := parseBackends([]config.BackendConfig{backendConfig}, p.logger)
+    if len(parsed) == 0 {
+        return fmt.Errorf("%w: %s", ErrInvalidBackend, backendConfig.URL)
+    }
+    backend := parsed[0]
+    current := *p.backends.Load()
+    for _, existing := range current {
+        if existing.Address.String() == backend.Address.String() {
+            return fmt.Errorf("%w: %s", ErrBackendExists, backend.Address)
+        }
+    }
+    p.attachBreakers(parsed)
+    p.attachOutlierTrackers(parsed)
+    p.applyLatencyDecay(parsed)
+    p.applyInitialState(parsed)
+
+    // Срез не изменяется на месте: Pick читает его без блокировок
+    backends := make([]*Backend, 0, len(current)+1)
+    backends = append(append(backends, current...), backend)
+    p.backends.Store(&backends)
+    p.weightsVersion.Add(1)
+    p.reportAlive(backend)
+    p.logger.Infof("Backend added: %s (%d backends)", backend.Address, len(backends))
+
+    if p.healthCheckInterval > 0 && backend.probeInFlight.CompareAndSwap(false, true) {
+        go p.runProbe(&http.Client{Timeout: p.healthCheckTimeout}, backend)
+    }
+    return nil
+}
+
+// RemoveBackend выводит backend из основного пула. Начатые на нем запросы дорабатывают.
+// Последний backend удалить нельзя — для полной замены набора есть ReplaceBackends.
+func (p *Pool) RemoveBackend(address string) error {
+    p.replaceMu.Lock()
+    defer p.replaceMu.Unlock()
+
+    current := *p.backends.Load()
+    backends := make([]*Backend, 0, len(current))
+    var removed *Backend
+    for _, backend := range current {
+        if removed == nil && backend.Address.String() == address {
+            removed = backend
+            continue
+        }
+        backends = append(backends, backend)
+    }
+    if removed == nil {
+        return fmt.Errorf("%w: %s", ErrBackendNotFound, address)
+    }
+    if len(backends) == 0 {
+        return fmt.Errorf("%w: cannot remove the last backend %s", ErrLastBackend, address)
+    }
+
+    p.backends.Store(&backends)
+    p.weightsVersion.Add(1)
+    p.logger.Infof("Backend removed: %s (%d backends left)", address, len(backends))
+
+    go p.waitForDrain([]*Backend{removed})
+    return nil
+}
+
+// SetBackendDraining включает или снимает режим вывода backend'а из ротации. Выводимый
+// backend не получает новых запросов, начатые на нем дорабатывают. Режим не снимается
+// health-check'ами — только повторным вызовом.
+func (p *Pool) SetBackendDraining(address string, draining bool) error {
+    for _, backend := range p.allBackends() {
+        if backend.Address.String() == address {
+            if backend.draining.Swap(draining) != draining {
+                if draining {
+                    p.logger.Infof("Backend %s is draining (%d active connections)", address, backend.ActiveConnections.Load())
+                } else {
+                    p.logger.Infof("Backend %s returned to rotation", address)
+                }
+            }
+            return nil
+        }
+    }
+    return fmt.Errorf("%w: %s", ErrBackendNotFound, address)
+}
+
+// waitForDrain дожидается завершения запросов, начатых на выведенных из ротации backend'ах.
+func (p *Pool) waitForDrain(backends []*Backend) {
+    ticker := time.NewTicker(100 * time.Millisecond)
+    defer ticker.Stop()
+
+    for range ticker.C {
+        var active int64
+        for _, backend := range backends {
+            active += backend.ActiveConnections.Load()
+        }
+        if active == 0 {
+            p.logger.Infof("Previous backend set drained (%d backends)", len(backends))
+            return
+        }
+    }
+}
+
+// allBackends возвращает основной и резервный пулы вместе.
+func (p *Pool) allBackends() []*Backend {
+    backends := *p.backends.Load()
+    standby := p.standby.Load()
+    if standby == nil || len(*standby) == 0 {
+        return backends
+    }
+    all := make([]*Backend, 0, len(backends)+len(*standby))
+    all = append(all, backends...)
+    return append(all, *standby...)
+}
@@ -0,0 +1,83 @@
+package balancer
+
+import (
+    "math/rand"
+
+    "github.com/Manzo48/loadBalancer/internal/config"
+    "go.uber.org/zap"
+)
+
+// RandomLoadBalancer выбирает backend случайно. В отличие от round-robin, у него нет
+// общего счетчика, за который конкурируют горутины на больших пулах.
+type RandomLoadBalancer struct {
+    *Pool
+}
+
+// NewRandomLoadBalancer создает балансировщик со случайным выбором backend'а.
+func NewRandomLoadBalancer(backendConfigs []config.BackendConfig, healthCheck config.HealthCheckConfig, logger *zap.SugaredLogger) *RandomLoadBalancer {
+    return &RandomLoadBalancer{Pool: NewPool(backendConfigs, healthCheck, logger)}
+}
+
+// NextAvailableBackend возвращает случайный доступный backend.
+func (lb *RandomLoadBalancer) NextAvailableBackend() *Backend {
+    return lb.Select(Selection{})
+}
+
+// NextAvailableBackendExcluding возвращает случайный backend, пропуская уже опробованные.
+func (lb *RandomLoadBalancer) NextAvailableBackendExcluding(tried map[*Backend]bool) *Backend {
+    return lb.Select(Selection{Tried: tried})
+}
+
+// Select возвращает случайный доступный backend.
+func (lb *RandomLoadBalancer) Select(sel Selection) *Backend {
+    return lb.Pick(sel, chooseRandom)
+}
+
+func chooseRandom(candidates []*Backend, _ Selection) *Backend {
+    return candidates[rand.Intn(len(candidates))]
+}
+
+// P2CLoadBalancer реализует power of two choices: из двух случайных backend'ов выбирается
+// тот, у которого меньше активных запросов. Распределение близко к least_connections,
+// но без просмотра всех backend'ов и без общего счетчика.
+type P2CLoadBalancer struct {
+    *Pool
+}
+
+// NewP2CLoadBalancer создает балансировщик power of two choices.
+func NewP2CLoadBalancer(backendConfigs []config.BackendConfig, healthCheck config.HealthCheckConfig, logger *zap.SugaredLogger) *P2CLoadBalancer {
+    return &P2CLoadBalancer{Pool: NewPool(backendConfigs, healthCheck, logger)}
+}
+
+// NextAvailableBackend возвращает менее загруженный из двух случайных backend'ов.
+func (lb *P2CLoadBalancer) NextAvailableBackend() *Backend {
+    return lb.Select(Selection{})
+}
+
+// NextAvailableBackendExcluding возвращает менее загруженный из двух случайных backend'ов,
+// пропуская уже опробованные.
+func (lb *P2CLoadBalancer) NextAvailableBackendExcluding(tried map[*Backend]bool) *Backend {
+    return lb.Select(Selection{Tried: tried})
+}
+
+// Select возвращает менее загруженный из двух случайных backend'ов.
+func (lb *P2CLoadBalancer) Select(sel Selection) *Backend {
+    return lb.Pick(sel, chooseP2C)
+}
+
+func chooseP2C(candidates []*Backend, _ Selection) *Backend {
+    if len(candidates) == 1 {
+        return candidates[0]
+    }
+    // Два разных индекса: второй выбирается среди оставшихся n-1 и сдвигается за первый
+    i := rand.Intn(len(candidates))
+    j := rand.Intn(len(candidates) - 1)
+    if j >= i {
+        j++
+    }
+    first, second := candidates[i], candidates[j]
+    if second.ActiveConnections.Load() < first.ActiveConnections.Load() {
+        return second
+    }
+    return first
+}
@@ -0,0 +1,44 @@
+package balancer
+
+import (
+    "fmt"
+    "sync"
+
+    "github.com/Manzo48/loadBalancer/internal/config"
+    "go.uber.org/zap"
+)
+
+// Constructor создает балансировщик пользовательской стратегии с теми же аргументами,
+// что и встроенные (см. New).
+type Constructor func(backendConfigs []config.BackendConfig, healthCheck config.HealthCheckConfig, logger *zap.SugaredLogger) LoadBalancer
+
+var (
+    registryMu sync.RWMutex
+    registry   = make(map[string]Constructor)
+)
+
+// Register регистрирует пользовательскую стратегию: после этого ее имя допустимо в strategy
+// (в том числе в routes и hosts), а New создает балансировщик через constructor.
+// Вызывается из init() пакета со стратегией; зарегистрированное имя проверяется раньше
+// встроенных стратегий. Пустое имя, nil и повторная регистрация — ошибка программы (panic).
+func Register(name string, constructor Constructor) {
+    registryMu.Lock()
+    defer registryMu.Unlock()
+
+    if name == "" || constructor == nil {
+        panic("balancer: Register requires a name and a constructor")
+    }
+    if _, exists := registry[name]; exists {
+        panic(fmt.Sprintf("balancer: strategy %q registered twice", name))
+    }
+    registry[name] = constructor
+    config.RegisterStrategy(name)
+}
+
+// registered возвращает конструктор зарегистрированной стратегии.
+func registered(name string) (Constructor, bool) {
+    registryMu.RLock()
+    defer registryMu.RUnlock()
+    constructor, ok := registry[name]
+    return constructor, ok
+}
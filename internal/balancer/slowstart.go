@@ -0,0 +1,65 @@
+package balancer
+
+import (
+    "math/rand"
+    "time"
+)
+
+// SetAlive помечает backend живым или недоступным. Переход в живое состояние запоминается:
+// от него отсчитывается slow start (health_check.slow_start).
+func (b *Backend) SetAlive(alive bool) {
+    if !b.IsAlive.Swap(alive) && alive {
+        b.healthySince.Store(time.Now().UnixNano())
+    }
+}
+
+// slowStartUntil возвращает конец slow start backend'а (false — backend получает полную долю трафика).
+func (b *Backend) slowStartUntil(now time.Time, window time.Duration) (time.Time, bool) {
+    if b.slowStartShare(now, window) >= 1 {
+        return time.Time{}, false
+    }
+    return time.Unix(0, b.healthySince.Load()).Add(window), true
+}
+
+// slowStartShare возвращает долю полного трафика backend'а на момент now: после
+// восстановления она растет линейно от 0 до 1 за window.
+func (b *Backend) slowStartShare(now time.Time, window time.Duration) float64 {
+    since := b.healthySince.Load()
+    if since == 0 || window <= 0 {
+        return 1
+    }
+    elapsed := now.Sub(time.Unix(0, since))
+    if elapsed >= window {
+        return 1
+    }
+    return max(float64(elapsed)/float64(window), 0)
+}
+
+// applySlowStart оставляет каждого восстанавливающегося кандидата с вероятностью, равной
+// его доле трафика, поэтому любая стратегия выбирает его тем реже, чем недавнее восстановление.
+// Если отсеялись все кандидаты, возвращается исходный список: отказывать в запросе
+// из-за slow start нельзя.
+func (p *Pool) applySlowStart(candidates []*Backend) []*Backend {
+    if p.slowStart <= 0 || len(candidates) < 2 {
+        return candidates
+    }
+    now := time.Now()
+    var kept []*Backend
+    for i, backend := range candidates {
+        share := backend.slowStartShare(now, p.slowStart)
+        if share >= 1 || rand.Float64() < share {
+            if kept != nil {
+                kept = append(kept, backend)
+            }
+            continue
+        }
+        if kept == nil {
+            // Первый отсеянный кандидат: копируем уже пройденных
+            kept = append(make([]*Backend, 0, len(candidates)), candidates[:i]...)
+        }
+    }
+    if len(kept) == 0 {
+        return candidates
+    }
+    return kept
+}
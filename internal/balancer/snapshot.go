@@ -0,0 +1,102 @@
+package balancer
+
+import (
+    "time"
+)
+
+// BackendStatus — состояние backend'а на момент вызова Snapshot, без внутренних атомарных полей.
+type BackendStatus struct {
+    Address           string     `json:"address"`
+    Alive             bool       `json:"alive"`
+    Standby           bool       `json:"standby,omitempty"`  // Backend из резервного пула
+    Draining          bool       `json:"draining,omitempty"` // Backend выводится из ротации
+    Drained           bool       `json:"drained,omitempty"`  // Выводимый backend дообработал все запросы
+    ActiveConnections int64      `json:"active_connections"`
+    MaxConcurrent     int        `json:"max_concurrent,omitempty"`       // Предел одновременных запросов
+    EjectedUntil      *time.Time `json:"ejected_until,omitempty"`        // Backend исключен outlier detection'ом до этого времени
+    SlowStartUntil    *time.Time `json:"slow_start_until,omitempty"`     // Backend недавно восстановился и получает неполную долю трафика
+    LastCheck         *time.Time `json:"last_check,omitempty"`           // Время последнего health-check
+    LastCheckHealthy  *bool      `json:"last_check_healthy,omitempty"`   // Результат последнего health-check
+    LastCheckError    string     `json:"last_check_error,omitempty"`     // Причина последней неудачи
+    LastSuccess       *time.Time `json:"last_success,omitempty"`         // Последний успешный probe
+    LastFailure       *time.Time `json:"last_failure,omitempty"`         // Последний неудачный probe
+    Failures          int        `json:"consecutive_failures,omitempty"` // Неудачных probe подряд
+    NextCheck         *time.Time `json:"next_check,omitempty"`           // Следующий probe, отложенный backoff'ом
+}
+
+// probeResult — итоги health-check'ов backend'а. Запись заменяется целиком, чтобы
+// Snapshot всегда видел согласованные значения.
+type probeResult struct {
+    at          time.Time
+    healthy     bool
+    err         string
+    lastSuccess time.Time
+    lastFailure time.Time
+    failures    int       // Неудачных проверок подряд
+    next        time.Time // Backoff: раньше этого времени backend не проверяется (нулевое — следующий цикл)
+}
+
+// recordProbe запоминает результат health-check'а и срок следующей проверки по backoff.
+// Вызывается только из probe этого backend'а.
+func (b *Backend) recordProbe(healthy bool, reason string, backoff func(failures int) time.Duration) {
+    now := time.Now()
+    result := probeResult{at: now, healthy: healthy, err: reason}
+    previous := b.lastProbe.Load()
+    if previous != nil {
+        result.lastSuccess, result.lastFailure = previous.lastSuccess, previous.lastFailure
+    }
+    if healthy {
+        result.lastSuccess = now
+    } else {
+        result.lastFailure = now
+        result.failures = 1
+        if previous != nil {
+            result.failures = previous.failures + 1
+        }
+        if delay := backoff(result.failures); delay > 0 {
+            result.next = now.Add(delay)
+        }
+    }
+    b.lastProbe.Store(&result)
+}
+
+// Snapshot возвращает состояние всех backend'ов пула, включая резервные.
+func (p *Pool) Snapshot() []BackendStatus {
+    primary := p.PrimaryBackends()
+    statuses := make([]BackendStatus, 0, len(primary))
+    for i, backend := range p.allBackends() {
+        status := BackendStatus{
+            Address:           backend.Address.String(),
+            Alive:             backend.IsAlive.Load(),
+            Standby:           i >= len(primary),
+            Draining:          backend.draining.Load(),
+            ActiveConnections: backend.ActiveConnections.Load(),
+            MaxConcurrent:     backend.MaxConcurrent(),
+        }
+        status.Drained = status.Draining && status.ActiveConnections == 0
+        if until, ejected := backend.Ejected(); ejected {
+            status.EjectedUntil = &until
+        }
+        if until, warming := backend.slowStartUntil(time.Now(), p.slowStart); warming {
+            status.SlowStartUntil = &until
+        }
+        if probe := backend.lastProbe.Load(); probe != nil {
+            status.LastCheck = &probe.at
+            status.LastCheckHealthy = &probe.healthy
+            status.LastCheckError = probe.err
+            status.LastSuccess = timeOrNil(probe.lastSuccess)
+            status.LastFailure = timeOrNil(probe.lastFailure)
+            status.Failures = probe.failures
+            status.NextCheck = timeOrNil(probe.next)
+        }
+        statuses = append(statuses, status)
+    }
+    return statuses
+}
+
+func timeOrNil(t time.Time) *time.Time {
+    if t.IsZero() {
+        return nil
+    }
+    return &t
+}
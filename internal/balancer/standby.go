@@ -0,0 +1,100 @@
+package balancer
+
+import (
+    "time"
+
+    "github.com/Manzo48/loadBalancer/internal/config"
+    "github.com/Manzo48/loadBalancer/internal/metrics"
+)
+
+const defaultStandbyCheckInterval = 5 * time.Second
+
+// ConfigureStandby регистрирует резервный пул и запускает контроллер, который включает его
+// в ротацию при превышении порогов нагрузки основного пула и выключает ниже нижних порогов.
+func (p *Pool) ConfigureStandby(cfg config.StandbyConfig, m metrics.Metrics) {
+    if len(cfg.Backends) == 0 {
+        return
+    }
+
+    standby := parseBackends(cfg.Backends, p.logger)
+    p.attachBreakers(standby)
+    p.attachOutlierTrackers(standby)
+    p.applyLatencyDecay(standby)
+    p.standby.Store(&standby)
+    m.Set("lb_standby_active", 0)
+
+    interval := cfg.CheckInterval
+    if interval <= 0 {
+        interval = defaultStandbyCheckInterval
+    }
+
+    go p.runStandbyController(cfg, interval, m)
+}
+
+// StandbyActive сообщает, участвует ли резервный пул в ротации.
+func (p *Pool) StandbyActive() bool {
+    return p.standbyActive.Load()
+}
+
+// runStandbyController периодически оценивает загрузку основного пула.
+func (p *Pool) runStandbyController(cfg config.StandbyConfig, interval time.Duration, m metrics.Metrics) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    var lastTotal, lastFailed uint64
+    for range ticker.C {
+        var active int64
+        var alive int
+        var total, failed uint64
+        for _, backend := range *p.backends.Load() {
+            total += backend.TotalRequests.Load()
+            failed += backend.FailedRequests.Load()
+            if backend.IsAlive.Load() {
+                alive++
+                active += backend.ActiveConnections.Load()
+            }
+        }
+
+        // Среднее число активных запросов на живой backend; без живых — пул перегружен
+        connections := float64(active)
+        if alive > 0 {
+            connections /= float64(alive)
+        }
+        errorRate := 0.0
+        if requests := total - lastTotal; requests > 0 {
+            errorRate = float64(failed-lastFailed) / float64(requests)
+        }
+        lastTotal, lastFailed = total, failed
+
+        overloaded := alive == 0 ||
+            (cfg.ActivateConnections > 0 && connections >= cfg.ActivateConnections) ||
+            (cfg.ActivateErrorRate > 0 && errorRate >= cfg.ActivateErrorRate)
+        relieved := alive > 0 &&
+            below(connections, cfg.ActivateConnections, cfg.DeactivateConnections) &&
+            below(errorRate, cfg.ActivateErrorRate, cfg.DeactivateErrorRate)
+
+        switch {
+        case !p.standbyActive.Load() && overloaded:
+            p.standbyActive.Store(true)
+            m.Set("lb_standby_active", 1)
+            m.Inc("lb_standby_activations_total")
+            p.logger.Warnf("Standby pool activated (avg connections %.2f, error rate %.2f)", connections, errorRate)
+        case p.standbyActive.Load() && relieved:
+            p.standbyActive.Store(false)
+            m.Set("lb_standby_active", 0)
+            p.logger.Infof("Standby pool deactivated (avg connections %.2f, error rate %.2f)", connections, errorRate)
+        }
+    }
+}
+
+// below проверяет, что сигнал опустился ниже порога деактивации. Выключенный сигнал
+// (activate <= 0) не мешает деактивации; без явного нижнего порога берется половина верхнего.
+func below(value, activate, deactivate float64) bool {
+    if activate <= 0 {
+        return true
+    }
+    if deactivate <= 0 {
+        deactivate = activate / 2
+    }
+    return value < deactivate
+}
@@ -0,0 +1,28 @@
+package balancer
+
+import (
+    "context"
+    "net"
+)
+
+// probeTCP проверяет, что backend принимает TCP-соединения на host:port, и сразу закрывает
+// соединение. Подходит для backend'ов, не отвечающих по HTTP на путь проверки.
+// Порт по умолчанию берется из схемы URL (80 для http, 443 для https).
+func probeTCP(ctx context.Context, b *Backend) string {
+    address := b.Address.Host
+    if b.Address.Port() == "" {
+        port := "80"
+        if b.Address.Scheme == "https" {
+            port = "443"
+        }
+        address = net.JoinHostPort(b.Address.Hostname(), port)
+    }
+
+    var dialer net.Dialer
+    conn, err := dialer.DialContext(ctx, "tcp", address)
+    if err != nil {
+        return err.Error()
+    }
+    conn.Close()
+    return ""
+}
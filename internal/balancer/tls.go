@@ -0,0 +1,56 @@
+package balancer
+
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "fmt"
+    "net/http"
+    "os"
+
+    "github.com/Manzo48/loadBalancer/internal/config"
+)
+
+// newUpstreamTransport создает транспорт для backend'а с собственными настройками TLS.
+//
+// server_name отправляется как SNI и используется для проверки сертификата вместо адреса
+// из URL. verify_name проверяет сертификат по заданному имени, не отправляя SNI, — для
+// backend'ов, адресуемых по IP, сертификат которых не содержит этот IP в SAN.
+func newUpstreamTransport(cfg *config.UpstreamTLSConfig) (http.RoundTripper, error) {
+    var roots *x509.CertPool
+    if cfg.CAFile != "" {
+        pem, err := os.ReadFile(cfg.CAFile)
+        if err != nil {
+            return nil, fmt.Errorf("read CA file: %w", err)
+        }
+        roots = x509.NewCertPool()
+        if !roots.AppendCertsFromPEM(pem) {
+            return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+        }
+    }
+
+    tlsConfig := &tls.Config{RootCAs: roots, ServerName: cfg.ServerName}
+    if cfg.VerifyName != "" {
+        // Стандартная проверка сверяет сертификат с адресом подключения, поэтому она
+        // отключается и заменяется полной проверкой цепочки по ожидаемому имени
+        tlsConfig.InsecureSkipVerify = true
+        tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+            if len(state.PeerCertificates) == 0 {
+                return fmt.Errorf("backend presented no certificate")
+            }
+            intermediates := x509.NewCertPool()
+            for _, cert := range state.PeerCertificates[1:] {
+                intermediates.AddCert(cert)
+            }
+            _, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+                DNSName:       cfg.VerifyName,
+                Roots:         roots,
+                Intermediates: intermediates,
+            })
+            return err
+        }
+    }
+
+    transport := http.DefaultTransport.(*http.Transport).Clone()
+    transport.TLSClientConfig = tlsConfig
+    return transport, nil
+}
@@ -0,0 +1,108 @@
+package balancer
+
+import (
+    "fmt"
+    "sync"
+
+    "github.com/Manzo48/loadBalancer/internal/config"
+    "go.uber.org/zap"
+)
+
+// WeightedRoundRobinLoadBalancer распределяет запросы пропорционально весам backend'ов
+// по алгоритму smooth weighted round-robin (как в nginx): backend'ы с большим весом
+// получают больше запросов, но не подряд, а вперемешку с остальными.
+// Веса читаются при каждом выборе, поэтому изменение через admin API действует сразу.
+// С adaptive_weights вместо заданных весов используются эффективные (см. EffectiveWeight).
+type WeightedRoundRobinLoadBalancer struct {
+    *Pool
+    mu          sync.Mutex // Защищает currentWeight backend'ов, seenVersion и warmup
+    seenVersion uint64     // Версия весов, для которой накоплено текущее состояние
+    warmup      uint32     // Случайное смещение: столько шагов пропускается перед первым выбором
+}
+
+// NewWeightedRoundRobinLoadBalancer создает балансировщик с учетом весов.
+// Как и в Round-Robin, последовательность начинается со случайной позиции.
+func NewWeightedRoundRobinLoadBalancer(backendConfigs []config.BackendConfig, healthCheck config.HealthCheckConfig, logger *zap.SugaredLogger) *WeightedRoundRobinLoadBalancer {
+    return &WeightedRoundRobinLoadBalancer{
+        Pool:   NewPool(backendConfigs, healthCheck, logger),
+        warmup: startOffset(),
+    }
+}
+
+// NextAvailableBackend возвращает следующий backend с учетом весов.
+func (lb *WeightedRoundRobinLoadBalancer) NextAvailableBackend() *Backend {
+    return lb.Select(Selection{})
+}
+
+// NextAvailableBackendExcluding возвращает следующий backend, пропуская уже опробованные.
+func (lb *WeightedRoundRobinLoadBalancer) NextAvailableBackendExcluding(tried map[*Backend]bool) *Backend {
+    return lb.Select(Selection{Tried: tried})
+}
+
+// Select возвращает следующий backend с учетом весов.
+func (lb *WeightedRoundRobinLoadBalancer) Select(sel Selection) *Backend {
+    return lb.Pick(sel, lb.choose)
+}
+
+func (lb *WeightedRoundRobinLoadBalancer) choose(candidates []*Backend, _ Selection) *Backend {
+    lb.mu.Lock()
+    defer lb.mu.Unlock()
+
+    // После изменения весов накопленное состояние сбрасывается, иначе backend,
+    // успевший накопить большой текущий вес, продолжит получать непропорционально много запросов
+    if version := lb.weightsVersion.Load(); version != lb.seenVersion {
+        for _, backend := range lb.allBackends() {
+            backend.currentWeight = 0
+        }
+        lb.seenVersion = version
+    }
+
+    if lb.warmup > 0 {
+        // Пропускаем случайное число шагов в пределах одного цикла весов
+        var total int64
+        for _, backend := range candidates {
+            total += backend.EffectiveWeight()
+        }
+        steps := int64(lb.warmup) % max(total, 1)
+        lb.warmup = 0
+        for i := int64(0); i < steps; i++ {
+            lb.step(candidates)
+        }
+    }
+    return lb.step(candidates)
+}
+
+// step выполняет один шаг smooth weighted round-robin. Вызывается под lb.mu.
+func (lb *WeightedRoundRobinLoadBalancer) step(candidates []*Backend) *Backend {
+    var best *Backend
+    var total int64
+    for _, backend := range candidates {
+        weight := backend.EffectiveWeight()
+        backend.currentWeight += weight
+        total += weight
+        if best == nil || backend.currentWeight > best.currentWeight {
+            best = backend
+        }
+    }
+    if best != nil {
+        best.currentWeight -= total
+    }
+    return best
+}
+
+// SetBackendWeight меняет вес backend'а на лету. Вес 0 прекращает новые запросы
+// к backend'у, но не выводит его из health-check'ов.
+func (p *Pool) SetBackendWeight(address string, weight int) error {
+    if weight < 0 {
+        return fmt.Errorf("weight must not be negative")
+    }
+    for _, backend := range p.allBackends() {
+        if backend.Address.String() == address {
+            backend.Weight.Store(int64(weight))
+            p.weightsVersion.Add(1)
+            p.logger.Infof("Backend %s weight set to %d", address, weight)
+            return nil
+        }
+    }
+    return fmt.Errorf("backend %s not found", address)
+}
@@ -0,0 +1,29 @@
+// Package bodybuf читает начало тела запроса в память так, чтобы тело можно было
+// передать дальше целиком: повторы запросов (proxy) и запись трафика (capture).
+package bodybuf
+
+import (
+    "bytes"
+    "io"
+)
+
+// Prefix читает из body не больше limit байт.
+//   - prefix — прочитанное начало тела (не длиннее limit);
+//   - rest — тело для подстановки вместо body: сначала все прочитанные байты, затем
+//     непрочитанный остаток body;
+//   - complete — тело закончилось в пределах limit; body тогда уже закрыт, а rest читается из памяти.
+//
+// Ошибка чтения не возвращается: тело считается неполным, и та же ошибка достанется
+// тому, кто дочитает rest.
+func Prefix(body io.ReadCloser, limit int64) (prefix []byte, rest io.ReadCloser, complete bool) {
+    data, err := io.ReadAll(io.LimitReader(body, limit+1))
+    if err == nil && int64(len(data)) <= limit {
+        body.Close()
+        return data, io.NopCloser(bytes.NewReader(data)), true
+    }
+    rest = struct {
+        io.Reader
+        io.Closer
+    }{io.MultiReader(bytes.NewReader(data), body), body}
+    return data[:min(int64(len(data)), limit)], rest, false
+}
@@ -0,0 +1,217 @@
+package capture
+
+import (
+    "encoding/json"
+    "fmt"
+    "math/rand"
+    "net/http"
+    "net/url"
+    "os"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/Manzo48/loadBalancer/internal/bodybuf"
+    "github.com/Manzo48/loadBalancer/internal/config"
+    "go.uber.org/zap"
+)
+
+const (
+    defaultSampleRate   = 0.01
+    defaultMaxBodyBytes = 4 * 1024
+    defaultMaxFileBytes = 100 * 1024 * 1024
+
+    redactedValue = "[REDACTED]"
+)
+
+// Заголовки, которые скрываются всегда, независимо от конфигурации.
+var defaultRedactHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+
+// Параметры query string, которые скрываются всегда (сравнение без учета регистра):
+// в них обычно передают токены и подписи.
+var defaultRedactQueryParams = []string{
+    "token", "access_token", "refresh_token", "id_token", "api_key", "apikey", "key",
+    "password", "secret", "client_secret", "signature", "sig",
+}
+
+// Record — одна запись в файле захвата (формат JSON Lines, одна запись на строку).
+// Тело сохраняется как base64 (стандартное поведение encoding/json для []byte).
+type Record struct {
+    Time          time.Time           `json:"time"`
+    Method        string              `json:"method"`
+    Host          string              `json:"host"`
+    URI           string              `json:"uri"`
+    Headers       map[string][]string `json:"headers"`
+    Body          []byte              `json:"body,omitempty"`
+    BodyTruncated bool                `json:"body_truncated,omitempty"`
+}
+
+// Sink записывает выборку входящих запросов в файл, ограничивая его размер.
+type Sink struct {
+    mu           sync.Mutex
+    file         *os.File
+    written      int64 // Сколько байт уже в файле
+    full         bool  // Достигнут лимит размера файла
+    sampleRate   float64
+    maxBodyBytes int
+    maxFileBytes int64
+    redact       map[string]bool
+    redactQuery  map[string]bool // Имена параметров в нижнем регистре
+    logger       *zap.SugaredLogger
+}
+
+// NewSink открывает (или дописывает) файл захвата согласно конфигурации.
+func NewSink(cfg config.CaptureConfig, logger *zap.SugaredLogger) (*Sink, error) {
+    if cfg.Path == "" {
+        return nil, fmt.Errorf("capture.path is required when capture is enabled")
+    }
+
+    file, err := os.OpenFile(cfg.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+    if err != nil {
+        return nil, fmt.Errorf("open capture file: %w", err)
+    }
+    info, err := file.Stat()
+    if err != nil {
+        file.Close()
+        return nil, fmt.Errorf("stat capture file: %w", err)
+    }
+
+    sink := &Sink{
+        file:         file,
+        written:      info.Size(),
+        sampleRate:   cfg.SampleRate,
+        maxBodyBytes: cfg.MaxBodyBytes,
+        maxFileBytes: cfg.MaxFileBytes,
+        redact:       make(map[string]bool),
+        redactQuery:  make(map[string]bool),
+        logger:       logger,
+    }
+    if sink.sampleRate <= 0 || sink.sampleRate > 1 {
+        sink.sampleRate = defaultSampleRate
+    }
+    if sink.maxBodyBytes <= 0 {
+        sink.maxBodyBytes = defaultMaxBodyBytes
+    }
+    if sink.maxFileBytes <= 0 {
+        sink.maxFileBytes = defaultMaxFileBytes
+    }
+    for _, name := range append(defaultRedactHeaders, cfg.RedactHeaders...) {
+        sink.redact[http.CanonicalHeaderKey(name)] = true
+    }
+    for _, name := range append(defaultRedactQueryParams, cfg.RedactQueryParams...) {
+        sink.redactQuery[strings.ToLower(name)] = true
+    }
+
+    logger.Infof("Request capture enabled: %s (sample rate %.4f, body limit %d bytes, file limit %d bytes)",
+        cfg.Path, sink.sampleRate, sink.maxBodyBytes, sink.maxFileBytes)
+
+    return sink, nil
+}
+
+// Middleware записывает выбранные по сэмплированию запросы. Начало тела (до max_body_bytes)
+// читается до передачи запроса дальше, поэтому запись полна, даже если запрос отклонят
+// без чтения тела (rate limit, квота, обязательные заголовки, режим обслуживания);
+// остаток тела передается потоком, как обычно.
+func (s *Sink) Middleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if s.isFull() || rand.Float64() >= s.sampleRate {
+            next.ServeHTTP(w, r)
+            return
+        }
+
+        record := Record{
+            Time:    time.Now().UTC(),
+            Method:  r.Method,
+            Host:    r.Host,
+            URI:     s.redactURI(r.URL),
+            Headers: s.redactHeaders(r.Header),
+        }
+        if r.Body != nil && r.Body != http.NoBody {
+            var complete bool
+            record.Body, r.Body, complete = bodybuf.Prefix(r.Body, int64(s.maxBodyBytes))
+            record.BodyTruncated = !complete
+        }
+
+        next.ServeHTTP(w, r)
+        s.write(record)
+    })
+}
+
+// Close закрывает файл захвата.
+func (s *Sink) Close() error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.file.Close()
+}
+
+// redactHeaders копирует заголовки, скрывая значения чувствительных.
+func (s *Sink) redactHeaders(header http.Header) map[string][]string {
+    result := make(map[string][]string, len(header))
+    for name, values := range header {
+        if s.redact[name] {
+            result[name] = []string{redactedValue}
+            continue
+        }
+        result[name] = append([]string(nil), values...)
+    }
+    return result
+}
+
+// redactURI возвращает путь и query string запроса, заменяя значения чувствительных
+// параметров на [REDACTED]. Остальные параметры и их порядок сохраняются как есть.
+func (s *Sink) redactURI(u *url.URL) string {
+    if u.RawQuery == "" {
+        return u.RequestURI()
+    }
+    params := strings.Split(u.RawQuery, "&")
+    for i, param := range params {
+        rawName, _, hasValue := strings.Cut(param, "=")
+        if !hasValue {
+            continue
+        }
+        name, err := url.QueryUnescape(rawName)
+        if err != nil {
+            name = rawName
+        }
+        if s.redactQuery[strings.ToLower(name)] {
+            params[i] = rawName + "=" + url.QueryEscape(redactedValue)
+        }
+    }
+    redacted := *u
+    redacted.RawQuery = strings.Join(params, "&")
+    return redacted.RequestURI()
+}
+
+func (s *Sink) isFull() bool {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.full
+}
+
+// write дописывает запись в файл, пока не достигнут лимит размера.
+func (s *Sink) write(record Record) {
+    line, err := json.Marshal(record)
+    if err != nil {
+        s.logger.Errorf("Failed to encode capture record: %v", err)
+        return
+    }
+    line = append(line, '\n')
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if s.full {
+        return
+    }
+    if s.written+int64(len(line)) > s.maxFileBytes {
+        s.full = true
+        s.logger.Warnf("Capture file reached size limit (%d bytes), capture stopped", s.maxFileBytes)
+        return
+    }
+
+    n, err := s.file.Write(line)
+    s.written += int64(n)
+    if err != nil {
+        s.logger.Errorf("Failed to write capture record: %v", err)
+    }
+}
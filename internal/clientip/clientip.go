@@ -0,0 +1,92 @@
+package clientip
+
+import (
+    "context"
+    "net"
+    "net/http"
+    "strings"
+)
+
+type contextKey struct{}
+
+// Resolve определяет IP клиента.
+//
+// Без доверенных прокси (trusted пуст) сохраняется прежнее поведение: X-Real-IP,
+// затем первый адрес X-Forwarded-For, затем адрес соединения.
+//
+// С доверенными прокси заголовки учитываются, только если соединение пришло от одного
+// из них. Цепочка X-Forwarded-For просматривается справа налево: доверенные хопы
+// пропускаются, первый недоверенный адрес и есть клиент. Если доверенные все —
+// клиентом считается самый левый адрес. X-Real-IP используется, только если
+// X-Forwarded-For нет.
+func Resolve(r *http.Request, trusted []*net.IPNet) string {
+    remote := remoteIP(r)
+    if len(trusted) == 0 {
+        if ip := r.Header.Get("X-Real-IP"); ip != "" {
+            return strings.TrimSpace(ip)
+        }
+        if ip := r.Header.Get("X-Forwarded-For"); ip != "" {
+            return strings.TrimSpace(strings.Split(ip, ",")[0])
+        }
+        return remote
+    }
+
+    if !contains(trusted, net.ParseIP(remote)) {
+        return remote
+    }
+    var hops []string
+    for _, value := range r.Header.Values("X-Forwarded-For") {
+        hops = append(hops, strings.Split(value, ",")...)
+    }
+    if len(hops) == 0 {
+        if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(ip) != nil {
+            return ip
+        }
+        return remote
+    }
+    client := remote
+    for i := len(hops) - 1; i >= 0; i-- {
+        hop := strings.TrimSpace(hops[i])
+        ip := net.ParseIP(hop)
+        if ip == nil {
+            // Мусор в цепочке: дальше нее верить нельзя, клиент — последний разобранный хоп
+            break
+        }
+        client = hop
+        if !contains(trusted, ip) {
+            break
+        }
+    }
+    return client
+}
+
+// NewContext сохраняет в контексте IP клиента, определенный один раз на запрос.
+func NewContext(ctx context.Context, ip string) context.Context {
+    return context.WithValue(ctx, contextKey{}, ip)
+}
+
+// FromRequest возвращает IP клиента из контекста запроса, а если его там нет
+// (обработчик вызван вне цепочки прокси) — определяет его без доверенных прокси.
+func FromRequest(r *http.Request) string {
+    if ip, ok := r.Context().Value(contextKey{}).(string); ok {
+        return ip
+    }
+    return Resolve(r, nil)
+}
+
+func remoteIP(r *http.Request) string {
+    ip, _, _ := net.SplitHostPort(r.RemoteAddr)
+    return strings.TrimSpace(ip)
+}
+
+func contains(networks []*net.IPNet, ip net.IP) bool {
+    if ip == nil {
+        return false
+    }
+    for _, network := range networks {
+        if network.Contains(ip) {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,52 @@
+package clientip
+
+import (
+    "fmt"
+    "net"
+    "strings"
+)
+
+// IPList — набор IP-адресов и подсетей: allowlist лимитера и режима обслуживания, denylist,
+// доверенные прокси и источники PROXY protocol.
+type IPList []*net.IPNet
+
+// ParseIPList разбирает список адресов и подсетей CIDR: "10.0.0.0/8", "192.168.1.10", "::1".
+// Одиночный адрес считается подсетью из одного адреса.
+func ParseIPList(entries []string) (IPList, error) {
+    list := make(IPList, 0, len(entries))
+    for _, entry := range entries {
+        entry = strings.TrimSpace(entry)
+        if !strings.Contains(entry, "/") {
+            ip := net.ParseIP(entry)
+            if ip == nil {
+                return nil, fmt.Errorf("invalid IP address %q", entry)
+            }
+            bits := 8 * net.IPv6len
+            if ip4 := ip.To4(); ip4 != nil {
+                ip, bits = ip4, 8*net.IPv4len
+            }
+            list = append(list, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+            continue
+        }
+        _, network, err := net.ParseCIDR(entry)
+        if err != nil {
+            return nil, fmt.Errorf("invalid CIDR %q", entry)
+        }
+        list = append(list, network)
+    }
+    return list, nil
+}
+
+// Contains проверяет, входит ли адрес в список. Некорректный адрес не входит никуда.
+func (l IPList) Contains(address string) bool {
+    ip := net.ParseIP(address)
+    if ip == nil {
+        return false
+    }
+    for _, network := range l {
+        if network.Contains(ip) {
+            return true
+        }
+    }
+    return false
+}
@@ -1,28 +1,638 @@
 package config
 
 import (
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"net/url"
 	"os"
+	"path"
+	"regexp"
+	"sort"
 	"strconv" 
+	"strings"
+	"time"
 
+	"github.com/Manzo48/loadBalancer/internal/clientip"
 	"gopkg.in/yaml.v2"
 )
 
+// Strategies — допустимые значения strategy. Балансировщик по имени создает balancer.New;
+// стратегии, зарегистрированные через balancer.Register, добавляются в конец списка.
+var Strategies = []string{"round_robin", "weighted_round_robin", "least_connections", "weighted_least_connections", "ip_hash", "random", "p2c", "consistent_hash", "least_response_time"}
+
 type Config struct {
     Port     int      `yaml:"port"`
-    Backends []string `yaml:"backends"`
+    Listen   string   `yaml:"listen"`   // Адрес прокси: host:port или unix:/путь/к.sock (по умолчанию :port)
+    Strategy string   `yaml:"strategy"` // Алгоритм балансировки (см. Strategies), по умолчанию round_robin
+    Backends []BackendConfig `yaml:"backends"`
+    HealthCheck HealthCheckConfig `yaml:"health_check"`
+    RetryAfterDefault time.Duration `yaml:"retry_after_default"` // Retry-After для 503, когда нет оценки восстановления
+    RequestTimeout    time.Duration `yaml:"request_timeout"`     // Общий бюджет запроса на все попытки (0 — без ограничения)
+    FailFast          bool          `yaml:"fail_fast"`           // Завершать процесс при старте, если ни один backend не удалось разобрать
     RateLimit struct {
-        Capacity   int `yaml:"capacity"`
-        RefillRate int `yaml:"refill_rate"`
+        Capacity    int                        `yaml:"capacity"`
+        RefillRate  int                        `yaml:"refill_rate"`
+        Persistence RateLimitPersistenceConfig `yaml:"persistence"`
+        Key         []string                   `yaml:"key"`        // Источники ключа бакета: ip, user_agent, path, header:<name> (по умолчанию [ip])
+        KeyHeader   string                     `yaml:"key_header"` // Заголовок с ключом клиента (X-API-Key); без него — ключ из key
+        Mode        string                     `yaml:"mode"`       // enforce (по умолчанию) | observe — только логировать и считать превышения
+        Algorithm   string                     `yaml:"algorithm"`  // token_bucket (по умолчанию) | sliding_window | leaky_bucket
+        Clients     map[string]ClientRateLimit `yaml:"clients"` // Индивидуальные лимиты по ключу клиента
+
+        GlobalCapacity   int `yaml:"global_capacity"`    // Общий бакет на все запросы (0 — без глобального лимита)
+        GlobalRefillRate int `yaml:"global_refill_rate"` // Пополнение общего бакета, токенов в секунду
+
+        Allowlist []string `yaml:"allowlist"` // IP и подсети CIDR, на которые лимиты не распространяются
+
+        CleanupInterval time.Duration `yaml:"cleanup_interval"` // Как часто удалять неактивные бакеты (по умолчанию 1m)
+        StaleAfter      time.Duration `yaml:"stale_after"`      // Бакет без запросов дольше этого удаляется (по умолчанию 5m)
+        MaxBuckets      int           `yaml:"max_buckets"`      // При превышении очистка запускается сразу (0 — без порога)
     } `yaml:"rate_limit"`
+    Capture CaptureConfig `yaml:"capture"`
+    Standby StandbyConfig `yaml:"standby"`
+    Admin   AdminConfig   `yaml:"admin"`
+
+    UpstreamHeaders UpstreamHeadersConfig `yaml:"upstream_headers"`
+    RequestHeaders  HeaderRules           `yaml:"request_headers"`  // Заголовки исходящего запроса к backend'у
+    ResponseHeaders HeaderRules           `yaml:"response_headers"` // Заголовки ответа backend'а клиенту
+    Transform       TransformConfig       `yaml:"transform"`
+    Compression     CompressionConfig     `yaml:"compression"`
+    RequiredHeaders []RequiredHeader      `yaml:"required_headers"`
+    Quota           QuotaConfig           `yaml:"quota"`
+    RequestSigning  RequestSigningConfig  `yaml:"request_signing"`
+    Options         OptionsConfig         `yaml:"options"`
+    Geo             GeoConfig             `yaml:"geo"`
+    Metrics         MetricsConfig         `yaml:"metrics"`
+    BodyRouting     BodyRoutingConfig     `yaml:"body_routing"`
+
+    Routes []Route                 `yaml:"routes"` // Группы backend'ов по префиксу пути; без совпадения — 404
+    Hosts  map[string]BackendGroup `yaml:"hosts"`  // Группы backend'ов по виртуальному хосту (api.example.com, *.example.com)
+
+    Rewrite []RewriteRule `yaml:"rewrite"` // Переписывание пути перед отправкой на backend, по порядку
+
+    ConsistentHash    ConsistentHashConfig    `yaml:"consistent_hash"`     // Настройки стратегии consistent_hash
+    LeastResponseTime LeastResponseTimeConfig `yaml:"least_response_time"` // Настройки стратегии least_response_time
+
+    SensitiveHeaders SensitiveHeadersConfig `yaml:"sensitive_headers"` // Политика по умолчанию для всех backend'ов
+    SelfTest         SelfTestConfig         `yaml:"selftest"`
+
+    HeaderNormalization   []HeaderNormalizationRule   `yaml:"header_normalization"`
+    QueryCanonicalization QueryCanonicalizationConfig `yaml:"query_canonicalization"`
+
+    CircuitBreaker   CircuitBreakerConfig   `yaml:"circuit_breaker"`
+    OutlierDetection OutlierDetectionConfig `yaml:"outlier_detection"`
+    Retry          RetryConfig          `yaml:"retry"`
+
+    AdaptiveWeights AdaptiveWeightsConfig `yaml:"adaptive_weights"`
+    InFlight        InFlightConfig        `yaml:"in_flight"`
+    BackendQueue    BackendQueueConfig    `yaml:"backend_queue"`
+    TLS             ServerTLSConfig       `yaml:"tls"`
+    Denylist        []string              `yaml:"denylist"` // IP и подсети CIDR, запросы с которых отклоняются с 403
+    TrustedProxies  []string              `yaml:"trusted_proxies"` // IP и подсети CIDR прокси, чьим X-Forwarded-* и X-Real-IP можно верить (пусто — всем)
+    ProxyProtocol   ProxyProtocolConfig   `yaml:"proxy_protocol"`
+    Server          ServerConfig          `yaml:"server"`
+    ConnectionPool  ConnectionPoolConfig  `yaml:"connection_pool"`
+    Maintenance     MaintenanceConfig     `yaml:"maintenance"`
+    Shutdown        ShutdownConfig        `yaml:"shutdown"`
+    AccessLog       AccessLogConfig       `yaml:"access_log"`
+    RequestID       RequestIDConfig       `yaml:"request_id"`
+    Probes          ProbesConfig          `yaml:"probes"`
+    Log             LogConfig             `yaml:"log"`
+    ConfigWatch     ConfigWatchConfig     `yaml:"config_watch"`
+}
+
+// ConfigWatchConfig включает перечитывание конфигурации при изменении файла, как по SIGHUP.
+// Применяется при старте.
+type ConfigWatchConfig struct {
+    Enabled  bool          `yaml:"enabled"`
+    Debounce time.Duration `yaml:"debounce"` // Пауза после последнего изменения перед перечитыванием (по умолчанию 500ms)
+}
+
+// Форматы логов (log.format).
+const (
+    LogFormatJSON    = "json"
+    LogFormatConsole = "console"
+)
+
+// LogConfig задает уровень, формат и вывод логов. Применяется при старте, SIGHUP его не меняет.
+type LogConfig struct {
+    Level  string `yaml:"level"`  // debug | info (по умолчанию) | warn | error
+    Format string `yaml:"format"` // json (по умолчанию) | console
+    Output string `yaml:"output"` // stderr (по умолчанию) | stdout | путь к файлу
+}
+
+// ProxyProtocolConfig включает разбор заголовка PROXY protocol (v1 и v2) на listener'е прокси:
+// адресом клиента становится исходный адрес, переданный L4-балансировщиком.
+type ProxyProtocolConfig struct {
+    Enabled          bool          `yaml:"enabled"`
+    TrustedUpstreams []string      `yaml:"trusted_upstreams"` // IP и подсети CIDR балансировщиков, от которых заголовок принимается
+    HeaderTimeout    time.Duration `yaml:"header_timeout"`    // Ожидание заголовка (по умолчанию 5s)
+}
+
+// ServerConfig задает таймауты соединений клиентов с прокси (защита от медленных клиентов).
+// Незаданные значения заменяются значениями по умолчанию; ожидание ответа backend'а
+// ограничивают request_timeout и retry.per_try_timeout.
+type ServerConfig struct {
+    ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"` // Чтение заголовков запроса (по умолчанию 10s)
+    ReadTimeout       time.Duration `yaml:"read_timeout"`        // Чтение всего запроса с телом (по умолчанию без ограничения)
+    WriteTimeout      time.Duration `yaml:"write_timeout"`       // От конца чтения заголовков до конца ответа (по умолчанию без ограничения)
+    IdleTimeout       time.Duration `yaml:"idle_timeout"`        // Простой keep-alive соединения между запросами (по умолчанию 120s)
+}
+
+// ConnectionPoolConfig задает пул соединений прокси с backend'ами. Незаданные значения
+// заменяются значениями по умолчанию; лимиты действуют отдельно для каждого backend'а.
+type ConnectionPoolConfig struct {
+    MaxIdleConns        int           `yaml:"max_idle_conns"`          // Простаивающих соединений со всеми backend'ами (по умолчанию 512)
+    MaxIdleConnsPerHost int           `yaml:"max_idle_conns_per_host"` // Простаивающих соединений с одним backend'ом (по умолчанию 64)
+    MaxConnsPerHost     int           `yaml:"max_conns_per_host"`      // Всех соединений с одним backend'ом (по умолчанию без ограничения)
+    IdleConnTimeout     time.Duration `yaml:"idle_conn_timeout"`       // Простой соединения до закрытия (по умолчанию 90s)
+}
+
+// MaintenanceConfig описывает режим обслуживания: вместо проксирования на запросы
+// отвечает сам балансировщик. Режим включается здесь или через admin API (/admin/maintenance).
+type MaintenanceConfig struct {
+    Enabled     bool          `yaml:"enabled"`
+    Status      int           `yaml:"status"`       // Код ответа (по умолчанию 503)
+    Body        string        `yaml:"body"`         // Тело ответа (по умолчанию JSON-ошибка)
+    BodyFile    string        `yaml:"body_file"`    // Или файл с телом ответа, читается при загрузке
+    ContentType string        `yaml:"content_type"` // Content-Type тела (по умолчанию text/html; charset=utf-8)
+    RetryAfter  time.Duration `yaml:"retry_after"`  // Retry-After в ответе (0 — без заголовка)
+    Paths       []string      `yaml:"paths"`        // Префиксы путей в режиме обслуживания (пусто — все запросы)
+    Allowlist   []string      `yaml:"allowlist"`    // IP и подсети CIDR, запросы с которых проксируются как обычно
+}
+
+// AccessLogConfig включает строку лога на каждый запрос (для SLO): метод, путь,
+// клиент, backend, код ответа, длительность и размер тела.
+type AccessLogConfig struct {
+    Enabled bool   `yaml:"enabled"`
+    Level   string `yaml:"level"` // debug | info (по умолчанию) | warn | error
+}
+
+// RequestIDConfig задает заголовок с идентификатором запроса для сквозной трассировки.
+type RequestIDConfig struct {
+    Header string `yaml:"header"` // По умолчанию X-Request-ID (например, X-Correlation-ID)
+}
+
+// ShutdownConfig задает порядок остановки по SIGTERM/SIGINT: сначала прокси перестает
+// проходить проверку готовности (drain_delay), затем закрывает listener и ждет
+// завершения начатых запросов не дольше grace_period.
+type ShutdownConfig struct {
+    GracePeriod time.Duration `yaml:"grace_period"` // Сколько ждать начатые запросы (по умолчанию 5s)
+    DrainDelay  time.Duration `yaml:"drain_delay"`  // Сколько еще принимать запросы с непрошедшей проверкой готовности (по умолчанию 0)
+}
+
+// ProbesConfig включает собственные проверки балансировщика на порту прокси.
+// Запросы к ним не проксируются и не проходят rate limit.
+type ProbesConfig struct {
+    LivePath  string `yaml:"live_path"`  // Проверка жизни процесса: 200, пока процесс работает, в том числе при остановке (пусто — выключена)
+    ReadyPath string `yaml:"ready_path"` // Проверка готовности: 200, если есть живой backend и прокси не останавливается (пусто — выключена)
+}
+
+// ServerTLSConfig включает HTTPS на порту прокси. Сертификат перечитывается с диска
+// при изменении файлов, поэтому обновляется без перезапуска.
+type ServerTLSConfig struct {
+    CertFile   string `yaml:"cert_file"`
+    KeyFile    string `yaml:"key_file"`
+    MinVersion string `yaml:"min_version"` // 1.0 | 1.1 | 1.2 (по умолчанию) | 1.3
+}
+
+// InFlightConfig ограничивает число одновременно проксируемых запросов.
+type InFlightConfig struct {
+    Max          int                `yaml:"max"`           // Предел одновременных запросов (0 — без ограничения)
+    QueueTimeout time.Duration      `yaml:"queue_timeout"` // Ожидание свободного слота перед отказом (0 — сразу 503)
+    Backpressure BackpressureConfig `yaml:"backpressure"`
+}
+
+// BackendQueueConfig задает поведение при достижении max_concurrent backend'а, когда
+// свободного слота нет ни на одном подходящем backend'е.
+type BackendQueueConfig struct {
+    Timeout time.Duration `yaml:"timeout"` // Сколько ждать освобождения слота (0 — сразу 503)
+}
+
+// BackpressureConfig задает пороги заполнения (доля от in_flight.max), на которых
+// прокси начинает просить клиентов снизить нагрузку, еще не достигнув предела.
+type BackpressureConfig struct {
+    HintAt     float64       `yaml:"hint_at"`     // С этой доли ответы получают X-Backpressure: high (0 — выключено)
+    ShedAt     float64       `yaml:"shed_at"`     // С этой доли часть запросов отклоняется с 503 (0 — выключено)
+    RetryAfter time.Duration `yaml:"retry_after"` // Retry-After отклоненных запросов (по умолчанию 1s)
+}
+
+// AdaptiveWeightsConfig описывает контроллер, который периодически пересчитывает
+// веса backend'ов для weighted_round_robin по сигналам нагрузки.
+type AdaptiveWeightsConfig struct {
+    Enabled      bool                 `yaml:"enabled"`
+    Interval     time.Duration        `yaml:"interval"`    // Период пересчета (по умолчанию 5s)
+    LoadHeader   string               `yaml:"load_header"` // Заголовок ответа, в котором backend сообщает свою нагрузку
+    Coefficients AdaptiveCoefficients `yaml:"coefficients"`
+}
+
+// AdaptiveCoefficients — вклад каждого сигнала в снижение веса; 0 — сигнал не учитывается.
+type AdaptiveCoefficients struct {
+    ActiveConnections float64 `yaml:"active_connections"` // На один активный запрос
+    ErrorRate         float64 `yaml:"error_rate"`         // На долю ошибок за интервал (0..1)
+    Latency           float64 `yaml:"latency"`            // На секунду EWMA задержки
+    ReportedLoad      float64 `yaml:"reported_load"`      // На единицу нагрузки из load_header
+}
+
+// CircuitBreakerConfig описывает исключение backend'а из выбора после серии ошибок подряд.
+type CircuitBreakerConfig struct {
+    Enabled          bool          `yaml:"enabled"`
+    FailureThreshold int           `yaml:"failure_threshold"` // Ошибок подряд до открытия (по умолчанию 5)
+    Cooldown         time.Duration `yaml:"cooldown"`          // Время в open до пробного запроса (по умолчанию 30s)
+}
+
+// OutlierDetectionConfig описывает временное исключение backend'а, который отвечает
+// заметно хуже остальных backend'ов пула (ошибки, 5xx, задержка).
+type OutlierDetectionConfig struct {
+    Enabled              bool          `yaml:"enabled"`
+    Interval             time.Duration `yaml:"interval"`               // Период анализа успешности и задержки (по умолчанию 10s)
+    Consecutive5xx       int           `yaml:"consecutive_5xx"`        // Ошибок и 5xx подряд до исключения (по умолчанию 5)
+    SuccessRateThreshold float64       `yaml:"success_rate_threshold"` // Доля от средней успешности остальных backend'ов, ниже которой backend исключается (0 — не проверять)
+    LatencyFactor        float64       `yaml:"latency_factor"`         // Во сколько раз задержка должна превышать среднюю у остальных (0 — не проверять)
+    MinRequests          int           `yaml:"min_requests"`           // Запросов за интервал, без которых backend не оценивается (по умолчанию 10)
+    EjectionTime         time.Duration `yaml:"ejection_time"`          // Время исключения (по умолчанию 30s)
+    MaxEjectionPercent   int           `yaml:"max_ejection_percent"`   // Предел доли исключенных backend'ов пула (по умолчанию 50)
+}
+
+// RetryConfig описывает повтор запроса на другом backend'е при ошибке соединения.
+type RetryConfig struct {
+    MaxRetries    int           `yaml:"max_retries"`     // Сколько раз повторять запрос (0 — без повторов)
+    PerTryTimeout time.Duration `yaml:"per_try_timeout"` // Таймаут каждой попытки отдельно (0 — только request_timeout)
+    AllowPost     bool          `yaml:"allow_post"`      // Повторять и POST (backend должен быть готов к дублям)
+    MaxBodySize   int64         `yaml:"max_body_size"`   // Сколько байт тела буферизовать для повторов (по умолчанию 1MiB)
+
+    // BufferRequired — запрос, который может повторяться, но не помещается в max_body_size,
+    // отклоняется с 413; иначе он передается потоком без повторов
+    BufferRequired bool `yaml:"buffer_required"`
+}
+
+// QueryCanonicalizationConfig описывает приведение query string к каноническому виду
+// там, где URL используется как ключ (ключи лимитера, хеширование, маршрутизация).
+// Запрос, отправляемый на backend, не меняется.
+type QueryCanonicalizationConfig struct {
+    SortParams   bool     `yaml:"sort_params"`   // Сортировать параметры по имени
+    IgnoreParams []string `yaml:"ignore_params"` // Шаблоны имен, исключаемых из ключа: utm_*, fbclid
+}
+
+// HeaderNormalizationRule задает, что делать с повторяющимся заголовком запроса.
+type HeaderNormalizationRule struct {
+    Header string `yaml:"header"`
+    Policy string `yaml:"policy"` // first (по умолчанию) | last | reject
+}
+
+// SelfTestConfig описывает синтетический запрос, который POST /admin/selftest
+// отправляет на каждый backend.
+type SelfTestConfig struct {
+    Method       string            `yaml:"method"`        // По умолчанию GET
+    Path         string            `yaml:"path"`          // По умолчанию /
+    Headers      map[string]string `yaml:"headers"`       // Дополнительные заголовки запроса
+    Timeout      time.Duration     `yaml:"timeout"`       // Общий таймаут проверки (по умолчанию 5s)
+    UpdateHealth bool              `yaml:"update_health"` // Помечать backend'ы по результату проверки
+}
+
+// BodyRoutingConfig описывает выбор пула backend'ов по полю JSON-тела запроса
+// (например, method у JSON-RPC). Запросы, не попавшие ни в один маршрут, идут в основной пул.
+type BodyRoutingConfig struct {
+    Enabled      bool        `yaml:"enabled"`
+    Field        string      `yaml:"field"`          // Путь к полю через точку: method, params.kind
+    MaxPeekBytes int64       `yaml:"max_peek_bytes"` // Сколько байт тела читать для разбора (по умолчанию 64KiB)
+    Routes       []BodyRoute `yaml:"routes"`
+}
+
+// BodyRoute — пул backend'ов для перечисленных значений поля.
+type BodyRoute struct {
+    Values   []string        `yaml:"values"`
+    Backends []BackendConfig `yaml:"backends"`
+}
+
+// Route — группа backend'ов со своей стратегией и health-check'ами для префикса пути.
+// Выбирается самый длинный совпавший префикс; префикс сравнивается по целым сегментам пути.
+type Route struct {
+    Prefix   string          `yaml:"prefix"`   // /api подходит для /api и /api/users, но не для /apiary
+    Backends []BackendConfig `yaml:"backends"`
+    Strategy string          `yaml:"strategy"` // См. Strategies, по умолчанию round_robin
+}
+
+// BackendGroup — группа backend'ов виртуального хоста со своей стратегией и health-check'ами.
+type BackendGroup struct {
+    Backends []BackendConfig `yaml:"backends"`
+    Strategy string          `yaml:"strategy"` // См. Strategies, по умолчанию round_robin
+}
+
+// ConsistentHashConfig задает ключ и кольцо стратегии consistent_hash
+// (для основного пула, routes и hosts).
+type ConsistentHashConfig struct {
+    Key          string `yaml:"key"`           // url (по умолчанию) | path | ip | header:<name>
+    VirtualNodes int    `yaml:"virtual_nodes"` // Точек на кольце у каждого backend'а (по умолчанию 160)
+}
+
+// LeastResponseTimeConfig задает сглаживание задержки для стратегии least_response_time.
+type LeastResponseTimeConfig struct {
+    Decay float64 `yaml:"decay"` // Вклад нового замера в EWMA задержки, (0, 1]; по умолчанию 0.2
+}
+
+// RewriteRule — правило переписывания пути исходящего запроса: либо strip_prefix, либо regex.
+// Маршрутизация (routes) выбирает группу по исходному пути клиента.
+type RewriteRule struct {
+    StripPrefix string `yaml:"strip_prefix"` // /api: /api/users -> /users, /api -> /; /apiary не меняется
+    Regex       string `yaml:"regex"`        // Регулярное выражение для пути
+    Replacement string `yaml:"replacement"`  // Замена для regex, поддерживает $1, ${name}
+}
+
+// MetricsConfig выбирает, куда отправляются метрики.
+type MetricsConfig struct {
+    Sink   string       `yaml:"sink"` // prometheus (по умолчанию, если задан admin.addr) | statsd | none
+    StatsD StatsDConfig `yaml:"statsd"`
+}
+
+// StatsDConfig описывает отправку метрик в StatsD/DogStatsD по UDP.
+type StatsDConfig struct {
+    Addr           string        `yaml:"addr"`             // host:port агента
+    Prefix         string        `yaml:"prefix"`           // Префикс имен метрик, например "lb."
+    FlushInterval  time.Duration `yaml:"flush_interval"`   // Как часто отправлять пакет (по умолчанию 100ms)
+    MaxPacketBytes int           `yaml:"max_packet_bytes"` // Предельный размер пакета (по умолчанию 1432)
+    Tags           string        `yaml:"tags"`             // dogstatsd (по умолчанию) | none — без тегов, для классического StatsD
+}
+
+// GeoConfig описывает выбор backend'а по близости: регион клиента определяется по базе
+// MaxMind GeoIP2/GeoLite2, после чего предпочитаются backend'ы этого региона.
+type GeoConfig struct {
+    Enabled       bool                `yaml:"enabled"`
+    Database      string              `yaml:"database"`       // Путь к .mmdb (City или Country)
+    Regions       map[string]string   `yaml:"regions"`        // Код страны (DE) или континента (EU) -> регион backend'ов
+    Fallback      map[string][]string `yaml:"fallback"`       // Регион -> запасные регионы в порядке предпочтения
+    DefaultRegion string              `yaml:"default_region"` // Регион для IP, не найденных в базе
+}
+
+// OptionsConfig управляет обработкой OPTIONS-запросов.
+type OptionsConfig struct {
+    Mode           string   `yaml:"mode"`            // passthrough (по умолчанию) | respond
+    AllowedMethods []string `yaml:"allowed_methods"` // Значение заголовка Allow в режиме respond
+}
+
+// RequestSigningConfig описывает HMAC-подпись запросов к backend'ам,
+// по которой backend может убедиться, что запрос прошел через прокси.
+type RequestSigningConfig struct {
+    Secret      string `yaml:"secret"`       // Общий секрет HMAC-SHA256
+    Header      string `yaml:"header"`       // По умолчанию X-LB-Signature
+    AllBackends bool   `yaml:"all_backends"` // Подписывать запросы ко всем backend'ам, а не только с sign_requests
+}
+
+// QuotaConfig описывает квоты на число запросов клиента за сутки/месяц.
+type QuotaConfig struct {
+    Enabled         bool                   `yaml:"enabled"`
+    Default         QuotaPolicy            `yaml:"default"`          // Квота для клиентов без индивидуальной
+    Clients         map[string]QuotaPolicy `yaml:"clients"`          // Индивидуальные квоты по ID клиента
+    Window          string                 `yaml:"window"`           // calendar (по умолчанию) | rolling
+    PersistPath     string                 `yaml:"persist_path"`     // Файл для сохранения счетчиков между рестартами
+    PersistInterval time.Duration          `yaml:"persist_interval"` // Как часто сохранять счетчики
+}
+
+// QuotaPolicy — лимит запросов за период (day | month). Limit 0 — без ограничения.
+type QuotaPolicy struct {
+    Limit  int64  `yaml:"limit"`
+    Period string `yaml:"period"`
+}
+
+// RequiredHeader описывает заголовок, без которого запрос отклоняется с 400.
+type RequiredHeader struct {
+    Name    string `yaml:"name"`
+    Pattern string `yaml:"pattern"` // Необязательное регулярное выражение для значения
+}
+
+// TransformConfig управляет подготовкой ответов backend'ов для трансформаций.
+type TransformConfig struct {
+    DecompressGzip bool  `yaml:"decompress_gzip"` // Разжимать gzip-ответы, чтобы трансформации видели открытый текст
+    MaxBodyBytes   int64 `yaml:"max_body_bytes"`  // Ответы больше лимита пропускаются без трансформации
+}
+
+// CompressionConfig включает сжатие ответов, которые backend отдал без сжатия.
+type CompressionConfig struct {
+    Enabled   bool     `yaml:"enabled"`
+    MinSize   int      `yaml:"min_size"`   // Ответы короче не сжимаются (по умолчанию 1024 байта)
+    Level     int      `yaml:"level"`      // Уровень сжатия 1-9 (0 — по умолчанию)
+    SkipTypes []string `yaml:"skip_types"` // Дополнительные несжимаемые Content-Type (image/*, application/zip)
+}
+
+// HeaderRules — заголовки, которые удаляются и добавляются (заменяются) по пути через прокси.
+// Сначала применяется remove, затем add.
+type HeaderRules struct {
+    Add    map[string]string `yaml:"add"`    // Имя -> значение; X-Forwarded-For в запросе дописывается в цепочку
+    Remove []string          `yaml:"remove"` // Имена заголовков
+}
+
+// UpstreamHeadersConfig включает отладочные заголовки ответа с адресом выбранного backend'а.
+// Выключено по умолчанию, так как раскрывает топологию.
+type UpstreamHeadersConfig struct {
+    Enabled        bool   `yaml:"enabled"`
+    BackendHeader  string `yaml:"backend_header"`  // По умолчанию X-Upstream
+    DurationHeader string `yaml:"duration_header"` // По умолчанию X-Upstream-Duration
+}
+
+// BackendConfig описывает один backend. В YAML допускается как строка с URL, так и объект.
+type BackendConfig struct {
+    URL          string `yaml:"url" json:"url"`
+    SignRequests bool   `yaml:"sign_requests,omitempty" json:"sign_requests,omitempty"` // Подписывать запросы к backend'у (см. request_signing)
+    Region       string `yaml:"region,omitempty" json:"region,omitempty"`               // Регион backend'а (см. geo)
+    Weight       *int   `yaml:"weight,omitempty" json:"weight,omitempty"`               // Вес для weighted_round_robin и weighted_least_connections (по умолчанию 1)
+
+    MaxConcurrent int           `yaml:"max_concurrent,omitempty" json:"max_concurrent,omitempty"` // Предел одновременных запросов к backend'у (0 — без ограничения)
+    Timeout       time.Duration `yaml:"timeout,omitempty" json:"timeout,omitempty"`               // Таймаут попытки до заголовков ответа (0 — retry.per_try_timeout)
+
+    SensitiveHeaders *SensitiveHeadersConfig `yaml:"sensitive_headers,omitempty" json:"sensitive_headers,omitempty"` // Переопределяет общую политику
+    TLS              *UpstreamTLSConfig      `yaml:"tls,omitempty" json:"tls,omitempty"`                             // Настройки TLS для https-backend'а
+    HealthCheck      *BackendHealthCheck     `yaml:"health_check,omitempty" json:"health_check,omitempty"`           // Переопределяет общий health_check
+}
+
+// BackendHealthCheck переопределяет параметры health-check для одного backend'а.
+type BackendHealthCheck struct {
+    Type            string `yaml:"type,omitempty" json:"type,omitempty"`                         // http | tcp (по умолчанию общий тип)
+    Path            string `yaml:"path,omitempty" json:"path,omitempty"`                         // Например /healthz
+    HealthyStatuses []int  `yaml:"healthy_statuses,omitempty" json:"healthy_statuses,omitempty"` // Например [200, 204]
+}
+
+// UpstreamTLSConfig описывает проверку сертификата https-backend'а.
+type UpstreamTLSConfig struct {
+    CAFile     string `yaml:"ca_file" json:"ca_file,omitempty"`         // PEM с доверенными CA (по умолчанию системные)
+    ServerName string `yaml:"server_name" json:"server_name,omitempty"` // SNI и имя для проверки сертификата
+    VerifyName string `yaml:"verify_name" json:"verify_name,omitempty"` // Проверять сертификат по этому имени без отправки SNI
+}
+
+// SensitiveHeadersConfig определяет, что делать с чувствительными заголовками клиента
+// (по умолчанию Authorization) перед отправкой на backend.
+type SensitiveHeadersConfig struct {
+    Mode    string   `yaml:"mode" json:"mode"`                           // preserve (по умолчанию) | strip | replace
+    Headers []string `yaml:"headers,omitempty" json:"headers,omitempty"` // По умолчанию [Authorization]
+    Value   string   `yaml:"value,omitempty" json:"value,omitempty"`     // Новое значение для replace (например, внутренний токен)
+}
+
+// EffectiveWeight возвращает вес backend'а с учетом значения по умолчанию.
+func (b BackendConfig) EffectiveWeight() int {
+    if b.Weight == nil {
+        return 1
+    }
+    return *b.Weight
+}
+
+// UnmarshalYAML поддерживает короткую форму записи backend'а строкой.
+func (b *BackendConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+    var rawURL string
+    if err := unmarshal(&rawURL); err == nil {
+        b.URL = rawURL
+        return nil
+    }
+    type plain BackendConfig
+    return unmarshal((*plain)(b))
+}
+
+const (
+    DefaultHealthCheckInterval = 10 * time.Second
+    DefaultHealthCheckTimeout  = 2 * time.Second
+)
+
+// Типы health-check (health_check.type)
+const (
+    HealthCheckHTTP = "http" // GET по path с проверкой кода ответа (по умолчанию)
+    HealthCheckTCP  = "tcp"  // Только установка TCP-соединения с host:port backend'а
+)
+
+// HealthCheckConfig описывает активные проверки доступности backend'ов.
+type HealthCheckConfig struct {
+    Type            string `yaml:"type"`             // http (по умолчанию) | tcp
+    Path            string `yaml:"path"`             // Путь проверки, по умолчанию /health
+    AbsolutePath    bool   `yaml:"absolute_path"`    // true — путь от корня хоста, а не от базового пути backend'а
+    HealthyStatuses []int  `yaml:"healthy_statuses"` // Коды ответа, при которых backend жив (по умолчанию [200])
+
+    ExpectedBody      string `yaml:"expected_body"`       // Подстрока, которая должна быть в теле ответа
+    ExpectedBodyRegex string `yaml:"expected_body_regex"` // Или регулярное выражение для тела ответа
+
+    MaxConcurrent int            `yaml:"max_concurrent"` // Предел одновременных probe по всем backend'ам (0 — без ограничения)
+    Timeout       time.Duration  `yaml:"timeout"`        // Общий таймаут probe: DNS, соединение и ответ (по умолчанию 2s)
+    Interval      *time.Duration `yaml:"interval"`       // Период проверок (по умолчанию 10s); 0 — проверки выключены
+
+    InitialState string                   `yaml:"initial_state"` // healthy (по умолчанию) | unhealthy — в ротацию только после первой успешной проверки
+    Backoff      HealthCheckBackoffConfig `yaml:"backoff"`       // Реже проверять backend'ы, которые долго недоступны
+    SlowStart    time.Duration            `yaml:"slow_start"`    // Разгон трафика восстановившегося backend'а от нуля до полной доли (0 — выключен)
+
+    CertExpiry CertExpiryConfig `yaml:"cert_expiry"`
+}
+
+// HealthCheckBackoffConfig задает экспоненциальное увеличение паузы между проверками
+// недоступного backend'а: после n неудач подряд следующая проверка через
+// base * multiplier^(n-1), но не позже max. Успешная проверка возвращает обычный интервал.
+type HealthCheckBackoffConfig struct {
+    Base       time.Duration `yaml:"base"`       // Пауза после первой неудачи (по умолчанию interval)
+    Max        time.Duration `yaml:"max"`        // Предел паузы; 0 — backoff выключен
+    Multiplier float64       `yaml:"multiplier"` // Во сколько раз растет пауза (по умолчанию 2)
+}
+
+// EffectiveInterval возвращает период проверок с учетом значения по умолчанию; 0 — проверки выключены.
+func (h HealthCheckConfig) EffectiveInterval() time.Duration {
+    if h.Interval == nil {
+        return DefaultHealthCheckInterval
+    }
+    return *h.Interval
+}
+
+// CertExpiryConfig задает пороги проверки срока действия сертификатов https-backend'ов.
+type CertExpiryConfig struct {
+    WarnBefore time.Duration `yaml:"warn_before"` // Предупреждение в логе, если до истечения меньше (например, 720h)
+    FailBefore time.Duration `yaml:"fail_before"` // Backend недоступен, если до истечения меньше (например, 24h)
+}
+
+// StandbyConfig описывает резервный пул, который включается в ротацию только под нагрузкой.
+// Пороги активации и деактивации различаются, чтобы пул не "мигал" около одного значения.
+type StandbyConfig struct {
+    Backends              []BackendConfig `yaml:"backends"`
+    ActivateConnections   float64         `yaml:"activate_connections"`   // Среднее число активных запросов на живой основной backend
+    DeactivateConnections float64         `yaml:"deactivate_connections"` // Ниже этого значения пул выключается
+    ActivateErrorRate     float64         `yaml:"activate_error_rate"`    // Доля ошибок основного пула (0..1) для включения
+    DeactivateErrorRate   float64         `yaml:"deactivate_error_rate"`
+    CheckInterval         time.Duration   `yaml:"check_interval"`
+}
+
+// AdminConfig описывает отдельный служебный listener (метрики и т.п.).
+type AdminConfig struct {
+    Addr   string            `yaml:"addr"`   // Например ":9090"; пусто — admin listener не запускается
+    Tokens map[string]string `yaml:"tokens"` // Оператор -> bearer-токен; без токенов изменяющие запросы запрещены
+}
+
+// Алгоритмы ограничения (rate_limit.algorithm)
+const (
+    RateLimitTokenBucket   = "token_bucket"   // По умолчанию: допускает всплеск до capacity сразу после пополнения
+    RateLimitSlidingWindow = "sliding_window" // Журнал запросов за скользящее окно: нагрузка распределяется ровнее
+    RateLimitLeakyBucket   = "leaky_bucket"   // Очередь с постоянной скоростью выхода: запросы идут строго равномерно
+)
+
+// Источники атрибутов ключа лимита (rate_limit.key)
+const (
+    RateLimitKeyIP        = "ip"
+    RateLimitKeyUserAgent = "user_agent"
+    RateLimitKeyPath      = "path"
+    RateLimitKeyURL       = "url"     // Путь и канонический query string
+    RateLimitKeyHeader    = "header:" // header:X-Api-Key
+)
+
+// ValidateRateLimitAlgorithm проверяет название алгоритма ограничения.
+func ValidateRateLimitAlgorithm(algorithm string) error {
+    switch algorithm {
+    case "", RateLimitTokenBucket, RateLimitSlidingWindow, RateLimitLeakyBucket:
+        return nil
+    default:
+        return fmt.Errorf("unknown rate limit algorithm %q (expected %s, %s or %s)", algorithm, RateLimitTokenBucket, RateLimitSlidingWindow, RateLimitLeakyBucket)
+    }
+}
+
+// ParseRateLimitKeySource разбирает источник ключа лимита и возвращает его вид
+// (одна из констант RateLimitKey*); для header:<name> дополнительно возвращается имя заголовка.
+func ParseRateLimitKeySource(source string) (kind, header string, err error) {
+    switch {
+    case source == RateLimitKeyIP, source == RateLimitKeyUserAgent, source == RateLimitKeyPath, source == RateLimitKeyURL:
+        return source, "", nil
+    case strings.HasPrefix(source, RateLimitKeyHeader):
+        name := strings.TrimSpace(strings.TrimPrefix(source, RateLimitKeyHeader))
+        if name == "" {
+            return "", "", fmt.Errorf("rate limit key source %q: header name is empty", source)
+        }
+        return RateLimitKeyHeader, name, nil
+    default:
+        return "", "", fmt.Errorf("unknown rate limit key source %q (expected ip, user_agent, path, url or header:<name>)", source)
+    }
+}
+
+// ClientRateLimit — индивидуальный лимит клиента; те же лимиты задаются на лету через admin API.
+// Применяется при создании лимитера; capacity 0 полностью блокирует клиента.
+type ClientRateLimit struct {
+    Capacity   int `yaml:"capacity"`
+    RefillRate int `yaml:"refill_rate"`
+}
+
+// RateLimitPersistenceConfig описывает сохранение состояния токен-бакетов между рестартами.
+type RateLimitPersistenceConfig struct {
+    Path     string        `yaml:"path"`     // Файл снимка; пусто — состояние только в памяти
+    Interval time.Duration `yaml:"interval"` // Как часто сохранять снимок (по умолчанию 30s)
+    MaxAge   time.Duration `yaml:"max_age"`  // Более старые записи при восстановлении отбрасываются (по умолчанию 10m)
+}
+
+// CaptureConfig описывает запись выборки входящих запросов в файл для последующего replay.
+type CaptureConfig struct {
+    Enabled           bool     `yaml:"enabled"`             // Запись выключена по умолчанию
+    Path              string   `yaml:"path"`                // Путь к файлу (JSON Lines)
+    SampleRate        float64  `yaml:"sample_rate"`         // Доля записываемых запросов (0, 1]
+    MaxBodyBytes      int      `yaml:"max_body_bytes"`      // Сколько байт тела сохранять
+    MaxFileBytes      int64    `yaml:"max_file_bytes"`      // Предельный размер файла, после которого запись прекращается
+    RedactHeaders     []string `yaml:"redact_headers"`      // Дополнительные заголовки, значения которых скрываются
+    RedactQueryParams []string `yaml:"redact_query_params"` // Дополнительные параметры query string, значения которых скрываются
 }
 
 func Load(path string) (*Config, error) {
-    data, err := ioutil.ReadFile(path)
+    data, err := os.ReadFile(path)
     if err != nil {
         return nil, err
     }
+    if data, err = expandEnv(data); err != nil {
+        return nil, err
+    }
     var cfg Config
     if err := yaml.Unmarshal(data, &cfg); err != nil {
         return nil, err
@@ -38,9 +648,563 @@ func Load(path string) (*Config, error) {
         }
     }
 
+    // BACKENDS заменяет список из файла целиком: URL перечисляются через запятую
     if backends := os.Getenv("BACKENDS"); backends != "" {
-        cfg.Backends = append(cfg.Backends, backends)
+        cfg.Backends = nil
+        for _, backend := range strings.Split(backends, ",") {
+            if backend = strings.TrimSpace(backend); backend != "" {
+                cfg.Backends = append(cfg.Backends, BackendConfig{URL: backend})
+            }
+        }
+    }
+
+    if err := validateParamPatterns(cfg.QueryCanonicalization.IgnoreParams); err != nil {
+        return nil, err
+    }
+
+    for _, rule := range cfg.HeaderNormalization {
+        if rule.Header == "" {
+            return nil, fmt.Errorf("header_normalization: header must not be empty")
+        }
+        if p := rule.Policy; p != "" && p != "first" && p != "last" && p != "reject" {
+            return nil, fmt.Errorf("header_normalization: %s: unknown policy %q (expected first, last or reject)", rule.Header, p)
+        }
+    }
+
+    if s := cfg.Strategy; s != "" && !knownStrategy(s) {
+        return nil, fmt.Errorf("strategy: unknown value %q (expected one of %s)", s, strings.Join(Strategies, ", "))
+    }
+    if cfg.AdaptiveWeights.Enabled {
+        if cfg.Strategy != "weighted_round_robin" {
+            return nil, fmt.Errorf("adaptive_weights requires strategy weighted_round_robin")
+        }
+        c := cfg.AdaptiveWeights.Coefficients
+        if c.ActiveConnections < 0 || c.ErrorRate < 0 || c.Latency < 0 || c.ReportedLoad < 0 {
+            return nil, fmt.Errorf("adaptive_weights.coefficients must not be negative")
+        }
+    }
+    if m := cfg.RateLimit.Mode; m != "" && m != "enforce" && m != "observe" {
+        return nil, fmt.Errorf("rate_limit.mode: unknown value %q (expected enforce or observe)", m)
+    }
+    if err := ValidateRateLimitAlgorithm(cfg.RateLimit.Algorithm); err != nil {
+        return nil, fmt.Errorf("rate_limit.algorithm: %v", err)
+    }
+    if cfg.RequestTimeout < 0 || cfg.Retry.PerTryTimeout < 0 {
+        return nil, fmt.Errorf("request_timeout and retry.per_try_timeout must not be negative")
+    }
+    if (cfg.TLS.CertFile == "") != (cfg.TLS.KeyFile == "") {
+        return nil, fmt.Errorf("tls.cert_file and tls.key_file must be set together")
+    }
+    switch cfg.TLS.MinVersion {
+    case "", "1.0", "1.1", "1.2", "1.3":
+    default:
+        return nil, fmt.Errorf("tls.min_version: unknown value %q (expected 1.0, 1.1, 1.2 or 1.3)", cfg.TLS.MinVersion)
+    }
+    if cfg.ConfigWatch.Debounce < 0 {
+        return nil, fmt.Errorf("config_watch.debounce must not be negative")
+    }
+    if cfg.Compression.MinSize < 0 {
+        return nil, fmt.Errorf("compression.min_size must not be negative")
+    }
+    if cfg.Compression.Level < 0 || cfg.Compression.Level > 9 {
+        return nil, fmt.Errorf("compression.level must be within 1-9 (or 0 for the default)")
+    }
+    if cfg.InFlight.Max < 0 {
+        return nil, fmt.Errorf("in_flight.max must not be negative")
+    }
+    if cfg.InFlight.QueueTimeout < 0 {
+        return nil, fmt.Errorf("in_flight.queue_timeout must not be negative")
+    }
+    if bp := cfg.InFlight.Backpressure; bp.HintAt < 0 || bp.HintAt > 1 || bp.ShedAt < 0 || bp.ShedAt >= 1 {
+        return nil, fmt.Errorf("in_flight.backpressure: hint_at must be within [0, 1] and shed_at within [0, 1)")
+    } else if bp.HintAt > 0 && bp.ShedAt > 0 && bp.HintAt > bp.ShedAt {
+        return nil, fmt.Errorf("in_flight.backpressure: hint_at must not exceed shed_at")
+    }
+    if cfg.BackendQueue.Timeout < 0 {
+        return nil, fmt.Errorf("backend_queue.timeout must not be negative")
+    }
+    if cfg.Retry.MaxBodySize < 0 {
+        return nil, fmt.Errorf("retry.max_body_size must not be negative")
+    }
+    if cfg.Retry.MaxRetries < 0 {
+        return nil, fmt.Errorf("retry.max_retries must not be negative")
+    }
+    if cfg.CircuitBreaker.FailureThreshold < 0 {
+        return nil, fmt.Errorf("circuit_breaker.failure_threshold must not be negative")
+    }
+    for _, source := range cfg.RateLimit.Key {
+        if _, _, err := ParseRateLimitKeySource(source); err != nil {
+            return nil, fmt.Errorf("rate_limit.key: %v", err)
+        }
+    }
+
+    if interval := cfg.HealthCheck.Interval; interval != nil {
+        timeout := cfg.HealthCheck.Timeout
+        if timeout <= 0 {
+            timeout = DefaultHealthCheckTimeout
+        }
+        if *interval < 0 {
+            return nil, fmt.Errorf("health_check.interval must not be negative")
+        }
+        if *interval > 0 && *interval <= timeout {
+            return nil, fmt.Errorf("health_check.interval (%s) must be greater than health_check.timeout (%s)", *interval, timeout)
+        }
+    }
+    if err := validateStatuses("health_check.healthy_statuses", cfg.HealthCheck.HealthyStatuses); err != nil {
+        return nil, err
+    }
+    if err := validateHealthCheckType("health_check.type", cfg.HealthCheck.Type); err != nil {
+        return nil, err
+    }
+    if cfg.HealthCheck.MaxConcurrent < 0 {
+        return nil, fmt.Errorf("health_check.max_concurrent must not be negative")
+    }
+    if backoff := cfg.HealthCheck.Backoff; backoff.Max != 0 || backoff.Base != 0 || backoff.Multiplier != 0 {
+        switch {
+        case backoff.Base < 0 || backoff.Max < 0:
+            return nil, fmt.Errorf("health_check.backoff: base and max must not be negative")
+        case backoff.Multiplier != 0 && backoff.Multiplier < 1:
+            return nil, fmt.Errorf("health_check.backoff.multiplier: %g must be at least 1", backoff.Multiplier)
+        case backoff.Max == 0:
+            return nil, fmt.Errorf("health_check.backoff.max is required to enable backoff")
+        case backoff.Max < max(backoff.Base, cfg.HealthCheck.EffectiveInterval()):
+            return nil, fmt.Errorf("health_check.backoff.max (%s) must not be less than base and interval", backoff.Max)
+        }
+    }
+    switch cfg.HealthCheck.InitialState {
+    case "", "healthy":
+    case "unhealthy":
+        if cfg.HealthCheck.EffectiveInterval() == 0 {
+            return nil, fmt.Errorf("health_check.initial_state: unhealthy requires health checks (interval > 0)")
+        }
+    default:
+        return nil, fmt.Errorf("health_check.initial_state: unknown value %q (expected healthy or unhealthy)", cfg.HealthCheck.InitialState)
+    }
+    if cfg.HealthCheck.SlowStart < 0 {
+        return nil, fmt.Errorf("health_check.slow_start must not be negative")
+    }
+    if cfg.HealthCheck.ExpectedBody != "" && cfg.HealthCheck.ExpectedBodyRegex != "" {
+        return nil, fmt.Errorf("health_check.expected_body and health_check.expected_body_regex are mutually exclusive")
+    }
+    if _, err := regexp.Compile(cfg.HealthCheck.ExpectedBodyRegex); err != nil {
+        return nil, fmt.Errorf("health_check.expected_body_regex: %v", err)
+    }
+    if err := validateSensitiveHeaders("sensitive_headers", cfg.SensitiveHeaders); err != nil {
+        return nil, err
+    }
+    for _, backend := range cfg.Backends {
+        if backend.EffectiveWeight() < 0 {
+            return nil, fmt.Errorf("backends: weight of %s must not be negative", backend.URL)
+        }
+        if backend.MaxConcurrent < 0 {
+            return nil, fmt.Errorf("backends: max_concurrent of %s must not be negative", backend.URL)
+        }
+        if backend.Timeout < 0 {
+            return nil, fmt.Errorf("backends: timeout of %s must not be negative", backend.URL)
+        }
+        if backend.HealthCheck != nil {
+            if err := validateStatuses("backends: "+backend.URL+": health_check.healthy_statuses", backend.HealthCheck.HealthyStatuses); err != nil {
+                return nil, err
+            }
+            if err := validateHealthCheckType("backends: "+backend.URL+": health_check.type", backend.HealthCheck.Type); err != nil {
+                return nil, err
+            }
+        }
+        if backend.TLS != nil && backend.TLS.ServerName != "" && backend.TLS.VerifyName != "" {
+            return nil, fmt.Errorf("backends: %s: tls.server_name and tls.verify_name are mutually exclusive", backend.URL)
+        }
+        if backend.SensitiveHeaders != nil {
+            if err := validateSensitiveHeaders("backends: "+backend.URL+": sensitive_headers", *backend.SensitiveHeaders); err != nil {
+                return nil, err
+            }
+        }
     }
 
+    for _, header := range cfg.RequiredHeaders {
+        if header.Name == "" {
+            return nil, fmt.Errorf("required_headers: name must not be empty")
+        }
+        if _, err := regexp.Compile(header.Pattern); err != nil {
+            return nil, fmt.Errorf("required_headers: invalid pattern for %s: %v", header.Name, err)
+        }
+    }
+
+    if (cfg.RequestSigning.AllBackends || anyBackendSigned(cfg.Backends)) && cfg.RequestSigning.Secret == "" {
+        return nil, fmt.Errorf("request_signing.secret is required when request signing is enabled")
+    }
+
+    if m := cfg.Options.Mode; m != "" && m != "passthrough" && m != "respond" {
+        return nil, fmt.Errorf("options.mode: unknown value %q (expected passthrough or respond)", m)
+    }
+
+    if cfg.Geo.Enabled && cfg.Geo.Database == "" {
+        return nil, fmt.Errorf("geo.database is required when geo balancing is enabled")
+    }
+
+    if cfg.BodyRouting.Enabled {
+        if cfg.BodyRouting.Field == "" {
+            return nil, fmt.Errorf("body_routing.field is required when body routing is enabled")
+        }
+        for i, route := range cfg.BodyRouting.Routes {
+            if len(route.Values) == 0 || len(route.Backends) == 0 {
+                return nil, fmt.Errorf("body_routing.routes[%d]: values and backends must not be empty", i)
+            }
+        }
+    }
+
+    switch cfg.Metrics.Sink {
+    case "", "none":
+    case "prometheus":
+        if cfg.Admin.Addr == "" {
+            return nil, fmt.Errorf("metrics.sink prometheus requires admin.addr to serve /metrics")
+        }
+    case "statsd":
+        if cfg.Metrics.StatsD.Addr == "" {
+            return nil, fmt.Errorf("metrics.statsd.addr is required when metrics.sink is statsd")
+        }
+        if t := cfg.Metrics.StatsD.Tags; t != "" && t != "dogstatsd" && t != "none" {
+            return nil, fmt.Errorf("metrics.statsd.tags: unknown value %q (expected dogstatsd or none)", t)
+        }
+    default:
+        return nil, fmt.Errorf("metrics.sink: unknown value %q (expected prometheus, statsd or none)", cfg.Metrics.Sink)
+    }
+
+    if cfg.Quota.Enabled {
+        if err := validateQuotaPolicy("quota.default", cfg.Quota.Default); err != nil {
+            return nil, err
+        }
+        for clientID, policy := range cfg.Quota.Clients {
+            if err := validateQuotaPolicy("quota.clients."+clientID, policy); err != nil {
+                return nil, err
+            }
+        }
+        if w := cfg.Quota.Window; w != "" && w != "calendar" && w != "rolling" {
+            return nil, fmt.Errorf("quota.window: unknown value %q (expected calendar or rolling)", w)
+        }
+    }
+
+    if err := cfg.Validate(); err != nil {
+        return nil, err
+    }
     return &cfg, nil
 }
+
+// Validate проверяет базовые параметры, без которых прокси не сможет работать:
+// хотя бы один backend с корректным URL, порт и неотрицательный лимит по умолчанию.
+// Возвращает все найденные ошибки сразу, по одной на строку.
+func (c *Config) Validate() error {
+    var errs []error
+    if c.Listen == "" && (c.Port < 1 || c.Port > 65535) {
+        errs = append(errs, fmt.Errorf("port: %d is out of range 1-65535", c.Port))
+    }
+    if c.Listen == "unix:" {
+        errs = append(errs, fmt.Errorf("listen: unix socket path must not be empty"))
+    }
+    if len(c.Backends) == 0 && len(c.Routes) == 0 {
+        errs = append(errs, fmt.Errorf("backends: at least one backend is required"))
+    }
+    errs = append(errs, validateBackendURLs("backends", c.Backends)...)
+    prefixes := make(map[string]bool, len(c.Routes))
+    for i, route := range c.Routes {
+        field := fmt.Sprintf("routes[%d]", i)
+        switch normalized := strings.TrimSuffix(route.Prefix, "/"); {
+        case !strings.HasPrefix(route.Prefix, "/"):
+            errs = append(errs, fmt.Errorf("%s.prefix: %q must start with /", field, route.Prefix))
+        case prefixes[normalized]:
+            errs = append(errs, fmt.Errorf("%s.prefix: %q is already routed", field, route.Prefix))
+        default:
+            prefixes[normalized] = true
+        }
+        if len(route.Backends) == 0 {
+            errs = append(errs, fmt.Errorf("%s.backends: at least one backend is required", field))
+        }
+        errs = append(errs, validateBackendURLs(field+".backends", route.Backends)...)
+        if route.Strategy != "" && !knownStrategy(route.Strategy) {
+            errs = append(errs, fmt.Errorf("%s.strategy: unknown value %q (expected one of %s)", field, route.Strategy, strings.Join(Strategies, ", ")))
+        }
+    }
+    hosts := make([]string, 0, len(c.Hosts))
+    for host := range c.Hosts {
+        hosts = append(hosts, host)
+    }
+    sort.Strings(hosts)
+    for _, host := range hosts {
+        group, field := c.Hosts[host], "hosts."+host
+        if name := strings.TrimPrefix(host, "*."); name == "" || strings.ContainsAny(name, "*/: ") {
+            errs = append(errs, fmt.Errorf("%s: invalid host name (expected api.example.com or *.example.com)", field))
+        }
+        if len(group.Backends) == 0 {
+            errs = append(errs, fmt.Errorf("%s.backends: at least one backend is required", field))
+        }
+        errs = append(errs, validateBackendURLs(field+".backends", group.Backends)...)
+        if group.Strategy != "" && !knownStrategy(group.Strategy) {
+            errs = append(errs, fmt.Errorf("%s.strategy: unknown value %q (expected one of %s)", field, group.Strategy, strings.Join(Strategies, ", ")))
+        }
+    }
+    switch key := c.ConsistentHash.Key; {
+    case key == "", key == "url", key == "path", key == "ip":
+    case strings.HasPrefix(key, "header:") && strings.TrimSpace(strings.TrimPrefix(key, "header:")) != "":
+    default:
+        errs = append(errs, fmt.Errorf("consistent_hash.key: unknown value %q (expected url, path, ip or header:<name>)", key))
+    }
+    if c.ConsistentHash.VirtualNodes < 0 || c.ConsistentHash.VirtualNodes > 10000 {
+        errs = append(errs, fmt.Errorf("consistent_hash.virtual_nodes: %d is out of range 0-10000", c.ConsistentHash.VirtualNodes))
+    }
+    if o := c.OutlierDetection; o.Interval < 0 || o.Consecutive5xx < 0 || o.MinRequests < 0 || o.EjectionTime < 0 || o.LatencyFactor < 0 {
+        errs = append(errs, fmt.Errorf("outlier_detection: interval, consecutive_5xx, min_requests, ejection_time and latency_factor must not be negative"))
+    }
+    if t := c.OutlierDetection.SuccessRateThreshold; t < 0 || t > 1 {
+        errs = append(errs, fmt.Errorf("outlier_detection.success_rate_threshold: %v is out of range 0-1", t))
+    }
+    if p := c.OutlierDetection.MaxEjectionPercent; p < 0 || p > 100 {
+        errs = append(errs, fmt.Errorf("outlier_detection.max_ejection_percent: %d is out of range 0-100", p))
+    }
+    if d := c.LeastResponseTime.Decay; d < 0 || d > 1 {
+        errs = append(errs, fmt.Errorf("least_response_time.decay: %v is out of range (0, 1] (or 0 for the default)", d))
+    }
+    errs = append(errs, validateHeaderRules("request_headers", c.RequestHeaders)...)
+    errs = append(errs, validateHeaderRules("response_headers", c.ResponseHeaders)...)
+    for i, rule := range c.Rewrite {
+        field := fmt.Sprintf("rewrite[%d]", i)
+        switch {
+        case (rule.StripPrefix == "") == (rule.Regex == ""):
+            errs = append(errs, fmt.Errorf("%s: exactly one of strip_prefix or regex is required", field))
+        case rule.StripPrefix != "" && !strings.HasPrefix(rule.StripPrefix, "/"):
+            errs = append(errs, fmt.Errorf("%s.strip_prefix: %q must start with /", field, rule.StripPrefix))
+        case rule.StripPrefix != "" && rule.Replacement != "":
+            errs = append(errs, fmt.Errorf("%s.replacement: only allowed with regex", field))
+        case rule.Regex != "":
+            if _, err := regexp.Compile(rule.Regex); err != nil {
+                errs = append(errs, fmt.Errorf("%s.regex: %v", field, err))
+            }
+        }
+    }
+    if len(c.Routes) > 0 && c.BodyRouting.Enabled {
+        errs = append(errs, fmt.Errorf("body_routing: cannot be combined with routes"))
+    }
+    if c.RateLimit.Capacity < 0 {
+        errs = append(errs, fmt.Errorf("rate_limit.capacity: %d must not be negative", c.RateLimit.Capacity))
+    }
+    if c.RateLimit.RefillRate < 0 {
+        errs = append(errs, fmt.Errorf("rate_limit.refill_rate: %d must not be negative", c.RateLimit.RefillRate))
+    }
+    clientIDs := make([]string, 0, len(c.RateLimit.Clients))
+    for clientID := range c.RateLimit.Clients {
+        clientIDs = append(clientIDs, clientID)
+    }
+    sort.Strings(clientIDs)
+    for _, clientID := range clientIDs {
+        limit := c.RateLimit.Clients[clientID]
+        if limit.Capacity < 0 {
+            errs = append(errs, fmt.Errorf("rate_limit.clients.%s.capacity: %d must not be negative", clientID, limit.Capacity))
+        }
+        if limit.RefillRate < 0 {
+            errs = append(errs, fmt.Errorf("rate_limit.clients.%s.refill_rate: %d must not be negative", clientID, limit.RefillRate))
+        }
+    }
+    if c.RateLimit.GlobalCapacity < 0 {
+        errs = append(errs, fmt.Errorf("rate_limit.global_capacity: %d must not be negative", c.RateLimit.GlobalCapacity))
+    }
+    if c.RateLimit.GlobalCapacity > 0 && c.RateLimit.GlobalRefillRate <= 0 {
+        errs = append(errs, fmt.Errorf("rate_limit.global_refill_rate: must be positive when global_capacity is set"))
+    }
+    if t := c.Server; t.ReadHeaderTimeout < 0 || t.ReadTimeout < 0 || t.WriteTimeout < 0 || t.IdleTimeout < 0 {
+        errs = append(errs, fmt.Errorf("server: timeouts must not be negative"))
+    }
+    if t := c.ConnectionPool; t.MaxIdleConns < 0 || t.MaxIdleConnsPerHost < 0 || t.MaxConnsPerHost < 0 || t.IdleConnTimeout < 0 {
+        errs = append(errs, fmt.Errorf("connection_pool: values must not be negative"))
+    }
+    if t := c.ConnectionPool; t.MaxConnsPerHost > 0 && t.MaxIdleConnsPerHost > t.MaxConnsPerHost {
+        errs = append(errs, fmt.Errorf("connection_pool.max_idle_conns_per_host: %d must not exceed max_conns_per_host (%d)", t.MaxIdleConnsPerHost, t.MaxConnsPerHost))
+    }
+    if c.Server.WriteTimeout > 0 && c.RequestTimeout > c.Server.WriteTimeout {
+        errs = append(errs, fmt.Errorf("server.write_timeout: %s must not be less than request_timeout (%s), otherwise clients never see the 504", c.Server.WriteTimeout, c.RequestTimeout))
+    }
+    switch c.AccessLog.Level {
+    case "", "debug", "info", "warn", "error":
+    default:
+        errs = append(errs, fmt.Errorf("access_log.level: unknown value %q (expected debug, info, warn or error)", c.AccessLog.Level))
+    }
+    if c.Shutdown.GracePeriod < 0 || c.Shutdown.DrainDelay < 0 {
+        errs = append(errs, fmt.Errorf("shutdown.grace_period and shutdown.drain_delay must not be negative"))
+    }
+    if path := c.Probes.LivePath; path != "" && !strings.HasPrefix(path, "/") {
+        errs = append(errs, fmt.Errorf("probes.live_path: %q must start with /", path))
+    }
+    if path := c.Probes.LivePath; path != "" && path == c.Probes.ReadyPath {
+        errs = append(errs, fmt.Errorf("probes.live_path and probes.ready_path must differ"))
+    }
+    if path := c.Probes.ReadyPath; path != "" && !strings.HasPrefix(path, "/") {
+        errs = append(errs, fmt.Errorf("probes.ready_path: %q must start with /", path))
+    }
+    if c.RateLimit.CleanupInterval < 0 || c.RateLimit.StaleAfter < 0 {
+        errs = append(errs, fmt.Errorf("rate_limit.cleanup_interval and rate_limit.stale_after must not be negative"))
+    }
+    if c.RateLimit.MaxBuckets < 0 {
+        errs = append(errs, fmt.Errorf("rate_limit.max_buckets: %d must not be negative", c.RateLimit.MaxBuckets))
+    }
+    if _, err := clientip.ParseIPList(c.RateLimit.Allowlist); err != nil {
+        errs = append(errs, fmt.Errorf("rate_limit.allowlist: %v", err))
+    }
+    switch strings.ToLower(c.Log.Level) {
+    case "", "debug", "info", "warn", "error":
+    default:
+        errs = append(errs, fmt.Errorf("log.level: unknown value %q (expected debug, info, warn or error)", c.Log.Level))
+    }
+    if f := c.Log.Format; f != "" && f != LogFormatJSON && f != LogFormatConsole {
+        errs = append(errs, fmt.Errorf("log.format: unknown value %q (expected json or console)", f))
+    }
+    if m := c.Maintenance; m.Status != 0 && (m.Status < 200 || m.Status > 599) {
+        errs = append(errs, fmt.Errorf("maintenance.status: %d is not a valid response status", m.Status))
+    }
+    if c.Maintenance.Body != "" && c.Maintenance.BodyFile != "" {
+        errs = append(errs, fmt.Errorf("maintenance.body and maintenance.body_file are mutually exclusive"))
+    }
+    if c.Maintenance.RetryAfter < 0 {
+        errs = append(errs, fmt.Errorf("maintenance.retry_after must not be negative"))
+    }
+    for _, path := range c.Maintenance.Paths {
+        if !strings.HasPrefix(path, "/") {
+            errs = append(errs, fmt.Errorf("maintenance.paths: %q must start with /", path))
+        }
+    }
+    if _, err := clientip.ParseIPList(c.Maintenance.Allowlist); err != nil {
+        errs = append(errs, fmt.Errorf("maintenance.allowlist: %v", err))
+    }
+    if _, err := clientip.ParseIPList(c.Denylist); err != nil {
+        errs = append(errs, fmt.Errorf("denylist: %v", err))
+    }
+    if _, err := clientip.ParseIPList(c.TrustedProxies); err != nil {
+        errs = append(errs, fmt.Errorf("trusted_proxies: %v", err))
+    }
+    if _, err := clientip.ParseIPList(c.ProxyProtocol.TrustedUpstreams); err != nil {
+        errs = append(errs, fmt.Errorf("proxy_protocol.trusted_upstreams: %v", err))
+    } else if c.ProxyProtocol.Enabled && len(c.ProxyProtocol.TrustedUpstreams) == 0 {
+        errs = append(errs, fmt.Errorf("proxy_protocol.trusted_upstreams is required when proxy_protocol is enabled"))
+    }
+    if c.ProxyProtocol.HeaderTimeout < 0 {
+        errs = append(errs, fmt.Errorf("proxy_protocol.header_timeout must not be negative"))
+    }
+    if len(errs) > 0 {
+        return fmt.Errorf("invalid config:\n%w", errors.Join(errs...))
+    }
+    return nil
+}
+
+// validateBackendURLs проверяет, что у каждого backend'а указаны схема и хост.
+func validateBackendURLs(field string, backends []BackendConfig) []error {
+    var errs []error
+    for i, backend := range backends {
+        parsed, err := url.Parse(backend.URL)
+        switch {
+        case err != nil:
+            errs = append(errs, fmt.Errorf("%s[%d].url: %v", field, i, err))
+        case parsed.Scheme == "" || parsed.Host == "":
+            errs = append(errs, fmt.Errorf("%s[%d].url: %q must include a scheme and host, e.g. http://backend:9001", field, i, backend.URL))
+        }
+    }
+    return errs
+}
+
+// RegisterStrategy добавляет имя пользовательской стратегии в Strategies, чтобы оно
+// проходило проверку конфигурации. Вызывается из balancer.Register.
+func RegisterStrategy(name string) {
+    if !knownStrategy(name) {
+        Strategies = append(Strategies, name)
+    }
+}
+
+func knownStrategy(strategy string) bool {
+    for _, known := range Strategies {
+        if strategy == known {
+            return true
+        }
+    }
+    return false
+}
+
+func anyBackendSigned(backends []BackendConfig) bool {
+    for _, backend := range backends {
+        if backend.SignRequests {
+            return true
+        }
+    }
+    return false
+}
+
+func validateParamPatterns(patterns []string) error {
+    for _, pattern := range patterns {
+        if _, err := path.Match(pattern, ""); err != nil {
+            return fmt.Errorf("query_canonicalization.ignore_params: invalid pattern %q: %v", pattern, err)
+        }
+    }
+    return nil
+}
+
+func validateStatuses(field string, statuses []int) error {
+    for _, status := range statuses {
+        if status < 100 || status > 599 {
+            return fmt.Errorf("%s: invalid HTTP status %d", field, status)
+        }
+    }
+    return nil
+}
+
+func validateHealthCheckType(field, checkType string) error {
+    switch checkType {
+    case "", HealthCheckHTTP, HealthCheckTCP:
+        return nil
+    default:
+        return fmt.Errorf("%s: unknown value %q (expected http or tcp)", field, checkType)
+    }
+}
+
+func validateSensitiveHeaders(field string, policy SensitiveHeadersConfig) error {
+    switch policy.Mode {
+    case "", "preserve", "strip":
+    case "replace":
+        if policy.Value == "" {
+            return fmt.Errorf("%s.value is required for mode replace", field)
+        }
+    default:
+        return fmt.Errorf("%s.mode: unknown value %q (expected preserve, strip or replace)", field, policy.Mode)
+    }
+    return nil
+}
+
+// validateHeaderRules проверяет имена заголовков: пустые и с пробелами или двоеточием отклоняются.
+func validateHeaderRules(field string, rules HeaderRules) []error {
+    var errs []error
+    names := make([]string, 0, len(rules.Add))
+    for name := range rules.Add {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    for _, name := range names {
+        if !validHeaderName(name) {
+            errs = append(errs, fmt.Errorf("%s.add: invalid header name %q", field, name))
+        } else if field == "request_headers" && strings.EqualFold(name, "Host") {
+            errs = append(errs, fmt.Errorf("%s.add: Host cannot be set, it is always the backend address", field))
+        }
+    }
+    for i, name := range rules.Remove {
+        if !validHeaderName(name) {
+            errs = append(errs, fmt.Errorf("%s.remove[%d]: invalid header name %q", field, i, name))
+        }
+    }
+    return errs
+}
+
+func validHeaderName(name string) bool {
+    return name != "" && !strings.ContainsAny(name, " \t:\r\n")
+}
+
+func validateQuotaPolicy(field string, policy QuotaPolicy) error {
+    if policy.Limit < 0 {
+        return fmt.Errorf("%s.limit must not be negative", field)
+    }
+    if policy.Period != "day" && policy.Period != "month" && !(policy.Limit == 0 && policy.Period == "") {
+        return fmt.Errorf("%s.period: unknown value %q (expected day or month)", field, policy.Period)
+    }
+    return nil
+}
@@ -0,0 +1,74 @@
+package config
+
+import (
+    "bytes"
+    "fmt"
+    "os"
+    "regexp"
+    "strings"
+)
+
+// envReference — ссылка на переменную окружения в файле конфигурации: ${VAR}, ${VAR:-default}
+// или $VAR, а также $$ для литерального $. Учитываются только имена из заглавных букв,
+// цифр и _, поэтому $1, ${1} и ${name} в замене rewrite остаются как есть.
+var envReference = regexp.MustCompile(`\$\$|\$\{([A-Z_][A-Z0-9_]*)(:-[^}]*)?\}|\$([A-Z_][A-Z0-9_]*)`)
+
+// expandEnv подставляет переменные окружения в файл конфигурации до разбора YAML.
+// Комментарии (целые строки и хвосты после " #" вне кавычек) не обрабатываются: YAML
+// их все равно отбрасывает. Ссылка на незаданную переменную без значения по умолчанию —
+// ошибка с именем переменной и номером строки; пустое значение заменяется значением
+// по умолчанию, как ${VAR:-default} в shell.
+func expandEnv(data []byte) ([]byte, error) {
+    var missing []string
+    lines := bytes.Split(data, []byte("\n"))
+    for i, line := range lines {
+        end := commentStart(line)
+        expanded := envReference.ReplaceAllFunc(line[:end], func(ref []byte) []byte {
+            if string(ref) == "$$" {
+                return []byte("$")
+            }
+            match := envReference.FindSubmatch(ref)
+            name, fallback := string(match[1])+string(match[3]), match[2]
+            value, ok := os.LookupEnv(name)
+            switch {
+            case fallback != nil && value == "":
+                return fallback[len(":-"):]
+            case !ok:
+                missing = append(missing, fmt.Sprintf("%s (line %d)", name, i+1))
+                return ref
+            }
+            return []byte(value)
+        })
+        lines[i] = append(expanded, line[end:]...)
+    }
+    if len(missing) > 0 {
+        return nil, fmt.Errorf("environment variables referenced in the config are not set and have no default: %s", strings.Join(missing, ", "))
+    }
+    return bytes.Join(lines, []byte("\n")), nil
+}
+
+// commentStart возвращает позицию начала комментария YAML в строке (len(line), если его нет):
+// # в начале строки или после пробела, вне строк в одинарных и двойных кавычках.
+// Кавычка открывает строку только в начале значения, поэтому апостроф внутри
+// слова (it's) строкой не считается.
+func commentStart(line []byte) int {
+    var quote byte
+    for i := 0; i < len(line); i++ {
+        c := line[i]
+        switch {
+        case quote == '"' && c == '\\':
+            i++ // Экранированный символ, в том числе \"
+        case quote == '\'' && c == '\'' && i+1 < len(line) && line[i+1] == '\'':
+            i++ // '' — апостроф внутри строки в одинарных кавычках
+        case quote != 0:
+            if c == quote {
+                quote = 0
+            }
+        case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+            return i
+        case (c == '"' || c == '\'') && (i == 0 || strings.IndexByte(" \t:[{,-", line[i-1]) >= 0):
+            quote = c
+        }
+    }
+    return len(line)
+}
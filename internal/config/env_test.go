@@ -0,0 +1,58 @@
+package config
+
+import (
+    "strings"
+    "testing"
+)
+
+func TestExpandEnv(t *testing.T) {
+    t.Setenv("LB_PORT", "9090")
+    t.Setenv("LB_EMPTY", "")
+
+    tests := []struct {
+        name  string
+        input string
+        want  string
+    }{
+        {"braced and bare", "port: ${LB_PORT}\nurl: http://h:$LB_PORT", "port: 9090\nurl: http://h:9090"},
+        {"default for unset", "port: ${LB_UNSET_PORT:-8080}", "port: 8080"},
+        {"default for empty", "port: ${LB_EMPTY:-8080}", "port: 8080"},
+        {"default ignored when set", "port: ${LB_PORT:-8080}", "port: 9090"},
+        {"literal dollar", "secret: pa$$word", "secret: pa$word"},
+        {"lowercase and numeric references untouched", "replacement: /v2/${rest}$1", "replacement: /v2/${rest}$1"},
+        {"whole-line comment", "  # port: $LB_UNSET", "  # port: $LB_UNSET"},
+        {"trailing comment", "port: $LB_PORT  # override with $LB_UNSET", "port: 9090  # override with $LB_UNSET"},
+        {"hash inside double quotes", `body: "a #$LB_PORT" # $LB_UNSET`, `body: "a #9090" # $LB_UNSET`},
+        {"hash inside single quotes", `body: 'it''s #$LB_PORT'`, `body: 'it''s #9090'`},
+        {"hash without leading space", "url: http://h/#$LB_PORT", "url: http://h/#9090"},
+        {"apostrophe in plain scalar", "note: it's $LB_PORT # $LB_UNSET", "note: it's 9090 # $LB_UNSET"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got, err := expandEnv([]byte(tt.input))
+            if err != nil {
+                t.Fatalf("expandEnv: %v", err)
+            }
+            if string(got) != tt.want {
+                t.Errorf("Expected %q, got %q", tt.want, got)
+            }
+        })
+    }
+}
+
+func TestExpandEnv_MissingVariables(t *testing.T) {
+    _, err := expandEnv([]byte("# $LB_COMMENTED\nport: ${LB_MISSING_PORT}\nurl: http://$LB_MISSING_HOST # $LB_IN_COMMENT\n"))
+    if err == nil {
+        t.Fatal("Expected an error for unset variables without a default")
+    }
+    for _, want := range []string{"LB_MISSING_PORT (line 2)", "LB_MISSING_HOST (line 3)"} {
+        if !strings.Contains(err.Error(), want) {
+            t.Errorf("Expected %q in %v", want, err)
+        }
+    }
+    for _, unexpected := range []string{"LB_COMMENTED", "LB_IN_COMMENT"} {
+        if strings.Contains(err.Error(), unexpected) {
+            t.Errorf("Variables in comments must be ignored, got %v", err)
+        }
+    }
+}
@@ -0,0 +1,138 @@
+package config
+
+import (
+    "reflect"
+    "strings"
+    "time"
+
+    "gopkg.in/yaml.v2"
+)
+
+// RedactedValue подставляется вместо секретов при экспорте конфигурации.
+const RedactedValue = "<redacted>"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Redacted возвращает копию конфигурации со скрытыми секретами: request_signing.secret,
+// токенами admin API, значениями sensitive_headers.value (общей политики и каждого backend'а,
+// включая routes, hosts, body_routing и standby) и значениями заголовков selftest.headers,
+// request_headers.add и response_headers.add.
+func (c *Config) Redacted() *Config {
+    redacted := *c
+    if redacted.RequestSigning.Secret != "" {
+        redacted.RequestSigning.Secret = RedactedValue
+    }
+    redacted.Admin.Tokens = redactValues(c.Admin.Tokens)
+    redacted.SensitiveHeaders = redactSensitiveHeaders(c.SensitiveHeaders)
+    redacted.SelfTest.Headers = redactValues(c.SelfTest.Headers)
+    redacted.RequestHeaders.Add = redactValues(c.RequestHeaders.Add)
+    redacted.ResponseHeaders.Add = redactValues(c.ResponseHeaders.Add)
+
+    redacted.Backends = redactBackends(c.Backends)
+    redacted.Standby.Backends = redactBackends(c.Standby.Backends)
+    if c.Routes != nil {
+        redacted.Routes = make([]Route, len(c.Routes))
+        for i, route := range c.Routes {
+            route.Backends = redactBackends(route.Backends)
+            redacted.Routes[i] = route
+        }
+    }
+    if c.Hosts != nil {
+        redacted.Hosts = make(map[string]BackendGroup, len(c.Hosts))
+        for host, group := range c.Hosts {
+            group.Backends = redactBackends(group.Backends)
+            redacted.Hosts[host] = group
+        }
+    }
+    if c.BodyRouting.Routes != nil {
+        redacted.BodyRouting.Routes = make([]BodyRoute, len(c.BodyRouting.Routes))
+        for i, route := range c.BodyRouting.Routes {
+            route.Backends = redactBackends(route.Backends)
+            redacted.BodyRouting.Routes[i] = route
+        }
+    }
+    return &redacted
+}
+
+// redactBackends копирует список backend'ов, скрывая значения их sensitive_headers.
+func redactBackends(backends []BackendConfig) []BackendConfig {
+    if backends == nil {
+        return nil
+    }
+    redacted := make([]BackendConfig, len(backends))
+    for i, backend := range backends {
+        if backend.SensitiveHeaders != nil {
+            policy := redactSensitiveHeaders(*backend.SensitiveHeaders)
+            backend.SensitiveHeaders = &policy
+        }
+        redacted[i] = backend
+    }
+    return redacted
+}
+
+func redactSensitiveHeaders(policy SensitiveHeadersConfig) SensitiveHeadersConfig {
+    if policy.Value != "" {
+        policy.Value = RedactedValue
+    }
+    return policy
+}
+
+// redactValues копирует map, заменяя все значения на RedactedValue (ключи сохраняются).
+func redactValues(values map[string]string) map[string]string {
+    if len(values) == 0 {
+        return values
+    }
+    redacted := make(map[string]string, len(values))
+    for key := range values {
+        redacted[key] = RedactedValue
+    }
+    return redacted
+}
+
+// Marshal сериализует конфигурацию в YAML, который снова читается через Load.
+// Длительности записываются строками (30s), незаданные (нулевые) поля опускаются.
+func Marshal(c *Config) ([]byte, error) {
+    return yaml.Marshal(yamlValue(reflect.ValueOf(c)))
+}
+
+// yamlValue переводит значение конфигурации в форму для yaml.Marshal
+// с учетом тегов yaml и форматирования длительностей.
+func yamlValue(v reflect.Value) interface{} {
+    switch {
+    case v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface:
+        if v.IsNil() {
+            return nil
+        }
+        return yamlValue(v.Elem())
+    case v.Type() == durationType:
+        return time.Duration(v.Int()).String()
+    }
+
+    switch v.Kind() {
+    case reflect.Struct:
+        var fields yaml.MapSlice
+        for i := 0; i < v.NumField(); i++ {
+            field := v.Type().Field(i)
+            name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+            if name == "" || name == "-" || !field.IsExported() || v.Field(i).IsZero() {
+                continue
+            }
+            fields = append(fields, yaml.MapItem{Key: name, Value: yamlValue(v.Field(i))})
+        }
+        return fields
+    case reflect.Slice:
+        items := make([]interface{}, v.Len())
+        for i := range items {
+            items[i] = yamlValue(v.Index(i))
+        }
+        return items
+    case reflect.Map:
+        items := make(map[interface{}]interface{}, v.Len())
+        for _, key := range v.MapKeys() {
+            items[key.Interface()] = yamlValue(v.MapIndex(key))
+        }
+        return items
+    default:
+        return v.Interface()
+    }
+}
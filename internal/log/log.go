@@ -1,9 +1,40 @@
 package log
 
-import "go.uber.org/zap"
+import (
+    "fmt"
+    "strings"
 
-func New() *zap.SugaredLogger {
-    logger, _ := zap.NewProduction()
-    defer logger.Sync()
-    return logger.Sugar()
-}
\ No newline at end of file
+    "github.com/Manzo48/loadBalancer/internal/config"
+    "go.uber.org/zap"
+    "go.uber.org/zap/zapcore"
+)
+
+// New создает логгер по разделу log конфигурации: уровень, формат (json или console)
+// и вывод (stdout, stderr или файл). Пустые поля — как у zap.NewProduction.
+// Буферы сбрасывает вызывающий через Sync перед завершением процесса.
+func New(cfg config.LogConfig) (*zap.SugaredLogger, error) {
+    zapCfg := zap.NewProductionConfig()
+    if cfg.Format == config.LogFormatConsole {
+        // Человекочитаемый вывод, но уровень и поведение Fatal/DPanic — как в production
+        zapCfg = zap.NewDevelopmentConfig()
+        zapCfg.Development = false
+        zapCfg.Level = zap.NewAtomicLevelAt(zapcore.InfoLevel)
+    }
+
+    if cfg.Level != "" {
+        level, err := zapcore.ParseLevel(strings.ToLower(cfg.Level))
+        if err != nil {
+            return nil, fmt.Errorf("log.level: %w", err)
+        }
+        zapCfg.Level = zap.NewAtomicLevelAt(level)
+    }
+    if cfg.Output != "" {
+        zapCfg.OutputPaths = []string{cfg.Output}
+    }
+
+    logger, err := zapCfg.Build()
+    if err != nil {
+        return nil, err
+    }
+    return logger.Sugar(), nil
+}
@@ -0,0 +1,162 @@
+package metrics
+
+import (
+    "fmt"
+    "net/http"
+    "sort"
+    "strings"
+    "sync"
+)
+
+// Metrics — минимальный интерфейс сбора метрик, используемый остальными пакетами.
+// labels передаются парами ключ/значение: "backend", "http://a:9001".
+type Metrics interface {
+    Inc(name string, labels ...string)
+    Add(name string, value float64, labels ...string)
+    Set(name string, value float64, labels ...string)
+    Observe(name string, value float64, labels ...string)
+}
+
+// Nop — реализация по умолчанию, которая ничего не делает.
+type Nop struct{}
+
+func (Nop) Inc(string, ...string)              {}
+func (Nop) Add(string, float64, ...string)     {}
+func (Nop) Set(string, float64, ...string)     {}
+func (Nop) Observe(string, float64, ...string) {}
+
+type metricKind int
+
+const (
+    kindCounter metricKind = iota
+    kindGauge
+    kindSummary
+)
+
+// series — одно значение метрики с конкретным набором меток.
+type series struct {
+    labels string
+    value  float64
+    count  uint64 // Для summary: количество наблюдений (value хранит сумму)
+}
+
+type family struct {
+    kind   metricKind
+    series map[string]*series
+}
+
+// Registry хранит метрики в памяти и отдает их в текстовом формате Prometheus.
+type Registry struct {
+    mu       sync.Mutex
+    families map[string]*family
+}
+
+// NewRegistry создает пустой реестр метрик.
+func NewRegistry() *Registry {
+    return &Registry{families: make(map[string]*family)}
+}
+
+// Inc увеличивает счетчик на единицу.
+func (r *Registry) Inc(name string, labels ...string) {
+    r.Add(name, 1, labels...)
+}
+
+// Add увеличивает счетчик на value.
+func (r *Registry) Add(name string, value float64, labels ...string) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    r.get(name, kindCounter, labels).value += value
+}
+
+// Set устанавливает значение gauge.
+func (r *Registry) Set(name string, value float64, labels ...string) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    r.get(name, kindGauge, labels).value = value
+}
+
+// Observe добавляет наблюдение в summary (экспортируются _sum и _count).
+func (r *Registry) Observe(name string, value float64, labels ...string) {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+    s := r.get(name, kindSummary, labels)
+    s.value += value
+    s.count++
+}
+
+// get возвращает (создавая при необходимости) серию метрики. Вызывается под r.mu.
+func (r *Registry) get(name string, kind metricKind, labels []string) *series {
+    f, ok := r.families[name]
+    if !ok {
+        f = &family{kind: kind, series: make(map[string]*series)}
+        r.families[name] = f
+    }
+    key := formatLabels(labels)
+    s, ok := f.series[key]
+    if !ok {
+        s = &series{labels: key}
+        f.series[key] = s
+    }
+    return s
+}
+
+// Handler отдает все метрики в текстовом формате Prometheus.
+func (r *Registry) Handler() http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+        w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+        w.Write([]byte(r.render()))
+    })
+}
+
+func (r *Registry) render() string {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    names := make([]string, 0, len(r.families))
+    for name := range r.families {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    var b strings.Builder
+    for _, name := range names {
+        f := r.families[name]
+        keys := make([]string, 0, len(f.series))
+        for key := range f.series {
+            keys = append(keys, key)
+        }
+        sort.Strings(keys)
+
+        switch f.kind {
+        case kindCounter:
+            fmt.Fprintf(&b, "# TYPE %s counter\n", name)
+        case kindGauge:
+            fmt.Fprintf(&b, "# TYPE %s gauge\n", name)
+        case kindSummary:
+            fmt.Fprintf(&b, "# TYPE %s summary\n", name)
+        }
+        for _, key := range keys {
+            s := f.series[key]
+            if f.kind == kindSummary {
+                fmt.Fprintf(&b, "%s_sum%s %g\n", name, s.labels, s.value)
+                fmt.Fprintf(&b, "%s_count%s %d\n", name, s.labels, s.count)
+                continue
+            }
+            fmt.Fprintf(&b, "%s%s %g\n", name, s.labels, s.value)
+        }
+    }
+    return b.String()
+}
+
+// formatLabels превращает пары ключ/значение в строку вида {k="v",...}.
+func formatLabels(labels []string) string {
+    if len(labels) < 2 {
+        return ""
+    }
+    parts := make([]string, 0, len(labels)/2)
+    for i := 0; i+1 < len(labels); i += 2 {
+        value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1])
+        parts = append(parts, fmt.Sprintf(`%s="%s"`, labels[i], value))
+    }
+    return "{" + strings.Join(parts, ",") + "}"
+}
@@ -0,0 +1,164 @@
+package metrics
+
+import (
+    "bytes"
+    "net"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+const (
+    defaultStatsDFlushInterval = 100 * time.Millisecond
+    defaultStatsDMaxPacket     = 1432 // Помещается в один UDP-пакет без фрагментации при MTU 1500
+)
+
+// StatsDOptions — параметры отправки метрик в StatsD/DogStatsD.
+type StatsDOptions struct {
+    Prefix         string        // Префикс имен метрик, например "lb."
+    FlushInterval  time.Duration // Как часто отправлять накопленный пакет
+    MaxPacketBytes int           // Предельный размер UDP-пакета
+    Tags           bool          // Передавать метки как теги DogStatsD (|#k:v); иначе метки отбрасываются
+}
+
+// StatsD отправляет метрики по UDP в формате StatsD. Строки накапливаются в буфере
+// и уходят одним пакетом по таймеру или при заполнении, а не отдельным syscall на каждую метрику.
+// Ошибки отправки игнорируются: недоступный агент не должен влиять на обработку запросов.
+type StatsD struct {
+    conn net.Conn
+    opts StatsDOptions
+
+    mu  sync.Mutex
+    buf bytes.Buffer
+
+    done chan struct{}
+    wg   sync.WaitGroup
+}
+
+// NewStatsD создает StatsD-клиент для адреса host:port и запускает периодическую отправку.
+func NewStatsD(addr string, opts StatsDOptions) (*StatsD, error) {
+    conn, err := net.Dial("udp", addr)
+    if err != nil {
+        return nil, err
+    }
+    if opts.FlushInterval <= 0 {
+        opts.FlushInterval = defaultStatsDFlushInterval
+    }
+    if opts.MaxPacketBytes <= 0 {
+        opts.MaxPacketBytes = defaultStatsDMaxPacket
+    }
+
+    s := &StatsD{conn: conn, opts: opts, done: make(chan struct{})}
+    s.wg.Add(1)
+    go s.run()
+    return s, nil
+}
+
+// Inc увеличивает счетчик на единицу.
+func (s *StatsD) Inc(name string, labels ...string) {
+    s.write(name, 1, "c", labels)
+}
+
+// Add увеличивает счетчик на value.
+func (s *StatsD) Add(name string, value float64, labels ...string) {
+    s.write(name, value, "c", labels)
+}
+
+// Set устанавливает значение gauge.
+func (s *StatsD) Set(name string, value float64, labels ...string) {
+    s.write(name, value, "g", labels)
+}
+
+// Observe отправляет наблюдение как таймер. Метрики с суффиксом _seconds
+// переводятся в миллисекунды, как ожидает StatsD.
+func (s *StatsD) Observe(name string, value float64, labels ...string) {
+    if strings.HasSuffix(name, "_seconds") {
+        value *= 1000
+    }
+    s.write(name, value, "ms", labels)
+}
+
+// write добавляет строку метрики в буфер, отправляя накопленное, если пакет переполнится.
+func (s *StatsD) write(name string, value float64, kind string, labels []string) {
+    line := s.format(name, value, kind, labels)
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if s.buf.Len() > 0 && s.buf.Len()+1+len(line) > s.opts.MaxPacketBytes {
+        s.flushLocked()
+    }
+    if s.buf.Len() > 0 {
+        s.buf.WriteByte('\n')
+    }
+    s.buf.WriteString(line)
+}
+
+// format собирает строку вида prefix.name:value|kind|#k:v,...
+func (s *StatsD) format(name string, value float64, kind string, labels []string) string {
+    var b strings.Builder
+    b.WriteString(s.opts.Prefix)
+    b.WriteString(name)
+    b.WriteByte(':')
+    b.WriteString(strconv.FormatFloat(value, 'f', -1, 64))
+    b.WriteByte('|')
+    b.WriteString(kind)
+
+    if s.opts.Tags && len(labels) >= 2 {
+        b.WriteString("|#")
+        for i := 0; i+1 < len(labels); i += 2 {
+            if i > 0 {
+                b.WriteByte(',')
+            }
+            b.WriteString(sanitizeTag(labels[i]))
+            b.WriteByte(':')
+            b.WriteString(sanitizeTag(labels[i+1]))
+        }
+    }
+    return b.String()
+}
+
+// sanitizeTag убирает символы, ломающие формат DogStatsD.
+func sanitizeTag(value string) string {
+    return strings.NewReplacer("|", "_", ",", "_", "#", "_", "\n", "_").Replace(value)
+}
+
+// run периодически отправляет накопленный буфер.
+func (s *StatsD) run() {
+    defer s.wg.Done()
+    ticker := time.NewTicker(s.opts.FlushInterval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ticker.C:
+            s.Flush()
+        case <-s.done:
+            return
+        }
+    }
+}
+
+// Flush немедленно отправляет накопленные метрики.
+func (s *StatsD) Flush() {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.flushLocked()
+}
+
+// flushLocked отправляет буфер одним пакетом. Вызывается под s.mu.
+func (s *StatsD) flushLocked() {
+    if s.buf.Len() == 0 {
+        return
+    }
+    s.conn.Write(s.buf.Bytes())
+    s.buf.Reset()
+}
+
+// Close отправляет остаток буфера и закрывает соединение.
+func (s *StatsD) Close() error {
+    close(s.done)
+    s.wg.Wait()
+    s.Flush()
+    return s.conn.Close()
+}
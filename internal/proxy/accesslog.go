@@ -0,0 +1,108 @@
+package proxy
+
+import (
+    "bufio"
+    "context"
+    "net"
+    "net/http"
+    "time"
+
+    "go.uber.org/zap/zapcore"
+)
+
+type accessLogKey struct{}
+
+// accessLogEntry — данные запроса для access log, которые становятся известны
+// глубже в цепочке обработчиков (выбранный backend).
+type accessLogEntry struct {
+    backend string
+}
+
+// setAccessLogBackend запоминает backend, к которому ушла попытка. При повторах
+// в access log попадает backend последней попытки.
+func setAccessLogBackend(r *http.Request, backend string) {
+    if entry, ok := r.Context().Value(accessLogKey{}).(*accessLogEntry); ok {
+        entry.backend = backend
+    }
+}
+
+// accessLogWriter запоминает код и размер ответа. Поддерживает Flusher (потоковые ответы)
+// и Hijacker (WebSocket): захваченное соединение записывается в лог как 101.
+type accessLogWriter struct {
+    http.ResponseWriter
+    status   int
+    bytes    int64
+    hijacked bool
+}
+
+func (w *accessLogWriter) WriteHeader(status int) {
+    if w.status == 0 && status >= http.StatusOK {
+        w.status = status
+    }
+    w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessLogWriter) Write(b []byte) (int, error) {
+    if w.status == 0 {
+        w.status = http.StatusOK
+    }
+    n, err := w.ResponseWriter.Write(b)
+    w.bytes += int64(n)
+    return n, err
+}
+
+func (w *accessLogWriter) Flush() {
+    if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+        flusher.Flush()
+    }
+}
+
+func (w *accessLogWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+    conn, rw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+    if err == nil {
+        w.hijacked = true
+    }
+    return conn, rw, err
+}
+
+func (w *accessLogWriter) Unwrap() http.ResponseWriter {
+    return w.ResponseWriter
+}
+
+// accessLogMiddleware пишет по строке на каждый запрос, включая отклоненные denylist
+// и лимитерами: метод, путь, IP клиента, backend, код ответа, длительность и размер тела.
+func (p *ProxyServer) accessLogMiddleware(next http.Handler) http.Handler {
+    if !p.accessLog.Enabled {
+        return next
+    }
+    level := zapcore.InfoLevel
+    if p.accessLog.Level != "" {
+        if parsed, err := zapcore.ParseLevel(p.accessLog.Level); err == nil {
+            level = parsed
+        }
+    }
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        start := time.Now()
+        entry := &accessLogEntry{}
+        writer := &accessLogWriter{ResponseWriter: w}
+        defer func() {
+            status := writer.status
+            switch {
+            case writer.hijacked:
+                status = http.StatusSwitchingProtocols
+            case status == 0:
+                status = http.StatusOK
+            }
+            p.requestLogger(r).Logw(level, "Access",
+                "method", r.Method,
+                "path", r.URL.Path,
+                "client_ip", getClientIP(r),
+                "backend", entry.backend,
+                "status", status,
+                "duration", time.Since(start),
+                "bytes", writer.bytes,
+            )
+        }()
+        next.ServeHTTP(writer, r.WithContext(context.WithValue(r.Context(), accessLogKey{}, entry)))
+    })
+}
@@ -0,0 +1,414 @@
+package proxy
+
+import (
+    "crypto/subtle"
+    "encoding/json"
+    "errors"
+    "math"
+    "net/http"
+    "net/url"
+    "strings"
+    "time"
+
+    "github.com/Manzo48/loadBalancer/internal/balancer"
+    "github.com/Manzo48/loadBalancer/internal/config"
+    "github.com/Manzo48/loadBalancer/internal/ratelimiter"
+)
+
+// startAdmin запускает служебный listener (метрики, admin API), отделенный от проксируемого трафика.
+func (p *ProxyServer) startAdmin() {
+    p.adminServer = &http.Server{
+        Addr:              p.adminAddr,
+        Handler:           p.AdminHandler(),
+        ReadHeaderTimeout: defaultReadHeaderTimeout,
+    }
+
+    p.logger.Infof("Starting admin server at %s", p.adminAddr)
+    go func() {
+        if err := p.adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+            p.logger.Errorf("Admin server failed: %v", err)
+        }
+    }()
+}
+
+// AdminHandler собирает обработчики admin API.
+func (p *ProxyServer) AdminHandler() http.Handler {
+    mux := http.NewServeMux()
+    if p.registry != nil {
+        mux.Handle("/metrics", p.metricsHandler())
+    }
+    mux.HandleFunc("/admin/backends", p.handleAdminBackends)
+    mux.HandleFunc("/admin/quota/", p.handleAdminQuota)
+    mux.HandleFunc("/admin/selftest", p.handleAdminSelfTest)
+    mux.HandleFunc("/admin/ratelimit/clients", p.handleAdminRateLimitClients)
+    mux.HandleFunc("/admin/ratelimit/clients/", p.handleAdminClientLimit)
+    mux.HandleFunc("/admin/certificates", p.handleAdminCertificates)
+    mux.HandleFunc("/admin/weights", p.handleAdminWeights)
+    mux.HandleFunc("/admin/config", p.handleAdminConfig)
+    mux.HandleFunc("/admin/maintenance", p.handleAdminMaintenance)
+
+    // URL backend'а в пути содержит "//", который ServeMux схлопнул бы редиректом,
+    // поэтому /admin/backends/{url} обрабатывается до него
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/admin/backends/drain" {
+            p.handleAdminDrain(w, r)
+            return
+        }
+        if strings.HasPrefix(r.URL.Path, "/admin/backends/") {
+            p.handleAdminBackend(w, r)
+            return
+        }
+        mux.ServeHTTP(w, r)
+    })
+}
+
+// authorizeOperator проверяет bearer-токен и возвращает имя оператора.
+// Без настроенных токенов изменяющие операции запрещены.
+func (p *ProxyServer) authorizeOperator(w http.ResponseWriter, r *http.Request) (string, bool) {
+    if len(p.adminTokens) == 0 {
+        sendJSONError(w, http.StatusForbidden, "Admin API is read-only: no admin tokens configured")
+        return "", false
+    }
+
+    token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+    if ok {
+        for operator, expected := range p.adminTokens {
+            if subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1 {
+                return operator, true
+            }
+        }
+    }
+
+    sendJSONError(w, http.StatusUnauthorized, "Invalid or missing admin token")
+    return "", false
+}
+
+// backendsRequest — тело запросов admin API, изменяющих набор backend'ов.
+type backendsRequest struct {
+    Backends []config.BackendConfig `json:"backends"`
+}
+
+// handleAdminBackends обрабатывает /admin/backends.
+// GET возвращает состояние backend'ов, PUT атомарно заменяет весь набор backend'ов,
+// POST добавляет один backend, DELETE удаляет один backend по url.
+func (p *ProxyServer) handleAdminBackends(w http.ResponseWriter, r *http.Request) {
+    switch r.Method {
+    case http.MethodGet:
+        writeJSON(w, http.StatusOK, p.balancer.Snapshot())
+    case http.MethodPost, http.MethodDelete:
+        p.handleAdminBackendMembership(w, r)
+    case http.MethodPut:
+        operator, ok := p.authorizeOperator(w, r)
+        if !ok {
+            return
+        }
+
+        var body backendsRequest
+        if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+            sendJSONError(w, http.StatusBadRequest, "Invalid JSON body: "+err.Error())
+            return
+        }
+        if len(body.Backends) == 0 {
+            sendJSONError(w, http.StatusBadRequest, "At least one backend is required")
+            return
+        }
+
+        if err := p.balancer.ReplaceBackends(body.Backends); err != nil {
+            p.logger.Warnf("Admin %s: backend replacement rejected: %v", operator, err)
+            sendJSONError(w, http.StatusConflict, err.Error())
+            return
+        }
+
+        p.logger.Infof("Admin %s: replaced backend set with %d backends", operator, len(body.Backends))
+        writeJSON(w, http.StatusOK, body)
+    default:
+        w.Header().Set("Allow", "GET, PUT, POST, DELETE")
+        sendJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+    }
+}
+
+// handleAdminBackendMembership добавляет (POST) или удаляет (DELETE) один backend:
+// тело — конфигурация backend'а, для удаления достаточно {"url": "..."}.
+func (p *ProxyServer) handleAdminBackendMembership(w http.ResponseWriter, r *http.Request) {
+    operator, ok := p.authorizeOperator(w, r)
+    if !ok {
+        return
+    }
+
+    var body config.BackendConfig
+    if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+        sendJSONError(w, http.StatusBadRequest, "Invalid JSON body: "+err.Error())
+        return
+    }
+    if body.URL == "" {
+        sendJSONError(w, http.StatusBadRequest, "url is required")
+        return
+    }
+
+    var err error
+    if r.Method == http.MethodPost {
+        err = p.balancer.AddBackend(body)
+    } else {
+        err = p.balancer.RemoveBackend(body.URL)
+    }
+    switch {
+    case errors.Is(err, balancer.ErrInvalidBackend):
+        sendJSONError(w, http.StatusBadRequest, err.Error())
+    case errors.Is(err, balancer.ErrBackendNotFound):
+        sendJSONError(w, http.StatusNotFound, err.Error())
+    case err != nil:
+        sendJSONError(w, http.StatusConflict, err.Error())
+    case r.Method == http.MethodPost:
+        p.logger.Infof("Admin %s: added backend %s", operator, body.URL)
+        writeJSON(w, http.StatusCreated, body)
+    default:
+        p.logger.Infof("Admin %s: removed backend %s", operator, body.URL)
+        w.WriteHeader(http.StatusNoContent)
+    }
+}
+
+// backendPatch — тело PATCH /admin/backends/{url}.
+type backendPatch struct {
+    Weight *int `json:"weight"`
+}
+
+// handleAdminBackend изменяет один backend: PATCH /admin/backends/{url} с {"weight": N}.
+// URL backend'а передается целиком, желательно в percent-encoding.
+func (p *ProxyServer) handleAdminBackend(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPatch {
+        w.Header().Set("Allow", http.MethodPatch)
+        sendJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+        return
+    }
+    operator, ok := p.authorizeOperator(w, r)
+    if !ok {
+        return
+    }
+
+    address, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), "/admin/backends/"))
+    if err != nil || address == "" {
+        sendJSONError(w, http.StatusBadRequest, "Backend URL is required")
+        return
+    }
+
+    var body backendPatch
+    if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+        sendJSONError(w, http.StatusBadRequest, "Invalid JSON body: "+err.Error())
+        return
+    }
+    if body.Weight == nil {
+        sendJSONError(w, http.StatusBadRequest, "weight is required")
+        return
+    }
+
+    if err := p.balancer.SetBackendWeight(address, *body.Weight); err != nil {
+        status := http.StatusNotFound
+        if *body.Weight < 0 {
+            status = http.StatusBadRequest
+        }
+        sendJSONError(w, status, err.Error())
+        return
+    }
+
+    p.logger.Infof("Admin %s: set weight of %s to %d", operator, address, *body.Weight)
+    writeJSON(w, http.StatusOK, config.BackendConfig{URL: address, Weight: body.Weight})
+}
+
+// handleAdminDrain выводит backend из ротации: POST /admin/backends/drain с {"url": "..."}.
+// Новые запросы на backend не направляются, начатые дорабатывают; DELETE с тем же телом
+// возвращает backend в ротацию. В ответе — состояние backend'а, включая признак drained.
+func (p *ProxyServer) handleAdminDrain(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+        w.Header().Set("Allow", "POST, DELETE")
+        sendJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+        return
+    }
+    operator, ok := p.authorizeOperator(w, r)
+    if !ok {
+        return
+    }
+
+    var body config.BackendConfig
+    if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+        sendJSONError(w, http.StatusBadRequest, "Invalid JSON body: "+err.Error())
+        return
+    }
+    if body.URL == "" {
+        sendJSONError(w, http.StatusBadRequest, "url is required")
+        return
+    }
+
+    draining := r.Method == http.MethodPost
+    if err := p.balancer.SetBackendDraining(body.URL, draining); err != nil {
+        sendJSONError(w, http.StatusNotFound, err.Error())
+        return
+    }
+    if draining {
+        p.logger.Infof("Admin %s: draining backend %s", operator, body.URL)
+    } else {
+        p.logger.Infof("Admin %s: returned backend %s to rotation", operator, body.URL)
+    }
+
+    for _, status := range p.balancer.Snapshot() {
+        if status.Address == body.URL {
+            writeJSON(w, http.StatusOK, status)
+            return
+        }
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAdminClientLimit меняет индивидуальный лимит клиента на лету:
+// PUT /admin/ratelimit/clients/{id} с {"capacity": N, "refill_rate": M} задает лимит,
+// DELETE возвращает лимит по умолчанию. Изменения сразу применяются к бакету клиента
+// и сохраняются, если включено сохранение состояния лимитера.
+func (p *ProxyServer) handleAdminClientLimit(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPut && r.Method != http.MethodDelete {
+        w.Header().Set("Allow", "PUT, DELETE")
+        sendJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+        return
+    }
+    operator, ok := p.authorizeOperator(w, r)
+    if !ok {
+        return
+    }
+
+    clientID := strings.TrimPrefix(r.URL.Path, "/admin/ratelimit/clients/")
+    if clientID == "" {
+        sendJSONError(w, http.StatusBadRequest, "Client ID is required")
+        return
+    }
+
+    if r.Method == http.MethodDelete {
+        p.rateLimiter.RemoveClientLimit(clientID)
+        p.logger.Infof("Admin %s: reverted rate limit of client %s to default", operator, clientID)
+        p.persistClientLimits()
+        w.WriteHeader(http.StatusNoContent)
+        return
+    }
+
+    var limit ratelimiter.ClientLimit
+    if err := json.NewDecoder(r.Body).Decode(&limit); err != nil {
+        sendJSONError(w, http.StatusBadRequest, "Invalid JSON body: "+err.Error())
+        return
+    }
+    if limit.Capacity <= 0 || limit.RefillRate <= 0 {
+        sendJSONError(w, http.StatusBadRequest, "capacity and refill_rate must be positive")
+        return
+    }
+
+    p.rateLimiter.SetClientLimit(clientID, limit)
+    p.logger.Infof("Admin %s: set rate limit of client %s to capacity %d, refill %d/s",
+        operator, clientID, limit.Capacity, limit.RefillRate)
+    p.persistClientLimits()
+    writeJSON(w, http.StatusOK, limit)
+}
+
+// handleAdminRateLimitClients возвращает заполненность бакетов активных клиентов
+// (GET /admin/ratelimit/clients). Требует токен оператора: ключи клиентов могут быть секретами.
+func (p *ProxyServer) handleAdminRateLimitClients(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        w.Header().Set("Allow", "GET")
+        sendJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+        return
+    }
+    // Ключ бакета может быть значением key_header, то есть API-ключом клиента
+    if _, ok := p.authorizeOperator(w, r); !ok {
+        return
+    }
+    writeJSON(w, http.StatusOK, p.rateLimiter.Snapshot())
+}
+
+// metricsHandler отдает /metrics, предварительно обновив gauge'и заполненности бакетов
+// rate limit. Значения по клиентам не экспортируются: число клиентов не ограничено.
+func (p *ProxyServer) metricsHandler() http.Handler {
+    handler := p.registry.Handler()
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        clients := p.rateLimiter.Snapshot()
+        exhausted := 0
+        fill := 0.0
+        for _, client := range clients {
+            if client.Tokens == 0 {
+                exhausted++
+            }
+            if client.Capacity > 0 {
+                fill += float64(client.Tokens) / float64(client.Capacity)
+            }
+        }
+        if len(clients) > 0 {
+            fill /= float64(len(clients))
+        }
+        p.registry.Set("lb_ratelimit_clients", float64(len(clients)))
+        p.registry.Set("lb_ratelimit_clients_exhausted", float64(exhausted))
+        p.registry.Set("lb_ratelimit_bucket_fill_ratio", fill)
+        handler.ServeHTTP(w, r)
+    })
+}
+
+// persistClientLimits сохраняет индивидуальные лимиты, если включено сохранение состояния.
+func (p *ProxyServer) persistClientLimits() {
+    if p.limiterStore == nil {
+        return
+    }
+    if err := p.rateLimiter.SaveOverrides(p.limiterStore); err != nil {
+        p.logger.Errorf("Failed to persist rate limit overrides: %v", err)
+    }
+}
+
+// certificateStatus — срок действия сертификата одного backend'а.
+type certificateStatus struct {
+    Backend   string    `json:"backend"`
+    ExpiresAt time.Time `json:"expires_at"`
+    DaysLeft  int       `json:"days_left"` // Отрицательное значение — сертификат уже истек
+}
+
+// handleAdminCertificates возвращает сроки действия сертификатов https-backend'ов,
+// увиденных последним health-check'ом: GET /admin/certificates.
+func (p *ProxyServer) handleAdminCertificates(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        w.Header().Set("Allow", http.MethodGet)
+        sendJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+        return
+    }
+
+    statuses := make([]certificateStatus, 0)
+    for _, backend := range p.balancer.Backends() {
+        expiresAt, ok := backend.CertExpiry()
+        if !ok {
+            continue
+        }
+        statuses = append(statuses, certificateStatus{
+            Backend:   backend.Address.String(),
+            ExpiresAt: expiresAt,
+            DaysLeft:  int(math.Floor(time.Until(expiresAt).Hours() / 24)),
+        })
+    }
+    writeJSON(w, http.StatusOK, statuses)
+}
+
+// handleAdminQuota возвращает состояние квоты клиента: GET /admin/quota/{clientID}.
+func (p *ProxyServer) handleAdminQuota(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        w.Header().Set("Allow", http.MethodGet)
+        sendJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+        return
+    }
+    if p.quota == nil {
+        sendJSONError(w, http.StatusNotFound, "Quotas are not enabled")
+        return
+    }
+
+    clientID := strings.TrimPrefix(r.URL.Path, "/admin/quota/")
+    if clientID == "" {
+        sendJSONError(w, http.StatusBadRequest, "Client ID is required")
+        return
+    }
+    writeJSON(w, http.StatusOK, p.quota.Status(clientID))
+}
+
+// writeJSON отвечает клиенту JSON-документом.
+func writeJSON(w http.ResponseWriter, statusCode int, value interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(statusCode)
+    json.NewEncoder(w).Encode(value)
+}
@@ -0,0 +1,125 @@
+package proxy
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "strings"
+
+    "github.com/Manzo48/loadBalancer/internal/balancer"
+    "github.com/Manzo48/loadBalancer/internal/config"
+    "go.uber.org/zap"
+)
+
+const defaultBodyRoutingMaxPeek = 64 * 1024
+
+// bodyRoute — пул backend'ов для набора значений поля тела запроса.
+type bodyRoute struct {
+    values map[string]bool
+    pool   balancer.LoadBalancer
+}
+
+// bodyRouter выбирает пул backend'ов по значению поля JSON-тела запроса.
+type bodyRouter struct {
+    field   []string // Путь к полю, разбитый по точкам
+    maxPeek int64
+    routes  []bodyRoute
+}
+
+// newBodyRouter создает пулы маршрутов; у каждого пула свой health-check.
+func newBodyRouter(cfg config.BodyRoutingConfig, healthCheck config.HealthCheckConfig, logger *zap.SugaredLogger) *bodyRouter {
+    router := &bodyRouter{
+        field:   strings.Split(cfg.Field, "."),
+        maxPeek: cfg.MaxPeekBytes,
+    }
+    if router.maxPeek <= 0 {
+        router.maxPeek = defaultBodyRoutingMaxPeek
+    }
+    for _, route := range cfg.Routes {
+        values := make(map[string]bool, len(route.Values))
+        for _, value := range route.Values {
+            values[value] = true
+        }
+        router.routes = append(router.routes, bodyRoute{
+            values: values,
+            pool:   balancer.NewRoundRobinLoadBalancer(route.Backends, healthCheck, logger),
+        })
+    }
+    return router
+}
+
+// route возвращает пул для запроса или nil, если запрос должен идти в пул по умолчанию.
+// Прочитанная часть тела всегда возвращается в r.Body, чтобы backend получил тело целиком.
+func (br *bodyRouter) route(r *http.Request) balancer.LoadBalancer {
+    if r.Body == nil || r.Body == http.NoBody || r.ContentLength > br.maxPeek {
+        return nil
+    }
+
+    peeked, err := io.ReadAll(io.LimitReader(r.Body, br.maxPeek+1))
+    r.Body = struct {
+        io.Reader
+        io.Closer
+    }{io.MultiReader(bytes.NewReader(peeked), r.Body), r.Body}
+    if err != nil || int64(len(peeked)) > br.maxPeek {
+        return nil
+    }
+
+    value, ok := jsonField(peeked, br.field)
+    if !ok {
+        return nil
+    }
+    for _, route := range br.routes {
+        if route.values[value] {
+            return route.pool
+        }
+    }
+    return nil
+}
+
+// jsonField извлекает скалярное значение по пути из JSON-объекта.
+func jsonField(data []byte, path []string) (string, bool) {
+    var node interface{}
+    if err := json.Unmarshal(data, &node); err != nil {
+        return "", false
+    }
+    for _, key := range path {
+        object, ok := node.(map[string]interface{})
+        if !ok {
+            return "", false
+        }
+        if node, ok = object[key]; !ok {
+            return "", false
+        }
+    }
+
+    switch value := node.(type) {
+    case string:
+        return value, true
+    case float64, bool:
+        return fmt.Sprint(value), true
+    default:
+        return "", false
+    }
+}
+
+// balancerFor возвращает балансировщик для запроса: группу виртуального хоста, группу
+// маршрута по пути, пул маршрута по телу или основной. nil — заданы маршруты по пути,
+// но ни один не подошел (404).
+func (p *ProxyServer) balancerFor(r *http.Request) balancer.LoadBalancer {
+    if p.hostRouter != nil {
+        if pool := p.hostRouter.route(r.Host); pool != nil {
+            return pool
+        }
+    }
+    if p.pathRouter != nil {
+        return p.pathRouter.route(r.URL.Path)
+    }
+    if p.bodyRouter != nil {
+        if pool := p.bodyRouter.route(r); pool != nil {
+            return pool
+        }
+    }
+    return p.balancer
+}
@@ -0,0 +1,231 @@
+package proxy
+
+import (
+    "bufio"
+    "compress/flate"
+    "compress/gzip"
+    "io"
+    "mime"
+    "net"
+    "net/http"
+    "strconv"
+    "strings"
+
+    "github.com/Manzo48/loadBalancer/internal/config"
+)
+
+const defaultCompressionMinSize = 1024
+
+// incompressibleTypes — Content-Type, которые уже сжаты и повторно не сжимаются.
+var incompressibleTypes = []string{
+    "image/*", "video/*", "audio/*", "font/woff", "font/woff2",
+    "application/zip", "application/gzip", "application/x-gzip", "application/x-bzip2",
+    "application/x-7z-compressed", "application/x-rar-compressed", "application/zstd",
+    "application/octet-stream", "application/pdf",
+}
+
+// compressor сжимает ответы backend'ов для клиентов, принимающих gzip или deflate.
+type compressor struct {
+    minSize   int
+    level     int
+    skipTypes []string
+}
+
+// newCompressor создает компрессор; nil — сжатие выключено.
+func newCompressor(cfg config.CompressionConfig) *compressor {
+    if !cfg.Enabled {
+        return nil
+    }
+    c := &compressor{
+        minSize:   cfg.MinSize,
+        level:     cfg.Level,
+        skipTypes: append(append([]string{}, incompressibleTypes...), cfg.SkipTypes...),
+    }
+    if c.minSize == 0 {
+        c.minSize = defaultCompressionMinSize
+    }
+    if c.level == 0 {
+        c.level = gzip.DefaultCompression
+    }
+    return c
+}
+
+// compressible проверяет, имеет ли смысл сжимать тело с таким Content-Type.
+func (c *compressor) compressible(contentType string) bool {
+    mediaType, _, err := mime.ParseMediaType(contentType)
+    if err != nil {
+        mediaType = strings.ToLower(strings.TrimSpace(contentType))
+    }
+    for _, skip := range c.skipTypes {
+        skip = strings.ToLower(skip)
+        if prefix, ok := strings.CutSuffix(skip, "*"); (ok && strings.HasPrefix(mediaType, prefix)) || mediaType == skip {
+            return false
+        }
+    }
+    return true
+}
+
+// compressionMiddleware сжимает ответы, если клиент прислал Accept-Encoding: gzip (или deflate),
+// backend не закодировал ответ сам, Content-Type сжимаем, а тело не короче min_size.
+// Размер определяется по Content-Length, а без него — по первым min_size байтам тела:
+// до решения они буферизуются. Flush потокового ответа (SSE) начинает сжатие сразу
+// и сбрасывает клиенту все, что уже сжато.
+func (p *ProxyServer) compressionMiddleware(next http.Handler) http.Handler {
+    c := p.compression
+    if c == nil {
+        return next
+    }
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        accept := r.Header.Get("Accept-Encoding")
+        encoding := ""
+        switch {
+        case r.Method == http.MethodHead || isUpgradeRequest(r):
+        case acceptsEncoding(accept, "gzip"):
+            encoding = "gzip"
+        case acceptsEncoding(accept, "deflate"):
+            encoding = "deflate"
+        }
+        if encoding == "" {
+            next.ServeHTTP(w, r)
+            return
+        }
+
+        writer := &compressWriter{ResponseWriter: w, compressor: c, encoding: encoding}
+        defer writer.close()
+        next.ServeHTTP(writer, r)
+    })
+}
+
+// compressWriter откладывает заголовки ответа, пока не станет ясно, сжимать ли тело.
+type compressWriter struct {
+    http.ResponseWriter
+    compressor *compressor
+    encoding   string
+
+    status  int            // Отложенный код ответа (0 — WriteHeader еще не вызывался)
+    decided bool           // Заголовки отправлены клиенту
+    encoder io.WriteCloser // Сжимающий writer (nil — тело идет без сжатия)
+    buf     []byte         // Начало тела до решения о сжатии
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+    if w.decided || w.status != 0 {
+        return
+    }
+    // Информационные 1xx отправляются сразу, финальный ответ будет позже
+    if status < http.StatusOK {
+        w.ResponseWriter.WriteHeader(status)
+        return
+    }
+    w.status = status
+    if !w.eligible() {
+        w.start(false)
+        return
+    }
+    if length, err := strconv.Atoi(w.Header().Get("Content-Length")); err == nil {
+        w.start(length >= w.compressor.minSize)
+    }
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+    if w.status == 0 {
+        w.WriteHeader(http.StatusOK)
+    }
+    if !w.decided {
+        w.buf = append(w.buf, b...)
+        if len(w.buf) >= w.compressor.minSize {
+            if err := w.start(true); err != nil {
+                return 0, err
+            }
+        }
+        return len(b), nil
+    }
+    if w.encoder != nil {
+        return w.encoder.Write(b)
+    }
+    return w.ResponseWriter.Write(b)
+}
+
+// Flush начинает сжатие потокового ответа, не дожидаясь min_size, и сбрасывает
+// клиенту все, что уже сжато.
+func (w *compressWriter) Flush() {
+    if !w.decided && w.status != 0 {
+        w.start(true)
+    }
+    if flusher, ok := w.encoder.(interface{ Flush() error }); ok {
+        flusher.Flush()
+    }
+    if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+        flusher.Flush()
+    }
+}
+
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+    return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+    return w.ResponseWriter
+}
+
+// eligible проверяет по коду и заголовкам ответа, можно ли его сжимать.
+func (w *compressWriter) eligible() bool {
+    header := w.Header()
+    switch {
+    case w.status == http.StatusNoContent || w.status == http.StatusNotModified || w.status == http.StatusPartialContent:
+        return false
+    case header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "":
+        return false
+    case strings.Contains(strings.ToLower(header.Get("Cache-Control")), "no-transform"):
+        return false
+    }
+    return w.compressor.compressible(header.Get("Content-Type"))
+}
+
+// start отправляет заголовки и буфер, включив сжатие, если compress и ответ подходит.
+func (w *compressWriter) start(compress bool) error {
+    w.decided = true
+    header := w.Header()
+    if compress && w.eligible() {
+        header.Del("Content-Length")
+        header.Set("Content-Encoding", w.encoding)
+        header.Add("Vary", "Accept-Encoding")
+        // Сжатое тело отличается побайтно: сильный ETag становится слабым
+        if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+            header.Set("ETag", "W/"+etag)
+        }
+        if w.encoding == "gzip" {
+            w.encoder, _ = gzip.NewWriterLevel(w.ResponseWriter, w.compressor.level)
+        } else {
+            w.encoder, _ = flate.NewWriter(w.ResponseWriter, w.compressor.level)
+        }
+    }
+    w.ResponseWriter.WriteHeader(w.status)
+
+    buf := w.buf
+    w.buf = nil
+    if len(buf) == 0 {
+        return nil
+    }
+    var err error
+    if w.encoder != nil {
+        _, err = w.encoder.Write(buf)
+    } else {
+        _, err = w.ResponseWriter.Write(buf)
+    }
+    return err
+}
+
+// close завершает ответ: отправляет короткое тело без сжатия или дописывает сжатый поток.
+func (w *compressWriter) close() {
+    if !w.decided {
+        if w.status == 0 {
+            // Обработчик ничего не записал — заголовки отправит net/http
+            return
+        }
+        w.start(false)
+    }
+    if w.encoder != nil {
+        w.encoder.Close()
+    }
+}
@@ -0,0 +1,60 @@
+package proxy
+
+import (
+    "net/http"
+
+    "github.com/Manzo48/loadBalancer/internal/config"
+)
+
+// EffectiveConfig возвращает конфигурацию, по которой прокси работает сейчас:
+// загруженную конфигурацию с изменениями, сделанными на лету (набор и веса backend'ов,
+// индивидуальные лимиты клиентов, режим лимитера, режим обслуживания).
+func (p *ProxyServer) EffectiveConfig() *config.Config {
+    cfg := *p.cfg.Load()
+
+    backends := p.balancer.PrimaryBackends()
+    cfg.Backends = make([]config.BackendConfig, 0, len(backends))
+    for _, backend := range backends {
+        cfg.Backends = append(cfg.Backends, backend.Config())
+    }
+
+    limits := p.rateLimiter.ClientLimits()
+    cfg.RateLimit.Clients = nil
+    if len(limits) > 0 {
+        cfg.RateLimit.Clients = make(map[string]config.ClientRateLimit, len(limits))
+        for clientID, limit := range limits {
+            cfg.RateLimit.Clients[clientID] = config.ClientRateLimit{Capacity: limit.Capacity, RefillRate: limit.RefillRate}
+        }
+    }
+    if p.rateLimiter.ObserveMode() {
+        cfg.RateLimit.Mode = "observe"
+    } else if cfg.RateLimit.Mode == "observe" {
+        cfg.RateLimit.Mode = "enforce"
+    }
+    cfg.Maintenance.Enabled = p.maintenanceOn.Load()
+    return &cfg
+}
+
+// handleAdminConfig отдает текущую конфигурацию в YAML без секретов: GET /admin/config.
+// Результат снова читается config.Load, поэтому его можно сохранить вместо файла на диске,
+// подставив скрытые секреты. Требует токен оператора: ключи rate_limit.clients и
+// quota.clients могут быть API-ключами клиентов.
+func (p *ProxyServer) handleAdminConfig(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        w.Header().Set("Allow", http.MethodGet)
+        sendJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+        return
+    }
+    if _, ok := p.authorizeOperator(w, r); !ok {
+        return
+    }
+
+    data, err := config.Marshal(p.EffectiveConfig().Redacted())
+    if err != nil {
+        p.logger.Errorf("Failed to serialize config: %v", err)
+        sendJSONError(w, http.StatusInternalServerError, "Failed to serialize config")
+        return
+    }
+    w.Header().Set("Content-Type", "application/yaml")
+    w.Write(data)
+}
@@ -0,0 +1,29 @@
+package proxy
+
+import (
+    "net/http"
+
+    "github.com/Manzo48/loadBalancer/internal/requestid"
+    "go.uber.org/zap"
+)
+
+// requestIDMiddleware берет идентификатор запроса из заголовка request_id.header или,
+// если его нет (или он некорректен), генерирует новый. Идентификатор возвращается
+// клиенту в том же заголовке, уходит на backend и добавляется ко всем строкам лога запроса.
+func (p *ProxyServer) requestIDMiddleware(next http.Handler) http.Handler {
+    header := p.requestIDHeader
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        id := r.Header.Get(header)
+        if !requestid.Valid(id) {
+            id = requestid.New()
+        }
+        r.Header.Set(header, id)
+        w.Header().Set(header, id)
+        next.ServeHTTP(w, r.WithContext(requestid.NewContext(r.Context(), id, p.logger)))
+    })
+}
+
+// requestLogger возвращает логгер с идентификатором запроса.
+func (p *ProxyServer) requestLogger(r *http.Request) *zap.SugaredLogger {
+    return requestid.Logger(r.Context(), p.logger)
+}
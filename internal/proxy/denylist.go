@@ -0,0 +1,33 @@
+package proxy
+
+import (
+    "net/http"
+
+    "github.com/Manzo48/loadBalancer/internal/clientip"
+)
+
+// setDenylist заменяет список заблокированных адресов. Вызывается при старте и при SIGHUP.
+func (p *ProxyServer) setDenylist(entries []string) error {
+    list, err := clientip.ParseIPList(entries)
+    if err != nil {
+        return err
+    }
+    p.denylist.Store(&list)
+    return nil
+}
+
+// denylistMiddleware отклоняет запросы с заблокированных адресов с 403 до rate limit
+// и проксирования: такие запросы не расходуют токены и не доходят до backend'ов.
+func (p *ProxyServer) denylistMiddleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if list := p.denylist.Load(); list != nil && len(*list) > 0 {
+            if ip := getClientIP(r); list.Contains(ip) {
+                p.metrics.Inc("lb_denylist_rejected_total")
+                p.requestLogger(r).Infof("Rejecting request from denylisted address %s", ip)
+                sendJSONError(w, http.StatusForbidden, "Forbidden")
+                return
+            }
+        }
+        next.ServeHTTP(w, r)
+    })
+}
@@ -0,0 +1,67 @@
+package proxy
+
+import (
+    "net"
+    "net/http"
+    "net/http/httputil"
+    "strings"
+
+    "github.com/Manzo48/loadBalancer/internal/clientip"
+)
+
+// trustsForwardedHeaders сообщает, можно ли верить X-Forwarded-* запроса: он пришел
+// с адреса из trusted_proxies. Пустой trusted_proxies — доверять всем, как раньше.
+func (p *ProxyServer) trustsForwardedHeaders(r *http.Request) bool {
+    if len(p.trustedProxies) == 0 {
+        return true
+    }
+    ip, _, _ := net.SplitHostPort(r.RemoteAddr)
+    return p.trustedProxies.Contains(ip)
+}
+
+// clientIPMiddleware один раз определяет IP клиента с учетом trusted_proxies; его видят
+// denylist, rate limiter, квоты, балансировка и логи.
+func (p *ProxyServer) clientIPMiddleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        ip := clientip.Resolve(r, p.trustedProxies)
+        next.ServeHTTP(w, r.WithContext(clientip.NewContext(r.Context(), ip)))
+    })
+}
+
+// setForwardedHeaders выставляет X-Forwarded-For, X-Forwarded-Proto и X-Forwarded-Host
+// исходящего запроса. ReverseProxy с Rewrite удаляет их из исходящего запроса сам,
+// поэтому цепочка клиента переносится, только если запрос пришел от доверенного прокси.
+// IP клиента дописывается в цепочку позже, в appendForwardedFor.
+func (p *ProxyServer) setForwardedHeaders(pr *httputil.ProxyRequest) {
+    in, out := pr.In, pr.Out
+    proto, host := "http", in.Host
+    if in.TLS != nil {
+        proto = "https"
+    }
+    if p.trustsForwardedHeaders(in) {
+        if chain := in.Header.Values("X-Forwarded-For"); len(chain) > 0 {
+            out.Header["X-Forwarded-For"] = append([]string(nil), chain...)
+        }
+        if forwarded := in.Header.Get("X-Forwarded-Proto"); forwarded != "" {
+            proto = forwarded
+        }
+        if forwarded := in.Header.Get("X-Forwarded-Host"); forwarded != "" {
+            host = forwarded
+        }
+        if forwarded := in.Header.Values("Forwarded"); len(forwarded) > 0 {
+            out.Header["Forwarded"] = append([]string(nil), forwarded...)
+        }
+    }
+    out.Header.Set("X-Forwarded-Proto", proto)
+    out.Header.Set("X-Forwarded-Host", host)
+}
+
+// appendForwardedFor дописывает IP соединения клиента в конец X-Forwarded-For.
+func appendForwardedFor(pr *httputil.ProxyRequest) {
+    ip, _, err := net.SplitHostPort(pr.In.RemoteAddr)
+    if err != nil {
+        return
+    }
+    chain := append(pr.Out.Header.Values("X-Forwarded-For"), ip)
+    pr.Out.Header.Set("X-Forwarded-For", strings.Join(chain, ", "))
+}
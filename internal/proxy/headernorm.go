@@ -0,0 +1,36 @@
+package proxy
+
+import (
+    "fmt"
+    "net/http"
+)
+
+// normalizeHeadersMiddleware сводит повторяющиеся заголовки к одному значению или отклоняет
+// запрос с 400, согласно header_normalization. Дубли Host и различающиеся Content-Length
+// отклоняет еще сам HTTP-сервер, до этого middleware.
+func (p *ProxyServer) normalizeHeadersMiddleware(next http.Handler) http.Handler {
+    if len(p.headerNormalization) == 0 {
+        return next
+    }
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        for _, rule := range p.headerNormalization {
+            name := http.CanonicalHeaderKey(rule.Header)
+            values := r.Header[name]
+            if len(values) < 2 {
+                continue
+            }
+
+            switch rule.Policy {
+            case "reject":
+                p.logger.Debugf("Rejecting request to %s: duplicate header %s", r.URL.Path, name)
+                sendJSONError(w, http.StatusBadRequest, fmt.Sprintf("Duplicate header %s", name))
+                return
+            case "last":
+                r.Header[name] = []string{values[len(values)-1]}
+            default:
+                r.Header[name] = []string{values[0]}
+            }
+        }
+        next.ServeHTTP(w, r)
+    })
+}
@@ -0,0 +1,35 @@
+package proxy
+
+import (
+    "net/http"
+
+    "github.com/Manzo48/loadBalancer/internal/config"
+)
+
+// applyRequestHeaderRules применяет request_headers к исходящему запросу. Вызывается в Rewrite
+// до политики чувствительных заголовков и подписи, поэтому они имеют приоритет.
+// X-Forwarded-For не заменяется, а дописывается в цепочку; IP клиента добавляется
+// в конец после правил (appendForwardedFor).
+func applyRequestHeaderRules(req *http.Request, rules config.HeaderRules) {
+    for _, name := range rules.Remove {
+        req.Header.Del(name)
+    }
+    for name, value := range rules.Add {
+        if http.CanonicalHeaderKey(name) == "X-Forwarded-For" {
+            req.Header.Add(name, value)
+            continue
+        }
+        req.Header.Set(name, value)
+    }
+}
+
+// applyResponseHeaderRules применяет response_headers к ответу backend'а.
+// Ответы, которые формирует сам прокси (429, 503 и др.), не меняются.
+func applyResponseHeaderRules(resp *http.Response, rules config.HeaderRules) {
+    for _, name := range rules.Remove {
+        resp.Header.Del(name)
+    }
+    for name, value := range rules.Add {
+        resp.Header.Set(name, value)
+    }
+}
@@ -0,0 +1,75 @@
+package proxy
+
+import (
+    "net"
+    "sort"
+    "strings"
+
+    "github.com/Manzo48/loadBalancer/internal/balancer"
+    "github.com/Manzo48/loadBalancer/internal/config"
+    "go.uber.org/zap"
+)
+
+// hostRouter выбирает группу backend'ов по имени хоста запроса (виртуальные хосты).
+type hostRouter struct {
+    exact     map[string]balancer.LoadBalancer // api.example.com
+    wildcards []hostWildcard                   // *.example.com, от самого длинного суффикса
+}
+
+// hostWildcard — группа для всех поддоменов суффикса.
+type hostWildcard struct {
+    suffix string // .example.com
+    pool   balancer.LoadBalancer
+}
+
+// newHostRouter создает группы виртуальных хостов; у каждой группы свой балансировщик и health-check.
+func newHostRouter(hosts map[string]config.BackendGroup, healthCheck config.HealthCheckConfig, logger *zap.SugaredLogger) *hostRouter {
+    router := &hostRouter{exact: make(map[string]balancer.LoadBalancer)}
+    for host, group := range hosts {
+        pool := newBackendGroup("hosts: "+host, group.Strategy, group.Backends, healthCheck, logger)
+        host = strings.ToLower(host)
+        if strings.HasPrefix(host, "*.") {
+            router.wildcards = append(router.wildcards, hostWildcard{suffix: host[1:], pool: pool})
+        } else {
+            router.exact[host] = pool
+        }
+    }
+    // Более длинный суффикс точнее: *.eu.example.com важнее *.example.com
+    sort.Slice(router.wildcards, func(i, j int) bool {
+        return len(router.wildcards[i].suffix) > len(router.wildcards[j].suffix)
+    })
+    return router
+}
+
+// pools возвращает балансировщики всех групп.
+func (hr *hostRouter) pools() []balancer.LoadBalancer {
+    pools := make([]balancer.LoadBalancer, 0, len(hr.exact)+len(hr.wildcards))
+    for _, pool := range hr.exact {
+        pools = append(pools, pool)
+    }
+    for _, wildcard := range hr.wildcards {
+        pools = append(pools, wildcard.pool)
+    }
+    return pools
+}
+
+// route возвращает группу для заголовка Host (порт не учитывается) или nil, если
+// хост не задан в конфигурации. Точное совпадение важнее шаблона; *.example.com
+// подходит для поддоменов любой вложенности, но не для самого example.com.
+func (hr *hostRouter) route(hostport string) balancer.LoadBalancer {
+    host := hostport
+    if h, _, err := net.SplitHostPort(hostport); err == nil {
+        host = h
+    }
+    host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+    if pool, ok := hr.exact[host]; ok {
+        return pool
+    }
+    for _, wildcard := range hr.wildcards {
+        if strings.HasSuffix(host, wildcard.suffix) {
+            return wildcard.pool
+        }
+    }
+    return nil
+}
@@ -0,0 +1,120 @@
+package proxy
+
+import (
+    "context"
+    "math"
+    "math/rand"
+    "net/http"
+    "strconv"
+    "time"
+
+    "github.com/Manzo48/loadBalancer/internal/config"
+)
+
+const defaultBackpressureRetryAfter = time.Second
+
+// inFlightLimiter ограничивает число одновременно проксируемых запросов
+// и сообщает клиентам о приближении к пределу.
+type inFlightLimiter struct {
+    slots        chan struct{}
+    hintAt       float64
+    shedAt       float64
+    retryAfter   time.Duration
+    queueTimeout time.Duration // Ожидание слота при заполнении (0 — сразу 503)
+}
+
+// newInFlightLimiter создает ограничитель; nil — предел не задан.
+func newInFlightLimiter(cfg config.InFlightConfig) *inFlightLimiter {
+    if cfg.Max <= 0 {
+        return nil
+    }
+    limiter := &inFlightLimiter{
+        slots:        make(chan struct{}, cfg.Max),
+        hintAt:       cfg.Backpressure.HintAt,
+        shedAt:       cfg.Backpressure.ShedAt,
+        retryAfter:   cfg.Backpressure.RetryAfter,
+        queueTimeout: cfg.QueueTimeout,
+    }
+    if limiter.retryAfter <= 0 {
+        limiter.retryAfter = defaultBackpressureRetryAfter
+    }
+    return limiter
+}
+
+// utilization возвращает долю занятых слотов (0..1).
+func (l *inFlightLimiter) utilization() float64 {
+    return float64(len(l.slots)) / float64(cap(l.slots))
+}
+
+// wait ждет освобождения слота не дольше queue_timeout или до отмены запроса.
+func (l *inFlightLimiter) wait(ctx context.Context) bool {
+    if l.queueTimeout <= 0 {
+        return false
+    }
+    timer := time.NewTimer(l.queueTimeout)
+    defer timer.Stop()
+    select {
+    case l.slots <- struct{}{}:
+        return true
+    case <-timer.C:
+        return false
+    case <-ctx.Done():
+        return false
+    }
+}
+
+// inFlightMiddleware применяет предел одновременных запросов с постепенным backpressure.
+// Заполнение u считается с учетом текущего запроса:
+//   - u < hint_at — запрос обслуживается как обычно;
+//   - hint_at <= u — запрос обслуживается, ответ получает X-Backpressure: high;
+//   - shed_at < u < 1 — запрос отклоняется с вероятностью (u - shed_at) / (1 - shed_at),
+//     линейно растущей до 100% у предела;
+//   - слотов нет — запрос ждет слот не дольше queue_timeout, а без него отклоняется сразу.
+// Дождавшиеся слота запросы не отклоняются по доле заполнения: они уже отстояли очередь.
+// Отклоненные запросы получают 503, Retry-After и X-Backpressure: critical.
+func (p *ProxyServer) inFlightMiddleware(next http.Handler) http.Handler {
+    limiter := p.inFlight
+    if limiter == nil {
+        return next
+    }
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        queued := false
+        select {
+        case limiter.slots <- struct{}{}:
+        default:
+            start := time.Now()
+            if !limiter.wait(r.Context()) {
+                p.shed(w, r, "full", 1)
+                return
+            }
+            queued = true
+            p.metrics.Observe("lb_inflight_queue_wait_seconds", time.Since(start).Seconds())
+        }
+        defer func() {
+            <-limiter.slots
+            p.metrics.Set("lb_inflight_utilization", limiter.utilization())
+        }()
+
+        utilization := limiter.utilization()
+        p.metrics.Set("lb_inflight_utilization", utilization)
+        if !queued && limiter.shedAt > 0 && utilization > limiter.shedAt &&
+            rand.Float64() < (utilization-limiter.shedAt)/(1-limiter.shedAt) {
+            p.shed(w, r, "backpressure", utilization)
+            return
+        }
+        if limiter.hintAt > 0 && utilization >= limiter.hintAt {
+            w.Header().Set("X-Backpressure", "high")
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+// shed отклоняет запрос из-за перегрузки.
+func (p *ProxyServer) shed(w http.ResponseWriter, r *http.Request, reason string, utilization float64) {
+    p.requestLogger(r).Warnf("Shedding request (%s): in-flight utilization %.0f%%", reason, utilization*100)
+    p.metrics.Inc("lb_backpressure_shed_total", "reason", reason)
+
+    w.Header().Set("X-Backpressure", "critical")
+    w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(p.inFlight.retryAfter.Seconds()))))
+    sendJSONError(w, http.StatusServiceUnavailable, "Server overloaded")
+}
@@ -0,0 +1,144 @@
+package proxy
+
+import (
+    "fmt"
+    "math"
+    "net/http"
+    "os"
+    "strconv"
+
+    "github.com/Manzo48/loadBalancer/internal/clientip"
+    "github.com/Manzo48/loadBalancer/internal/config"
+)
+
+const defaultMaintenanceContentType = "text/html; charset=utf-8"
+
+// maintenancePage — подготовленный ответ режима обслуживания (см. config.MaintenanceConfig).
+// Включен ли режим, хранится отдельно (maintenanceOn), чтобы admin API переключал его,
+// не трогая страницу.
+type maintenancePage struct {
+    status      int
+    body        []byte // nil — JSON-ошибка по умолчанию
+    contentType string
+    retryAfter  string // Значение Retry-After (пусто — без заголовка)
+    paths       []string
+    allowlist   clientip.IPList
+}
+
+// compileMaintenance готовит ответ режима обслуживания, читая body_file.
+func compileMaintenance(cfg config.MaintenanceConfig) (*maintenancePage, error) {
+    allowlist, err := clientip.ParseIPList(cfg.Allowlist)
+    if err != nil {
+        return nil, fmt.Errorf("allowlist: %w", err)
+    }
+    page := &maintenancePage{
+        status:      cfg.Status,
+        contentType: cfg.ContentType,
+        paths:       cfg.Paths,
+        allowlist:   allowlist,
+    }
+    if page.status == 0 {
+        page.status = http.StatusServiceUnavailable
+    }
+    if page.contentType == "" {
+        page.contentType = defaultMaintenanceContentType
+    }
+    if cfg.RetryAfter > 0 {
+        page.retryAfter = strconv.Itoa(max(1, int(math.Ceil(cfg.RetryAfter.Seconds()))))
+    }
+    switch {
+    case cfg.BodyFile != "":
+        if page.body, err = os.ReadFile(cfg.BodyFile); err != nil {
+            return nil, fmt.Errorf("body_file: %w", err)
+        }
+    case cfg.Body != "":
+        page.body = []byte(cfg.Body)
+    }
+    return page, nil
+}
+
+// setMaintenance применяет раздел maintenance: страницу и состояние режима.
+func (p *ProxyServer) setMaintenance(cfg config.MaintenanceConfig) error {
+    page, err := compileMaintenance(cfg)
+    if err != nil {
+        return err
+    }
+    p.maintenance.Store(page)
+    p.maintenanceOn.Store(cfg.Enabled)
+    return nil
+}
+
+// serveMaintenance отвечает страницей обслуживания, если режим включен, путь запроса
+// подпадает под paths, а клиент не из allowlist. false — запрос нужно проксировать.
+func (p *ProxyServer) serveMaintenance(w http.ResponseWriter, r *http.Request, clientIP string) bool {
+    page := p.maintenance.Load()
+    if !p.maintenanceOn.Load() || page == nil {
+        return false
+    }
+    if len(page.paths) > 0 {
+        matched := false
+        for _, prefix := range page.paths {
+            if matchPrefix(r.URL.Path, prefix) {
+                matched = true
+                break
+            }
+        }
+        if !matched {
+            return false
+        }
+    }
+    if page.allowlist.Contains(clientIP) {
+        return false
+    }
+
+    p.metrics.Inc("lb_maintenance_responses_total")
+    w.Header().Set("Cache-Control", "no-store")
+    if page.retryAfter != "" {
+        w.Header().Set("Retry-After", page.retryAfter)
+    }
+    if page.body == nil {
+        sendJSONError(w, page.status, "Service is under maintenance")
+        return true
+    }
+    w.Header().Set("Content-Type", page.contentType)
+    w.WriteHeader(page.status)
+    w.Write(page.body)
+    return true
+}
+
+// maintenanceStatus — ответ GET/POST/DELETE /admin/maintenance.
+type maintenanceStatus struct {
+    Enabled bool     `json:"enabled"`
+    Status  int      `json:"status"`
+    Paths   []string `json:"paths,omitempty"` // Пусто — все запросы
+}
+
+// handleAdminMaintenance показывает (GET), включает (POST) и выключает (DELETE) режим обслуживания.
+// Состояние действует до следующего изменения раздела maintenance в конфигурации.
+func (p *ProxyServer) handleAdminMaintenance(w http.ResponseWriter, r *http.Request) {
+    switch r.Method {
+    case http.MethodGet:
+    case http.MethodPost, http.MethodDelete:
+        operator, ok := p.authorizeOperator(w, r)
+        if !ok {
+            return
+        }
+        enabled := r.Method == http.MethodPost
+        p.maintenanceOn.Store(enabled)
+        if enabled {
+            p.logger.Warnf("Admin %s: maintenance mode enabled", operator)
+        } else {
+            p.logger.Infof("Admin %s: maintenance mode disabled", operator)
+        }
+    default:
+        w.Header().Set("Allow", "GET, POST, DELETE")
+        sendJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+        return
+    }
+
+    status := maintenanceStatus{Enabled: p.maintenanceOn.Load()}
+    if page := p.maintenance.Load(); page != nil {
+        status.Status, status.Paths = page.status, page.paths
+    }
+    writeJSON(w, http.StatusOK, status)
+}
@@ -0,0 +1,43 @@
+package proxy
+
+import (
+    "net/http"
+    "strings"
+)
+
+var defaultAllowedMethods = []string{
+    http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+    http.MethodPatch, http.MethodDelete, http.MethodOptions,
+}
+
+// isCORSPreflight определяет CORS preflight: OPTIONS с Origin и Access-Control-Request-Method.
+// Preflight-запросы не отвечаются автоматически — за них отвечает CORS-логика (или backend).
+func isCORSPreflight(r *http.Request) bool {
+    return r.Method == http.MethodOptions &&
+        r.Header.Get("Origin") != "" &&
+        r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// optionsMiddleware в режиме respond отвечает на OPTIONS (включая OPTIONS *) на стороне прокси
+// заголовком Allow, не отправляя запрос на backend. В режиме passthrough запросы проксируются.
+func (p *ProxyServer) optionsMiddleware(next http.Handler) http.Handler {
+    if p.optionsCfg.Mode != "respond" {
+        return next
+    }
+
+    methods := p.optionsCfg.AllowedMethods
+    if len(methods) == 0 {
+        methods = defaultAllowedMethods
+    }
+    allow := strings.ToUpper(strings.Join(methods, ", "))
+
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodOptions || isCORSPreflight(r) {
+            next.ServeHTTP(w, r)
+            return
+        }
+
+        w.Header().Set("Allow", allow)
+        w.WriteHeader(http.StatusNoContent)
+    })
+}
@@ -0,0 +1,62 @@
+package proxy
+
+import (
+    "sort"
+    "strings"
+
+    "github.com/Manzo48/loadBalancer/internal/balancer"
+    "github.com/Manzo48/loadBalancer/internal/config"
+    "go.uber.org/zap"
+)
+
+// pathRoute — группа backend'ов для префикса пути.
+type pathRoute struct {
+    prefix string
+    pool   balancer.LoadBalancer
+}
+
+// pathRouter выбирает группу backend'ов по самому длинному совпавшему префиксу пути.
+type pathRouter struct {
+    routes []pathRoute // Отсортированы по убыванию длины префикса
+}
+
+// newPathRouter создает группы маршрутов; у каждой группы свой балансировщик и health-check.
+func newPathRouter(routes []config.Route, healthCheck config.HealthCheckConfig, logger *zap.SugaredLogger) *pathRouter {
+    router := &pathRouter{}
+    for _, route := range routes {
+        pool := newBackendGroup("routes: "+route.Prefix, route.Strategy, route.Backends, healthCheck, logger)
+        router.routes = append(router.routes, pathRoute{prefix: route.Prefix, pool: pool})
+    }
+    sort.SliceStable(router.routes, func(i, j int) bool {
+        return len(router.routes[i].prefix) > len(router.routes[j].prefix)
+    })
+    return router
+}
+
+// route возвращает группу для пути или nil, если ни один префикс не подошел.
+func (pr *pathRouter) route(path string) balancer.LoadBalancer {
+    for _, route := range pr.routes {
+        if matchPrefix(path, route.prefix) {
+            return route.pool
+        }
+    }
+    return nil
+}
+
+// newBackendGroup создает балансировщик группы backend'ов маршрута со своим health-check'ом.
+func newBackendGroup(name, strategy string, backends []config.BackendConfig, healthCheck config.HealthCheckConfig, logger *zap.SugaredLogger) balancer.LoadBalancer {
+    pool, err := balancer.New(strategy, backends, healthCheck, logger)
+    if err != nil {
+        // config.Load отклоняет неизвестные стратегии, сюда попадает только конфигурация, собранная в коде
+        logger.Errorf("%s: %v, using round_robin", name, err)
+        pool = balancer.NewRoundRobinLoadBalancer(backends, healthCheck, logger)
+    }
+    return pool
+}
+
+// matchPrefix сравнивает путь с префиксом по целым сегментам:
+// /api подходит для /api и /api/users, но не для /apiary.
+func matchPrefix(path, prefix string) bool {
+    prefix = strings.TrimSuffix(prefix, "/")
+    return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+    "net/http"
+
+    "github.com/Manzo48/loadBalancer/internal/balancer"
+)
+
+// probesMiddleware отвечает на собственные проверки балансировщика до denylist,
+// rate limit и проксирования: оркестратор не должен получать 429 или ответ backend'а.
+// Проверка жизни (live_path) проходит, пока процесс обслуживает запросы, в том числе
+// во время остановки: перезапуск процесса из-за нее ничего бы не исправил.
+// Проверка готовности (ready_path) не проходит, пока нет ни одного живого backend'а
+// (например, до первых health-check'ов с initial_state: unhealthy), и с начала остановки,
+// чтобы внешний балансировщик перестал направлять сюда новые запросы.
+func (p *ProxyServer) probesMiddleware(next http.Handler) http.Handler {
+    livePath, readyPath := p.probes.LivePath, p.probes.ReadyPath
+    if livePath == "" && readyPath == "" {
+        return next
+    }
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        switch {
+        case livePath != "" && r.URL.Path == livePath:
+            writeJSON(w, http.StatusOK, map[string]string{"status": "alive"})
+        case readyPath != "" && r.URL.Path == readyPath:
+            p.handleReady(w)
+        default:
+            next.ServeHTTP(w, r)
+        }
+    })
+}
+
+// handleReady отвечает на проверку готовности.
+func (p *ProxyServer) handleReady(w http.ResponseWriter) {
+    if p.draining.Load() {
+        writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "draining"})
+        return
+    }
+    if !p.HasBackends() {
+        writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "no valid backends"})
+        return
+    }
+    if !p.hasHealthyBackend() {
+        writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "no healthy backends"})
+        return
+    }
+    writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
+
+// HasBackends сообщает, зарегистрирован ли хотя бы один backend в каком-либо пуле.
+// Пусто бывает, если ни один backend из конфигурации не удалось разобрать
+// (например, из-за некорректных настроек TLS).
+func (p *ProxyServer) HasBackends() bool {
+    for _, pool := range p.pools() {
+        if len(pool.Backends()) > 0 {
+            return true
+        }
+    }
+    return false
+}
+
+// hasHealthyBackend сообщает, есть ли живой backend хотя бы в одном пуле.
+func (p *ProxyServer) hasHealthyBackend() bool {
+    for _, pool := range p.pools() {
+        for _, backend := range pool.Backends() {
+            if backend.IsAlive.Load() {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// pools возвращает все пулы: основной, маршрутов по пути, виртуальных хостов
+// и маршрутизации по телу.
+func (p *ProxyServer) pools() []balancer.LoadBalancer {
+    pools := []balancer.LoadBalancer{p.balancer}
+    if p.pathRouter != nil {
+        for _, route := range p.pathRouter.routes {
+            pools = append(pools, route.pool)
+        }
+    }
+    if p.hostRouter != nil {
+        pools = append(pools, p.hostRouter.pools()...)
+    }
+    if p.bodyRouter != nil {
+        for _, route := range p.bodyRouter.routes {
+            pools = append(pools, route.pool)
+        }
+    }
+    return pools
+}
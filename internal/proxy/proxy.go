@@ -1,17 +1,34 @@
 package proxy
 
 import (
+    "bytes"
     "context"
+    "crypto/tls"
     "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "math"
     "net"
     "net/http"
     "net/http/httputil"
+    "os"
+    "strconv"
     "strings"
+    "sync/atomic"
+    "syscall"
     "time"
 
     "github.com/Manzo48/loadBalancer/internal/balancer"
+    "github.com/Manzo48/loadBalancer/internal/clientip"
+    "github.com/Manzo48/loadBalancer/internal/capture"
     "github.com/Manzo48/loadBalancer/internal/config"
+    "github.com/Manzo48/loadBalancer/internal/metrics"
+    "github.com/Manzo48/loadBalancer/internal/proxyproto"
     "github.com/Manzo48/loadBalancer/internal/ratelimiter"
+    "github.com/Manzo48/loadBalancer/internal/requestid"
+    "github.com/Manzo48/loadBalancer/internal/urlkey"
     "go.uber.org/zap"
 )
 
@@ -28,110 +45,868 @@ func sendJSONError(w http.ResponseWriter, statusCode int, message string) {
     json.NewEncoder(w).Encode(errorResponse{Code: statusCode, Message: message})
 }
 
+// statusClientClosedRequest — нестандартный код (как в nginx) для запросов, клиент которых
+// отключился до ответа. Клиент его уже не увидит, но он попадает в логи и метрики.
+const statusClientClosedRequest = 499
+
+// defaultShutdownGracePeriod — сколько по умолчанию ждать начатые запросы при остановке.
+const defaultShutdownGracePeriod = 5 * time.Second
+
+// Таймауты соединений клиентов по умолчанию (server.*). Чтение тела и запись ответа
+// по умолчанию не ограничены: это оборвало бы загрузки, потоковые ответы и WebSocket.
+const (
+    defaultReadHeaderTimeout = 10 * time.Second
+    defaultIdleTimeout       = 120 * time.Second
+)
+
 // ProxyServer реализует прокси с поддержкой балансировки нагрузки и ограничения частоты.
 type ProxyServer struct {
     balancer     balancer.LoadBalancer       // Интерфейс балансировщика (например, RoundRobin)
     logger       *zap.SugaredLogger
     httpServer   *http.Server
     rateLimiter  *ratelimiter.RateLimiter
+    capture      *capture.Sink               // Запись выборки запросов (nil, если выключена)
+    metrics      metrics.Metrics
+    registry     *metrics.Registry           // Реестр для /metrics (nil, если метрики отдаются не в Prometheus)
+    statsd       *metrics.StatsD             // Клиент StatsD (nil, если метрики отправляются не в StatsD)
+    adminAddr    string
+    adminTokens  map[string]string           // Оператор -> bearer-токен admin API
+    adminServer  *http.Server
+
+    upstreamHeaders config.UpstreamHeadersConfig // Отладочные заголовки X-Upstream*
+    requestHeaders  config.HeaderRules           // Заголовки исходящего запроса
+    responseHeaders config.HeaderRules           // Заголовки ответа backend'а
+    transformCfg    config.TransformConfig
+    transforms      []ResponseTransform          // Трансформации тела ответа
+    compression     *compressor                  // Сжатие ответов (nil, если выключено)
+    requiredHeaders []requiredHeader             // Обязательные заголовки запроса
+    rewrites        []pathRewrite                // Переписывание пути перед отправкой на backend
+    quota           *ratelimiter.QuotaTracker    // Квоты за сутки/месяц (nil, если выключены)
+    retryAfter      time.Duration                // Retry-After по умолчанию, когда нет живых backend'ов
+    requestTimeout  time.Duration                // Общий бюджет запроса на все попытки (0 — без ограничения)
+    limiterStore    ratelimiter.StateStore       // Хранилище состояния лимитера (nil — без сохранения)
+    signing         config.RequestSigningConfig  // HMAC-подпись запросов к backend'ам
+    optionsCfg      config.OptionsConfig         // Обработка OPTIONS-запросов
+    bodyRouter      *bodyRouter                  // Маршрутизация по полю JSON-тела (nil, если выключена)
+    pathRouter      *pathRouter                  // Маршрутизация по префиксу пути (nil, если маршруты не заданы)
+    hostRouter      *hostRouter                  // Маршрутизация по виртуальному хосту (nil, если хосты не заданы)
+    urlKey          *urlkey.Canonicalizer        // Канонический ключ URL для хеширования и маршрутизации
+
+    sensitiveHeaders config.SensitiveHeadersConfig // Политика для Authorization и др. по умолчанию
+    proxyErrorLog    *log.Logger                   // Сообщения ReverseProxy на уровне Debug: они дублируют наши логи
+    selfTest         config.SelfTestConfig         // Синтетический запрос POST /admin/selftest
+
+    headerNormalization []config.HeaderNormalizationRule   // Политики для повторяющихся заголовков
+    retry               config.RetryConfig                 // Повтор запросов на другом backend'е
+    loadHeader          string                             // Заголовок ответа с нагрузкой backend'а (adaptive_weights)
+    cfg                 atomic.Pointer[config.Config]      // Загруженная конфигурация (основа для GET /admin/config и Reload)
+    inFlight            *inFlightLimiter                   // Предел одновременных запросов (nil — без ограничения)
+    backendQueue        config.BackendQueueConfig          // Ожидание слота backend'а с max_concurrent
+    serverTLS           config.ServerTLSConfig             // HTTPS на порту прокси (пустой — обычный HTTP)
+    inFlightRequests    atomic.Int64                       // Запросы в обработке (lb_inflight_requests и лог остановки)
+    denylist            atomic.Pointer[clientip.IPList] // Заблокированные адреса (меняются при SIGHUP)
+    trustedProxies      clientip.IPList                 // Прокси, чьим X-Forwarded-* можно верить (пусто — всем)
+    proxyProtocol       config.ProxyProtocolConfig         // Разбор заголовка PROXY protocol на listener'е
+    server              config.ServerConfig                // Таймауты соединений клиентов
+    connectionPool      config.ConnectionPoolConfig        // Пул соединений с backend'ами
+    transport           *http.Transport                    // Общий транспорт к backend'ам без собственного TLS
+    shutdown            config.ShutdownConfig              // Порядок и сроки остановки
+    accessLog           config.AccessLogConfig             // Строка лога на каждый запрос
+    requestIDHeader     string                             // Заголовок с идентификатором запроса
+    probes              config.ProbesConfig                // Собственные проверки балансировщика
+    draining            atomic.Bool                        // Началась остановка: проверка готовности не проходит
+    maintenance         atomic.Pointer[maintenancePage]    // Ответ режима обслуживания (nil — раздел не загружен)
+    maintenanceOn       atomic.Bool                        // Режим обслуживания включен
 }
 
 // NewProxyServer инициализирует новый экземпляр ProxyServer.
 func NewProxyServer(cfg *config.Config, logger *zap.SugaredLogger) *ProxyServer {
-    loadBalancer := balancer.NewRoundRobinLoadBalancer(cfg.Backends, logger)
+    loadBalancer := newLoadBalancer(cfg, logger)
     limiter := ratelimiter.NewRateLimiter(cfg.RateLimit.Capacity, cfg.RateLimit.RefillRate, logger)
+    urlKey := urlkey.New(cfg.QueryCanonicalization)
+    keyFunc, err := ratelimiter.NewKeyFunc(cfg.RateLimit.Key, urlKey.Key)
+    if err != nil {
+        logger.Errorf("Invalid rate limit key, using client IP: %v", err)
+        keyFunc, _ = ratelimiter.NewKeyFunc(nil, nil)
+    }
+    if cfg.RateLimit.KeyHeader != "" {
+        keyFunc = ratelimiter.WithKeyHeader(cfg.RateLimit.KeyHeader, keyFunc)
+    }
+    limiter.SetKeyFunc(keyFunc)
+
+    if err := limiter.SetAlgorithm(cfg.RateLimit.Algorithm); err != nil {
+        logger.Errorf("Invalid rate limit algorithm, using token bucket: %v", err)
+    }
 
     proxy := &ProxyServer{
         balancer:    loadBalancer,
         logger:      logger,
         rateLimiter: limiter,
+        metrics:     metrics.Nop{},
+        adminAddr:   cfg.Admin.Addr,
+        adminTokens: cfg.Admin.Tokens,
+
+        upstreamHeaders: cfg.UpstreamHeaders,
+        requestHeaders:  cfg.RequestHeaders,
+        responseHeaders: cfg.ResponseHeaders,
+        transformCfg:    cfg.Transform,
+        compression:     newCompressor(cfg.Compression),
+        requiredHeaders: compileRequiredHeaders(cfg.RequiredHeaders),
+        rewrites:        compileRewrites(cfg.Rewrite),
+        retryAfter:      cfg.RetryAfterDefault,
+        requestTimeout:  cfg.RequestTimeout,
+        signing:         cfg.RequestSigning,
+        optionsCfg:      cfg.Options,
+        urlKey:          urlKey,
+
+        sensitiveHeaders: cfg.SensitiveHeaders,
+        selfTest:         cfg.SelfTest,
+
+        headerNormalization: cfg.HeaderNormalization,
+        retry:               cfg.Retry,
+        loadHeader:          cfg.AdaptiveWeights.LoadHeader,
+        inFlight:            newInFlightLimiter(cfg.InFlight),
+        backendQueue:        cfg.BackendQueue,
+        serverTLS:           cfg.TLS,
+        server:              cfg.Server,
+        connectionPool:      cfg.ConnectionPool,
+        transport:           newUpstreamTransport(cfg.ConnectionPool),
+        shutdown:            cfg.Shutdown,
+        accessLog:           cfg.AccessLog,
+        requestIDHeader:     cfg.RequestID.Header,
+        probes:              cfg.Probes,
+        proxyProtocol:       cfg.ProxyProtocol,
+    }
+
+    proxy.cfg.Store(cfg)
+    if err := proxy.setDenylist(cfg.Denylist); err != nil {
+        logger.Errorf("Invalid denylist, ignoring it: %v", err)
+    }
+    if err := proxy.setMaintenance(cfg.Maintenance); err != nil {
+        logger.Errorf("Invalid maintenance settings, ignoring them: %v", err)
+    }
+    if trusted, err := clientip.ParseIPList(cfg.TrustedProxies); err != nil {
+        logger.Errorf("Invalid trusted_proxies, ignoring it: %v", err)
+    } else {
+        proxy.trustedProxies = trusted
+    }
+
+    if errorLog, err := zap.NewStdLogAt(logger.Desugar(), zap.DebugLevel); err == nil {
+        proxy.proxyErrorLog = errorLog
+    }
+
+    if proxy.signing.Header == "" {
+        proxy.signing.Header = "X-LB-Signature"
+    }
+
+    if proxy.retryAfter <= 0 {
+        proxy.retryAfter = 5 * time.Second
+    }
+
+    if proxy.requestIDHeader == "" {
+        proxy.requestIDHeader = requestid.DefaultHeader
+    }
+
+    if proxy.upstreamHeaders.BackendHeader == "" {
+        proxy.upstreamHeaders.BackendHeader = "X-Upstream"
+    }
+    if proxy.upstreamHeaders.DurationHeader == "" {
+        proxy.upstreamHeaders.DurationHeader = "X-Upstream-Duration"
+    }
+
+    proxy.configureMetrics(cfg.Metrics)
+    limiter.SetMetrics(proxy.metrics)
+    loadBalancer.SetMetrics(proxy.metrics)
+    limiter.SetObserveMode(cfg.RateLimit.Mode == "observe")
+    limiter.SetGlobalLimit(cfg.RateLimit.GlobalCapacity, cfg.RateLimit.GlobalRefillRate)
+    if err := limiter.SetAllowlist(cfg.RateLimit.Allowlist); err != nil {
+        logger.Errorf("Invalid rate limit allowlist, ignoring it: %v", err)
+    }
+    for clientID, limit := range cfg.RateLimit.Clients {
+        limiter.SetClientLimit(clientID, ratelimiter.ClientLimit{Capacity: limit.Capacity, RefillRate: limit.RefillRate})
+    }
+
+    loadBalancer.ConfigureCircuitBreaker(cfg.CircuitBreaker)
+    loadBalancer.ConfigureOutlierDetection(cfg.OutlierDetection)
+    loadBalancer.ConfigureAdaptiveWeights(cfg.AdaptiveWeights, nil)
+    loadBalancer.ConfigureStandby(cfg.Standby, proxy.metrics)
+
+    if persistence := cfg.RateLimit.Persistence; persistence.Path != "" {
+        proxy.limiterStore = ratelimiter.NewFileStore(persistence.Path)
+
+        maxAge := persistence.MaxAge
+        if maxAge <= 0 {
+            maxAge = 10 * time.Minute
+        }
+        if restored, err := limiter.RestoreOverrides(proxy.limiterStore); err != nil {
+            logger.Warnf("Failed to restore rate limit overrides: %v", err)
+        } else if restored > 0 {
+            logger.Infof("Restored %d rate limit overrides", restored)
+        }
+        if restored, err := limiter.RestoreState(proxy.limiterStore, maxAge); err != nil {
+            logger.Warnf("Failed to restore rate limiter state, starting empty: %v", err)
+        } else {
+            logger.Infof("Restored %d rate limiter buckets from %s", restored, persistence.Path)
+        }
+
+        interval := persistence.Interval
+        if interval <= 0 {
+            interval = 30 * time.Second
+        }
+        go limiter.RunSnapshots(proxy.limiterStore, interval)
+    }
+
+    if cfg.Quota.Enabled {
+        policies := make(map[string]ratelimiter.QuotaPolicy, len(cfg.Quota.Clients))
+        for clientID, policy := range cfg.Quota.Clients {
+            policies[clientID] = ratelimiter.QuotaPolicy{Limit: policy.Limit, Period: policy.Period}
+        }
+        proxy.quota = ratelimiter.NewQuotaTracker(
+            ratelimiter.QuotaPolicy{Limit: cfg.Quota.Default.Limit, Period: cfg.Quota.Default.Period},
+            policies, cfg.Quota.Window, cfg.Quota.PersistPath, logger)
+
+        interval := cfg.Quota.PersistInterval
+        if interval <= 0 {
+            interval = time.Minute
+        }
+        go proxy.quota.RunPersistence(interval)
+    }
+
+    if cfg.BodyRouting.Enabled {
+        proxy.bodyRouter = newBodyRouter(cfg.BodyRouting, cfg.HealthCheck, logger)
+        for _, route := range proxy.bodyRouter.routes {
+            route.pool.ConfigureCircuitBreaker(cfg.CircuitBreaker)
+            route.pool.ConfigureOutlierDetection(cfg.OutlierDetection)
+        }
+    }
+
+    if len(cfg.Routes) > 0 {
+        proxy.pathRouter = newPathRouter(cfg.Routes, cfg.HealthCheck, logger)
+        for _, route := range proxy.pathRouter.routes {
+            route.pool.SetMetrics(proxy.metrics)
+            route.pool.ConfigureCircuitBreaker(cfg.CircuitBreaker)
+            route.pool.ConfigureOutlierDetection(cfg.OutlierDetection)
+            configureStrategy(route.pool, cfg)
+        }
+    }
+    if len(cfg.Hosts) > 0 {
+        proxy.hostRouter = newHostRouter(cfg.Hosts, cfg.HealthCheck, logger)
+        for _, pool := range proxy.hostRouter.pools() {
+            pool.SetMetrics(proxy.metrics)
+            pool.ConfigureCircuitBreaker(cfg.CircuitBreaker)
+            pool.ConfigureOutlierDetection(cfg.OutlierDetection)
+            configureStrategy(pool, cfg)
+        }
+    }
+
+    if cfg.Capture.Enabled {
+        sink, err := capture.NewSink(cfg.Capture, logger)
+        if err != nil {
+            logger.Errorf("Request capture disabled: %v", err)
+        } else {
+            proxy.capture = sink
+        }
     }
 
     logger.Infof("ProxyServer initialized on port %d with %d backends and rate limit %d/%ds",
         cfg.Port, len(cfg.Backends), cfg.RateLimit.Capacity, cfg.RateLimit.RefillRate)
+    if !proxy.HasBackends() {
+        logger.Error("No valid backends configured: every request will get 503 until backends are added")
+    }
 
-    go proxy.cleanupStaleClients()
+    proxy.cleanupStaleClients(cfg)
 
     return proxy
 }
 
-// Start запускает HTTP-прокси-сервер.
-func (p *ProxyServer) Start(addr string) error {
+// configureMetrics выбирает backend метрик. По умолчанию метрики собираются для /metrics
+// только при включенном admin listener; ошибка StatsD отключает метрики, но не прокси.
+func (p *ProxyServer) configureMetrics(cfg config.MetricsConfig) {
+    sink := cfg.Sink
+    if sink == "" && p.adminAddr != "" {
+        sink = "prometheus"
+    }
+
+    switch sink {
+    case "prometheus":
+        p.registry = metrics.NewRegistry()
+        p.metrics = p.registry
+    case "statsd":
+        client, err := metrics.NewStatsD(cfg.StatsD.Addr, metrics.StatsDOptions{
+            Prefix:         cfg.StatsD.Prefix,
+            FlushInterval:  cfg.StatsD.FlushInterval,
+            MaxPacketBytes: cfg.StatsD.MaxPacketBytes,
+            Tags:           cfg.StatsD.Tags != "none",
+        })
+        if err != nil {
+            p.logger.Errorf("StatsD metrics disabled: %v", err)
+            return
+        }
+        p.statsd = client
+        p.metrics = client
+    }
+}
+
+// newLoadBalancer создает балансировщик согласно конфигурации.
+// Если базу GeoIP открыть не удалось, используется стратегия из strategy.
+func newLoadBalancer(cfg *config.Config, logger *zap.SugaredLogger) balancer.LoadBalancer {
+    if cfg.Geo.Enabled {
+        resolver, err := balancer.NewGeoIPResolver(cfg.Geo.Database, cfg.Geo.Regions)
+        if err == nil {
+            return balancer.NewGeoLoadBalancer(cfg.Backends, cfg.HealthCheck, cfg.Geo, resolver, logger)
+        }
+        logger.Errorf("Geo balancing disabled, falling back to the configured strategy: %v", err)
+    }
+    lb, err := balancer.New(cfg.Strategy, cfg.Backends, cfg.HealthCheck, logger)
+    if err != nil {
+        // config.Load отклоняет неизвестные стратегии, сюда попадает только конфигурация, собранная в коде
+        logger.Errorf("%v, using round_robin", err)
+        return balancer.NewRoundRobinLoadBalancer(cfg.Backends, cfg.HealthCheck, logger)
+    }
+    configureStrategy(lb, cfg)
+    return lb
+}
+
+// configureStrategy передает пулу настройки его стратегии (consistent_hash, least_response_time).
+func configureStrategy(pool balancer.LoadBalancer, cfg *config.Config) {
+    switch lb := pool.(type) {
+    case *balancer.ConsistentHashLoadBalancer:
+        lb.Configure(cfg.ConsistentHash)
+    case *balancer.LeastResponseTimeLoadBalancer:
+        lb.Configure(cfg.LeastResponseTime)
+    }
+}
+
+// Handler собирает цепочку обработчиков прокси (middleware + проксирование).
+func (p *ProxyServer) Handler() http.Handler {
     mux := http.NewServeMux()
-    mux.HandleFunc("/", p.handleProxy)
+    mux.Handle("/", p.normalizeHeadersMiddleware(p.requireHeadersMiddleware(p.inFlightMiddleware(p.compressionMiddleware(http.HandlerFunc(p.handleProxy))))))
+
+    // OPTIONS * не проходит через ServeMux, поэтому обработка OPTIONS стоит перед ним
+    var handler http.Handler = p.optionsMiddleware(mux)
+    if p.quota != nil {
+        handler = ratelimiter.QuotaMiddleware(p.quota, p.logger)(handler)
+    }
+    handler = ratelimiter.RateLimitMiddleware(p.rateLimiter, p.logger)(handler)
+    if p.capture != nil {
+        handler = p.capture.Middleware(handler)
+    }
+    return p.probesMiddleware(p.clientIPMiddleware(p.requestIDMiddleware(p.accessLogMiddleware(p.requestMetricsMiddleware(p.denylistMiddleware(handler))))))
+}
 
-    handlerWithRateLimit := ratelimiter.RateLimitMiddleware(p.rateLimiter, p.logger)(mux)
+// Listen синхронно занимает адрес прокси и готовит HTTP-сервер. Ошибка привязки
+// (например, занятый порт) или некорректный сертификат возвращаются сразу,
+// до запуска обслуживания в горутине.
+func (p *ProxyServer) Listen(addr string) (net.Listener, error) {
+    var tlsConfig *tls.Config
+    if p.serverTLS.CertFile != "" {
+        var err error
+        if tlsConfig, err = newServerTLSConfig(p.serverTLS, p.logger); err != nil {
+            return nil, err
+        }
+    }
+
+    listener, err := listen(addr)
+    if errors.Is(err, syscall.EADDRINUSE) {
+        return nil, fmt.Errorf("address %s already in use: %w", addr, err)
+    }
+    if err != nil {
+        return nil, err
+    }
+    if p.proxyProtocol.Enabled {
+        upstreams, err := clientip.ParseIPList(p.proxyProtocol.TrustedUpstreams)
+        if err != nil {
+            listener.Close()
+            return nil, fmt.Errorf("proxy_protocol.trusted_upstreams: %w", err)
+        }
+        listener = &proxyproto.Listener{Listener: listener, Trusted: upstreams.Contains, HeaderTimeout: p.proxyProtocol.HeaderTimeout}
+        p.logger.Infof("PROXY protocol enabled for %d trusted upstreams", len(upstreams))
+    }
 
     p.httpServer = &http.Server{
-        Addr:    addr,
-        Handler: handlerWithRateLimit,
+        Handler: p.Handler(),
+
+        // В режиме respond на OPTIONS * отвечает optionsMiddleware, а не встроенный обработчик
+        DisableGeneralOptionsHandler: p.optionsCfg.Mode == "respond",
+        TLSConfig:                    tlsConfig,
+
+        ReadHeaderTimeout: p.server.ReadHeaderTimeout,
+        ReadTimeout:       p.server.ReadTimeout,
+        WriteTimeout:      p.server.WriteTimeout,
+        IdleTimeout:       p.server.IdleTimeout,
+    }
+    if p.httpServer.ReadHeaderTimeout <= 0 {
+        p.httpServer.ReadHeaderTimeout = defaultReadHeaderTimeout
+    }
+    if p.httpServer.IdleTimeout <= 0 {
+        p.httpServer.IdleTimeout = defaultIdleTimeout
+    }
+    return listener, nil
+}
+
+// listen занимает TCP-адрес или, для адреса вида unix:/path, Unix-сокет. Файл сокета,
+// оставшийся от аварийно завершенного процесса, удаляется, если его никто не слушает;
+// при закрытии listener'а (Shutdown) net удаляет файл сам.
+func listen(addr string) (net.Listener, error) {
+    path, ok := strings.CutPrefix(addr, "unix:")
+    if !ok {
+        return net.Listen("tcp", addr)
+    }
+    if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+        if conn, err := net.Dial("unix", path); err == nil {
+            conn.Close()
+        } else {
+            os.Remove(path)
+        }
+    }
+    return net.Listen("unix", path)
+}
+
+// Serve обслуживает запросы на адресе, занятом через Listen, до вызова Shutdown.
+// Штатная остановка через Shutdown ошибкой не считается.
+func (p *ProxyServer) Serve(listener net.Listener) error {
+    if p.adminAddr != "" {
+        p.startAdmin()
     }
 
-    p.logger.Infof("Starting proxy server at %s", addr)
-    return p.httpServer.ListenAndServe()
+    var err error
+    if p.httpServer.TLSConfig != nil {
+        p.logger.Infof("Starting HTTPS proxy server at %s", listener.Addr())
+        // Сертификат отдает TLSConfig.GetCertificate, поэтому пути к файлам не передаются
+        err = p.httpServer.ServeTLS(listener, "", "")
+    } else {
+        p.logger.Infof("Starting proxy server at %s", listener.Addr())
+        err = p.httpServer.Serve(listener)
+    }
+    if err != http.ErrServerClosed {
+        return err
+    }
+    return nil
 }
 
-// Shutdown корректно завершает работу сервера.
+// Start занимает адрес и запускает HTTP-прокси-сервер.
+func (p *ProxyServer) Start(addr string) error {
+    listener, err := p.Listen(addr)
+    if err != nil {
+        return err
+    }
+    return p.Serve(listener)
+}
+
+// Shutdown корректно завершает работу сервера. Сначала проверка готовности перестает
+// проходить и keep-alive отключается, чтобы клиенты и внешний балансировщик ушли
+// на другие экземпляры; через shutdown.drain_delay listener закрывается,
+// а начатые запросы получают shutdown.grace_period на завершение.
 func (p *ProxyServer) Shutdown() {
-    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    p.draining.Store(true)
+    p.httpServer.SetKeepAlivesEnabled(false)
+    if delay := p.shutdown.DrainDelay; delay > 0 {
+        p.logger.Infof("Draining for %s before closing the listener", delay)
+        time.Sleep(delay)
+    }
+
+    grace := p.shutdown.GracePeriod
+    if grace <= 0 {
+        grace = defaultShutdownGracePeriod
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), grace)
     defer cancel()
 
-    p.logger.Info("Shutting down proxy server...")
+    p.logger.Infof("Shutting down proxy server, waiting up to %s for %d in-flight requests...", grace, p.inFlightRequests.Load())
     if err := p.httpServer.Shutdown(ctx); err != nil {
-        p.logger.Errorf("Graceful shutdown failed: %v", err)
+        p.logger.Errorf("Graceful shutdown failed after %s with %d requests still in flight: %v", grace, p.inFlightRequests.Load(), err)
     } else {
         p.logger.Info("Shutdown complete")
     }
+
+    if p.adminServer != nil {
+        if err := p.adminServer.Shutdown(ctx); err != nil {
+            p.logger.Errorf("Admin server shutdown failed: %v", err)
+        }
+    }
+
+    if p.limiterStore != nil {
+        if err := p.rateLimiter.SaveState(p.limiterStore); err != nil {
+            p.logger.Errorf("Failed to persist rate limiter state: %v", err)
+        }
+    }
+
+    if p.quota != nil {
+        if err := p.quota.Save(); err != nil {
+            p.logger.Errorf("Failed to persist quota counters: %v", err)
+        }
+    }
+
+    if p.capture != nil {
+        if err := p.capture.Close(); err != nil {
+            p.logger.Errorf("Failed to close capture file: %v", err)
+        }
+    }
+
+    if p.statsd != nil {
+        p.statsd.Close()
+    }
 }
 
 // handleProxy обрабатывает входящие HTTP-запросы и выполняет проксирование.
+// При ошибке соединения с backend'ом запрос, допускающий повтор, отправляется
+// на другой backend (не более retry.max_retries раз) с тем же телом.
+// Upgrade-запросы (WebSocket) проходят тот же выбор backend'а и повторы рукопожатия,
+// но без буферизации тела и без request_timeout: после 101 соединение живет, сколько нужно клиенту.
 func (p *ProxyServer) handleProxy(w http.ResponseWriter, r *http.Request) {
     clientIP := getClientIP(r)
+    if p.serveMaintenance(w, r, clientIP) {
+        return
+    }
+    upgrade := isUpgradeRequest(r)
+    logger := p.requestLogger(r)
 
-    target := p.balancer.NextAvailableBackend()
-    if target == nil {
-        p.logger.Warn("No available backends")
-        sendJSONError(w, http.StatusServiceUnavailable, "No available backends")
+    lb := p.balancerFor(r)
+    if lb == nil {
+        logger.Infof("No route for %s from %s", r.URL.Path, clientIP)
+        sendJSONError(w, http.StatusNotFound, "No route for path")
         return
     }
+    tracker := &responseTracker{ResponseWriter: w}
+    sel := balancer.Selection{ClientIP: clientIP, Request: r, URLKey: p.urlKey.Key(r.URL)}
 
-    proxy := httputil.NewSingleHostReverseProxy(target.Address)
+    if p.requestTimeout > 0 && !upgrade {
+        ctx, cancel := context.WithTimeoutCause(r.Context(), p.requestTimeout, errRequestTimeout)
+        defer cancel()
+        r = r.WithContext(ctx)
+    }
 
-    originalDirector := proxy.Director
-    proxy.Director = func(req *http.Request) {
-        originalDirector(req)
-        req.Host = target.Address.Host
+    attempts := 1
+    var body []byte
+    if upgrade && p.retryable(r) {
+        // Тело рукопожатия пустое, а данные после 101 идут через захваченное соединение
+        attempts += p.retry.MaxRetries
+    } else if p.retryable(r) {
+        var ok bool
+        body, ok = p.bufferBody(r)
+        switch {
+        case ok:
+            attempts += p.retry.MaxRetries
+        case p.retry.BufferRequired:
+            logger.Warnf("Request body from %s exceeds retry.max_body_size, rejecting", clientIP)
+            sendJSONError(w, http.StatusRequestEntityTooLarge, "Request body too large")
+            return
+        }
     }
+    for attempt := 1; ; attempt++ {
+        if body != nil {
+            r.Body = io.NopCloser(bytes.NewReader(body))
+        }
+        target, saturated := p.acquireBackend(r, lb, sel)
+        if target == nil && saturated {
+            logger.Warnf("All available backends are at max_concurrent, rejecting request from %s", clientIP)
+            sendJSONError(tracker, http.StatusServiceUnavailable, "Backends at concurrency limit")
+            return
+        }
+        if target == nil && attempt == 1 {
+            logger.Warn("No available backends")
+            w.Header().Set("Retry-After", strconv.Itoa(p.retryAfterSeconds()))
+            sendJSONError(w, http.StatusServiceUnavailable, "No available backends")
+            return
+        }
+        if target == nil {
+            logger.Errorf("No backends left to retry request from %s after %d attempts", clientIP, attempt-1)
+            sendJSONError(tracker, http.StatusServiceUnavailable, "Backend unavailable")
+            return
+        }
 
-    proxy.ErrorHandler = func(rw http.ResponseWriter, req *http.Request, err error) {
-        p.logger.Errorf("Proxy error for backend %s: %v", target.Address, err)
-        p.balancer.MarkBackendUnhealthy(target.Address)
-        sendJSONError(rw, http.StatusServiceUnavailable, "Backend unavailable")
+        retry := p.forward(tracker, r, lb, target, clientIP, attempt < attempts)
+        target.ReleaseSlot()
+        if !retry {
+            return
+        }
+        if timeoutCause(r.Context()) != nil {
+            // Общий бюджет исчерпан — следующую попытку не начинаем
+            logger.Errorf("Request from %s exceeded request_timeout %s after %d attempts", clientIP, p.requestTimeout, attempt)
+            sendJSONError(tracker, http.StatusGatewayTimeout, "Backend timeout")
+            return
+        }
+        if sel.Tried == nil {
+            sel.Tried = make(map[*balancer.Backend]bool)
+        }
+        sel.Tried[target] = true
+        p.metrics.Inc("lb_retries_total", "backend", target.Address.String())
     }
+}
 
-    p.logger.Infof("Forwarding request from %s to %s", clientIP, target.Address)
-    proxy.ServeHTTP(w, r)
+// acquireBackend выбирает backend и занимает его слот max_concurrent. Занятые до предела
+// backend'ы пропускаются в пользу остальных; если свободных не осталось, запрос ждет слот
+// первого выбранного не дольше backend_queue.timeout. saturated сообщает, что backend'ы
+// были, но все оказались заняты.
+func (p *ProxyServer) acquireBackend(r *http.Request, lb balancer.LoadBalancer, sel balancer.Selection) (target *balancer.Backend, saturated bool) {
+    var first *balancer.Backend
+    for {
+        target = lb.Select(sel)
+        if target == nil {
+            break
+        }
+        if target.TryAcquireSlot() {
+            return target, false
+        }
+        // Выбор резервирует пробный запрос circuit breaker'а, который не будет отправлен
+        target.Breaker().Release()
+        p.metrics.Inc("lb_backend_saturated_total", "backend", target.Address.String())
+        if first == nil {
+            first = target
+        }
+        // Занятый backend исключается только из этого выбора, а не из повторов запроса
+        tried := make(map[*balancer.Backend]bool, len(sel.Tried)+1)
+        for backend := range sel.Tried {
+            tried[backend] = true
+        }
+        tried[target] = true
+        sel.Tried = tried
+    }
+    if first == nil {
+        return nil, false
+    }
+    if first.AcquireSlot(r.Context(), p.backendQueue.Timeout) {
+        return first, false
+    }
+    return nil, true
 }
 
-// cleanupStaleClients запускает периодическую очистку старых записей rate limiter-а.
-func (p *ProxyServer) cleanupStaleClients() {
-    ticker := time.NewTicker(1 * time.Minute)
-    defer ticker.Stop()
+// attemptKey — ключ контекста запроса, под которым лежит forwardAttempt.
+type attemptKey struct{}
+
+// forwardAttempt — состояние одной попытки проксирования. Обратный прокси создается один раз
+// на backend, поэтому его ModifyResponse и ErrorHandler получают попытку из контекста запроса,
+// а не из замыканий, создаваемых на каждый запрос.
+type forwardAttempt struct {
+    tracker  *responseTracker
+    lb       balancer.LoadBalancer
+    target   *balancer.Backend
+    clientIP string
+    canRetry bool
+    logger   *zap.SugaredLogger
+    perTry   *time.Timer // Таймаут попытки (nil — не задан)
+    start    time.Time
 
-    for range ticker.C {
-        p.logger.Debug("Running rate limiter cleanup")
-        p.rateLimiter.Cleanup(5 * time.Minute)
+    reported    bool // Исход попытки уже учтен в circuit breaker'е
+    retry       bool // Запрос нужно повторить на другом backend'е
+    abortLogged bool // Обрыв ответа уже залогирован ErrorHandler'ом
+}
+
+// attemptFromContext возвращает попытку проксирования, к которой относится запрос.
+func attemptFromContext(ctx context.Context) *forwardAttempt {
+    attempt, _ := ctx.Value(attemptKey{}).(*forwardAttempt)
+    return attempt
+}
+
+// forward проксирует запрос на выбранный backend. Возвращает true, если попытка
+// завершилась ошибкой до начала ответа клиенту и запрос нужно повторить на другом backend'е
+// (только при canRetry).
+func (p *ProxyServer) forward(tracker *responseTracker, r *http.Request, lb balancer.LoadBalancer, target *balancer.Backend, clientIP string, canRetry bool) bool {
+    proxy := target.ReverseProxy(p.newBackendProxy)
+    attempt := &forwardAttempt{
+        tracker:  tracker,
+        lb:       lb,
+        target:   target,
+        clientIP: clientIP,
+        canRetry: canRetry,
+        logger:   p.requestLogger(r),
+    }
+    logger := attempt.logger
+
+    // Таймаут попытки действует до получения заголовков ответа: начатую передачу тела он не прерывает.
+    // Отмена контекста прерывает запрос транспорта и закрывает соединение с backend'ом
+    perTryTimeout := p.retry.PerTryTimeout
+    if timeout := target.Timeout(); timeout > 0 {
+        perTryTimeout = timeout
+    }
+    ctx := r.Context()
+    if perTryTimeout > 0 {
+        var cancel context.CancelCauseFunc
+        ctx, cancel = context.WithCancelCause(ctx)
+        defer cancel(nil)
+        attempt.perTry = time.AfterFunc(perTryTimeout, func() { cancel(errPerTryTimeout) })
+        defer attempt.perTry.Stop()
     }
+    r = r.WithContext(context.WithValue(ctx, attemptKey{}, attempt))
+
+    // Каждая попытка, в том числе повторная, учитывается в circuit breaker'е backend'а ровно один раз
+    defer func() {
+        if !attempt.reported {
+            target.Breaker().Release()
+        }
+    }()
+
+    target.ActiveConnections.Add(1)
+    target.TotalRequests.Add(1)
+    defer target.ActiveConnections.Add(-1)
+
+    // Обрыв тела ответа backend'а ReverseProxy обрабатывает сам, прерывая соединение
+    // через panic(http.ErrAbortHandler); здесь он только логируется отдельно от ошибок до ответа
+    defer func() {
+        recovered := recover()
+        if recovered == nil {
+            return
+        }
+        if recovered == http.ErrAbortHandler && !attempt.abortLogged {
+            if timeoutCause(r.Context()) == nil && r.Context().Err() != nil {
+                logger.Infof("Client %s disconnected during response from %s after %d bytes", clientIP, target.Address, tracker.bytes)
+            } else {
+                target.FailedRequests.Add(1)
+                p.metrics.Inc("lb_backend_errors_total", "backend", target.Address.String())
+                p.reportAttempt(attempt, false)
+                logger.Errorf("Backend %s aborted response after %d bytes", target.Address, tracker.bytes)
+            }
+        }
+        panic(recovered)
+    }()
+
+    logger.Infof("Forwarding request from %s to %s", clientIP, target.Address)
+    setAccessLogBackend(r, target.Address.String())
+    attempt.start = time.Now()
+    proxy.ServeHTTP(tracker, r)
+
+    p.metrics.Inc("lb_requests_total", "backend", target.Address.String())
+    p.metrics.Observe("lb_request_duration_seconds", time.Since(attempt.start).Seconds(), "backend", target.Address.String())
+    return attempt.retry
 }
 
-// getClientIP извлекает IP-адрес клиента из заголовков или соединения.
-func getClientIP(r *http.Request) string {
-    if ip := r.Header.Get("X-Real-IP"); ip != "" {
-        return strings.TrimSpace(ip)
+// reportAttempt учитывает исход попытки в circuit breaker'е и outlier detection не больше одного раза.
+func (p *ProxyServer) reportAttempt(attempt *forwardAttempt, ok bool) {
+    if !attempt.reported {
+        attempt.reported = true
+        p.reportOutcome(attempt.target, ok)
+    }
+}
+
+// newBackendProxy создает обратный прокси, общий для всех запросов к backend'у.
+// Состояние запроса ModifyResponse и ErrorHandler берут из forwardAttempt в его контексте.
+func (p *ProxyServer) newBackendProxy(target *balancer.Backend) *httputil.ReverseProxy {
+    proxy := p.newReverseProxy(target)
+    proxy.ModifyResponse = p.modifyResponse
+    proxy.ErrorHandler = p.handleProxyError
+    return proxy
+}
+
+// modifyResponse обрабатывает заголовки ответа backend'а: учитывает исход попытки
+// и задержку, применяет response_headers и трансформации тела.
+func (p *ProxyServer) modifyResponse(resp *http.Response) error {
+    attempt := attemptFromContext(resp.Request.Context())
+    if attempt.perTry != nil {
+        attempt.perTry.Stop()
+    }
+    target := attempt.target
+    p.reportAttempt(attempt, resp.StatusCode < http.StatusInternalServerError)
+    // Клиенту уже выставлен идентификатор запроса; копия от backend'а его бы задублировала
+    resp.Header.Del(p.requestIDHeader)
+    target.ObserveResponse(time.Since(attempt.start), p.reportedLoad(resp))
+    if p.upstreamHeaders.Enabled {
+        resp.Header.Set(p.upstreamHeaders.BackendHeader, target.Address.String())
+        resp.Header.Set(p.upstreamHeaders.DurationHeader, time.Since(attempt.start).String())
+    }
+    applyResponseHeaderRules(resp, p.responseHeaders)
+    return p.applyTransforms(resp)
+}
+
+// handleProxyError обрабатывает ошибку попытки: повтор на другом backend'е, обрыв уже
+// начатого ответа или JSON-ошибку клиенту.
+func (p *ProxyServer) handleProxyError(rw http.ResponseWriter, req *http.Request, err error) {
+    attempt := attemptFromContext(req.Context())
+    target, logger := attempt.target, attempt.logger
+
+    timeout := timeoutCause(req.Context())
+    if timeout == nil && req.Context().Err() != nil {
+        // Клиент отключился сам — backend не виноват
+        logger.Infof("Client %s disconnected before backend %s responded: %v", attempt.clientIP, target.Address, err)
+        if !attempt.tracker.started() {
+            rw.WriteHeader(statusClientClosedRequest)
+        }
+        return
+    }
+
+    target.FailedRequests.Add(1)
+    p.metrics.Inc("lb_backend_errors_total", "backend", target.Address.String())
+    if timeout != nil {
+        p.metrics.Inc("lb_backend_timeouts_total", "backend", target.Address.String())
+    }
+    p.reportAttempt(attempt, false)
+    // С circuit breaker'ом ошибки учитываются им; без него backend выводится до следующего health-check
+    if target.Breaker() == nil {
+        attempt.lb.MarkBackendUnhealthy(target.Address)
+    }
+
+    if attempt.tracker.started() {
+        logger.Errorf("Backend %s failed mid-response after %d bytes, aborting client connection: %v",
+            target.Address, attempt.tracker.bytes, err)
+        attempt.abortLogged = true
+        panic(http.ErrAbortHandler)
     }
-    if ip := r.Header.Get("X-Forwarded-For"); ip != "" {
-        return strings.TrimSpace(strings.Split(ip, ",")[0])
+    if attempt.canRetry && timeout != errRequestTimeout {
+        logger.Warnf("Proxy error for backend %s, retrying on another backend: %v", target.Address, err)
+        attempt.retry = true
+        return
+    }
+    if timeout != nil {
+        logger.Errorf("Backend %s did not respond in time: %v", target.Address, timeout)
+        sendJSONError(rw, http.StatusGatewayTimeout, "Backend timeout")
+        return
+    }
+    status, message := upstreamErrorStatus(err)
+    logger.Errorf("Proxy error for backend %s (%d): %v", target.Address, status, err)
+    sendJSONError(rw, status, message)
+}
+
+// newReverseProxy создает обратный прокси к backend'у с общей подготовкой исходящего запроса:
+// переписывание пути, Host backend'а, X-Forwarded-*, request_headers, политика чувствительных
+// заголовков и подпись. Используется Rewrite, а не Director: с Director ReverseProxy сам
+// дописывает X-Forwarded-For уже после него, и доверие к цепочке клиента не настроить.
+// IP клиента и идентификатор запроса Rewrite берет из контекста запроса.
+func (p *ProxyServer) newReverseProxy(target *balancer.Backend) *httputil.ReverseProxy {
+    proxy := &httputil.ReverseProxy{ErrorLog: p.proxyErrorLog, Transport: p.transportFor(target)}
+
+    proxy.Rewrite = func(pr *httputil.ProxyRequest) {
+        req := pr.Out
+        rewritePath(req.URL, p.rewrites)
+        pr.SetURL(target.Address)
+        req.Host = target.Address.Host
+        p.setForwardedHeaders(pr)
+        if id := requestid.FromContext(req.Context()); id != "" {
+            req.Header.Set(p.requestIDHeader, id)
+        }
+        applyRequestHeaderRules(req, p.requestHeaders)
+        appendForwardedFor(pr)
+        applySensitiveHeaders(req, p.sensitiveHeadersPolicy(target))
+        if target.SignRequests || p.signing.AllBackends {
+            p.signRequest(req)
+        }
+    }
+    return proxy
+}
+
+// retryAfterSeconds возвращает значение Retry-After: оценку ближайшего восстановления
+// backend'ов от балансировщика или значение по умолчанию.
+func (p *ProxyServer) retryAfterSeconds() int {
+    wait := p.retryAfter
+    if estimate, ok := p.balancer.RecoveryEstimate(); ok {
+        wait = estimate
+    }
+    return max(1, int(math.Ceil(wait.Seconds())))
+}
+
+// cleanupStaleClients запускает периодическую очистку старых записей rate limiter-а.
+func (p *ProxyServer) cleanupStaleClients(cfg *config.Config) {
+    interval := cfg.RateLimit.CleanupInterval
+    if interval <= 0 {
+        interval = time.Minute
+    }
+    staleAfter := cfg.RateLimit.StaleAfter
+    if staleAfter <= 0 {
+        staleAfter = 5 * time.Minute
     }
-    ip, _, _ := net.SplitHostPort(r.RemoteAddr)
-    return strings.TrimSpace(ip)
+    p.rateLimiter.SetMaxBuckets(cfg.RateLimit.MaxBuckets)
+    go p.rateLimiter.RunCleanup(interval, staleAfter)
+}
+
+// getClientIP возвращает IP клиента, определенный clientIPMiddleware с учетом trusted_proxies.
+func getClientIP(r *http.Request) string {
+    return clientip.FromRequest(r)
 }
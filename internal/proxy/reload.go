@@ -0,0 +1,115 @@
+package proxy
+
+import (
+    "reflect"
+    "strings"
+
+    "github.com/Manzo48/loadBalancer/internal/config"
+)
+
+// Reload применяет конфигурацию, перечитанную с диска (SIGHUP): набор backend'ов, лимиты,
+// режим rate limit, denylist и maintenance меняются на лету, начатые запросы дорабатывают как есть. Остальные изменения
+// требуют перезапуска — о каждом пишется предупреждение, и оно пропускается.
+// Сравнение идет с предыдущей загруженной конфигурацией, поэтому неизменившиеся в файле
+// разделы не отменяют правки, сделанные через admin API.
+func (p *ProxyServer) Reload(next *config.Config) {
+    current := p.cfg.Load()
+    applied := *current
+
+    if !reflect.DeepEqual(next.Backends, current.Backends) {
+        if err := p.balancer.ReplaceBackends(next.Backends); err != nil {
+            p.logger.Errorf("Config reload: backend set not applied: %v", err)
+        } else {
+            applied.Backends = next.Backends
+            p.logger.Infof("Config reload: backend set updated to %d backends", len(next.Backends))
+        }
+    }
+
+    if next.RateLimit.Capacity != current.RateLimit.Capacity || next.RateLimit.RefillRate != current.RateLimit.RefillRate {
+        p.rateLimiter.SetDefaultLimit(next.RateLimit.Capacity, next.RateLimit.RefillRate)
+        applied.RateLimit.Capacity = next.RateLimit.Capacity
+        applied.RateLimit.RefillRate = next.RateLimit.RefillRate
+        p.logger.Infof("Config reload: default rate limit set to %d/%ds", next.RateLimit.Capacity, next.RateLimit.RefillRate)
+    }
+
+    if next.RateLimit.GlobalCapacity != current.RateLimit.GlobalCapacity || next.RateLimit.GlobalRefillRate != current.RateLimit.GlobalRefillRate {
+        p.rateLimiter.SetGlobalLimit(next.RateLimit.GlobalCapacity, next.RateLimit.GlobalRefillRate)
+        applied.RateLimit.GlobalCapacity = next.RateLimit.GlobalCapacity
+        applied.RateLimit.GlobalRefillRate = next.RateLimit.GlobalRefillRate
+        p.logger.Infof("Config reload: global rate limit set to %d/%ds", next.RateLimit.GlobalCapacity, next.RateLimit.GlobalRefillRate)
+    }
+
+    if next.RateLimit.Mode != current.RateLimit.Mode {
+        p.rateLimiter.SetObserveMode(next.RateLimit.Mode == "observe")
+        applied.RateLimit.Mode = next.RateLimit.Mode
+        p.logger.Infof("Config reload: rate limit mode set to %s", rateLimitMode(next.RateLimit.Mode))
+    }
+
+    if !reflect.DeepEqual(next.Denylist, current.Denylist) {
+        if err := p.setDenylist(next.Denylist); err != nil {
+            p.logger.Errorf("Config reload: denylist not applied: %v", err)
+        } else {
+            applied.Denylist = next.Denylist
+            p.logger.Infof("Config reload: denylist updated to %d entries", len(next.Denylist))
+        }
+    }
+
+    if !reflect.DeepEqual(next.Maintenance, current.Maintenance) {
+        if err := p.setMaintenance(next.Maintenance); err != nil {
+            p.logger.Errorf("Config reload: maintenance settings not applied: %v", err)
+        } else {
+            applied.Maintenance = next.Maintenance
+            p.logger.Infof("Config reload: maintenance mode %s", maintenanceState(next.Maintenance.Enabled))
+        }
+    }
+
+    // Отклоненный набор backend'ов уже залогирован выше, перезапуск ему не поможет
+    rest := *next
+    rest.Backends = applied.Backends
+    rest.Denylist = applied.Denylist
+    rest.Maintenance = applied.Maintenance
+    for _, field := range changedFields(reflect.ValueOf(applied), reflect.ValueOf(rest), "") {
+        p.logger.Warnf("Config reload: %s changed but cannot be applied without a restart, skipping", field)
+    }
+    p.cfg.Store(&applied)
+}
+
+// rateLimitMode возвращает режим rate limit для лога (пустой — enforce).
+func rateLimitMode(mode string) string {
+    if mode == "" {
+        return "enforce"
+    }
+    return mode
+}
+
+// maintenanceState возвращает состояние режима обслуживания для лога.
+func maintenanceState(enabled bool) string {
+    if enabled {
+        return "enabled"
+    }
+    return "disabled"
+}
+
+// changedFields возвращает yaml-пути различающихся полей двух конфигураций.
+// Вложенные структуры сравниваются по полям, остальные значения — целиком.
+func changedFields(old, next reflect.Value, prefix string) []string {
+    var changed []string
+    for i := 0; i < old.NumField(); i++ {
+        field := old.Type().Field(i)
+        name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+        if name == "" || name == "-" {
+            continue
+        }
+        if prefix != "" {
+            name = prefix + "." + name
+        }
+        if field.Type.Kind() == reflect.Struct {
+            changed = append(changed, changedFields(old.Field(i), next.Field(i), name)...)
+            continue
+        }
+        if !reflect.DeepEqual(old.Field(i).Interface(), next.Field(i).Interface()) {
+            changed = append(changed, name)
+        }
+    }
+    return changed
+}
@@ -0,0 +1,28 @@
+package proxy
+
+import (
+    "net/http"
+
+    "github.com/Manzo48/loadBalancer/internal/metrics"
+)
+
+// requestMetricsMiddleware считает все входящие запросы (включая отклоненные лимитерами)
+// и число обрабатываемых прямо сейчас. Число запросов в обработке ведется всегда —
+// его пишет в лог Shutdown; метрики отправляются, только если задан их получатель.
+func (p *ProxyServer) requestMetricsMiddleware(next http.Handler) http.Handler {
+    if _, nop := p.metrics.(metrics.Nop); nop {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            p.inFlightRequests.Add(1)
+            defer p.inFlightRequests.Add(-1)
+            next.ServeHTTP(w, r)
+        })
+    }
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        p.metrics.Inc("lb_http_requests_total")
+        p.metrics.Set("lb_inflight_requests", float64(p.inFlightRequests.Add(1)))
+        defer func() {
+            p.metrics.Set("lb_inflight_requests", float64(p.inFlightRequests.Add(-1)))
+        }()
+        next.ServeHTTP(w, r)
+    })
+}
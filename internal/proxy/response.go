@@ -0,0 +1,47 @@
+package proxy
+
+import (
+    "net/http"
+)
+
+// responseTracker запоминает, начат ли уже ответ клиенту (отправлены заголовки или часть тела).
+// После этого записать JSON-ошибку нельзя — остается только оборвать соединение.
+type responseTracker struct {
+    http.ResponseWriter
+    status int   // Код финального ответа (0 — заголовки еще не отправлены)
+    bytes  int64 // Сколько байт тела уже записано
+}
+
+func (t *responseTracker) WriteHeader(status int) {
+    // Информационные 1xx не мешают отправить финальный ответ позже
+    if t.status == 0 && status >= http.StatusOK {
+        t.status = status
+    }
+    t.ResponseWriter.WriteHeader(status)
+}
+
+func (t *responseTracker) Write(b []byte) (int, error) {
+    if t.status == 0 {
+        t.status = http.StatusOK
+    }
+    n, err := t.ResponseWriter.Write(b)
+    t.bytes += int64(n)
+    return n, err
+}
+
+// Flush нужен ReverseProxy для потоковых ответов (SSE и т.п.).
+func (t *responseTracker) Flush() {
+    if flusher, ok := t.ResponseWriter.(http.Flusher); ok {
+        flusher.Flush()
+    }
+}
+
+// Unwrap позволяет http.ResponseController (и ReverseProxy при upgrade) добраться до исходного writer'а.
+func (t *responseTracker) Unwrap() http.ResponseWriter {
+    return t.ResponseWriter
+}
+
+// started сообщает, ушли ли клиенту заголовки ответа.
+func (t *responseTracker) started() bool {
+    return t.status != 0
+}
@@ -0,0 +1,86 @@
+package proxy
+
+import (
+    "context"
+    "errors"
+    "net/http"
+
+    "github.com/Manzo48/loadBalancer/internal/balancer"
+    "github.com/Manzo48/loadBalancer/internal/bodybuf"
+)
+
+const defaultRetryMaxBodySize = 1 << 20
+
+var (
+    errRequestTimeout = errors.New("request timeout exceeded")
+    errPerTryTimeout  = errors.New("per-try timeout exceeded")
+)
+
+// timeoutCause возвращает errRequestTimeout или errPerTryTimeout, если контекст попытки
+// отменен таймаутом прокси, и nil — если он активен или отменен клиентом.
+func timeoutCause(ctx context.Context) error {
+    switch cause := context.Cause(ctx); cause {
+    case errRequestTimeout, errPerTryTimeout:
+        return cause
+    default:
+        return nil
+    }
+}
+
+// retryable сообщает, можно ли повторить запрос на другом backend'е: по умолчанию
+// повторяются только идемпотентные методы, POST — при retry.allow_post.
+func (p *ProxyServer) retryable(r *http.Request) bool {
+    if p.retry.MaxRetries <= 0 {
+        return false
+    }
+    switch r.Method {
+    case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+        return true
+    case http.MethodPost:
+        return p.retry.AllowPost
+    default:
+        return false
+    }
+}
+
+// bufferBody читает тело запроса в память и подменяет r.Body, чтобы каждая попытка
+// отправила его заново. false — тело больше retry.max_body_size: прочитанная часть
+// возвращается в r.Body, и запрос может быть передан потоком один раз.
+func (p *ProxyServer) bufferBody(r *http.Request) ([]byte, bool) {
+    if r.Body == nil || r.Body == http.NoBody {
+        return nil, true
+    }
+    limit := p.retry.MaxBodySize
+    if limit <= 0 {
+        limit = defaultRetryMaxBodySize
+    }
+    if r.ContentLength > limit {
+        return nil, false
+    }
+
+    body, rest, complete := bodybuf.Prefix(r.Body, limit)
+    r.Body = rest
+    if !complete {
+        return nil, false
+    }
+    return body, true
+}
+
+// reportOutcome передает результат попытки в circuit breaker и outlier detection backend'а.
+// Ошибкой считаются сбой соединения и ответ 5xx.
+func (p *ProxyServer) reportOutcome(target *balancer.Backend, ok bool) {
+    target.ObserveOutcome(ok)
+    breaker := target.Breaker()
+    if ok {
+        if breaker.Success() {
+            p.logger.Infof("Circuit breaker closed for backend %s after a successful trial request", target.Address)
+            p.metrics.Set("lb_circuit_breaker_open", 0, "backend", target.Address.String())
+        }
+        return
+    }
+    if breaker.Failure() {
+        p.logger.Warnf("Circuit breaker opened for backend %s", target.Address)
+        p.metrics.Inc("lb_circuit_breaker_opened_total", "backend", target.Address.String())
+        p.metrics.Set("lb_circuit_breaker_open", 1, "backend", target.Address.String())
+    }
+}
@@ -0,0 +1,60 @@
+package proxy
+
+import (
+    "net/url"
+    "regexp"
+    "strings"
+
+    "github.com/Manzo48/loadBalancer/internal/config"
+)
+
+// pathRewrite — скомпилированное правило из config.RewriteRule.
+type pathRewrite struct {
+    stripPrefix string         // Без завершающего /
+    pattern     *regexp.Regexp // nil для strip_prefix
+    replacement string
+}
+
+// compileRewrites компилирует правила переписывания пути; выражения уже проверены в config.Validate.
+func compileRewrites(rules []config.RewriteRule) []pathRewrite {
+    rewrites := make([]pathRewrite, 0, len(rules))
+    for _, rule := range rules {
+        rewrite := pathRewrite{stripPrefix: strings.TrimSuffix(rule.StripPrefix, "/"), replacement: rule.Replacement}
+        if rule.Regex != "" {
+            rewrite.pattern = regexp.MustCompile(rule.Regex)
+        }
+        rewrites = append(rewrites, rewrite)
+    }
+    return rewrites
+}
+
+// rewritePath применяет правила по порядку к пути исходящего запроса.
+// Вызывается в Rewrite до склейки с путем backend'а, поэтому запрос клиента не меняется,
+// а повторная попытка переписывает путь заново.
+func rewritePath(u *url.URL, rewrites []pathRewrite) {
+    for _, rewrite := range rewrites {
+        if rewrite.pattern != nil {
+            path := rewrite.pattern.ReplaceAllString(u.Path, rewrite.replacement)
+            if path != u.Path {
+                // Экранированная форма пересчитывается из нового пути
+                u.Path, u.RawPath = ensureLeadingSlash(path), ""
+            }
+            continue
+        }
+        if !matchPrefix(u.Path, rewrite.stripPrefix) {
+            continue
+        }
+        u.Path = ensureLeadingSlash(strings.TrimPrefix(u.Path, rewrite.stripPrefix))
+        if u.RawPath != "" {
+            // Сохраняем исходное экранирование остатка пути (например, %2F)
+            u.RawPath = ensureLeadingSlash(strings.TrimPrefix(u.RawPath, rewrite.stripPrefix))
+        }
+    }
+}
+
+func ensureLeadingSlash(path string) string {
+    if !strings.HasPrefix(path, "/") {
+        return "/" + path
+    }
+    return path
+}
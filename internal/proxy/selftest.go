@@ -0,0 +1,138 @@
+package proxy
+
+import (
+    "context"
+    "net/http"
+    "sync"
+    "time"
+
+    "github.com/Manzo48/loadBalancer/internal/balancer"
+)
+
+const defaultSelfTestTimeout = 5 * time.Second
+
+// selfTestResult — результат синтетического запроса к одному backend'у.
+type selfTestResult struct {
+    Backend   string  `json:"backend"`
+    OK        bool    `json:"ok"`
+    Status    int     `json:"status,omitempty"`
+    LatencyMs float64 `json:"latency_ms"`
+    Error     string  `json:"error,omitempty"`
+}
+
+// selfTestReport — ответ POST /admin/selftest.
+type selfTestReport struct {
+    OK       bool             `json:"ok"`
+    Backends []selfTestResult `json:"backends"`
+}
+
+// statusRecorder запоминает код ответа и отбрасывает тело.
+type statusRecorder struct {
+    header http.Header
+    status int
+}
+
+func (r *statusRecorder) Header() http.Header {
+    return r.header
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+    if r.status == 0 {
+        r.status = status
+    }
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+    r.WriteHeader(http.StatusOK)
+    return len(b), nil
+}
+
+// handleAdminSelfTest отправляет синтетический запрос на каждый backend тем же путем,
+// что и клиентский трафик (Rewrite, подпись, политика заголовков), и возвращает отчет.
+// Состояние health-check меняется только при selftest.update_health.
+func (p *ProxyServer) handleAdminSelfTest(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        w.Header().Set("Allow", http.MethodPost)
+        sendJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+        return
+    }
+    operator, ok := p.authorizeOperator(w, r)
+    if !ok {
+        return
+    }
+
+    timeout := p.selfTest.Timeout
+    if timeout <= 0 {
+        timeout = defaultSelfTestTimeout
+    }
+    ctx, cancel := context.WithTimeout(r.Context(), timeout)
+    defer cancel()
+
+    backends := p.balancer.Backends()
+    report := selfTestReport{OK: true, Backends: make([]selfTestResult, len(backends))}
+
+    var wg sync.WaitGroup
+    for i, backend := range backends {
+        wg.Add(1)
+        go func(i int, backend *balancer.Backend) {
+            defer wg.Done()
+            report.Backends[i] = p.selfTestBackend(ctx, backend)
+        }(i, backend)
+    }
+    wg.Wait()
+
+    for _, result := range report.Backends {
+        report.OK = report.OK && result.OK
+    }
+    p.logger.Infof("Admin %s: self-test of %d backends finished (ok: %t)", operator, len(backends), report.OK)
+    writeJSON(w, http.StatusOK, report)
+}
+
+// selfTestBackend проксирует один синтетический запрос на backend.
+func (p *ProxyServer) selfTestBackend(ctx context.Context, backend *balancer.Backend) selfTestResult {
+    method := p.selfTest.Method
+    if method == "" {
+        method = http.MethodGet
+    }
+    path := p.selfTest.Path
+    if path == "" {
+        path = "/"
+    }
+
+    result := selfTestResult{Backend: backend.Address.String()}
+    req, err := http.NewRequestWithContext(ctx, method, path, nil)
+    if err != nil {
+        result.Error = err.Error()
+        return result
+    }
+    for name, value := range p.selfTest.Headers {
+        req.Header.Set(name, value)
+    }
+
+    var proxyErr error
+    // Копия общего прокси backend'а: тот же транспорт и пул соединений, но без учета
+    // запроса в circuit breaker'е и без трансформаций ответа
+    proxy := *backend.ReverseProxy(p.newBackendProxy)
+    proxy.ModifyResponse = nil
+    proxy.ErrorHandler = func(rw http.ResponseWriter, _ *http.Request, err error) {
+        proxyErr = err
+        rw.WriteHeader(http.StatusBadGateway)
+    }
+
+    recorder := &statusRecorder{header: make(http.Header)}
+    start := time.Now()
+    proxy.ServeHTTP(recorder, req)
+    result.LatencyMs = float64(time.Since(start).Microseconds()) / 1000
+
+    if proxyErr != nil {
+        result.Error = proxyErr.Error()
+    } else {
+        result.Status = recorder.status
+        result.OK = recorder.status < http.StatusInternalServerError
+    }
+
+    if p.selfTest.UpdateHealth {
+        backend.SetAlive(result.OK)
+    }
+    return result
+}
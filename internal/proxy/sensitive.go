@@ -0,0 +1,36 @@
+package proxy
+
+import (
+    "net/http"
+
+    "github.com/Manzo48/loadBalancer/internal/balancer"
+    "github.com/Manzo48/loadBalancer/internal/config"
+)
+
+// sensitiveHeadersPolicy возвращает политику для backend'а: собственную или общую.
+func (p *ProxyServer) sensitiveHeadersPolicy(target *balancer.Backend) config.SensitiveHeadersConfig {
+    if target.SensitiveHeaders != nil {
+        return *target.SensitiveHeaders
+    }
+    return p.sensitiveHeaders
+}
+
+// applySensitiveHeaders удаляет или заменяет чувствительные заголовки исходящего запроса.
+// Вызывается в Rewrite до подписи и до любого логирования исходящего запроса.
+func applySensitiveHeaders(req *http.Request, policy config.SensitiveHeadersConfig) {
+    headers := policy.Headers
+    if len(headers) == 0 {
+        headers = []string{"Authorization"}
+    }
+
+    switch policy.Mode {
+    case "strip":
+        for _, header := range headers {
+            req.Header.Del(header)
+        }
+    case "replace":
+        for _, header := range headers {
+            req.Header.Set(header, policy.Value)
+        }
+    }
+}
@@ -0,0 +1,101 @@
+package proxy
+
+import (
+    "crypto/tls"
+    "fmt"
+    "os"
+    "sync"
+    "time"
+
+    "github.com/Manzo48/loadBalancer/internal/config"
+    "go.uber.org/zap"
+)
+
+// tlsVersions сопоставляет значения tls.min_version константам crypto/tls.
+var tlsVersions = map[string]uint16{
+    "1.0": tls.VersionTLS10,
+    "1.1": tls.VersionTLS11,
+    "1.2": tls.VersionTLS12,
+    "1.3": tls.VersionTLS13,
+}
+
+// certReloader отдает сертификат прокси для каждого TLS-рукопожатия и перечитывает
+// его с диска, когда меняется файл сертификата или ключа, — без перезапуска процесса.
+type certReloader struct {
+    certFile string
+    keyFile  string
+    logger   *zap.SugaredLogger
+
+    mu      sync.Mutex
+    cert    *tls.Certificate
+    modTime time.Time // Время изменения файлов, из которых загружен cert
+}
+
+// newServerTLSConfig загружает сертификат и собирает tls.Config для listener'а прокси.
+func newServerTLSConfig(cfg config.ServerTLSConfig, logger *zap.SugaredLogger) (*tls.Config, error) {
+    reloader := &certReloader{certFile: cfg.CertFile, keyFile: cfg.KeyFile, logger: logger}
+    if _, err := reloader.load(); err != nil {
+        return nil, err
+    }
+
+    minVersion := uint16(tls.VersionTLS12)
+    if cfg.MinVersion != "" {
+        minVersion = tlsVersions[cfg.MinVersion]
+    }
+    return &tls.Config{
+        GetCertificate: reloader.GetCertificate,
+        MinVersion:     minVersion,
+    }, nil
+}
+
+// GetCertificate возвращает актуальный сертификат. Если новый файл не читается
+// (например, записан наполовину), продолжает работать предыдущий сертификат.
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+    cert, err := c.load()
+    if err != nil {
+        c.logger.Errorf("Failed to reload TLS certificate, serving the previous one: %v", err)
+        c.mu.Lock()
+        defer c.mu.Unlock()
+        return c.cert, nil
+    }
+    return cert, nil
+}
+
+// load перечитывает пару сертификат/ключ, если файлы изменились с прошлой загрузки.
+func (c *certReloader) load() (*tls.Certificate, error) {
+    modTime, err := latestModTime(c.certFile, c.keyFile)
+    if err != nil {
+        return nil, err
+    }
+
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if c.cert != nil && modTime.Equal(c.modTime) {
+        return c.cert, nil
+    }
+
+    cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+    if err != nil {
+        return nil, fmt.Errorf("load TLS certificate: %w", err)
+    }
+    if c.cert != nil {
+        c.logger.Infof("TLS certificate reloaded from %s", c.certFile)
+    }
+    c.cert, c.modTime = &cert, modTime
+    return c.cert, nil
+}
+
+// latestModTime возвращает самое позднее время изменения файлов.
+func latestModTime(paths ...string) (time.Time, error) {
+    var latest time.Time
+    for _, path := range paths {
+        info, err := os.Stat(path)
+        if err != nil {
+            return time.Time{}, fmt.Errorf("stat %s: %w", path, err)
+        }
+        if info.ModTime().After(latest) {
+            latest = info.ModTime()
+        }
+    }
+    return latest, nil
+}
@@ -0,0 +1,35 @@
+package proxy
+
+import (
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "net/http"
+    "strconv"
+    "time"
+)
+
+// signRequest добавляет заголовок подписи к исходящему запросу:
+//
+//    X-LB-Signature: t=<unix-время>,n=<nonce>,s=<hex(HMAC-SHA256(secret, METHOD\nPATH\nt\nn))>
+//
+// PATH — путь запроса в том виде, в каком его получает backend (без query string).
+// Заголовок с тем же именем от клиента перезаписывается.
+func (p *ProxyServer) signRequest(req *http.Request) {
+    nonce := make([]byte, 16)
+    if _, err := rand.Read(nonce); err != nil {
+        p.logger.Errorf("Failed to generate signature nonce: %v", err)
+        return
+    }
+
+    timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+    nonceHex := hex.EncodeToString(nonce)
+
+    mac := hmac.New(sha256.New, []byte(p.signing.Secret))
+    fmt.Fprintf(mac, "%s\n%s\n%s\n%s", req.Method, req.URL.EscapedPath(), timestamp, nonceHex)
+    signature := hex.EncodeToString(mac.Sum(nil))
+
+    req.Header.Set(p.signing.Header, fmt.Sprintf("t=%s,n=%s,s=%s", timestamp, nonceHex, signature))
+}
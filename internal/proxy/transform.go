@@ -0,0 +1,130 @@
+package proxy
+
+import (
+    "bytes"
+    "compress/gzip"
+    "fmt"
+    "io"
+    "net/http"
+    "strconv"
+    "strings"
+)
+
+const defaultTransformMaxBodyBytes = 10 * 1024 * 1024
+
+// ResponseTransform изменяет тело ответа backend'а перед отправкой клиенту.
+// Получает уже разжатое тело; возвращает новое тело.
+type ResponseTransform func(resp *http.Response, body []byte) ([]byte, error)
+
+// AddResponseTransform регистрирует трансформацию ответов. Вызывается до Start.
+func (p *ProxyServer) AddResponseTransform(transform ResponseTransform) {
+    p.transforms = append(p.transforms, transform)
+}
+
+// applyTransforms разжимает ответ (если нужно и разрешено), применяет трансформации
+// и кодирует тело обратно согласно Accept-Encoding клиента.
+// Ответы с неподдерживаемым кодированием или слишком большие пропускаются без изменений.
+func (p *ProxyServer) applyTransforms(resp *http.Response) error {
+    // Тело ответа 101 — само соединение после смены протокола, читать его нельзя
+    if len(p.transforms) == 0 || resp.StatusCode == http.StatusSwitchingProtocols {
+        return nil
+    }
+
+    encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+    switch {
+    case encoding == "" || encoding == "identity":
+    case encoding == "gzip" && p.transformCfg.DecompressGzip:
+    default:
+        p.logger.Debugf("Skipping response transform for Content-Encoding %q", encoding)
+        return nil
+    }
+
+    maxBody := p.transformCfg.MaxBodyBytes
+    if maxBody <= 0 {
+        maxBody = defaultTransformMaxBodyBytes
+    }
+    if resp.ContentLength > maxBody {
+        return nil
+    }
+
+    raw, err := io.ReadAll(io.LimitReader(resp.Body, maxBody+1))
+    if err != nil {
+        return fmt.Errorf("read upstream body: %w", err)
+    }
+    if int64(len(raw)) > maxBody {
+        // Слишком большое тело: отдаем как есть, склеив прочитанное с остатком
+        resp.Body = struct {
+            io.Reader
+            io.Closer
+        }{io.MultiReader(bytes.NewReader(raw), resp.Body), resp.Body}
+        return nil
+    }
+    resp.Body.Close()
+
+    body := raw
+    if encoding == "gzip" {
+        if body, err = gunzip(raw); err != nil {
+            return fmt.Errorf("decompress upstream body: %w", err)
+        }
+    }
+
+    for _, transform := range p.transforms {
+        if body, err = transform(resp, body); err != nil {
+            return fmt.Errorf("response transform: %w", err)
+        }
+    }
+
+    // Кодируем обратно только если клиент принимает gzip; иначе отдаем открытый текст
+    resp.Header.Del("Content-Encoding")
+    if encoding == "gzip" && acceptsEncoding(resp.Request.Header.Get("Accept-Encoding"), "gzip") {
+        if body, err = gzipBytes(body); err != nil {
+            return fmt.Errorf("compress response body: %w", err)
+        }
+        resp.Header.Set("Content-Encoding", "gzip")
+    }
+
+    resp.Body = io.NopCloser(bytes.NewReader(body))
+    resp.ContentLength = int64(len(body))
+    resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+    return nil
+}
+
+// acceptsEncoding проверяет, принимает ли клиент кодирование encoding (с учетом q=0).
+func acceptsEncoding(acceptEncoding, encoding string) bool {
+    for _, part := range strings.Split(acceptEncoding, ",") {
+        name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+        name = strings.ToLower(strings.TrimSpace(name))
+        if name != encoding && name != "*" {
+            continue
+        }
+        params = strings.ReplaceAll(params, " ", "")
+        if q, ok := strings.CutPrefix(params, "q="); ok {
+            if value, err := strconv.ParseFloat(q, 64); err == nil && value == 0 {
+                return false
+            }
+        }
+        return true
+    }
+    return false
+}
+
+func gunzip(data []byte) ([]byte, error) {
+    reader, err := gzip.NewReader(bytes.NewReader(data))
+    if err != nil {
+        return nil, err
+    }
+    defer reader.Close()
+    return io.ReadAll(reader)
+}
+
+func gzipBytes(data []byte) ([]byte, error) {
+    var buf bytes.Buffer
+    writer := gzip.NewWriter(&buf)
+    if _, err := writer.Write(data); err != nil {
+        return nil, err
+    }
+    if err := writer.Close(); err != nil {
+        return nil, err
+    }
+    return buf.Bytes(), nil
+}
@@ -0,0 +1,62 @@
+package proxy
+
+import (
+    "net/http"
+    "time"
+
+    "github.com/Manzo48/loadBalancer/internal/balancer"
+    "github.com/Manzo48/loadBalancer/internal/config"
+)
+
+// Пул соединений с backend'ами по умолчанию (connection_pool.*). Стандартный транспорт
+// держит всего 2 простаивающих соединения на хост: под нагрузкой остальные закрываются
+// после ответа, и каждый следующий запрос платит за новое TCP- и TLS-соединение.
+const (
+    defaultMaxIdleConns        = 512
+    defaultMaxIdleConnsPerHost = 64
+    defaultIdleConnTimeout     = 90 * time.Second
+)
+
+// newUpstreamTransport создает общий транспорт к backend'ам с настройками connection_pool.
+func newUpstreamTransport(cfg config.ConnectionPoolConfig) *http.Transport {
+    transport := http.DefaultTransport.(*http.Transport).Clone()
+    applyConnectionPool(transport, cfg)
+    return transport
+}
+
+// applyConnectionPool переносит настройки connection_pool на транспорт, подставляя
+// значения по умолчанию.
+func applyConnectionPool(transport *http.Transport, cfg config.ConnectionPoolConfig) {
+    transport.MaxIdleConns = cfg.MaxIdleConns
+    if transport.MaxIdleConns <= 0 {
+        transport.MaxIdleConns = defaultMaxIdleConns
+    }
+    transport.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+    if transport.MaxIdleConnsPerHost <= 0 {
+        transport.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+    }
+    if cfg.MaxConnsPerHost > 0 && transport.MaxIdleConnsPerHost > cfg.MaxConnsPerHost {
+        transport.MaxIdleConnsPerHost = cfg.MaxConnsPerHost
+    }
+    transport.MaxConnsPerHost = cfg.MaxConnsPerHost
+    transport.IdleConnTimeout = cfg.IdleConnTimeout
+    if transport.IdleConnTimeout <= 0 {
+        transport.IdleConnTimeout = defaultIdleConnTimeout
+    }
+}
+
+// transportFor возвращает транспорт для обратного прокси к backend'у: общий или, если
+// у backend'а собственные настройки TLS, копию его транспорта с настройками connection_pool.
+// Вызывается один раз на backend (см. Backend.ReverseProxy), поэтому копии не накапливаются.
+func (p *ProxyServer) transportFor(target *balancer.Backend) http.RoundTripper {
+    if target.Transport == nil {
+        return p.transport
+    }
+    custom, ok := target.Transport.(*http.Transport)
+    if !ok {
+        return target.Transport
+    }
+    transport := custom.Clone()
+    applyConnectionPool(transport, p.connectionPool)
+    return transport
+}
@@ -0,0 +1,22 @@
+package proxy
+
+import (
+    "net/http"
+    "strings"
+)
+
+// isUpgradeRequest сообщает, запрашивает ли клиент смену протокола (WebSocket и т.п.):
+// Connection содержит токен upgrade и задан заголовок Upgrade.
+func isUpgradeRequest(r *http.Request) bool {
+    if r.Header.Get("Upgrade") == "" {
+        return false
+    }
+    for _, value := range r.Header.Values("Connection") {
+        for _, token := range strings.Split(value, ",") {
+            if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+                return true
+            }
+        }
+    }
+    return false
+}
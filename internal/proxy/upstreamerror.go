@@ -0,0 +1,54 @@
+package proxy
+
+import (
+    "context"
+    "crypto/tls"
+    "crypto/x509"
+    "errors"
+    "net"
+    "net/http"
+)
+
+// upstreamErrorStatus выбирает код ответа клиенту по ошибке проксирования, полученной
+// до начала ответа: 504 — backend не ответил вовремя, 502 — не удалось соединиться,
+// не прошло TLS-рукопожатие или ответ backend'а не разобран. 503 отдается, только
+// когда отправить запрос некуда (см. handleProxy).
+func upstreamErrorStatus(err error) (int, string) {
+    var netErr net.Error
+    switch {
+    case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+        return http.StatusGatewayTimeout, "Backend timeout"
+    case isTLSError(err):
+        return http.StatusBadGateway, "Backend TLS handshake failed"
+    case isDialError(err):
+        return http.StatusBadGateway, "Backend connection failed"
+    default:
+        return http.StatusBadGateway, "Invalid response from backend"
+    }
+}
+
+// isTLSError сообщает, что соединение с backend'ом не прошло TLS-рукопожатие
+// или проверку сертификата.
+func isTLSError(err error) bool {
+    var (
+        recordErr    tls.RecordHeaderError
+        alertErr     tls.AlertError
+        verifyErr    *tls.CertificateVerificationError
+        authorityErr x509.UnknownAuthorityError
+        hostnameErr  x509.HostnameError
+        invalidErr   x509.CertificateInvalidError
+    )
+    return errors.As(err, &recordErr) || errors.As(err, &alertErr) || errors.As(err, &verifyErr) ||
+        errors.As(err, &authorityErr) || errors.As(err, &hostnameErr) || errors.As(err, &invalidErr)
+}
+
+// isDialError сообщает, что соединение с backend'ом не установлено: DNS, отказ в соединении,
+// недоступная сеть.
+func isDialError(err error) bool {
+    var dnsErr *net.DNSError
+    if errors.As(err, &dnsErr) {
+        return true
+    }
+    var opErr *net.OpError
+    return errors.As(err, &opErr) && opErr.Op == "dial"
+}
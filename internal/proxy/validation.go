@@ -0,0 +1,48 @@
+package proxy
+
+import (
+    "fmt"
+    "net/http"
+    "regexp"
+
+    "github.com/Manzo48/loadBalancer/internal/config"
+)
+
+// requiredHeader — скомпилированное правило из config.RequiredHeader.
+type requiredHeader struct {
+    name    string
+    pattern *regexp.Regexp // nil, если проверяется только наличие
+}
+
+// compileRequiredHeaders компилирует правила; шаблоны уже проверены в config.Load.
+func compileRequiredHeaders(headers []config.RequiredHeader) []requiredHeader {
+    rules := make([]requiredHeader, 0, len(headers))
+    for _, header := range headers {
+        rule := requiredHeader{name: http.CanonicalHeaderKey(header.Name)}
+        if header.Pattern != "" {
+            rule.pattern = regexp.MustCompile(header.Pattern)
+        }
+        rules = append(rules, rule)
+    }
+    return rules
+}
+
+// requireHeadersMiddleware отклоняет запросы без обязательных заголовков (или с неверным значением) до проксирования.
+func (p *ProxyServer) requireHeadersMiddleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        for _, rule := range p.requiredHeaders {
+            value := r.Header.Get(rule.name)
+            if value == "" {
+                p.logger.Debugf("Rejecting request to %s: missing header %s", r.URL.Path, rule.name)
+                sendJSONError(w, http.StatusBadRequest, fmt.Sprintf("Missing required header %s", rule.name))
+                return
+            }
+            if rule.pattern != nil && !rule.pattern.MatchString(value) {
+                p.logger.Debugf("Rejecting request to %s: invalid header %s", r.URL.Path, rule.name)
+                sendJSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid value for header %s", rule.name))
+                return
+            }
+        }
+        next.ServeHTTP(w, r)
+    })
+}
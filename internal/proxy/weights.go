@@ -0,0 +1,53 @@
+package proxy
+
+import (
+    "net/http"
+    "strconv"
+
+    "github.com/Manzo48/loadBalancer/internal/balancer"
+)
+
+// backendWeight — вес backend'а в ответе GET /admin/weights.
+type backendWeight struct {
+    Backend         string                   `json:"backend"`
+    Weight          int64                    `json:"weight"`           // Заданный вес
+    EffectiveWeight int64                    `json:"effective_weight"` // Вес, используемый при выборе
+    Signals         *balancer.BackendSignals `json:"signals,omitempty"`
+}
+
+// handleAdminWeights возвращает заданные и эффективные веса backend'ов вместе с сигналами,
+// по которым адаптивный контроллер их вычислил: GET /admin/weights.
+func (p *ProxyServer) handleAdminWeights(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        w.Header().Set("Allow", http.MethodGet)
+        sendJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+        return
+    }
+
+    weights := make([]backendWeight, 0)
+    for _, backend := range p.balancer.Backends() {
+        weight := backendWeight{
+            Backend:         backend.Address.String(),
+            Weight:          backend.Weight.Load(),
+            EffectiveWeight: backend.EffectiveWeight(),
+        }
+        if signals, ok := backend.Signals(); ok {
+            weight.Signals = &signals
+        }
+        weights = append(weights, weight)
+    }
+    writeJSON(w, http.StatusOK, weights)
+}
+
+// reportedLoad читает нагрузку, сообщенную backend'ом в заголовке load_header.
+// -1 — заголовок не настроен, отсутствует или некорректен.
+func (p *ProxyServer) reportedLoad(resp *http.Response) float64 {
+    if p.loadHeader == "" {
+        return -1
+    }
+    load, err := strconv.ParseFloat(resp.Header.Get(p.loadHeader), 64)
+    if err != nil || load < 0 {
+        return -1
+    }
+    return load
+}
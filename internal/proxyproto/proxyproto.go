@@ -0,0 +1,179 @@
+// Package proxyproto разбирает заголовок PROXY protocol (v1 и v2), которым L4-балансировщик
+// передает исходный адрес клиента перед данными соединения.
+package proxyproto
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+const (
+    // DefaultHeaderTimeout — сколько ждать заголовок от доверенного upstream'а.
+    DefaultHeaderTimeout = 5 * time.Second
+
+    maxV1HeaderLength = 107 // Максимальная длина строки v1 вместе с CRLF
+)
+
+// signatureV2 начинает каждый заголовок версии 2.
+var signatureV2 = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ErrInvalidHeader возвращается чтением из соединения доверенного upstream'а,
+// которое не начинается с корректного заголовка.
+var ErrInvalidHeader = errors.New("invalid PROXY protocol header")
+
+// Listener принимает соединения и, если они пришли от доверенного upstream'а, читает
+// заголовок PROXY protocol: RemoteAddr соединения становится адресом клиента из заголовка.
+// Соединения от остальных адресов передаются как есть — подделать адрес они не могут.
+// Соединение от доверенного upstream'а без корректного заголовка закрывается при первом чтении.
+type Listener struct {
+    net.Listener
+    Trusted       func(address string) bool // Доверенные upstream'ы (nil — никто)
+    HeaderTimeout time.Duration             // Ожидание заголовка (0 — DefaultHeaderTimeout)
+}
+
+// Accept возвращает следующее соединение. Заголовок читается не здесь, а при первом
+// обращении к соединению, чтобы медленный upstream не задерживал прием остальных.
+func (l *Listener) Accept() (net.Conn, error) {
+    conn, err := l.Listener.Accept()
+    if err != nil {
+        return nil, err
+    }
+    host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+    if l.Trusted == nil || !l.Trusted(host) {
+        return conn, nil
+    }
+    timeout := l.HeaderTimeout
+    if timeout <= 0 {
+        timeout = DefaultHeaderTimeout
+    }
+    return &Conn{Conn: conn, reader: bufio.NewReader(conn), timeout: timeout}, nil
+}
+
+// Conn — соединение от доверенного upstream'а, начинающееся с заголовка PROXY protocol.
+type Conn struct {
+    net.Conn
+    reader  *bufio.Reader
+    timeout time.Duration
+
+    once   sync.Once
+    remote net.Addr // Адрес клиента из заголовка (nil — заголовок без адреса, например LOCAL)
+    err    error
+}
+
+// Read возвращает данные соединения после заголовка.
+func (c *Conn) Read(b []byte) (int, error) {
+    c.once.Do(c.readHeader)
+    if c.err != nil {
+        return 0, c.err
+    }
+    return c.reader.Read(b)
+}
+
+// RemoteAddr возвращает адрес клиента из заголовка, а если его нет — адрес upstream'а.
+func (c *Conn) RemoteAddr() net.Addr {
+    c.once.Do(c.readHeader)
+    if c.remote != nil {
+        return c.remote
+    }
+    return c.Conn.RemoteAddr()
+}
+
+func (c *Conn) readHeader() {
+    c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+    defer c.Conn.SetReadDeadline(time.Time{})
+
+    first, err := c.reader.Peek(1)
+    if err != nil {
+        c.err = fmt.Errorf("%w: %v", ErrInvalidHeader, err)
+        return
+    }
+    if first[0] == 'P' {
+        c.remote, c.err = readV1(c.reader)
+    } else {
+        c.remote, c.err = readV2(c.reader)
+    }
+}
+
+// readV1 разбирает текстовый заголовок: "PROXY TCP4 <src> <dst> <sport> <dport>\r\n"
+// или "PROXY UNKNOWN ...\r\n".
+func readV1(reader *bufio.Reader) (net.Addr, error) {
+    var line []byte
+    for len(line) < maxV1HeaderLength {
+        b, err := reader.ReadByte()
+        if err != nil {
+            return nil, fmt.Errorf("%w: %v", ErrInvalidHeader, err)
+        }
+        line = append(line, b)
+        if b == '\n' {
+            break
+        }
+    }
+    text, ok := strings.CutSuffix(string(line), "\r\n")
+    if !ok {
+        return nil, fmt.Errorf("%w: v1 header is not terminated by CRLF within %d bytes", ErrInvalidHeader, maxV1HeaderLength)
+    }
+
+    fields := strings.Split(text, " ")
+    if fields[0] != "PROXY" || len(fields) < 2 {
+        return nil, fmt.Errorf("%w: %q", ErrInvalidHeader, text)
+    }
+    if fields[1] == "UNKNOWN" {
+        return nil, nil
+    }
+    if (fields[1] != "TCP4" && fields[1] != "TCP6") || len(fields) != 6 {
+        return nil, fmt.Errorf("%w: %q", ErrInvalidHeader, text)
+    }
+    ip := net.ParseIP(fields[2])
+    port, err := strconv.ParseUint(fields[4], 10, 16)
+    if ip == nil || err != nil || (fields[1] == "TCP4") != (ip.To4() != nil) {
+        return nil, fmt.Errorf("%w: %q", ErrInvalidHeader, text)
+    }
+    return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readV2 разбирает бинарный заголовок: сигнатура, версия и команда, семейство адресов,
+// длина и адреса; TLV-расширения пропускаются.
+func readV2(reader *bufio.Reader) (net.Addr, error) {
+    header := make([]byte, 16)
+    if _, err := io.ReadFull(reader, header); err != nil {
+        return nil, fmt.Errorf("%w: %v", ErrInvalidHeader, err)
+    }
+    if !bytes.Equal(header[:12], signatureV2) {
+        return nil, fmt.Errorf("%w: missing PROXY protocol signature", ErrInvalidHeader)
+    }
+    if header[12]>>4 != 2 {
+        return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidHeader, header[12]>>4)
+    }
+    payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+    if _, err := io.ReadFull(reader, payload); err != nil {
+        return nil, fmt.Errorf("%w: %v", ErrInvalidHeader, err)
+    }
+
+    switch command := header[12] & 0x0f; command {
+    case 0x0: // LOCAL: соединение самого upstream'а (например, его health-check)
+        return nil, nil
+    case 0x1: // PROXY
+    default:
+        return nil, fmt.Errorf("%w: unsupported command %d", ErrInvalidHeader, command)
+    }
+
+    switch family := header[13] >> 4; {
+    case family == 0x1 && len(payload) >= 12: // AF_INET
+        return &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}, nil
+    case family == 0x2 && len(payload) >= 36: // AF_INET6
+        return &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}, nil
+    case family == 0x0 || family == 0x3: // AF_UNSPEC, AF_UNIX: IP-адреса клиента нет
+        return nil, nil
+    default:
+        return nil, fmt.Errorf("%w: address block too short for family %d", ErrInvalidHeader, family)
+    }
+}
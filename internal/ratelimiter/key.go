@@ -0,0 +1,76 @@
+package ratelimiter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Manzo48/loadBalancer/internal/config"
+)
+
+// KeyFunc вычисляет ключ токен-бакета для запроса.
+type KeyFunc func(r *http.Request) string
+
+// NewKeyFunc собирает функцию ключа из упорядоченного списка источников (см. config.RateLimitKey*).
+// Один источник ip дает сам IP (совместимо с индивидуальными лимитами по IP);
+// иначе значения склеиваются и хешируются, чтобы ключ был фиксированной длины.
+// canonicalURL приводит URL к каноническому виду для источника url (nil — URL как есть).
+func NewKeyFunc(sources []string, canonicalURL func(u *url.URL) string) (KeyFunc, error) {
+	if len(sources) == 0 || (len(sources) == 1 && sources[0] == config.RateLimitKeyIP) {
+		return extractClientIP, nil
+	}
+
+	extractors := make([]func(r *http.Request) string, 0, len(sources))
+	for _, source := range sources {
+		extractor, err := keyExtractor(source)
+		if err != nil {
+			return nil, err
+		}
+		if source == config.RateLimitKeyURL && canonicalURL != nil {
+			extractor = func(r *http.Request) string { return canonicalURL(r.URL) }
+		}
+		extractors = append(extractors, extractor)
+	}
+
+	return func(r *http.Request) string {
+		hash := sha256.New()
+		for _, extract := range extractors {
+			hash.Write([]byte(extract(r)))
+			hash.Write([]byte{0}) // Разделитель, чтобы "ab"+"c" не совпадало с "a"+"bc"
+		}
+		return hex.EncodeToString(hash.Sum(nil)[:16])
+	}, nil
+}
+
+// WithKeyHeader возвращает функцию ключа, которая берет ключ из заголовка запроса
+// (например, X-API-Key) как есть, чтобы на него можно было задать индивидуальный лимит,
+// а при отсутствии заголовка вычисляет ключ через fallback.
+func WithKeyHeader(header string, fallback KeyFunc) KeyFunc {
+	return func(r *http.Request) string {
+		if key := strings.TrimSpace(r.Header.Get(header)); key != "" {
+			return key
+		}
+		return fallback(r)
+	}
+}
+
+func keyExtractor(source string) (func(r *http.Request) string, error) {
+	kind, header, err := config.ParseRateLimitKeySource(source)
+	if err != nil {
+		return nil, err
+	}
+	switch kind {
+	case config.RateLimitKeyUserAgent:
+		return func(r *http.Request) string { return r.UserAgent() }, nil
+	case config.RateLimitKeyPath:
+		return func(r *http.Request) string { return r.URL.Path }, nil
+	case config.RateLimitKeyURL:
+		return func(r *http.Request) string { return r.URL.RequestURI() }, nil
+	case config.RateLimitKeyHeader:
+		return func(r *http.Request) string { return r.Header.Get(header) }, nil
+	default: // config.RateLimitKeyIP
+		return extractClientIP, nil
+	}
+}
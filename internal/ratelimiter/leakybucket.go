@@ -0,0 +1,57 @@
+package ratelimiter
+
+import (
+	"time"
+)
+
+// NewLeakyBucket создает бакет-очередь: запросы покидают его строго с интервалом
+// 1/refillRate секунды, а capacity — глубина очереди (вместе с запросом, уходящим сейчас).
+// Запрос, заставший очередь полной, отклоняется; остальные ждут своей очереди в Allow.
+// При refillRate 0 бакет не опустошается и работает как токен-бакет без пополнения.
+func NewLeakyBucket(capacity, refillRate int) *TokenBucket {
+	bucket := NewTokenBucket(capacity, refillRate)
+	bucket.leaky = true
+	return bucket
+}
+
+// leakInterval возвращает интервал между запросами, покидающими очередь. Вызывается под tb.mu.
+func (tb *TokenBucket) leakInterval() time.Duration {
+	return time.Second / time.Duration(tb.RefillRate)
+}
+
+// leak пересчитывает свободные места в очереди на момент now. Вызывается под tb.mu.
+func (tb *TokenBucket) leak(now time.Time) {
+	if tb.RefillRate <= 0 {
+		return
+	}
+	interval := tb.leakInterval()
+	pending := 0
+	if wait := tb.nextLeak.Sub(now); wait > 0 {
+		pending = int((wait + interval - 1) / interval)
+	}
+	tb.Tokens = max(tb.Capacity-pending, 0)
+}
+
+// allowLeaky — AllowWithInfo для режима leaky_bucket. delay — сколько разрешенный
+// запрос должен ждать своей очереди. Вызывается под tb.mu.
+func (tb *TokenBucket) allowLeaky(now time.Time) (allowed bool, remaining int, retryAfter, delay time.Duration) {
+	interval := tb.leakInterval()
+	start := tb.nextLeak
+	if start.Before(now) {
+		start = now
+	}
+	// Впереди ceil(delay / interval) запросов; вместе с этим их должно быть не больше capacity
+	limit := time.Duration(tb.Capacity-1) * interval
+	if delay = start.Sub(now); delay <= limit {
+		tb.nextLeak = start.Add(interval)
+		allowed = true
+	} else {
+		delay = 0
+	}
+	tb.leak(now)
+	if tb.Tokens == 0 {
+		// Место освободится, когда очередь продвинется на один запрос
+		retryAfter = max(tb.nextLeak.Sub(now)-limit, 0)
+	}
+	return allowed, tb.Tokens, retryAfter, delay
+}
@@ -1,22 +1,49 @@
 package ratelimiter
 
 import (
-	"net"
+	"math"
 	"net/http"
-	"strings"
-
+	"strconv"
 
+	"github.com/Manzo48/loadBalancer/internal/clientip"
+	"github.com/Manzo48/loadBalancer/internal/requestid"
 	"go.uber.org/zap"
 )
 
 func RateLimitMiddleware(rl *RateLimiter, logger *zap.SugaredLogger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			clientID := extractClientIP(r)
+			logger := requestid.Logger(r.Context(), logger)
+			if ip := extractClientIP(r); rl.Allowlisted(ip) {
+				logger.Debugw("Rate limit bypassed (allowlist)", "client_ip", ip, "path", r.URL.Path)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			clientID := rl.Key(r)
+
+			allowed, info := rl.AllowWithInfo(r.Context(), clientID)
+			if !allowed && r.Context().Err() != nil {
+				// Клиент отключился, не дождавшись очереди leaky_bucket: отвечать некому
+				logger.Debugw("Client left while queued by rate limiter", "client_key", clientID)
+				return
+			}
+			// В режиме observe лимиты клиенту не показываются: они не применяются
+			if !rl.ObserveMode() {
+				setLimitHeaders(w, info)
+			}
+			if !allowed {
+				if rl.ObserveMode() {
+					// Режим observe: учитываем превышение, но пропускаем запрос
+					rl.metrics.Inc("lb_ratelimit_would_reject_total")
+					logger.Infow("Rate limit would be exceeded (observe mode)", "client_ip", extractClientIP(r), "client_key", clientID)
+					next.ServeHTTP(w, r)
+					return
+				}
 
-			if !rl.Allow(clientID) {
 				// Логируем превышение лимита
-				logger.Warnw("Rate limit exceeded", "client_ip", clientID)
+				rl.metrics.Inc("lb_ratelimit_rejected_total")
+				logger.Warnw("Rate limit exceeded", "client_ip", extractClientIP(r), "client_key", clientID)
 
 				// Отправляем ошибку с кодом 429
 				http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
@@ -28,13 +55,18 @@ func RateLimitMiddleware(rl *RateLimiter, logger *zap.SugaredLogger) func(http.H
 	}
 }
 
-func extractClientIP(r *http.Request) string {
-	ip := r.Header.Get("X-Real-IP")
-	if ip == "" {
-		ip = r.Header.Get("X-Forwarded-For")
-	}
-	if ip == "" {
-		ip, _, _ = net.SplitHostPort(r.RemoteAddr)
+// setLimitHeaders сообщает клиенту состояние его бакета. Retry-After выставляется,
+// когда токенов не осталось: следующий запрос раньше этого срока будет отклонен.
+func setLimitHeaders(w http.ResponseWriter, info LimitInfo) {
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(info.Limit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(info.Remaining))
+	if info.Remaining == 0 && info.RetryAfter > 0 {
+		seconds := int(math.Ceil(info.RetryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(max(seconds, 1)))
 	}
-	return strings.TrimSpace(ip)
+}
+
+// extractClientIP возвращает IP клиента, определенный прокси с учетом доверенных прокси.
+func extractClientIP(r *http.Request) string {
+	return clientip.FromRequest(r)
 }
@@ -0,0 +1,198 @@
+package ratelimiter
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// BucketState — сохраняемое состояние токен-бакета одного клиента.
+type BucketState struct {
+	ClientID   string    `json:"client_id"`
+	Tokens     int       `json:"tokens"`
+	Capacity   int       `json:"capacity"`
+	RefillRate int       `json:"refill_rate"`
+	LastRefill time.Time `json:"last_refill"`
+	LastSeen   time.Time `json:"last_seen"`
+}
+
+// StateStore — хранилище снимков состояния лимитера. Реализация может писать на диск
+// (FileStore) или во внешнее хранилище (например, Redis) — лимитеру важен только интерфейс.
+type StateStore interface {
+	Save(states []BucketState) error
+	Load() ([]BucketState, error)
+}
+
+// OverrideStore — хранилище индивидуальных лимитов, заданных на лету (admin API).
+// Необязательное расширение StateStore: если хранилище его не реализует, лимиты живут только в памяти.
+type OverrideStore interface {
+	SaveOverrides(limits map[string]ClientLimit) error
+	LoadOverrides() (map[string]ClientLimit, error)
+}
+
+// FileStore хранит снимок в JSON-файле, а индивидуальные лимиты — в соседнем файле <path>.clients.
+type FileStore struct {
+	path string
+}
+
+// NewFileStore создает файловое хранилище снимков.
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+// Save атомарно записывает снимок (через временный файл и rename).
+func (fs *FileStore) Save(states []BucketState) error {
+	return writeJSONFile(fs.path, states)
+}
+
+// Load читает снимок; отсутствие файла не считается ошибкой.
+func (fs *FileStore) Load() ([]BucketState, error) {
+	var states []BucketState
+	if err := readJSONFile(fs.path, &states); err != nil {
+		return nil, err
+	}
+	return states, nil
+}
+
+// SaveOverrides атомарно записывает индивидуальные лимиты.
+func (fs *FileStore) SaveOverrides(limits map[string]ClientLimit) error {
+	return writeJSONFile(fs.path+".clients", limits)
+}
+
+// LoadOverrides читает индивидуальные лимиты; отсутствие файла не считается ошибкой.
+func (fs *FileStore) LoadOverrides() (map[string]ClientLimit, error) {
+	var limits map[string]ClientLimit
+	if err := readJSONFile(fs.path+".clients", &limits); err != nil {
+		return nil, err
+	}
+	return limits, nil
+}
+
+// writeJSONFile записывает значение через временный файл и rename.
+func writeJSONFile(path string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o640); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// readJSONFile читает JSON-файл в value; отсутствующий файл оставляет value без изменений.
+func readJSONFile(path string, value interface{}) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
+// bucketStates копирует состояние всех бакетов. Блокировки бакетов берутся по одной,
+// чтобы не держать общий мьютекс лимитера на время сериализации.
+func (rl *RateLimiter) bucketStates() []BucketState {
+	rl.mu.RLock()
+	buckets := make(map[string]*TokenBucket, len(rl.buckets))
+	for clientID, bucket := range rl.buckets {
+		buckets[clientID] = bucket
+	}
+	rl.mu.RUnlock()
+
+	states := make([]BucketState, 0, len(buckets))
+	for clientID, bucket := range buckets {
+		bucket.mu.Lock()
+		states = append(states, BucketState{
+			ClientID:   clientID,
+			Tokens:     bucket.Tokens,
+			Capacity:   bucket.Capacity,
+			RefillRate: bucket.RefillRate,
+			LastRefill: bucket.lastRefill,
+			LastSeen:   bucket.lastSeen,
+		})
+		bucket.mu.Unlock()
+	}
+	return states
+}
+
+// SaveState сохраняет текущее состояние лимитера в хранилище.
+func (rl *RateLimiter) SaveState(store StateStore) error {
+	return store.Save(rl.bucketStates())
+}
+
+// RestoreState восстанавливает бакеты из хранилища.
+//
+// Устаревание: снимок старше maxAge отбрасывается целиком. Для более свежих записей
+// сохраняется время последнего пополнения, поэтому при первом запросе бакет пополнится
+// на время, прошедшее с момента снимка (включая простой во время рестарта).
+// Токены обрезаются по текущему лимиту клиента, если он изменился.
+// Журнал запросов sliding_window не сохраняется: такие бакеты начинают с пустого окна.
+func (rl *RateLimiter) RestoreState(store StateStore, maxAge time.Duration) (int, error) {
+	states, err := store.Load()
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	restored := 0
+	for _, state := range states {
+		if maxAge > 0 && now.Sub(state.LastSeen) > maxAge {
+			continue
+		}
+
+		limit := rl.limitFor(state.ClientID)
+		bucket := rl.newBucket(limit)
+		bucket.Tokens = min(state.Tokens, limit.Capacity)
+		bucket.lastRefill = state.LastRefill
+		bucket.lastSeen = state.LastSeen
+		rl.buckets[state.ClientID] = bucket
+		restored++
+	}
+	return restored, nil
+}
+
+// SaveOverrides сохраняет индивидуальные лимиты, если хранилище это поддерживает.
+func (rl *RateLimiter) SaveOverrides(store StateStore) error {
+	overrides, ok := store.(OverrideStore)
+	if !ok {
+		return nil
+	}
+	return overrides.SaveOverrides(rl.ClientLimits())
+}
+
+// RestoreOverrides восстанавливает индивидуальные лимиты. Вызывается до RestoreState,
+// чтобы восстановленные бакеты сразу получили свои лимиты.
+func (rl *RateLimiter) RestoreOverrides(store StateStore) (int, error) {
+	overrides, ok := store.(OverrideStore)
+	if !ok {
+		return 0, nil
+	}
+	limits, err := overrides.LoadOverrides()
+	if err != nil {
+		return 0, err
+	}
+	for clientID, limit := range limits {
+		rl.SetClientLimit(clientID, limit)
+	}
+	return len(limits), nil
+}
+
+// RunSnapshots периодически сохраняет состояние лимитера. Ошибки хранилища только логируются:
+// недоступное хранилище не влияет на обработку запросов.
+func (rl *RateLimiter) RunSnapshots(store StateStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := rl.SaveState(store); err != nil {
+			rl.logger.Warnf("Failed to snapshot rate limiter state: %v", err)
+		}
+	}
+}
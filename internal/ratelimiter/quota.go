@@ -0,0 +1,257 @@
+package ratelimiter
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Manzo48/loadBalancer/internal/requestid"
+	"go.uber.org/zap"
+)
+
+// Периоды квот
+const (
+	QuotaPeriodDay   = "day"
+	QuotaPeriodMonth = "month"
+)
+
+// Режимы окна квоты
+const (
+	QuotaWindowCalendar = "calendar" // Сброс на границе календарных суток/месяца (UTC)
+	QuotaWindowRolling  = "rolling"  // Окно отсчитывается от первого запроса клиента в периоде
+)
+
+// QuotaPolicy описывает квоту: не больше Limit запросов за период.
+type QuotaPolicy struct {
+	Limit  int64  `json:"limit"`
+	Period string `json:"period"` // day | month
+}
+
+// quotaUsage — счетчик клиента в текущем периоде.
+type quotaUsage struct {
+	Count       int64     `json:"count"`
+	PeriodStart time.Time `json:"period_start"`
+	PeriodEnd   time.Time `json:"period_end"`
+}
+
+// QuotaStatus — состояние квоты клиента после учета запроса.
+type QuotaStatus struct {
+	Limit     int64     `json:"limit"`
+	Used      int64     `json:"used"`
+	Remaining int64     `json:"remaining"`
+	ResetAt   time.Time `json:"reset_at"`
+}
+
+// QuotaTracker считает запросы клиентов за длинные периоды (сутки/месяц),
+// независимо от токен-бакетов, которые ограничивают мгновенную частоту.
+type QuotaTracker struct {
+	mu            sync.Mutex
+	usage         map[string]*quotaUsage
+	policies      map[string]QuotaPolicy // Индивидуальные квоты клиентов
+	defaultPolicy QuotaPolicy            // Квота по умолчанию (Limit == 0 — без ограничения)
+	window        string
+	persistPath   string // Файл для сохранения счетчиков (пусто — только в памяти)
+	logger        *zap.SugaredLogger
+	now           func() time.Time
+}
+
+// NewQuotaTracker создает трекер квот и, если задан persistPath, восстанавливает счетчики с диска.
+func NewQuotaTracker(defaultPolicy QuotaPolicy, policies map[string]QuotaPolicy, window, persistPath string, logger *zap.SugaredLogger) *QuotaTracker {
+	if window == "" {
+		window = QuotaWindowCalendar
+	}
+	qt := &QuotaTracker{
+		usage:         make(map[string]*quotaUsage),
+		policies:      make(map[string]QuotaPolicy),
+		defaultPolicy: defaultPolicy,
+		window:        window,
+		persistPath:   persistPath,
+		logger:        logger,
+		now:           time.Now,
+	}
+	for clientID, policy := range policies {
+		qt.policies[clientID] = policy
+	}
+
+	if persistPath != "" {
+		if err := qt.load(); err != nil {
+			logger.Warnf("Failed to restore quota counters from %s: %v", persistPath, err)
+		}
+	}
+	return qt
+}
+
+// policyFor возвращает квоту клиента. Вызывается под qt.mu.
+func (qt *QuotaTracker) policyFor(clientID string) QuotaPolicy {
+	if policy, ok := qt.policies[clientID]; ok {
+		return policy
+	}
+	return qt.defaultPolicy
+}
+
+// periodBounds возвращает границы периода, в который попадает момент now.
+func (qt *QuotaTracker) periodBounds(period string, now time.Time) (time.Time, time.Time) {
+	if qt.window == QuotaWindowRolling {
+		if period == QuotaPeriodMonth {
+			return now, now.AddDate(0, 1, 0)
+		}
+		return now, now.Add(24 * time.Hour)
+	}
+
+	now = now.UTC()
+	if period == QuotaPeriodMonth {
+		start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 1, 0)
+	}
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 0, 1)
+}
+
+// current возвращает счетчик клиента, сбрасывая его на границе периода. Вызывается под qt.mu.
+func (qt *QuotaTracker) current(clientID string, policy QuotaPolicy) *quotaUsage {
+	now := qt.now()
+	usage, ok := qt.usage[clientID]
+	if !ok || !now.Before(usage.PeriodEnd) {
+		start, end := qt.periodBounds(policy.Period, now)
+		usage = &quotaUsage{PeriodStart: start, PeriodEnd: end}
+		qt.usage[clientID] = usage
+	}
+	return usage
+}
+
+// Consume учитывает один запрос клиента. Возвращает false, если квота исчерпана.
+func (qt *QuotaTracker) Consume(clientID string) (QuotaStatus, bool) {
+	qt.mu.Lock()
+	defer qt.mu.Unlock()
+
+	policy := qt.policyFor(clientID)
+	if policy.Limit <= 0 {
+		return QuotaStatus{}, true
+	}
+
+	usage := qt.current(clientID, policy)
+	allowed := usage.Count < policy.Limit
+	if allowed {
+		usage.Count++
+	}
+	return QuotaStatus{
+		Limit:     policy.Limit,
+		Used:      usage.Count,
+		Remaining: policy.Limit - usage.Count,
+		ResetAt:   usage.PeriodEnd,
+	}, allowed
+}
+
+// Status возвращает состояние квоты клиента без учета запроса.
+func (qt *QuotaTracker) Status(clientID string) QuotaStatus {
+	qt.mu.Lock()
+	defer qt.mu.Unlock()
+
+	policy := qt.policyFor(clientID)
+	if policy.Limit <= 0 {
+		return QuotaStatus{}
+	}
+	// Не создаем запись для клиента, который еще не делал запросов
+	usage, ok := qt.usage[clientID]
+	if !ok || !qt.now().Before(usage.PeriodEnd) {
+		_, end := qt.periodBounds(policy.Period, qt.now())
+		return QuotaStatus{Limit: policy.Limit, Remaining: policy.Limit, ResetAt: end}
+	}
+	return QuotaStatus{
+		Limit:     policy.Limit,
+		Used:      usage.Count,
+		Remaining: policy.Limit - usage.Count,
+		ResetAt:   usage.PeriodEnd,
+	}
+}
+
+// RunPersistence периодически сохраняет счетчики на диск (если задан persistPath).
+func (qt *QuotaTracker) RunPersistence(interval time.Duration) {
+	if qt.persistPath == "" || interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := qt.Save(); err != nil {
+			qt.logger.Warnf("Failed to persist quota counters: %v", err)
+		}
+	}
+}
+
+// Save атомарно записывает счетчики в persistPath (через временный файл и rename).
+func (qt *QuotaTracker) Save() error {
+	if qt.persistPath == "" {
+		return nil
+	}
+
+	qt.mu.Lock()
+	data, err := json.Marshal(qt.usage)
+	qt.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp := qt.persistPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o640); err != nil {
+		return err
+	}
+	return os.Rename(tmp, qt.persistPath)
+}
+
+// load восстанавливает счетчики; записи за истекшие периоды отбрасываются.
+func (qt *QuotaTracker) load() error {
+	data, err := os.ReadFile(qt.persistPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var usage map[string]*quotaUsage
+	if err := json.Unmarshal(data, &usage); err != nil {
+		return fmt.Errorf("decode quota snapshot: %w", err)
+	}
+
+	now := qt.now()
+	for clientID, u := range usage {
+		if now.Before(u.PeriodEnd) {
+			qt.usage[clientID] = u
+		}
+	}
+	qt.logger.Infof("Restored quota counters for %d clients", len(qt.usage))
+	return nil
+}
+
+// QuotaMiddleware отклоняет запросы клиентов, исчерпавших квоту, и сообщает остаток в заголовках.
+func QuotaMiddleware(qt *QuotaTracker, logger *zap.SugaredLogger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			clientID := extractClientIP(r)
+
+			status, allowed := qt.Consume(clientID)
+			if status.Limit > 0 {
+				w.Header().Set("X-Quota-Limit", strconv.FormatInt(status.Limit, 10))
+				w.Header().Set("X-Quota-Remaining", strconv.FormatInt(status.Remaining, 10))
+				w.Header().Set("X-Quota-Reset", strconv.FormatInt(status.ResetAt.Unix(), 10))
+			}
+
+			if !allowed {
+				requestid.Logger(r.Context(), logger).Warnw("Quota exceeded", "client_ip", clientID, "limit", status.Limit)
+
+				retryAfter := int(time.Until(status.ResetAt).Seconds()) + 1
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+				http.Error(w, "Quota exceeded", http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
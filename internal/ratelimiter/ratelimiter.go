@@ -1,9 +1,16 @@
 package ratelimiter
 
 import (
+	"context"
+	"net/http"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/Manzo48/loadBalancer/internal/clientip"
+	"github.com/Manzo48/loadBalancer/internal/config"
+	"github.com/Manzo48/loadBalancer/internal/metrics"
 	"go.uber.org/zap"
 )
 
@@ -16,6 +23,10 @@ type TokenBucket struct {
 	mu         sync.Mutex    // Мьютекс для потокобезопасного доступа
 	lastRefill time.Time     // Последнее время пополнения токенов
 	lastSeen   time.Time     // Последнее время активности клиента
+	sliding    bool          // Режим sliding_window: вместо токенов — журнал запросов
+	requests   []time.Time   // Время запросов в текущем окне (только sliding_window)
+	leaky      bool          // Режим leaky_bucket: токены — свободные места в очереди
+	nextLeak   time.Time     // Когда очередь отпустит следующий запрос (только leaky_bucket)
 }
 
 // NewTokenBucket создает новый токен-бакет с заданной ёмкостью и скоростью пополнения
@@ -47,32 +58,109 @@ func (tb *TokenBucket) refill() {
 // Allow проверяет, есть ли доступный токен для клиента
 // Возвращает true, если токен доступен, иначе false
 func (tb *TokenBucket) Allow() bool {
+	allowed, _, _ := tb.AllowWithInfo()
+	return allowed
+}
+
+// AllowWithInfo работает как Allow и дополнительно возвращает остаток токенов
+// после запроса и время до появления следующего токена (0, если токены есть
+// или бакет не пополняется). В режиме leaky_bucket разрешенный запрос ждет своей очереди.
+func (tb *TokenBucket) AllowWithInfo() (allowed bool, remaining int, retryAfter time.Duration) {
+	allowed, remaining, retryAfter, delay := tb.take()
+	time.Sleep(delay)
+	return allowed, remaining, retryAfter
+}
+
+// take — AllowWithInfo без ожидания: delay — сколько разрешенный запрос должен
+// простоять в очереди leaky_bucket (0 для остальных алгоритмов).
+func (tb *TokenBucket) take() (allowed bool, remaining int, retryAfter, delay time.Duration) {
 	tb.mu.Lock()
 	defer tb.mu.Unlock()
 
-	tb.refill()                  // Пополняем токены
 	tb.lastSeen = time.Now()    // Обновляем время последней активности
+	if tb.sliding {
+		allowed, remaining, retryAfter = tb.allowSliding(tb.lastSeen)
+		return allowed, remaining, retryAfter, 0
+	}
+	if tb.leaky && tb.RefillRate > 0 {
+		return tb.allowLeaky(tb.lastSeen)
+	}
+	tb.refill()                  // Пополняем токены
 
 	if tb.Tokens > 0 {
 		tb.Tokens-- // Используем токен
-		return true
+		allowed = true
+	}
+	if tb.Tokens == 0 && tb.RefillRate > 0 {
+		next := tb.lastRefill.Add(time.Second / time.Duration(tb.RefillRate))
+		retryAfter = max(time.Until(next), 0)
+	}
+	return allowed, tb.Tokens, retryAfter, 0
+}
+
+// refund возвращает токен, взятый запросом, который в итоге не был обслужен
+func (tb *TokenBucket) refund() {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if tb.sliding {
+		if n := len(tb.requests); n > 0 {
+			tb.requests = tb.requests[:n-1]
+		}
+	}
+	if tb.leaky && tb.RefillRate > 0 {
+		tb.nextLeak = tb.nextLeak.Add(-tb.leakInterval())
+		tb.leak(time.Now())
+		return
 	}
-	return false // Нет токенов — лимит превышен
+	tb.Tokens = min(tb.Capacity, tb.Tokens+1)
+}
+
+// setLimit меняет лимит бакета. Накопленные токены (или журнал запросов)
+// сохраняются, но не больше новой ёмкости.
+func (tb *TokenBucket) setLimit(capacity, refillRate int) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if tb.sliding {
+		tb.Capacity = capacity
+		tb.RefillRate = refillRate
+		tb.expire(time.Now())
+		return
+	}
+	if tb.leaky && refillRate > 0 {
+		// Очередь сохраняется: запросы в ней уже получили свое время выхода
+		tb.Capacity = capacity
+		tb.RefillRate = refillRate
+		tb.leak(time.Now())
+		return
+	}
+	tb.refill()
+	tb.Capacity = capacity
+	tb.RefillRate = refillRate
+	tb.Tokens = min(tb.Tokens, capacity)
 }
 
 // RateLimiter управляет токен-бакетами для всех клиентов
 type RateLimiter struct {
-	buckets           map[string]*TokenBucket // Мапа токен-бакетов по IP/ClientID
-	mu                sync.RWMutex            // RW-мьютекс для безопасного доступа
-	clientLimits      map[string]ClientLimit  // Индивидуальные лимиты для клиентов
-	defaultCapacity   int                     // Значение по умолчанию: ёмкость бакета
-	defaultRefillRate int                     // Значение по умолчанию: скорость пополнения
+	buckets           map[string]*TokenBucket     // Мапа токен-бакетов по IP/ClientID
+	mu                sync.RWMutex                // RW-мьютекс для безопасного доступа
+	clientLimits      map[string]ClientLimit      // Индивидуальные лимиты для клиентов
+	defaultCapacity   int                         // Значение по умолчанию: ёмкость бакета
+	defaultRefillRate int                         // Значение по умолчанию: скорость пополнения
+	keyFunc           KeyFunc                     // Ключ бакета для запроса (по умолчанию IP клиента)
+	algorithm         string                      // Алгоритм бакетов клиентов (token_bucket, sliding_window или leaky_bucket)
+	observe           atomic.Bool                 // Режим observe: превышения только считаются, запросы не блокируются
+	global            atomic.Pointer[TokenBucket] // Общий бакет на все запросы (nil — без глобального лимита)
+	allowlist         atomic.Pointer[clientip.IPList]      // Адреса, на которые лимиты не распространяются
+	maxBuckets        int                         // Порог числа бакетов для внеочередной очистки (0 — без порога)
+	cleanupNow        chan struct{}               // Запрос внеочередной очистки от getBucket
+	metrics           metrics.Metrics
+	logger            *zap.SugaredLogger
 }
 
 // ClientLimit описывает лимит токен-бакета для конкретного клиента
 type ClientLimit struct {
-	Capacity   int // Максимум токенов
-	RefillRate int // Скорость пополнения токенов (в сек.)
+	Capacity   int `json:"capacity"`    // Максимум токенов
+	RefillRate int `json:"refill_rate"` // Скорость пополнения токенов (в сек.)
 }
 
 // NewRateLimiter создает новый rate limiter с настройками по умолчанию
@@ -82,14 +170,149 @@ func NewRateLimiter(capacity, refillRate int, logger *zap.SugaredLogger) *RateLi
 		clientLimits:      make(map[string]ClientLimit),
 		defaultCapacity:   capacity,
 		defaultRefillRate: refillRate,
+		keyFunc:           extractClientIP,
+		algorithm:         AlgorithmTokenBucket,
+		cleanupNow:        make(chan struct{}, 1),
+		metrics:           metrics.Nop{},
+		logger:            logger,
 	}
 }
 
-// SetClientLimit задаёт индивидуальный лимит для конкретного клиента
+// SetKeyFunc задаёт способ вычисления ключа бакета. Вызывается до начала обработки запросов.
+func (rl *RateLimiter) SetKeyFunc(keyFunc KeyFunc) {
+	rl.keyFunc = keyFunc
+}
+
+// SetAlgorithm выбирает алгоритм для бакетов клиентов. Вызывается до начала
+// обработки запросов; глобальный лимит всегда работает как токен-бакет.
+func (rl *RateLimiter) SetAlgorithm(algorithm string) error {
+	if err := config.ValidateRateLimitAlgorithm(algorithm); err != nil {
+		return err
+	}
+	if algorithm != "" {
+		rl.algorithm = algorithm
+	}
+	return nil
+}
+
+// SetMaxBuckets задаёт число бакетов, при превышении которого очистка запускается
+// сразу, не дожидаясь очередного интервала. 0 — без порога. Вызывается до начала
+// обработки запросов.
+func (rl *RateLimiter) SetMaxBuckets(maxBuckets int) {
+	rl.maxBuckets = maxBuckets
+}
+
+// SetObserveMode включает режим observe: middleware вызывает Allow и учитывает
+// превышения в логах и метриках, но всегда пропускает запрос. Можно менять на лету.
+func (rl *RateLimiter) SetObserveMode(observe bool) {
+	rl.observe.Store(observe)
+}
+
+// ObserveMode сообщает, включен ли режим observe.
+func (rl *RateLimiter) ObserveMode() bool {
+	return rl.observe.Load()
+}
+
+// SetMetrics задаёт получателя метрик лимитера. Вызывается до начала обработки запросов.
+func (rl *RateLimiter) SetMetrics(m metrics.Metrics) {
+	rl.metrics = m
+}
+
+// Key возвращает ключ бакета для запроса.
+func (rl *RateLimiter) Key(r *http.Request) string {
+	return rl.keyFunc(r)
+}
+
+// SetClientLimit задаёт индивидуальный лимит для конкретного клиента.
+// Уже существующий бакет клиента сразу переходит на новый лимит.
 func (rl *RateLimiter) SetClientLimit(clientID string, limit ClientLimit) {
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
 	rl.clientLimits[clientID] = limit
+	rl.applyLimit(clientID, limit)
+}
+
+// SetDefaultLimit меняет лимит по умолчанию на лету. Бакеты клиентов без
+// индивидуального лимита сразу переходят на новый лимит.
+func (rl *RateLimiter) SetDefaultLimit(capacity, refillRate int) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.defaultCapacity = capacity
+	rl.defaultRefillRate = refillRate
+	for clientID := range rl.buckets {
+		if _, custom := rl.clientLimits[clientID]; !custom {
+			rl.applyLimit(clientID, ClientLimit{Capacity: capacity, RefillRate: refillRate})
+		}
+	}
+}
+
+// SetGlobalLimit задаёт общий лимит на все запросы сразу, независимо от клиента.
+// capacity <= 0 выключает глобальный лимит. Можно менять на лету.
+func (rl *RateLimiter) SetGlobalLimit(capacity, refillRate int) {
+	if capacity <= 0 {
+		rl.global.Store(nil)
+		return
+	}
+	if global := rl.global.Load(); global != nil {
+		global.setLimit(capacity, refillRate)
+		return
+	}
+	rl.global.Store(NewTokenBucket(capacity, refillRate))
+}
+
+// SetAllowlist задаёт адреса и подсети, запросы с которых не ограничиваются
+// (внутренний мониторинг и т.п.). Можно менять на лету.
+func (rl *RateLimiter) SetAllowlist(entries []string) error {
+	list, err := clientip.ParseIPList(entries)
+	if err != nil {
+		return err
+	}
+	rl.allowlist.Store(&list)
+	return nil
+}
+
+// Allowlisted сообщает, освобожден ли адрес от ограничений.
+func (rl *RateLimiter) Allowlisted(ip string) bool {
+	list := rl.allowlist.Load()
+	return list != nil && list.Contains(ip)
+}
+
+// RemoveClientLimit возвращает клиенту лимит по умолчанию.
+func (rl *RateLimiter) RemoveClientLimit(clientID string) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	delete(rl.clientLimits, clientID)
+	rl.applyLimit(clientID, rl.limitFor(clientID))
+}
+
+// ClientLimits возвращает копию индивидуальных лимитов.
+func (rl *RateLimiter) ClientLimits() map[string]ClientLimit {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+	limits := make(map[string]ClientLimit, len(rl.clientLimits))
+	for clientID, limit := range rl.clientLimits {
+		limits[clientID] = limit
+	}
+	return limits
+}
+
+// applyLimit обновляет лимит существующего бакета клиента. Накопленные токены
+// сохраняются, но не больше новой ёмкости. Вызывается под rl.mu.
+func (rl *RateLimiter) applyLimit(clientID string, limit ClientLimit) {
+	if bucket, exists := rl.buckets[clientID]; exists {
+		bucket.setLimit(limit.Capacity, limit.RefillRate)
+	}
+}
+
+// newBucket создает бакет клиента по выбранному алгоритму.
+func (rl *RateLimiter) newBucket(limit ClientLimit) *TokenBucket {
+	switch rl.algorithm {
+	case AlgorithmSlidingWindow:
+		return NewSlidingWindow(limit.Capacity, limit.RefillRate)
+	case AlgorithmLeakyBucket:
+		return NewLeakyBucket(limit.Capacity, limit.RefillRate)
+	}
+	return NewTokenBucket(limit.Capacity, limit.RefillRate)
 }
 
 // getBucket возвращает токен-бакет для клиента.
@@ -103,42 +326,146 @@ func (rl *RateLimiter) getBucket(clientID string) *TokenBucket {
 		rl.mu.Lock()
 		defer rl.mu.Unlock()
 
-		// Проверяем, есть ли индивидуальный лимит
-		limit, exists := rl.clientLimits[clientID]
-		if !exists {
-			limit = ClientLimit{
-				Capacity:   rl.defaultCapacity,
-				RefillRate: rl.defaultRefillRate,
-			}
-		}
-
 		// Создаём и сохраняем новый бакет
-		bucket = NewTokenBucket(limit.Capacity, limit.RefillRate)
+		bucket = rl.newBucket(rl.limitFor(clientID))
 		rl.buckets[clientID] = bucket
+		if rl.maxBuckets > 0 && len(rl.buckets) > rl.maxBuckets {
+			// Очистка уже запрошена, если канал заполнен
+			select {
+			case rl.cleanupNow <- struct{}{}:
+			default:
+			}
+		}
 	}
 	return bucket
 }
 
-// Allow проверяет, можно ли обслужить клиента с данным ID (IP, токен и т.п.)
+// limitFor возвращает индивидуальный лимит клиента или лимит по умолчанию.
+// Вызывается под rl.mu.
+func (rl *RateLimiter) limitFor(clientID string) ClientLimit {
+	if limit, exists := rl.clientLimits[clientID]; exists {
+		return limit
+	}
+	return ClientLimit{
+		Capacity:   rl.defaultCapacity,
+		RefillRate: rl.defaultRefillRate,
+	}
+}
+
+// LimitInfo — состояние бакета клиента после запроса, для заголовков X-RateLimit-*.
+type LimitInfo struct {
+	Limit      int           // Ёмкость бакета клиента
+	Remaining  int           // Сколько токенов осталось
+	RetryAfter time.Duration // Через сколько появится токен (0 — токены есть)
+}
+
+// Allow проверяет, можно ли обслужить клиента с данным ID (IP, токен и т.п.).
+// Если задан глобальный лимит, запрос должен получить токен и из общего бакета;
+// при его нехватке токен клиента возвращается обратно.
 func (rl *RateLimiter) Allow(clientID string) bool {
+	allowed, _ := rl.AllowWithInfo(context.Background(), clientID)
+	return allowed
+}
+
+// AllowWithInfo работает как Allow и дополнительно возвращает состояние бакета клиента.
+// При отказе по глобальному лимиту RetryAfter указывает на пополнение общего бакета.
+// В режиме leaky_bucket разрешенный запрос возвращается, когда подойдет его очередь
+// (в режиме observe — сразу). Если ctx отменен раньше (клиент отключился), запрос
+// отклоняется, а его место в очереди и глобальный токен возвращаются.
+func (rl *RateLimiter) AllowWithInfo(ctx context.Context, clientID string) (bool, LimitInfo) {
 	bucket := rl.getBucket(clientID)
-	return bucket.Allow()
+	allowed, remaining, retryAfter, delay := bucket.take()
+	bucket.mu.Lock()
+	info := LimitInfo{Limit: bucket.Capacity, Remaining: remaining, RetryAfter: retryAfter}
+	bucket.mu.Unlock()
+	if !allowed {
+		return false, info
+	}
+
+	global := rl.global.Load()
+	if global != nil {
+		globalAllowed, _, globalRetry := global.AllowWithInfo()
+		if !globalAllowed {
+			bucket.refund()
+			rl.metrics.Inc("lb_ratelimit_global_rejected_total")
+			// Сейчас клиенту недоступен ни один запрос, хотя его собственный бакет не пуст
+			info.Remaining = 0
+			info.RetryAfter = globalRetry
+			return false, info
+		}
+	}
+	if delay > 0 && !rl.observe.Load() && !waitQueue(ctx, delay) {
+		bucket.refund()
+		if global != nil {
+			global.refund()
+		}
+		return false, info
+	}
+	return true, info
 }
 
-// Cleanup удаляет неактивные токен-бакеты, которые не использовались дольше заданного времени
-func (rl *RateLimiter) Cleanup(expiration time.Duration) {
+// waitQueue ждет delay или отмены ctx. false — ожидание прервано отменой.
+func waitQueue(ctx context.Context, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Cleanup удаляет неактивные токен-бакеты, которые не использовались дольше заданного времени.
+// Если бакетов и после этого больше порога SetMaxBuckets, удаляются самые давно
+// активные из оставшихся. Возвращает число удаленных бакетов.
+func (rl *RateLimiter) Cleanup(expiration time.Duration) int {
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
 
 	now := time.Now()
+	evicted := 0
+	lastSeen := make(map[string]time.Time, len(rl.buckets))
 	for clientID, bucket := range rl.buckets {
 		bucket.mu.Lock()
-		lastSeen := bucket.lastSeen
+		seen := bucket.lastSeen
 		bucket.mu.Unlock()
 
-		if now.Sub(lastSeen) > expiration {
+		if now.Sub(seen) > expiration {
+			delete(rl.buckets, clientID)
+			evicted++
+			continue
+		}
+		lastSeen[clientID] = seen
+	}
+
+	if excess := len(rl.buckets) - rl.maxBuckets; rl.maxBuckets > 0 && excess > 0 {
+		clients := make([]string, 0, len(lastSeen))
+		for clientID := range lastSeen {
+			clients = append(clients, clientID)
+		}
+		sort.Slice(clients, func(i, j int) bool { return lastSeen[clients[i]].Before(lastSeen[clients[j]]) })
+		for _, clientID := range clients[:excess] {
 			delete(rl.buckets, clientID)
 		}
+		evicted += excess
+	}
+	return evicted
+}
+
+// RunCleanup периодически удаляет неактивные бакеты, а также внеочередно — когда
+// их число превышает порог SetMaxBuckets.
+func (rl *RateLimiter) RunCleanup(interval, expiration time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-rl.cleanupNow:
+		}
+		evicted := rl.Cleanup(expiration)
+		rl.logger.Debugf("Rate limiter cleanup evicted %d buckets", evicted)
 	}
 }
 
@@ -0,0 +1,69 @@
+package ratelimiter
+
+import (
+	"time"
+
+	"github.com/Manzo48/loadBalancer/internal/config"
+)
+
+// Алгоритмы ограничения (rate_limit.algorithm, см. config.RateLimit*)
+const (
+	AlgorithmTokenBucket   = config.RateLimitTokenBucket
+	AlgorithmSlidingWindow = config.RateLimitSlidingWindow
+	AlgorithmLeakyBucket   = config.RateLimitLeakyBucket
+)
+
+// NewSlidingWindow создает бакет, работающий по журналу запросов: запрос пропускается,
+// если за последние capacity/refillRate секунд было меньше capacity запросов.
+// Средняя пропускная способность та же, что у токен-бакета с теми же параметрами,
+// но всплеск в capacity запросов возможен не чаще одного раза за окно.
+func NewSlidingWindow(capacity, refillRate int) *TokenBucket {
+	bucket := NewTokenBucket(capacity, refillRate)
+	bucket.sliding = true
+	return bucket
+}
+
+// windowSize возвращает длину окна. 0 — окно бесконечно (бакет не пополняется).
+// Вызывается под tb.mu.
+func (tb *TokenBucket) windowSize() time.Duration {
+	if tb.RefillRate <= 0 {
+		return 0
+	}
+	return time.Duration(tb.Capacity) * time.Second / time.Duration(tb.RefillRate)
+}
+
+// expire удаляет из журнала запросы, вышедшие за окно, и пересчитывает остаток.
+// Вызывается под tb.mu.
+func (tb *TokenBucket) expire(now time.Time) {
+	if window := tb.windowSize(); window > 0 {
+		i := 0
+		for i < len(tb.requests) && now.Sub(tb.requests[i]) >= window {
+			i++
+		}
+		if i > 0 {
+			n := copy(tb.requests, tb.requests[i:])
+			tb.requests = tb.requests[:n]
+		}
+	}
+	// Журнал длиннее ёмкости после её уменьшения: самые старые записи больше не нужны
+	if extra := len(tb.requests) - tb.Capacity; extra > 0 {
+		n := copy(tb.requests, tb.requests[extra:])
+		tb.requests = tb.requests[:n]
+	}
+	tb.Tokens = max(tb.Capacity-len(tb.requests), 0)
+}
+
+// allowSliding — AllowWithInfo для режима sliding_window. Вызывается под tb.mu.
+func (tb *TokenBucket) allowSliding(now time.Time) (allowed bool, remaining int, retryAfter time.Duration) {
+	tb.expire(now)
+	if tb.Tokens > 0 {
+		tb.requests = append(tb.requests, now)
+		tb.Tokens--
+		allowed = true
+	}
+	if window := tb.windowSize(); tb.Tokens == 0 && window > 0 && len(tb.requests) > 0 {
+		// Место в окне освободится, когда из него выйдет самый старый запрос
+		retryAfter = max(tb.requests[0].Add(window).Sub(now), 0)
+	}
+	return allowed, tb.Tokens, retryAfter
+}
@@ -0,0 +1,51 @@
+package ratelimiter
+
+import (
+	"sort"
+	"time"
+)
+
+// ClientSnapshot — заполненность бакета клиента на момент вызова Snapshot.
+type ClientSnapshot struct {
+	ClientID   string    `json:"client_id"`
+	Tokens     int       `json:"tokens"` // Токенов сейчас, с учетом пополнения (sliding_window — свободных мест в окне, leaky_bucket — в очереди)
+	Capacity   int       `json:"capacity"`
+	RefillRate int       `json:"refill_rate"`
+	LastSeen   time.Time `json:"last_seen"`
+}
+
+// Snapshot возвращает состояние бакетов активных клиентов, отсортированное по ClientID.
+// Общий мьютекс лимитера держится только на время копирования списка бакетов, а мьютекс
+// бакета — только на время чтения его полей, поэтому сериализация результата не задерживает Allow.
+func (rl *RateLimiter) Snapshot() []ClientSnapshot {
+	rl.mu.RLock()
+	buckets := make(map[string]*TokenBucket, len(rl.buckets))
+	for clientID, bucket := range rl.buckets {
+		buckets[clientID] = bucket
+	}
+	rl.mu.RUnlock()
+
+	now := time.Now()
+	clients := make([]ClientSnapshot, 0, len(buckets))
+	for clientID, bucket := range buckets {
+		bucket.mu.Lock()
+		switch {
+		case bucket.sliding:
+			bucket.expire(now)
+		case bucket.leaky && bucket.RefillRate > 0:
+			bucket.leak(now)
+		default:
+			bucket.refill()
+		}
+		clients = append(clients, ClientSnapshot{
+			ClientID:   clientID,
+			Tokens:     bucket.Tokens,
+			Capacity:   bucket.Capacity,
+			RefillRate: bucket.RefillRate,
+			LastSeen:   bucket.lastSeen,
+		})
+		bucket.mu.Unlock()
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].ClientID < clients[j].ClientID })
+	return clients
+}
@@ -0,0 +1,66 @@
+package requestid
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+
+    "go.uber.org/zap"
+)
+
+// DefaultHeader — заголовок с идентификатором запроса по умолчанию.
+const DefaultHeader = "X-Request-ID"
+
+// maxLength — идентификаторы длиннее считаются некорректными и заменяются новыми.
+const maxLength = 128
+
+type contextKey struct{}
+
+type entry struct {
+    id     string
+    logger *zap.SugaredLogger
+}
+
+// New генерирует случайный идентификатор запроса (32 hex-символа).
+func New() string {
+    var b [16]byte
+    rand.Read(b[:])
+    return hex.EncodeToString(b[:])
+}
+
+// Valid сообщает, можно ли принять идентификатор клиента как есть: непустой, не длиннее
+// 128 символов и только из видимых ASCII-символов, чтобы его нельзя было использовать
+// для подделки строк лога или заголовков.
+func Valid(id string) bool {
+    if id == "" || len(id) > maxLength {
+        return false
+    }
+    for i := 0; i < len(id); i++ {
+        if id[i] <= ' ' || id[i] > '~' {
+            return false
+        }
+    }
+    return true
+}
+
+// NewContext сохраняет в контексте идентификатор запроса и логгер, добавляющий его
+// к каждой строке (поле request_id).
+func NewContext(ctx context.Context, id string, logger *zap.SugaredLogger) context.Context {
+    return context.WithValue(ctx, contextKey{}, &entry{id: id, logger: logger.With("request_id", id)})
+}
+
+// FromContext возвращает идентификатор запроса ("" — не задан).
+func FromContext(ctx context.Context) string {
+    if e, ok := ctx.Value(contextKey{}).(*entry); ok {
+        return e.id
+    }
+    return ""
+}
+
+// Logger возвращает логгер запроса или fallback, если идентификатора в контексте нет.
+func Logger(ctx context.Context, fallback *zap.SugaredLogger) *zap.SugaredLogger {
+    if e, ok := ctx.Value(contextKey{}).(*entry); ok {
+        return e.logger
+    }
+    return fallback
+}
@@ -0,0 +1,79 @@
+package urlkey
+
+import (
+    "net/url"
+    "path"
+    "sort"
+    "strings"
+
+    "github.com/Manzo48/loadBalancer/internal/config"
+)
+
+// Canonicalizer приводит URL к каноническому ключу: семантически одинаковые запросы
+// (/search?q=a&b=1 и /search?b=1&q=a) дают один и тот же ключ. Используется везде,
+// где URL служит ключом — в ключах лимитера, хешировании и маршрутизации.
+type Canonicalizer struct {
+    sort   bool
+    ignore []string // Шаблоны имен параметров (path.Match): utm_*, fbclid
+}
+
+// New создает Canonicalizer по конфигурации; шаблоны уже проверены в config.Load.
+func New(cfg config.QueryCanonicalizationConfig) *Canonicalizer {
+    return &Canonicalizer{sort: cfg.SortParams, ignore: cfg.IgnoreParams}
+}
+
+// Key возвращает путь и канонический query string.
+func (c *Canonicalizer) Key(u *url.URL) string {
+    query := c.Query(u.RawQuery)
+    if query == "" {
+        return u.EscapedPath()
+    }
+    return u.EscapedPath() + "?" + query
+}
+
+// Query удаляет игнорируемые параметры и, если включено, сортирует оставшиеся по имени.
+// Порядок значений одного параметра сохраняется: для a=1&a=2 он может быть значимым.
+func (c *Canonicalizer) Query(rawQuery string) string {
+    if rawQuery == "" {
+        return ""
+    }
+
+    type param struct {
+        name string
+        raw  string
+    }
+    params := make([]param, 0, strings.Count(rawQuery, "&")+1)
+    for _, raw := range strings.Split(rawQuery, "&") {
+        if raw == "" {
+            continue
+        }
+        rawName, _, _ := strings.Cut(raw, "=")
+        name, err := url.QueryUnescape(rawName)
+        if err != nil {
+            name = rawName
+        }
+        if c.ignored(name) {
+            continue
+        }
+        params = append(params, param{name: name, raw: raw})
+    }
+
+    if c.sort {
+        sort.SliceStable(params, func(i, j int) bool { return params[i].name < params[j].name })
+    }
+
+    parts := make([]string, len(params))
+    for i, p := range params {
+        parts[i] = p.raw
+    }
+    return strings.Join(parts, "&")
+}
+
+func (c *Canonicalizer) ignored(name string) bool {
+    for _, pattern := range c.ignore {
+        if matched, _ := path.Match(pattern, name); matched {
+            return true
+        }
+    }
+    return false
+}
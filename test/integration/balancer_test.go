@@ -0,0 +1,1333 @@
+package integration
+
+import (
+    "fmt"
+    "math/rand"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/Manzo48/loadBalancer/internal/balancer"
+    "github.com/Manzo48/loadBalancer/internal/config"
+    "github.com/Manzo48/loadBalancer/internal/metrics"
+    "go.uber.org/zap"
+    "go.uber.org/zap/zaptest/observer"
+)
+
+func TestRoundRobin_ExcludingTriedBackends(t *testing.T) {
+    logger := zap.NewNop().Sugar()
+    lb := balancer.NewRoundRobinLoadBalancer([]config.BackendConfig{
+        {URL: "http://backend1:9001"},
+        {URL: "http://backend2:9002"},
+        {URL: "http://backend3:9003"},
+    }, config.HealthCheckConfig{}, logger)
+
+    tried := make(map[*balancer.Backend]bool)
+    for i := 0; i < 3; i++ {
+        backend := lb.NextAvailableBackendExcluding(tried)
+        if backend == nil {
+            t.Fatalf("Retry %d: expected a backend, got nil", i+1)
+        }
+        if tried[backend] {
+            t.Fatalf("Retry %d: backend %s was already tried", i+1, backend.Address)
+        }
+        tried[backend] = true
+    }
+
+    // Все backend'ы опробованы — выбирать больше нечего
+    if backend := lb.NextAvailableBackendExcluding(tried); backend != nil {
+        t.Errorf("Expected nil after all backends were tried, got %s", backend.Address)
+    }
+}
+
+// healthyBackend — backend, всегда отвечающий 200 (в том числе на /health).
+func healthyBackend(t *testing.T) *httptest.Server {
+    t.Helper()
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(http.StatusOK)
+    }))
+    t.Cleanup(server.Close)
+    return server
+}
+
+func TestRoundRobin_ReplaceBackendsAtomically(t *testing.T) {
+    logger := zap.NewNop().Sugar()
+    oldA, oldB := healthyBackend(t), healthyBackend(t)
+    newA, newB := healthyBackend(t), healthyBackend(t)
+
+    lb := balancer.NewRoundRobinLoadBalancer([]config.BackendConfig{{URL: oldA.URL}, {URL: oldB.URL}}, config.HealthCheckConfig{}, logger)
+    newSet := map[string]bool{newA.URL: true, newB.URL: true}
+
+    var replaced atomic.Bool
+    var wg sync.WaitGroup
+    errs := make(chan string, 8)
+    stop := make(chan struct{})
+    for i := 0; i < 8; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for {
+                select {
+                case <-stop:
+                    return
+                default:
+                }
+                // Флаг читается до выбора: если замена уже завершилась, выбран должен быть новый backend
+                afterReplace := replaced.Load()
+                backend := lb.NextAvailableBackend()
+                if backend == nil {
+                    errs <- "no backend selected during cutover"
+                    return
+                }
+                if afterReplace && !newSet[backend.Address.String()] {
+                    errs <- "old backend selected after replacement: " + backend.Address.String()
+                    return
+                }
+            }
+        }()
+    }
+
+    if err := lb.ReplaceBackends([]config.BackendConfig{{URL: newA.URL}, {URL: newB.URL}}); err != nil {
+        t.Fatalf("ReplaceBackends failed: %v", err)
+    }
+    replaced.Store(true)
+
+    close(stop)
+    wg.Wait()
+    close(errs)
+    for err := range errs {
+        t.Error(err)
+    }
+}
+
+// Выбор backend'а, health-check'и и изменение набора на лету работают с одним
+// неизменяемым срезом через атомарный указатель; тест имеет смысл под go test -race.
+func TestRoundRobin_ConcurrentSelectAndMutate(t *testing.T) {
+    logger := zap.NewNop().Sugar()
+    interval := 5 * time.Millisecond
+    lb := balancer.NewRoundRobinLoadBalancer([]config.BackendConfig{{URL: healthyBackend(t).URL}},
+        config.HealthCheckConfig{Interval: &interval, Timeout: 4 * time.Millisecond}, logger)
+    extra := healthyBackend(t).URL
+
+    stop := make(chan struct{})
+    var wg sync.WaitGroup
+    for i := 0; i < 4; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for {
+                select {
+                case <-stop:
+                    return
+                default:
+                    if backend := lb.NextAvailableBackend(); backend != nil {
+                        _ = backend.Address.String()
+                    }
+                    _ = lb.Snapshot()
+                }
+            }
+        }()
+    }
+    for i := 0; i < 50; i++ {
+        if err := lb.AddBackend(config.BackendConfig{URL: extra}); err != nil {
+            t.Fatal(err)
+        }
+        if err := lb.SetBackendWeight(extra, i%3); err != nil {
+            t.Fatal(err)
+        }
+        if err := lb.RemoveBackend(extra); err != nil {
+            t.Fatal(err)
+        }
+    }
+    close(stop)
+    wg.Wait()
+}
+
+func TestRoundRobin_ReplaceBackendsRejectsUnhealthySet(t *testing.T) {
+    logger := zap.NewNop().Sugar()
+    current := healthyBackend(t)
+    lb := balancer.NewRoundRobinLoadBalancer([]config.BackendConfig{{URL: current.URL}}, config.HealthCheckConfig{}, logger)
+
+    // Порт закрытого сервера не отвечает на health-check
+    dead := httptest.NewServer(http.NotFoundHandler())
+    dead.Close()
+
+    if err := lb.ReplaceBackends([]config.BackendConfig{{URL: dead.URL}}); err == nil {
+        t.Fatal("Expected replacement with an unhealthy set to fail")
+    }
+    if backend := lb.NextAvailableBackend(); backend == nil || backend.Address.String() != current.URL {
+        t.Errorf("Expected the original backend to stay active")
+    }
+}
+
+// pathBackend — один хост, на котором /service-a и /service-b выступают разными backend'ами.
+// Собственный health-check есть только у service-a; общий /health — в корне хоста.
+func pathBackend(t *testing.T) *httptest.Server {
+    t.Helper()
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        switch r.URL.Path {
+        case "/health", "/service-a/health":
+            w.WriteHeader(http.StatusOK)
+        case "/service-b/health":
+            w.WriteHeader(http.StatusServiceUnavailable)
+        default:
+            w.Write([]byte(r.URL.Path))
+        }
+    }))
+    t.Cleanup(server.Close)
+    return server
+}
+
+func TestRoundRobin_BackendsDifferingOnlyByPath(t *testing.T) {
+    logger := zap.NewNop().Sugar()
+    server := pathBackend(t)
+    serviceA, serviceB := server.URL+"/service-a", server.URL+"/service-b"
+
+    // Путь health-check добавляется к базовому пути каждого backend'а
+    lb := balancer.NewRoundRobinLoadBalancer(nil, config.HealthCheckConfig{}, logger)
+    if err := lb.ReplaceBackends([]config.BackendConfig{{URL: serviceA}, {URL: serviceB}}); err != nil {
+        t.Fatalf("ReplaceBackends failed: %v", err)
+    }
+    for i := 0; i < 4; i++ {
+        backend := lb.NextAvailableBackend()
+        if backend == nil || backend.Address.String() != serviceA {
+            t.Fatalf("Expected only %s to be healthy, got %v", serviceA, backend)
+        }
+    }
+
+    // Пассивная пометка одного backend'а не затрагивает соседа по хосту
+    lb.MarkBackendUnhealthy(backendURL(t, serviceA))
+    if backend := lb.NextAvailableBackend(); backend != nil {
+        t.Errorf("Expected no healthy backends, got %s", backend.Address)
+    }
+}
+
+func TestRoundRobin_AbsoluteHealthCheckPath(t *testing.T) {
+    logger := zap.NewNop().Sugar()
+    server := pathBackend(t)
+    serviceA, serviceB := server.URL+"/service-a", server.URL+"/service-b"
+
+    lb := balancer.NewRoundRobinLoadBalancer(nil, config.HealthCheckConfig{Path: "/health", AbsolutePath: true}, logger)
+    if err := lb.ReplaceBackends([]config.BackendConfig{{URL: serviceA}, {URL: serviceB}}); err != nil {
+        t.Fatalf("ReplaceBackends failed: %v", err)
+    }
+
+    seen := make(map[string]bool)
+    for i := 0; i < 4; i++ {
+        if backend := lb.NextAvailableBackend(); backend != nil {
+            seen[backend.Address.String()] = true
+        }
+    }
+    if !seen[serviceA] || !seen[serviceB] {
+        t.Errorf("Expected both path backends to be healthy via the host-level check, got %v", seen)
+    }
+}
+
+func backendURL(t *testing.T, rawURL string) *url.URL {
+    t.Helper()
+    parsed, err := url.Parse(rawURL)
+    if err != nil {
+        t.Fatalf("Invalid URL %s: %v", rawURL, err)
+    }
+    return parsed
+}
+
+func TestRoundRobin_StuckHealthCheckTimesOut(t *testing.T) {
+    // Backend принимает соединения, но никогда не отвечает
+    listener, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatalf("listen: %v", err)
+    }
+    defer listener.Close()
+    go func() {
+        for {
+            conn, err := listener.Accept()
+            if err != nil {
+                return
+            }
+            defer conn.Close()
+        }
+    }()
+
+    logger := zap.NewNop().Sugar()
+    timeout := 200 * time.Millisecond
+    lb := balancer.NewRoundRobinLoadBalancer(nil, config.HealthCheckConfig{Timeout: timeout}, logger)
+
+    start := time.Now()
+    err = lb.ReplaceBackends([]config.BackendConfig{{URL: "http://" + listener.Addr().String()}})
+    elapsed := time.Since(start)
+
+    if err == nil {
+        t.Error("Expected the unresponsive backend to fail the health check")
+    }
+    if elapsed > timeout+500*time.Millisecond {
+        t.Errorf("Probe took %v, expected it to be cancelled after ~%v", elapsed, timeout)
+    }
+}
+
+func TestRoundRobin_HealthCheckConcurrencyLimits(t *testing.T) {
+    const maxConcurrent = 2
+    var total, maxTotal atomic.Int32
+    raiseMax := func(max *atomic.Int32, value int32) {
+        for {
+            current := max.Load()
+            if value <= current || max.CompareAndSwap(current, value) {
+                return
+            }
+        }
+    }
+
+    // 6 медленных backend'ов при пределе 2 probe: цикл занимает ~90ms при интервале 60ms,
+    // поэтому следующий тик застает probe предыдущего цикла еще выполняющимися
+    var perBackendExceeded atomic.Bool
+    var configs []config.BackendConfig
+    for i := 0; i < 6; i++ {
+        var active atomic.Int32
+        server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            if active.Add(1) > 1 {
+                perBackendExceeded.Store(true)
+            }
+            raiseMax(&maxTotal, total.Add(1))
+            time.Sleep(30 * time.Millisecond)
+            total.Add(-1)
+            active.Add(-1)
+        }))
+        defer server.Close()
+        configs = append(configs, config.BackendConfig{URL: server.URL})
+    }
+
+    core, logs := observer.New(zap.WarnLevel)
+    interval := 60 * time.Millisecond
+    balancer.NewRoundRobinLoadBalancer(configs, config.HealthCheckConfig{
+        Interval:      &interval,
+        Timeout:       50 * time.Millisecond,
+        MaxConcurrent: maxConcurrent,
+    }, zap.New(core).Sugar())
+    time.Sleep(500 * time.Millisecond)
+
+    if perBackendExceeded.Load() {
+        t.Error("Expected at most one in-flight probe per backend")
+    }
+    if got := maxTotal.Load(); got > maxConcurrent {
+        t.Errorf("Expected at most %d concurrent probes, got %d", maxConcurrent, got)
+    } else if got < maxConcurrent {
+        t.Errorf("Expected probes to run %d at a time, got at most %d", maxConcurrent, got)
+    }
+    if skipped := logs.FilterMessageSnippet("previous probe still in flight").Len(); skipped == 0 {
+        t.Error("Expected overlapping ticks to skip backends with a probe in flight")
+    }
+
+    path := filepath.Join(t.TempDir(), "config.yaml")
+    if err := os.WriteFile(path, []byte("health_check:\n  max_concurrent: -1\n"), 0o600); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := config.Load(path); err == nil || !strings.Contains(err.Error(), "health_check.max_concurrent") {
+        t.Errorf("Expected negative max_concurrent to be rejected, got %v", err)
+    }
+}
+
+// staticResolver сопоставляет IP регионам без базы GeoIP.
+type staticResolver map[string]string
+
+func (r staticResolver) Region(ip net.IP) (string, bool) {
+    region, ok := r[ip.String()]
+    return region, ok
+}
+
+func TestGeo_PrefersNearestRegionWithFallback(t *testing.T) {
+    logger := zap.NewNop().Sugar()
+    backends := []config.BackendConfig{
+        {URL: "http://eu1:9001", Region: "eu"},
+        {URL: "http://us1:9001", Region: "us"},
+        {URL: "http://ap1:9001", Region: "ap"},
+    }
+    geo := config.GeoConfig{
+        Fallback:      map[string][]string{"eu": {"us"}, "ap": {"us"}},
+        DefaultRegion: "us",
+    }
+    resolver := staticResolver{"81.2.69.142": "eu", "1.0.0.1": "ap"}
+    lb := balancer.NewGeoLoadBalancer(backends, config.HealthCheckConfig{}, geo, resolver, logger)
+
+    selectFor := func(ip string) string {
+        backend := lb.Select(balancer.Selection{ClientIP: ip})
+        if backend == nil {
+            return ""
+        }
+        return backend.Address.Host
+    }
+
+    if got := selectFor("81.2.69.142"); got != "eu1:9001" {
+        t.Errorf("EU client: expected eu1, got %s", got)
+    }
+    if got := selectFor("1.0.0.1"); got != "ap1:9001" {
+        t.Errorf("AP client: expected ap1, got %s", got)
+    }
+    // IP, которого нет в базе, обслуживается регионом по умолчанию
+    if got := selectFor("203.0.113.7"); got != "us1:9001" {
+        t.Errorf("Unknown client: expected default region us1, got %s", got)
+    }
+
+    // Ближайший регион недоступен — используется запасной
+    lb.MarkBackendUnhealthy(backendURL(t, "http://eu1:9001"))
+    if got := selectFor("81.2.69.142"); got != "us1:9001" {
+        t.Errorf("EU client with EU down: expected fallback us1, got %s", got)
+    }
+}
+
+func TestWeightedRoundRobin_DistributesByWeight(t *testing.T) {
+    logger := zap.NewNop().Sugar()
+    heavy, light := 3, 1
+    lb := balancer.NewWeightedRoundRobinLoadBalancer([]config.BackendConfig{
+        {URL: "http://heavy:9001", Weight: &heavy},
+        {URL: "http://light:9002", Weight: &light},
+    }, config.HealthCheckConfig{}, logger)
+
+    counts := make(map[string]int)
+    for i := 0; i < 400; i++ {
+        counts[lb.NextAvailableBackend().Address.Host]++
+    }
+    if counts["heavy:9001"] != 300 || counts["light:9002"] != 100 {
+        t.Errorf("Expected 300/100 split, got %v", counts)
+    }
+
+    // Вес 0 прекращает новые запросы, но backend остается живым
+    if err := lb.SetBackendWeight("http://heavy:9001", 0); err != nil {
+        t.Fatalf("SetBackendWeight: %v", err)
+    }
+    for i := 0; i < 10; i++ {
+        if backend := lb.NextAvailableBackend(); backend.Address.Host != "light:9002" {
+            t.Fatalf("Expected only light backend after weight 0, got %s", backend.Address)
+        }
+    }
+    for _, backend := range lb.Backends() {
+        if !backend.IsAlive.Load() {
+            t.Errorf("Backend %s should stay healthy with weight 0", backend.Address)
+        }
+    }
+}
+
+func TestRoundRobin_RandomStartOffset(t *testing.T) {
+    logger := zap.NewNop().Sugar()
+    backends := []config.BackendConfig{
+        {URL: "http://backend1:9001"},
+        {URL: "http://backend2:9002"},
+        {URL: "http://backend3:9003"},
+    }
+    firstBackend := func() string {
+        return balancer.NewRoundRobinLoadBalancer(backends, config.HealthCheckConfig{}, logger).NextAvailableBackend().Address.Host
+    }
+
+    // Одинаковый seed — одинаковое начало ротации
+    balancer.SeedStartOffsets(42)
+    first := firstBackend()
+    balancer.SeedStartOffsets(42)
+    if again := firstBackend(); again != first {
+        t.Errorf("Expected deterministic start with the same seed: %s vs %s", first, again)
+    }
+
+    // Экземпляры, запущенные одновременно, начинают с разных backend'ов
+    starts := make(map[string]int)
+    for i := 0; i < 30; i++ {
+        starts[firstBackend()]++
+    }
+    if len(starts) < 2 {
+        t.Errorf("Expected start offsets to differ between instances, got %v", starts)
+    }
+}
+
+func TestIPHash_StickyWithStableFallback(t *testing.T) {
+    logger := zap.NewNop().Sugar()
+    lb := balancer.NewIPHashLoadBalancer([]config.BackendConfig{
+        {URL: "http://backend1:9001"},
+        {URL: "http://backend2:9002"},
+        {URL: "http://backend3:9003"},
+    }, config.HealthCheckConfig{}, logger)
+
+    clients := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "192.168.1.7", "172.16.5.4", "2001:db8::1"}
+    assigned := make(map[string]*balancer.Backend)
+    for _, ip := range clients {
+        assigned[ip] = lb.Select(balancer.Selection{ClientIP: ip})
+        for i := 0; i < 5; i++ {
+            if backend := lb.Select(balancer.Selection{ClientIP: ip}); backend != assigned[ip] {
+                t.Fatalf("Client %s moved from %s to %s", ip, assigned[ip].Address, backend.Address)
+            }
+        }
+    }
+
+    // Выводим backend одного из клиентов: его клиенты переезжают, остальные остаются на месте
+    down := assigned[clients[0]]
+    lb.MarkBackendUnhealthy(down.Address)
+    for _, ip := range clients {
+        backend := lb.Select(balancer.Selection{ClientIP: ip})
+        switch {
+        case backend == down:
+            t.Errorf("Client %s routed to unhealthy backend", ip)
+        case assigned[ip] != down && backend != assigned[ip]:
+            t.Errorf("Client %s of a healthy backend was reassigned to %s", ip, backend.Address)
+        }
+        if again := lb.Select(balancer.Selection{ClientIP: ip}); again != backend {
+            t.Errorf("Fallback for client %s is not stable", ip)
+        }
+    }
+
+    // После восстановления клиенты возвращаются на свой backend
+    down.IsAlive.Store(true)
+    for _, ip := range clients {
+        if backend := lb.Select(balancer.Selection{ClientIP: ip}); backend != assigned[ip] {
+            t.Errorf("Client %s did not return to %s after recovery", ip, assigned[ip].Address)
+        }
+    }
+}
+
+func TestConsistentHash_MinimalReshuffling(t *testing.T) {
+    noHealthChecks := time.Duration(0)
+    lb := balancer.NewConsistentHashLoadBalancer(backendConfigs(4), config.HealthCheckConfig{Interval: &noHealthChecks}, zap.NewNop().Sugar())
+    lb.Configure(config.ConsistentHashConfig{Key: "header:X-Cache-Key", VirtualNodes: 100})
+
+    selectAll := func() map[string]*balancer.Backend {
+        assigned := make(map[string]*balancer.Backend)
+        for i := 0; i < 400; i++ {
+            key := fmt.Sprintf("object-%d", i)
+            req := httptest.NewRequest(http.MethodGet, "/", nil)
+            req.Header.Set("X-Cache-Key", key)
+            assigned[key] = lb.Select(balancer.Selection{Request: req})
+        }
+        return assigned
+    }
+    before := selectAll()
+    used := make(map[*balancer.Backend]bool)
+    for _, backend := range before {
+        used[backend] = true
+    }
+    if len(used) != 4 {
+        t.Fatalf("Expected keys on all 4 backends, got %d", len(used))
+    }
+
+    // Ключи недоступного backend'а уходят на соседей по кольцу, остальные остаются на месте
+    down := before["object-0"]
+    down.IsAlive.Store(false)
+    for key, backend := range selectAll() {
+        if before[key] != down && backend != before[key] {
+            t.Fatalf("Key %s moved from healthy %s to %s", key, before[key].Address, backend.Address)
+        }
+        if backend == down {
+            t.Fatalf("Key %s still routed to unhealthy %s", key, down.Address)
+        }
+    }
+    down.IsAlive.Store(true)
+    for key, backend := range selectAll() {
+        if backend != before[key] {
+            t.Fatalf("Key %s did not return to %s after recovery", key, before[key].Address)
+        }
+    }
+
+    // Новый backend забирает примерно свою долю ключей (1/5), а не перемешивает все
+    if err := lb.AddBackend(config.BackendConfig{URL: "http://backend4:9000"}); err != nil {
+        t.Fatal(err)
+    }
+    moved := 0
+    for key, backend := range selectAll() {
+        if backend != before[key] {
+            if backend.Address.Host != "backend4:9000" {
+                t.Fatalf("Key %s moved between existing backends", key)
+            }
+            moved++
+        }
+    }
+    if moved == 0 || moved > 160 {
+        t.Errorf("Expected about 80 of 400 keys to move to the new backend, moved %d", moved)
+    }
+}
+
+func TestLeastConnections_PrefersLeastLoaded(t *testing.T) {
+    lb, err := balancer.New("least_connections", []config.BackendConfig{
+        {URL: "http://backend1:9001"},
+        {URL: "http://backend2:9002"},
+        {URL: "http://backend3:9003"},
+    }, config.HealthCheckConfig{}, zap.NewNop().Sugar())
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    backends := lb.Backends()
+    backends[0].ActiveConnections.Store(5)
+    backends[1].ActiveConnections.Store(1)
+    backends[2].ActiveConnections.Store(3)
+    for i := 0; i < 5; i++ {
+        if backend := lb.NextAvailableBackend(); backend != backends[1] {
+            t.Fatalf("Expected least loaded backend %s, got %s", backends[1].Address, backend.Address)
+        }
+    }
+}
+
+func TestP2C_AvoidsMostLoadedBackend(t *testing.T) {
+    lb, err := balancer.New("p2c", []config.BackendConfig{
+        {URL: "http://backend1:9001"},
+        {URL: "http://backend2:9002"},
+        {URL: "http://backend3:9003"},
+    }, config.HealthCheckConfig{}, zap.NewNop().Sugar())
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    backends := lb.Backends()
+    backends[0].ActiveConnections.Store(5)
+    backends[1].ActiveConnections.Store(1)
+    backends[2].ActiveConnections.Store(3)
+    counts := make(map[*balancer.Backend]int)
+    for i := 0; i < 300; i++ {
+        counts[lb.NextAvailableBackend()]++
+    }
+    // Самый загруженный проигрывает в любой паре, наименее загруженный выигрывает в 2 из 3 пар
+    if counts[backends[0]] != 0 {
+        t.Errorf("Expected most loaded backend never to be picked, got %d", counts[backends[0]])
+    }
+    if counts[backends[1]] <= counts[backends[2]] {
+        t.Errorf("Expected least loaded backend to be picked most often, got %d vs %d", counts[backends[1]], counts[backends[2]])
+    }
+
+    random, err := balancer.New("random", backendConfigs(3), config.HealthCheckConfig{}, zap.NewNop().Sugar())
+    if err != nil {
+        t.Fatal(err)
+    }
+    seen := make(map[*balancer.Backend]bool)
+    for i := 0; i < 300; i++ {
+        seen[random.NextAvailableBackend()] = true
+    }
+    if len(seen) != 3 {
+        t.Errorf("Expected random strategy to use all 3 backends, used %d", len(seen))
+    }
+}
+
+// backendConfigs возвращает n backend'ов с разными адресами.
+func backendConfigs(n int) []config.BackendConfig {
+    configs := make([]config.BackendConfig, n)
+    for i := range configs {
+        configs[i] = config.BackendConfig{URL: fmt.Sprintf("http://backend%d:9000", i)}
+    }
+    return configs
+}
+
+func TestLeastResponseTime_PrefersFastBackendUnlessOverloaded(t *testing.T) {
+    lb, err := balancer.New("least_response_time", []config.BackendConfig{
+        {URL: "http://fast:9001"},
+        {URL: "http://slow:9002"},
+    }, config.HealthCheckConfig{}, zap.NewNop().Sugar())
+    if err != nil {
+        t.Fatal(err)
+    }
+    lb.(*balancer.LeastResponseTimeLoadBalancer).Configure(config.LeastResponseTimeConfig{Decay: 0.5})
+
+    backends := lb.Backends()
+    fast, slow := backends[0], backends[1]
+    fast.ObserveResponse(10*time.Millisecond, -1)
+    slow.ObserveResponse(50*time.Millisecond, -1)
+    slow.ObserveResponse(30*time.Millisecond, -1)
+    if got := slow.LatencyEWMA(); got != 40*time.Millisecond {
+        t.Fatalf("Expected EWMA 40ms with decay 0.5, got %v", got)
+    }
+    for i := 0; i < 5; i++ {
+        if backend := lb.NextAvailableBackend(); backend != fast {
+            t.Fatalf("Expected fast backend, got %s", backend.Address)
+        }
+    }
+
+    // 10ms × 5 > 40ms × 1: быстрый, но перегруженный backend уступает
+    fast.ActiveConnections.Store(4)
+    if backend := lb.NextAvailableBackend(); backend != slow {
+        t.Fatalf("Expected slow backend while fast one is overloaded, got %s", backend.Address)
+    }
+}
+
+func TestWeightedLeastConnections_HoldsConnectionsProportionalToWeight(t *testing.T) {
+    heavy, light := 10, 1
+    lb, err := balancer.New("weighted_least_connections", []config.BackendConfig{
+        {URL: "http://heavy:9001", Weight: &heavy},
+        {URL: "http://light:9002", Weight: &light},
+    }, config.HealthCheckConfig{}, zap.NewNop().Sugar())
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // Постоянная нагрузка: 22 одновременных запроса, завершающихся в случайном порядке
+    var held []*balancer.Backend
+    acquire := func() {
+        backend := lb.NextAvailableBackend()
+        backend.ActiveConnections.Add(1)
+        held = append(held, backend)
+    }
+    for i := 0; i < 22; i++ {
+        acquire()
+    }
+    for i := 0; i < 1000; i++ {
+        j := rand.Intn(len(held))
+        held[j].ActiveConnections.Add(-1)
+        held = append(held[:j], held[j+1:]...)
+        acquire()
+    }
+
+    backends := lb.Backends()
+    if h, l := backends[0].ActiveConnections.Load(), backends[1].ActiveConnections.Load(); h != 20 || l != 2 {
+        t.Errorf("Expected 20 and 2 connections for weights 10 and 1, got %d and %d", h, l)
+    }
+}
+
+func TestSlowStart_RampsUpRecoveredBackend(t *testing.T) {
+    noHealthChecks := time.Duration(0)
+    lb, err := balancer.New("round_robin", []config.BackendConfig{
+        {URL: "http://steady:9001"},
+        {URL: "http://recovered:9002"},
+    }, config.HealthCheckConfig{Interval: &noHealthChecks, SlowStart: 500 * time.Millisecond}, zap.NewNop().Sugar())
+    if err != nil {
+        t.Fatal(err)
+    }
+    recovered := lb.Backends()[1]
+
+    share := func() float64 {
+        hits := 0
+        for i := 0; i < 2000; i++ {
+            if lb.NextAvailableBackend() == recovered {
+                hits++
+            }
+        }
+        return float64(hits) / 2000
+    }
+
+    if got := share(); got < 0.45 {
+        t.Fatalf("Expected full share before any recovery, got %.2f", got)
+    }
+
+    recovered.SetAlive(false)
+    recovered.SetAlive(true)
+    if got := share(); got > 0.1 {
+        t.Errorf("Expected a near-zero share right after recovery, got %.2f", got)
+    }
+    if status := lb.Snapshot()[1]; status.SlowStartUntil == nil {
+        t.Error("Expected slow_start_until in the snapshot of the recovering backend")
+    }
+
+    time.Sleep(500 * time.Millisecond)
+    if got := share(); got < 0.45 {
+        t.Errorf("Expected full share after the slow start window, got %.2f", got)
+    }
+    if status := lb.Snapshot()[1]; status.SlowStartUntil != nil {
+        t.Errorf("Expected slow start to be over, snapshot says until %s", status.SlowStartUntil)
+    }
+}
+
+// waitStandby ждет, пока резервный пул придет в состояние active, или истечет timeout.
+func waitStandby(lb *balancer.RoundRobinLoadBalancer, active bool, timeout time.Duration) bool {
+    deadline := time.Now().Add(timeout)
+    for time.Now().Before(deadline) {
+        if lb.StandbyActive() == active {
+            return true
+        }
+        time.Sleep(5 * time.Millisecond)
+    }
+    return lb.StandbyActive() == active
+}
+
+func TestStandby_ActivatesWithHysteresisAndDeactivates(t *testing.T) {
+    noHealthChecks := time.Duration(0)
+    lb := balancer.NewRoundRobinLoadBalancer([]config.BackendConfig{{URL: "http://primary:9001"}},
+        config.HealthCheckConfig{Interval: &noHealthChecks}, zap.NewNop().Sugar())
+    registry := metrics.NewRegistry()
+    // deactivate_connections не задан: пул выключается ниже половины порога включения (2)
+    lb.ConfigureStandby(config.StandbyConfig{
+        Backends:            []config.BackendConfig{{URL: "http://standby:9002"}},
+        ActivateConnections: 4,
+        CheckInterval:       10 * time.Millisecond,
+    }, registry)
+    primary := lb.Backends()[0]
+    gauge := func() string {
+        rec := httptest.NewRecorder()
+        registry.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+        return rec.Body.String()
+    }
+
+    primary.ActiveConnections.Store(5)
+    if !waitStandby(lb, true, time.Second) {
+        t.Fatal("Expected standby pool to activate above activate_connections")
+    }
+    if !strings.Contains(gauge(), "lb_standby_active 1") {
+        t.Errorf("Expected lb_standby_active 1, got:\n%s", gauge())
+    }
+    standbyHits := 0
+    for i := 0; i < 10; i++ {
+        if lb.NextAvailableBackend().Address.Host == "standby:9002" {
+            standbyHits++
+        }
+    }
+    if standbyHits == 0 {
+        t.Error("Expected active standby backends to receive traffic")
+    }
+
+    // Между порогами пул остается включенным
+    primary.ActiveConnections.Store(3)
+    time.Sleep(100 * time.Millisecond)
+    if !lb.StandbyActive() {
+        t.Error("Expected standby pool to stay active between deactivate and activate thresholds")
+    }
+
+    primary.ActiveConnections.Store(1)
+    if !waitStandby(lb, false, time.Second) {
+        t.Fatal("Expected standby pool to deactivate below half of activate_connections")
+    }
+    if !strings.Contains(gauge(), "lb_standby_active 0") {
+        t.Errorf("Expected lb_standby_active 0, got:\n%s", gauge())
+    }
+    for i := 0; i < 10; i++ {
+        if backend := lb.NextAvailableBackend(); backend != primary {
+            t.Fatalf("Expected only primary backends after deactivation, got %s", backend.Address)
+        }
+    }
+
+    // Без живых основных backend'ов пул перегружен при любых порогах
+    primary.ActiveConnections.Store(0)
+    primary.IsAlive.Store(false)
+    if !waitStandby(lb, true, time.Second) {
+        t.Fatal("Expected standby pool to activate when no primary backend is alive")
+    }
+    time.Sleep(50 * time.Millisecond)
+    if !lb.StandbyActive() {
+        t.Error("Expected standby pool to stay active while no primary backend is alive")
+    }
+    primary.IsAlive.Store(true)
+    if !waitStandby(lb, false, time.Second) {
+        t.Error("Expected standby pool to deactivate once the primary backend is back")
+    }
+}
+
+func TestStandby_ErrorRateWithExplicitDeactivateThreshold(t *testing.T) {
+    noHealthChecks := time.Duration(0)
+    lb := balancer.NewRoundRobinLoadBalancer([]config.BackendConfig{{URL: "http://primary:9001"}},
+        config.HealthCheckConfig{Interval: &noHealthChecks}, zap.NewNop().Sugar())
+    lb.ConfigureStandby(config.StandbyConfig{
+        Backends:            []config.BackendConfig{{URL: "http://standby:9002"}},
+        ActivateErrorRate:   0.5,
+        DeactivateErrorRate: 0.1,
+        CheckInterval:       20 * time.Millisecond,
+    }, metrics.Nop{})
+    primary := lb.Backends()[0]
+
+    // Поток запросов с заданной долей ошибок (из 10), несколько пачек на интервал проверки
+    var stop atomic.Bool
+    var failures atomic.Int64
+    failures.Store(8)
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        for !stop.Load() {
+            primary.TotalRequests.Add(10)
+            primary.FailedRequests.Add(uint64(failures.Load()))
+            time.Sleep(2 * time.Millisecond)
+        }
+    }()
+    defer func() { stop.Store(true); <-done }()
+
+    if !waitStandby(lb, true, time.Second) {
+        t.Fatal("Expected standby pool to activate above activate_error_rate")
+    }
+    // 30% ошибок: ниже порога включения, но выше явного порога выключения
+    failures.Store(3)
+    time.Sleep(150 * time.Millisecond)
+    if !lb.StandbyActive() {
+        t.Error("Expected standby pool to stay active above deactivate_error_rate")
+    }
+    failures.Store(0)
+    if !waitStandby(lb, false, time.Second) {
+        t.Error("Expected standby pool to deactivate below deactivate_error_rate")
+    }
+}
+
+// BenchmarkStrategySelect сравнивает стоимость выбора backend'а на большом пуле
+// при параллельных запросах.
+func BenchmarkStrategySelect(b *testing.B) {
+    noHealthChecks := time.Duration(0)
+    for _, strategy := range []string{"round_robin", "least_connections", "random", "p2c"} {
+        b.Run(strategy, func(b *testing.B) {
+            lb, err := balancer.New(strategy, backendConfigs(1000), config.HealthCheckConfig{Interval: &noHealthChecks}, zap.NewNop().Sugar())
+            if err != nil {
+                b.Fatal(err)
+            }
+            b.ResetTimer()
+            b.RunParallel(func(pb *testing.PB) {
+                for pb.Next() {
+                    lb.Select(balancer.Selection{})
+                }
+            })
+        })
+    }
+}
+
+func TestStrategy_UnknownValueRejected(t *testing.T) {
+    if _, err := balancer.New("fastest", nil, config.HealthCheckConfig{}, zap.NewNop().Sugar()); err == nil {
+        t.Error("Expected factory error for unknown strategy")
+    }
+
+    path := filepath.Join(t.TempDir(), "config.yaml")
+    if err := os.WriteFile(path, []byte("port: 8080\nstrategy: fastest\n"), 0o600); err != nil {
+        t.Fatal(err)
+    }
+    _, err := config.Load(path)
+    if err == nil || !strings.Contains(err.Error(), `"fastest"`) || !strings.Contains(err.Error(), "least_connections") {
+        t.Errorf("Expected descriptive error listing valid strategies, got %v", err)
+    }
+}
+
+// lowestPortLoadBalancer — пример пользовательской стратегии: всегда backend с наименьшим
+// портом среди доступных. Все, кроме выбора, берется из встроенного *balancer.Pool.
+type lowestPortLoadBalancer struct {
+    *balancer.Pool
+}
+
+func init() {
+    balancer.Register("lowest_port", func(backendConfigs []config.BackendConfig, healthCheck config.HealthCheckConfig, logger *zap.SugaredLogger) balancer.LoadBalancer {
+        return &lowestPortLoadBalancer{Pool: balancer.NewPool(backendConfigs, healthCheck, logger)}
+    })
+}
+
+func (lb *lowestPortLoadBalancer) NextAvailableBackend() *balancer.Backend {
+    return lb.Select(balancer.Selection{})
+}
+
+func (lb *lowestPortLoadBalancer) NextAvailableBackendExcluding(tried map[*balancer.Backend]bool) *balancer.Backend {
+    return lb.Select(balancer.Selection{Tried: tried})
+}
+
+func (lb *lowestPortLoadBalancer) Select(sel balancer.Selection) *balancer.Backend {
+    return lb.Pick(sel, func(candidates []*balancer.Backend, _ balancer.Selection) *balancer.Backend {
+        best := candidates[0]
+        for _, backend := range candidates[1:] {
+            if backend.Address.Port() < best.Address.Port() {
+                best = backend
+            }
+        }
+        return best
+    })
+}
+
+func TestStrategy_RegisteredCustomStrategy(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "config.yaml")
+    if err := os.WriteFile(path, []byte("port: 8080\nstrategy: lowest_port\nbackends:\n  - \"http://backend1:9001\"\n"), 0o600); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := config.Load(path); err != nil {
+        t.Fatalf("Expected registered strategy to pass validation, got %v", err)
+    }
+
+    lb, err := balancer.New("lowest_port", []config.BackendConfig{
+        {URL: "http://backend3:9003"},
+        {URL: "http://backend1:9001"},
+        {URL: "http://backend2:9002"},
+    }, config.HealthCheckConfig{}, zap.NewNop().Sugar())
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, ok := lb.(*lowestPortLoadBalancer); !ok {
+        t.Fatalf("Expected the registered constructor to be used, got %T", lb)
+    }
+    backends := lb.Backends()
+    if backend := lb.NextAvailableBackend(); backend != backends[1] {
+        t.Fatalf("Expected backend with the lowest port, got %s", backend.Address)
+    }
+    // Исключения Pick (опробованные backend'ы) действуют и для пользовательской стратегии
+    if backend := lb.NextAvailableBackendExcluding(map[*balancer.Backend]bool{backends[1]: true}); backend != backends[2] {
+        t.Errorf("Expected the next lowest port after excluding the first, got %s", backend.Address)
+    }
+}
+
+func TestConfig_BackendsEnvReplacesFileList(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "config.yaml")
+    if err := os.WriteFile(path, []byte("port: 8080\nbackends:\n  - \"http://from-file:9001\"\n"), 0o600); err != nil {
+        t.Fatal(err)
+    }
+    t.Setenv("BACKENDS", "http://a:9001, http://b:9002,,")
+
+    cfg, err := config.Load(path)
+    if err != nil {
+        t.Fatalf("Expected config with BACKENDS to load, got %v", err)
+    }
+    var urls []string
+    for _, backend := range cfg.Backends {
+        urls = append(urls, backend.URL)
+    }
+    if strings.Join(urls, " ") != "http://a:9001 http://b:9002" {
+        t.Errorf("Expected BACKENDS to replace the file list with two backends, got %q", urls)
+    }
+}
+
+func TestConfig_ExpandsEnvironmentVariables(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "config.yaml")
+    write := func(content string) {
+        if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+            t.Fatal(err)
+        }
+    }
+    t.Setenv("LB_PORT", "9090")
+    t.Setenv("LB_BACKEND_HOST", "backend1")
+
+    write("port: ${LB_PORT}\nbackends:\n  - url: http://$LB_BACKEND_HOST:${LB_BACKEND_PORT:-9001}\n" +
+        "rewrite:\n  - regex: ^/v1/(?P<rest>.*)$\n    replacement: /v2/${rest}$1\n")
+    cfg, err := config.Load(path)
+    if err != nil {
+        t.Fatalf("Expected config with environment references to load, got %v", err)
+    }
+    if cfg.Port != 9090 || cfg.Backends[0].URL != "http://backend1:9001" {
+        t.Errorf("Expected substituted port and backend, got %d %q", cfg.Port, cfg.Backends[0].URL)
+    }
+    if cfg.Rewrite[0].Replacement != "/v2/${rest}$1" || cfg.Rewrite[0].Regex != "^/v1/(?P<rest>.*)$" {
+        t.Errorf("Regex references must be left untouched, got %+v", cfg.Rewrite[0])
+    }
+
+    write("# port: $COMMENTED_OUT\nport: ${LB_MISSING_PORT}\nbackends:\n  - url: http://backend1:9001\n")
+    if _, err := config.Load(path); err == nil || !strings.Contains(err.Error(), "LB_MISSING_PORT (line 2)") ||
+        strings.Contains(err.Error(), "COMMENTED_OUT") {
+        t.Errorf("Expected an error naming the unset variable and its line, got %v", err)
+    }
+}
+
+func TestRoundRobin_PerBackendHealthCheckPathAndStatuses(t *testing.T) {
+    logger := zap.NewNop().Sugar()
+    // Отвечает на readiness-проверку 204 по /healthz; /health у него нет
+    readiness := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/healthz" {
+            w.WriteHeader(http.StatusNoContent)
+            return
+        }
+        w.WriteHeader(http.StatusNotFound)
+    }))
+    defer readiness.Close()
+    classic := healthyBackend(t)
+
+    lb := balancer.NewRoundRobinLoadBalancer(nil, config.HealthCheckConfig{}, logger)
+    err := lb.ReplaceBackends([]config.BackendConfig{
+        {URL: classic.URL},
+        {URL: readiness.URL, HealthCheck: &config.BackendHealthCheck{Path: "/healthz", HealthyStatuses: []int{204}}},
+        // Тот же backend без переопределения: /health отвечает 404
+        {URL: readiness.URL + "/"},
+    })
+    if err != nil {
+        t.Fatalf("ReplaceBackends failed: %v", err)
+    }
+
+    seen := make(map[string]bool)
+    for i := 0; i < 6; i++ {
+        if backend := lb.NextAvailableBackend(); backend != nil {
+            seen[backend.Address.String()] = true
+        }
+    }
+    if !seen[classic.URL] || !seen[readiness.URL] || seen[readiness.URL+"/"] {
+        t.Errorf("Expected the override to apply only to its backend, got healthy %v", seen)
+    }
+}
+
+func TestRoundRobin_ConfigurableHealthCheckInterval(t *testing.T) {
+    logger := zap.NewNop().Sugar()
+    var down atomic.Bool
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if down.Load() {
+            w.WriteHeader(http.StatusServiceUnavailable)
+        }
+    }))
+    defer server.Close()
+
+    interval := 50 * time.Millisecond
+    lb := balancer.NewRoundRobinLoadBalancer([]config.BackendConfig{{URL: server.URL}},
+        config.HealthCheckConfig{Interval: &interval, Timeout: 20 * time.Millisecond}, logger)
+    down.Store(true)
+    deadline := time.Now().Add(time.Second)
+    for lb.NextAvailableBackend() != nil {
+        if time.Now().After(deadline) {
+            t.Fatal("Failure not detected within a second with a 50ms interval")
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+
+    // Интервал 0 выключает проверки: backend остается в ротации даже после ошибок
+    disabled := time.Duration(0)
+    lb = balancer.NewRoundRobinLoadBalancer([]config.BackendConfig{{URL: server.URL}},
+        config.HealthCheckConfig{Interval: &disabled}, logger)
+    lb.MarkBackendUnhealthy(backendURL(t, server.URL))
+    if lb.NextAvailableBackend() == nil {
+        t.Error("Expected backend to stay in rotation with health checks disabled")
+    }
+
+    path := filepath.Join(t.TempDir(), "config.yaml")
+    if err := os.WriteFile(path, []byte("health_check:\n  interval: 1s\n  timeout: 2s\n"), 0o600); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := config.Load(path); err == nil || !strings.Contains(err.Error(), "health_check.interval") {
+        t.Errorf("Expected interval <= timeout to be rejected, got %v", err)
+    }
+}
+
+func TestRoundRobin_TCPHealthCheck(t *testing.T) {
+    logger := zap.NewNop().Sugar()
+    // Обычный TCP-сервис: на HTTP-запрос /health не отвечает
+    listener, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    go func() {
+        for {
+            conn, err := listener.Accept()
+            if err != nil {
+                return
+            }
+            conn.Close()
+        }
+    }()
+
+    interval := 50 * time.Millisecond
+    lb := balancer.NewRoundRobinLoadBalancer([]config.BackendConfig{{URL: "http://" + listener.Addr().String()}},
+        config.HealthCheckConfig{Type: config.HealthCheckTCP, Interval: &interval, Timeout: 20 * time.Millisecond}, logger)
+    time.Sleep(3 * interval)
+    if lb.NextAvailableBackend() == nil {
+        t.Fatal("Expected TCP backend accepting connections to stay healthy")
+    }
+
+    listener.Close()
+    deadline := time.Now().Add(time.Second)
+    for lb.NextAvailableBackend() != nil {
+        if time.Now().After(deadline) {
+            t.Fatal("Closed TCP backend not detected within a second")
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+}
+
+func TestRoundRobin_HealthCheckExpectedBody(t *testing.T) {
+    logger := zap.NewNop().Sugar()
+    var degraded atomic.Bool
+    // Деградировавший backend продолжает отвечать 200, но сообщает статус в теле
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if degraded.Load() {
+            w.Write([]byte(`{"status":"degraded"}`))
+            return
+        }
+        w.Write([]byte(`{"status":"ok"}`))
+    }))
+    defer server.Close()
+
+    interval := 50 * time.Millisecond
+    for _, hc := range []config.HealthCheckConfig{
+        {ExpectedBody: `"status":"ok"`, Interval: &interval, Timeout: 20 * time.Millisecond},
+        {ExpectedBodyRegex: `"status"\s*:\s*"ok"`, Interval: &interval, Timeout: 20 * time.Millisecond},
+    } {
+        degraded.Store(false)
+        lb := balancer.NewRoundRobinLoadBalancer([]config.BackendConfig{{URL: server.URL}}, hc, logger)
+        time.Sleep(3 * interval)
+        if lb.NextAvailableBackend() == nil {
+            t.Fatal("Expected backend with matching body to be healthy")
+        }
+
+        degraded.Store(true)
+        deadline := time.Now().Add(time.Second)
+        for lb.NextAvailableBackend() != nil {
+            if time.Now().After(deadline) {
+                t.Fatal("Degraded backend with status 200 not marked unhealthy")
+            }
+            time.Sleep(10 * time.Millisecond)
+        }
+    }
+}
+
+func TestRoundRobin_HealthCheckBackoff(t *testing.T) {
+    logger := zap.NewNop().Sugar()
+    var healthy atomic.Bool
+    var probes atomic.Int32
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        probes.Add(1)
+        if !healthy.Load() {
+            w.WriteHeader(http.StatusServiceUnavailable)
+        }
+    }))
+    defer server.Close()
+
+    interval := 20 * time.Millisecond
+    lb := balancer.NewRoundRobinLoadBalancer([]config.BackendConfig{{URL: server.URL}}, config.HealthCheckConfig{
+        Interval: &interval,
+        Timeout:  10 * time.Millisecond,
+        Backoff:  config.HealthCheckBackoffConfig{Max: 160 * time.Millisecond, Multiplier: 2},
+    }, logger)
+
+    // Без backoff'а за 600ms было бы около 30 проверок; паузы 20, 40, 80, 160, 160... дают около 7
+    time.Sleep(600 * time.Millisecond)
+    if n := probes.Load(); n < 3 || n > 15 {
+        t.Errorf("Expected backoff to reduce probes of a dead backend to about 7, got %d", n)
+    }
+    status := lb.Snapshot()[0]
+    if status.Failures < 3 || status.NextCheck == nil {
+        t.Errorf("Expected snapshot to report consecutive failures and next check, got %d, %v", status.Failures, status.NextCheck)
+    }
+
+    // Восстановление обнаруживается не позже max, после чего проверки снова идут каждый интервал
+    healthy.Store(true)
+    deadline := time.Now().Add(time.Second)
+    for lb.NextAvailableBackend() == nil {
+        if time.Now().After(deadline) {
+            t.Fatal("Recovered backend not detected within a second")
+        }
+        time.Sleep(5 * time.Millisecond)
+    }
+    before := probes.Load()
+    time.Sleep(200 * time.Millisecond)
+    if n := probes.Load() - before; n < 5 {
+        t.Errorf("Expected probes every interval after recovery, got %d in 200ms", n)
+    }
+}
+
+func TestCircuitBreaker_OpenHalfOpenClosed(t *testing.T) {
+    lb := balancer.NewRoundRobinLoadBalancer([]config.BackendConfig{
+        {URL: "http://backend1:9001"},
+        {URL: "http://backend2:9002"},
+    }, config.HealthCheckConfig{}, zap.NewNop().Sugar())
+    lb.ConfigureCircuitBreaker(config.CircuitBreakerConfig{Enabled: true, FailureThreshold: 2, Cooldown: 50 * time.Millisecond})
+
+    flaky := lb.Backends()[0]
+    breaker := flaky.Breaker()
+    countFlaky := func(n int) int {
+        count := 0
+        for i := 0; i < n; i++ {
+            if lb.NextAvailableBackend() == flaky {
+                count++
+            }
+        }
+        return count
+    }
+
+    if breaker.Failure() || !breaker.Failure() {
+        t.Fatal("Expected the breaker to open exactly at the threshold")
+    }
+    if breaker.State() != balancer.BreakerOpen || countFlaky(6) != 0 {
+        t.Fatalf("Open breaker must exclude the backend, state %s", breaker.State())
+    }
+
+    // После cooldown пропускается ровно один пробный запрос
+    time.Sleep(60 * time.Millisecond)
+    if breaker.State() != balancer.BreakerHalfOpen {
+        t.Fatalf("Expected half_open after cooldown, got %s", breaker.State())
+    }
+    if trials := countFlaky(6); trials != 1 {
+        t.Fatalf("Expected a single trial request in half-open, got %d", trials)
+    }
+    // Неудачная проба снова открывает breaker
+    if !breaker.Failure() || countFlaky(6) != 0 {
+        t.Fatal("Failed trial must reopen the breaker")
+    }
+
+    time.Sleep(60 * time.Millisecond)
+    if countFlaky(6) != 1 {
+        t.Fatal("Expected a new trial after the second cooldown")
+    }
+    if !breaker.Success() || breaker.State() != balancer.BreakerClosed {
+        t.Fatalf("Successful trial must close the breaker, got %s", breaker.State())
+    }
+    if countFlaky(6) != 3 {
+        t.Error("Closed breaker must return the backend to regular rotation")
+    }
+}
+
+func TestOutlierDetection_EjectsFailingBackend(t *testing.T) {
+    lb := balancer.NewRoundRobinLoadBalancer([]config.BackendConfig{
+        {URL: "http://backend1:9001"},
+        {URL: "http://backend2:9002"},
+        {URL: "http://backend3:9003"},
+    }, config.HealthCheckConfig{}, zap.NewNop().Sugar())
+    lb.ConfigureOutlierDetection(config.OutlierDetectionConfig{
+        Enabled:              true,
+        Interval:             50 * time.Millisecond,
+        Consecutive5xx:       3,
+        SuccessRateThreshold: 0.9,
+        MinRequests:          20,
+        EjectionTime:         150 * time.Millisecond,
+        MaxEjectionPercent:   50,
+    })
+    backends := lb.Backends()
+    picked := func(target *balancer.Backend) bool {
+        for i := 0; i < 6; i++ {
+            if lb.NextAvailableBackend() == target {
+                return true
+            }
+        }
+        return false
+    }
+
+    for i := 0; i < 3; i++ {
+        backends[0].ObserveOutcome(false)
+    }
+    if _, ejected := backends[0].Ejected(); !ejected || picked(backends[0]) {
+        t.Fatal("Expected the backend to be ejected after consecutive_5xx failures")
+    }
+    // Второе исключение превысило бы max_ejection_percent: 2 из 3 backend'ов
+    for i := 0; i < 3; i++ {
+        backends[1].ObserveOutcome(false)
+    }
+    if _, ejected := backends[1].Ejected(); ejected {
+        t.Error("Expected max_ejection_percent to keep the second backend in rotation")
+    }
+
+    time.Sleep(200 * time.Millisecond)
+    if !picked(backends[0]) {
+        t.Fatal("Expected the backend to return after ejection_time")
+    }
+
+    // Успешность 60% против 100% у остальных — ниже порога 0.9 от средней
+    for i := 0; i < 50; i++ {
+        backends[0].ObserveOutcome(true)
+        backends[1].ObserveOutcome(true)
+        backends[2].ObserveOutcome(i%5 >= 2)
+    }
+    time.Sleep(80 * time.Millisecond)
+    if _, ejected := backends[2].Ejected(); !ejected {
+        t.Fatal("Expected the backend with a low success rate to be ejected")
+    }
+    if status := lb.Snapshot()[2]; status.EjectedUntil == nil {
+        t.Errorf("Expected ejected_until in the snapshot, got %+v", status)
+    }
+    if _, ejected := backends[0].Ejected(); ejected {
+        t.Error("Healthy backends must stay in rotation")
+    }
+}
+
+func TestConfig_ValidateReportsAllErrors(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "config.yaml")
+    write := func(content string) {
+        if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    write("port: 0\nbackends:\n  - \"backend1:9001\"\n  - \"http://ok:9002\"\nrate_limit:\n  capacity: -1\n  refill_rate: -5\n")
+    _, err := config.Load(path)
+    if err == nil {
+        t.Fatal("Expected invalid config to be rejected")
+    }
+    for _, field := range []string{"port", "backends[0].url", "rate_limit.capacity", "rate_limit.refill_rate"} {
+        if !strings.Contains(err.Error(), field) {
+            t.Errorf("Expected error to name %s, got:\n%v", field, err)
+        }
+    }
+    if strings.Contains(err.Error(), "backends[1]") {
+        t.Errorf("Valid backend reported as invalid:\n%v", err)
+    }
+
+    write("port: 8080\n")
+    if _, err := config.Load(path); err == nil || !strings.Contains(err.Error(), "at least one backend") {
+        t.Errorf("Expected missing backends to be rejected, got %v", err)
+    }
+
+    write("port: 8080\nbackends:\n  - \"http://backend1:9001\"\n")
+    if _, err := config.Load(path); err != nil {
+        t.Errorf("Expected minimal config to be valid, got %v", err)
+    }
+}
@@ -0,0 +1,3118 @@
+package integration
+
+import (
+    "bufio"
+    "bytes"
+    "compress/gzip"
+    "context"
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha1"
+    "crypto/sha256"
+    "crypto/tls"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/base64"
+    "encoding/hex"
+    "encoding/json"
+    "encoding/pem"
+    "fmt"
+    "io"
+    "math/big"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/Manzo48/loadBalancer/internal/balancer"
+    "github.com/Manzo48/loadBalancer/internal/capture"
+    "github.com/Manzo48/loadBalancer/internal/config"
+    applog "github.com/Manzo48/loadBalancer/internal/log"
+    "github.com/Manzo48/loadBalancer/internal/metrics"
+    "github.com/Manzo48/loadBalancer/internal/proxy"
+    "go.uber.org/zap"
+    "go.uber.org/zap/zaptest/observer"
+)
+
+// newTestProxy создает ProxyServer с одним backend'ом и щедрым rate limit.
+func newTestProxy(t *testing.T, backendURL string, configure func(cfg *config.Config)) *proxy.ProxyServer {
+    t.Helper()
+    cfg := &config.Config{Port: 8080, Backends: []config.BackendConfig{{URL: backendURL}}}
+    cfg.RateLimit.Capacity = 1000
+    cfg.RateLimit.RefillRate = 1000
+    if configure != nil {
+        configure(cfg)
+    }
+    return proxy.NewProxyServer(cfg, zap.NewNop().Sugar())
+}
+
+// gzipBackend отдает тело, сжатое gzip, если клиент его принимает.
+func gzipBackend(t *testing.T, body string) *httptest.Server {
+    t.Helper()
+    return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Encoding", "gzip")
+        gz := gzip.NewWriter(w)
+        gz.Write([]byte(body))
+        gz.Close()
+    }))
+}
+
+func upperCaseTransform(_ *http.Response, body []byte) ([]byte, error) {
+    return bytes.ToUpper(body), nil
+}
+
+func TestProxy_TransformGzipResponse(t *testing.T) {
+    backend := gzipBackend(t, "hello from backend")
+    defer backend.Close()
+
+    lb := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+        cfg.Transform.DecompressGzip = true
+    })
+    lb.AddResponseTransform(upperCaseTransform)
+    handler := lb.Handler()
+
+    // Клиент принимает gzip — ответ должен быть сжат заново
+    req := httptest.NewRequest(http.MethodGet, "/", nil)
+    req.Header.Set("Accept-Encoding", "gzip")
+    rec := httptest.NewRecorder()
+    handler.ServeHTTP(rec, req)
+
+    if got := rec.Header().Get("Content-Encoding"); got != "gzip" {
+        t.Fatalf("Expected gzip Content-Encoding, got %q", got)
+    }
+    reader, err := gzip.NewReader(rec.Body)
+    if err != nil {
+        t.Fatalf("Response is not valid gzip: %v", err)
+    }
+    body, _ := io.ReadAll(reader)
+    if string(body) != "HELLO FROM BACKEND" {
+        t.Errorf("Unexpected transformed body: %q", body)
+    }
+
+    // Клиент не принимает gzip — ответ должен быть открытым текстом
+    req = httptest.NewRequest(http.MethodGet, "/", nil)
+    req.Header.Set("Accept-Encoding", "identity")
+    rec = httptest.NewRecorder()
+    handler.ServeHTTP(rec, req)
+
+    if got := rec.Header().Get("Content-Encoding"); got != "" {
+        t.Errorf("Expected no Content-Encoding, got %q", got)
+    }
+    if rec.Body.String() != "HELLO FROM BACKEND" {
+        t.Errorf("Unexpected plain body: %q", rec.Body.String())
+    }
+}
+
+func TestProxy_TransformSkipsUnsupportedEncoding(t *testing.T) {
+    backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Encoding", "br")
+        w.Write([]byte("opaque-bytes"))
+    }))
+    defer backend.Close()
+
+    lb := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+        cfg.Transform.DecompressGzip = true
+    })
+    lb.AddResponseTransform(upperCaseTransform)
+
+    req := httptest.NewRequest(http.MethodGet, "/", nil)
+    req.Header.Set("Accept-Encoding", "br")
+    rec := httptest.NewRecorder()
+    lb.Handler().ServeHTTP(rec, req)
+
+    if got := rec.Header().Get("Content-Encoding"); got != "br" {
+        t.Errorf("Expected br Content-Encoding to pass through, got %q", got)
+    }
+    if rec.Body.String() != "opaque-bytes" {
+        t.Errorf("Body must pass through untouched, got %q", rec.Body.String())
+    }
+}
+
+func TestProxy_RequiredHeaders(t *testing.T) {
+    backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte("ok"))
+    }))
+    defer backend.Close()
+
+    lb := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+        cfg.RequiredHeaders = []config.RequiredHeader{
+            {Name: "X-Tenant-ID", Pattern: "^[a-z0-9-]+$"},
+        }
+    })
+    handler := lb.Handler()
+
+    tests := []struct {
+        name   string
+        value  string
+        status int
+    }{
+        {name: "present", value: "tenant-42", status: http.StatusOK},
+        {name: "absent", value: "", status: http.StatusBadRequest},
+        {name: "malformed", value: "Tenant 42!", status: http.StatusBadRequest},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            req := httptest.NewRequest(http.MethodGet, "/", nil)
+            if tt.value != "" {
+                req.Header.Set("X-Tenant-ID", tt.value)
+            }
+            rec := httptest.NewRecorder()
+            handler.ServeHTTP(rec, req)
+
+            if rec.Code != tt.status {
+                t.Errorf("Expected status %d, got %d", tt.status, rec.Code)
+            }
+        })
+    }
+}
+
+func TestProxy_PathBackendKeepsBasePath(t *testing.T) {
+    backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte(r.URL.Path))
+    }))
+    defer backend.Close()
+
+    lb := newTestProxy(t, backend.URL+"/service-b", nil)
+    rec := httptest.NewRecorder()
+    lb.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users/1", nil))
+
+    if rec.Body.String() != "/service-b/users/1" {
+        t.Errorf("Expected request to be forwarded under the backend base path, got %q", rec.Body.String())
+    }
+}
+
+func TestProxy_UpstreamErrorStatusCodes(t *testing.T) {
+    refused := httptest.NewServer(http.NotFoundHandler())
+    refused.Close()
+    plain := echoBackend("plain")
+    defer plain.Close()
+    garbage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if conn, _, err := http.NewResponseController(w).Hijack(); err == nil {
+            conn.Write([]byte("not http at all\r\n\r\n"))
+            conn.Close()
+        }
+    }))
+    defer garbage.Close()
+    slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        select {
+        case <-r.Context().Done():
+        case <-time.After(2 * time.Second):
+        }
+    }))
+    defer slow.Close()
+
+    cases := []struct {
+        name     string
+        backends []config.BackendConfig
+        status   int
+        message  string
+    }{
+        {"connection refused", []config.BackendConfig{{URL: refused.URL}}, http.StatusBadGateway, "Backend connection failed"},
+        {"TLS handshake", []config.BackendConfig{{URL: strings.Replace(plain.URL, "http://", "https://", 1)}}, http.StatusBadGateway, "Backend TLS handshake failed"},
+        {"malformed response", []config.BackendConfig{{URL: garbage.URL}}, http.StatusBadGateway, "Invalid response from backend"},
+        {"timeout", []config.BackendConfig{{URL: slow.URL, Timeout: 50 * time.Millisecond}}, http.StatusGatewayTimeout, "Backend timeout"},
+        {"no backends", nil, http.StatusServiceUnavailable, "No available backends"},
+    }
+    for _, tc := range cases {
+        lb := newTestProxy(t, "", func(cfg *config.Config) {
+            cfg.Backends = tc.backends
+        })
+        rec := httptest.NewRecorder()
+        lb.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+        if rec.Code != tc.status || !strings.Contains(rec.Body.String(), fmt.Sprintf(`"code":%d`, tc.status)) || !strings.Contains(rec.Body.String(), tc.message) {
+            t.Errorf("%s: expected JSON %d %q, got %d %s", tc.name, tc.status, tc.message, rec.Code, rec.Body.String())
+        }
+    }
+}
+
+func TestProxy_RetryAfterWhenAllBackendsDown(t *testing.T) {
+    backend := httptest.NewServer(http.NotFoundHandler())
+    backend.Close()
+
+    lb := newTestProxy(t, backend.URL, nil)
+    handler := lb.Handler()
+
+    // Первый запрос помечает backend недоступным, второй получает 503 без попытки проксирования
+    handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+    rec := httptest.NewRecorder()
+    handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+    if rec.Code != http.StatusServiceUnavailable {
+        t.Fatalf("Expected 503, got %d", rec.Code)
+    }
+    retryAfter, err := strconv.Atoi(rec.Header().Get("Retry-After"))
+    if err != nil || retryAfter < 1 || retryAfter > 12 {
+        t.Errorf("Expected Retry-After derived from the next health check, got %q", rec.Header().Get("Retry-After"))
+    }
+}
+
+func TestProxy_SignsRequestsToBackend(t *testing.T) {
+    const secret = "shared-secret"
+    signatures := make(chan string, 1)
+    backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        signatures <- r.Method + " " + r.URL.Path + " " + r.Header.Get("X-LB-Signature")
+    }))
+    defer backend.Close()
+
+    lb := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+        cfg.Backends[0].SignRequests = true
+        cfg.RequestSigning.Secret = secret
+    })
+    req := httptest.NewRequest(http.MethodPost, "/orders?id=1", nil)
+    req.Header.Set("X-LB-Signature", "forged")
+    lb.Handler().ServeHTTP(httptest.NewRecorder(), req)
+
+    var method, path, header string
+    fmt.Sscanf(<-signatures, "%s %s %s", &method, &path, &header)
+
+    var timestamp int64
+    var nonce, signature string
+    parts := strings.Split(header, ",")
+    if len(parts) != 3 {
+        t.Fatalf("Unexpected signature header format: %q", header)
+    }
+    fmt.Sscanf(parts[0], "t=%d", &timestamp)
+    nonce = strings.TrimPrefix(parts[1], "n=")
+    signature = strings.TrimPrefix(parts[2], "s=")
+
+    // Проверка, которую выполняет backend
+    mac := hmac.New(sha256.New, []byte(secret))
+    fmt.Fprintf(mac, "%s\n%s\n%d\n%s", method, path, timestamp, nonce)
+    expected := hex.EncodeToString(mac.Sum(nil))
+    if !hmac.Equal([]byte(signature), []byte(expected)) {
+        t.Errorf("Signature mismatch: got %s, expected %s", signature, expected)
+    }
+    if time.Since(time.Unix(timestamp, 0)) > time.Minute {
+        t.Errorf("Signature timestamp is stale: %d", timestamp)
+    }
+}
+
+func TestProxy_OptionsModes(t *testing.T) {
+    var backendHits atomic.Int32
+    backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        backendHits.Add(1)
+        w.WriteHeader(http.StatusOK)
+    }))
+    defer backend.Close()
+
+    t.Run("respond", func(t *testing.T) {
+        backendHits.Store(0)
+        lb := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+            cfg.Options.Mode = "respond"
+            cfg.Options.AllowedMethods = []string{"GET", "POST"}
+        })
+        handler := lb.Handler()
+
+        for _, target := range []string{"*", "/users"} {
+            rec := httptest.NewRecorder()
+            handler.ServeHTTP(rec, httptest.NewRequest(http.MethodOptions, target, nil))
+            if rec.Code != http.StatusNoContent || rec.Header().Get("Allow") != "GET, POST" {
+                t.Errorf("OPTIONS %s: expected 204 with Allow, got %d %q", target, rec.Code, rec.Header().Get("Allow"))
+            }
+        }
+        if backendHits.Load() != 0 {
+            t.Errorf("Expected OPTIONS to be answered without the backend, got %d backend hits", backendHits.Load())
+        }
+
+        // CORS preflight не отвечается автоматически
+        req := httptest.NewRequest(http.MethodOptions, "/users", nil)
+        req.Header.Set("Origin", "https://app.example.com")
+        req.Header.Set("Access-Control-Request-Method", "POST")
+        handler.ServeHTTP(httptest.NewRecorder(), req)
+        if backendHits.Load() != 1 {
+            t.Errorf("Expected CORS preflight to be passed through")
+        }
+    })
+
+    t.Run("passthrough", func(t *testing.T) {
+        backendHits.Store(0)
+        lb := newTestProxy(t, backend.URL, nil)
+        rec := httptest.NewRecorder()
+        lb.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodOptions, "/users", nil))
+
+        if rec.Code != http.StatusOK || backendHits.Load() != 1 {
+            t.Errorf("Expected OPTIONS to reach the backend, got status %d and %d hits", rec.Code, backendHits.Load())
+        }
+    })
+}
+
+func TestStatsD_BatchesMetricsIntoPackets(t *testing.T) {
+    listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatalf("listen udp: %v", err)
+    }
+    defer listener.Close()
+
+    client, err := metrics.NewStatsD(listener.LocalAddr().String(), metrics.StatsDOptions{
+        Prefix:        "lb.",
+        FlushInterval: time.Hour, // отправка только по Flush
+        Tags:          true,
+    })
+    if err != nil {
+        t.Fatalf("NewStatsD: %v", err)
+    }
+    defer client.Close()
+
+    client.Inc("lb_requests_total", "backend", "a")
+    client.Set("lb_standby_active", 1)
+    client.Observe("lb_request_duration_seconds", 0.25)
+    client.Flush()
+
+    buf := make([]byte, 2048)
+    listener.SetReadDeadline(time.Now().Add(2 * time.Second))
+    n, _, err := listener.ReadFrom(buf)
+    if err != nil {
+        t.Fatalf("read packet: %v", err)
+    }
+
+    expected := "lb.lb_requests_total:1|c|#backend:a\n" +
+        "lb.lb_standby_active:1|g\n" +
+        "lb.lb_request_duration_seconds:250|ms"
+    if got := string(buf[:n]); got != expected {
+        t.Errorf("Expected single batched packet:\n%s\ngot:\n%s", expected, got)
+    }
+}
+
+// echoBackend отвечает своим именем и полученным телом запроса.
+func echoBackend(name string) *httptest.Server {
+    return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        body, _ := io.ReadAll(r.Body)
+        fmt.Fprintf(w, "%s:%s", name, body)
+    }))
+}
+
+func TestProxy_BodyRoutingByJSONField(t *testing.T) {
+    defaultBackend := echoBackend("default")
+    defer defaultBackend.Close()
+    rpcBackend := echoBackend("rpc")
+    defer rpcBackend.Close()
+
+    lb := newTestProxy(t, defaultBackend.URL, func(cfg *config.Config) {
+        cfg.BodyRouting = config.BodyRoutingConfig{
+            Enabled:      true,
+            Field:        "method",
+            MaxPeekBytes: 64,
+            Routes: []config.BodyRoute{
+                {Values: []string{"eth_call"}, Backends: []config.BackendConfig{{URL: rpcBackend.URL}}},
+            },
+        }
+    })
+    handler := lb.Handler()
+
+    cases := []struct {
+        name     string
+        body     string
+        expected string
+    }{
+        {"matching method", `{"method":"eth_call","id":1}`, `rpc:{"method":"eth_call","id":1}`},
+        {"other method", `{"method":"eth_send","id":1}`, `default:{"method":"eth_send","id":1}`},
+        {"not JSON", `method=eth_call`, `default:method=eth_call`},
+        {"oversized", `{"method":"eth_call","pad":"` + strings.Repeat("x", 100) + `"}`,
+            `default:{"method":"eth_call","pad":"` + strings.Repeat("x", 100) + `"}`},
+    }
+    for _, tc := range cases {
+        req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tc.body))
+        req.ContentLength = -1 // размер заранее неизвестен — проверяется ограничение чтения
+        rec := httptest.NewRecorder()
+        handler.ServeHTTP(rec, req)
+
+        if rec.Body.String() != tc.expected {
+            t.Errorf("%s: expected %q, got %q", tc.name, tc.expected, rec.Body.String())
+        }
+    }
+}
+
+func TestProxy_LiveWeightChangeDoesNotDropRequests(t *testing.T) {
+    var hitsA, hitsB atomic.Int64
+    backendA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { hitsA.Add(1) }))
+    defer backendA.Close()
+    backendB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { hitsB.Add(1) }))
+    defer backendB.Close()
+
+    lb := newTestProxy(t, backendA.URL, func(cfg *config.Config) {
+        cfg.Strategy = "weighted_round_robin"
+        cfg.Backends = append(cfg.Backends, config.BackendConfig{URL: backendB.URL})
+        cfg.Admin.Tokens = map[string]string{"alice": "secret"}
+        // Запросы идут без пауз; лимит не должен срабатывать даже на быстрой машине
+        cfg.RateLimit.Capacity = 1 << 30
+    })
+    handler, admin := lb.Handler(), lb.AdminHandler()
+
+    stop := make(chan struct{})
+    var failed atomic.Int64
+    var wg sync.WaitGroup
+    for i := 0; i < 4; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for {
+                select {
+                case <-stop:
+                    return
+                default:
+                }
+                rec := httptest.NewRecorder()
+                handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+                if rec.Code != http.StatusOK {
+                    failed.Add(1)
+                }
+            }
+        }()
+    }
+
+    time.Sleep(50 * time.Millisecond)
+    req := httptest.NewRequest(http.MethodPatch, "/admin/backends/"+url.PathEscape(backendB.URL), strings.NewReader(`{"weight": 0}`))
+    req.Header.Set("Authorization", "Bearer secret")
+    rec := httptest.NewRecorder()
+    admin.ServeHTTP(rec, req)
+    if rec.Code != http.StatusOK {
+        t.Fatalf("PATCH weight: expected 200, got %d: %s", rec.Code, rec.Body.String())
+    }
+    hitsAfterPatch := hitsB.Load()
+
+    time.Sleep(50 * time.Millisecond)
+    close(stop)
+    wg.Wait()
+
+    if failed.Load() != 0 {
+        t.Errorf("Expected no failed requests during weight change, got %d", failed.Load())
+    }
+    // Запросы, выбранные до PATCH, могут еще дорабатывать — допускаем их
+    if extra := hitsB.Load() - hitsAfterPatch; extra > 4 {
+        t.Errorf("Backend with weight 0 still received %d new requests", extra)
+    }
+    if hitsA.Load() == 0 {
+        t.Error("Expected backend A to keep serving traffic")
+    }
+}
+
+func TestProxy_SensitiveHeaders(t *testing.T) {
+    var received atomic.Value
+    backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        received.Store(r.Header.Get("Authorization"))
+    }))
+    defer backend.Close()
+
+    cases := []struct {
+        name      string
+        configure func(cfg *config.Config)
+        expected  string
+    }{
+        {"default preserve", nil, "Bearer client-token"},
+        {"global strip", func(cfg *config.Config) {
+            cfg.SensitiveHeaders = config.SensitiveHeadersConfig{Mode: "strip"}
+        }, ""},
+        {"global replace", func(cfg *config.Config) {
+            cfg.SensitiveHeaders = config.SensitiveHeadersConfig{Mode: "replace", Value: "Bearer internal"}
+        }, "Bearer internal"},
+        {"backend overrides global", func(cfg *config.Config) {
+            cfg.SensitiveHeaders = config.SensitiveHeadersConfig{Mode: "strip"}
+            cfg.Backends[0].SensitiveHeaders = &config.SensitiveHeadersConfig{Mode: "preserve"}
+        }, "Bearer client-token"},
+    }
+    for _, tc := range cases {
+        received.Store("<not called>")
+        lb := newTestProxy(t, backend.URL, tc.configure)
+
+        req := httptest.NewRequest(http.MethodGet, "/", nil)
+        req.Header.Set("Authorization", "Bearer client-token")
+        lb.Handler().ServeHTTP(httptest.NewRecorder(), req)
+
+        if got := received.Load().(string); got != tc.expected {
+            t.Errorf("%s: backend received Authorization %q, expected %q", tc.name, got, tc.expected)
+        }
+    }
+}
+
+func TestProxy_StrippedAuthorizationIsNotLogged(t *testing.T) {
+    backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+    defer backend.Close()
+
+    core, logs := observer.New(zap.DebugLevel)
+    cfg := &config.Config{Port: 8080, Backends: []config.BackendConfig{{URL: backend.URL}}}
+    cfg.RateLimit.Capacity = 1000
+    cfg.RateLimit.RefillRate = 1000
+    cfg.SensitiveHeaders = config.SensitiveHeadersConfig{Mode: "strip"}
+    lb := proxy.NewProxyServer(cfg, zap.New(core).Sugar())
+
+    req := httptest.NewRequest(http.MethodGet, "/", nil)
+    req.Header.Set("Authorization", "Bearer very-secret-token")
+    lb.Handler().ServeHTTP(httptest.NewRecorder(), req)
+
+    for _, entry := range logs.All() {
+        if strings.Contains(fmt.Sprint(entry.Message, entry.ContextMap()), "very-secret-token") {
+            t.Errorf("Authorization value leaked into log entry: %q", entry.Message)
+        }
+    }
+}
+
+func TestProxy_AdminSelfTest(t *testing.T) {
+    var smokePath atomic.Value
+    good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        smokePath.Store(r.Method + " " + r.URL.Path)
+    }))
+    defer good.Close()
+    bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(http.StatusInternalServerError)
+    }))
+    defer bad.Close()
+
+    lb := newTestProxy(t, good.URL, func(cfg *config.Config) {
+        cfg.Backends = append(cfg.Backends, config.BackendConfig{URL: bad.URL})
+        cfg.Admin.Tokens = map[string]string{"alice": "secret"}
+        cfg.SelfTest = config.SelfTestConfig{Method: http.MethodHead, Path: "/smoke", Timeout: time.Second}
+    })
+    admin := lb.AdminHandler()
+
+    rec := httptest.NewRecorder()
+    admin.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/selftest", nil))
+    if rec.Code != http.StatusUnauthorized {
+        t.Fatalf("Expected 401 without token, got %d", rec.Code)
+    }
+
+    req := httptest.NewRequest(http.MethodPost, "/admin/selftest", nil)
+    req.Header.Set("Authorization", "Bearer secret")
+    rec = httptest.NewRecorder()
+    admin.ServeHTTP(rec, req)
+
+    var report struct {
+        OK       bool `json:"ok"`
+        Backends []struct {
+            Backend string `json:"backend"`
+            OK      bool   `json:"ok"`
+            Status  int    `json:"status"`
+        } `json:"backends"`
+    }
+    if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+        t.Fatalf("Invalid report: %v", err)
+    }
+    if report.OK || len(report.Backends) != 2 {
+        t.Fatalf("Expected failed report for 2 backends, got %+v", report)
+    }
+    if !report.Backends[0].OK || report.Backends[0].Status != http.StatusOK {
+        t.Errorf("Expected good backend to pass, got %+v", report.Backends[0])
+    }
+    if report.Backends[1].OK || report.Backends[1].Status != http.StatusInternalServerError {
+        t.Errorf("Expected bad backend to fail with 500, got %+v", report.Backends[1])
+    }
+    if got := smokePath.Load(); got != "HEAD /smoke" {
+        t.Errorf("Expected synthetic HEAD /smoke, got %v", got)
+    }
+
+    // Без update_health состояние backend'ов не меняется: запросы по-прежнему идут на оба
+    rec = httptest.NewRecorder()
+    lb.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+    rec2 := httptest.NewRecorder()
+    lb.Handler().ServeHTTP(rec2, httptest.NewRequest(http.MethodGet, "/", nil))
+    if rec.Code == rec2.Code {
+        t.Errorf("Expected both backends to stay in rotation, got %d and %d", rec.Code, rec2.Code)
+    }
+}
+
+func TestProxy_BackendClosesConnectionMidBody(t *testing.T) {
+    backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        conn, buf, err := http.NewResponseController(w).Hijack()
+        if err != nil {
+            t.Errorf("hijack: %v", err)
+            return
+        }
+        // Обещаем 100 байт, отправляем 10 и закрываем соединение
+        buf.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 100\r\n\r\npartial-10")
+        buf.Flush()
+        conn.Close()
+    }))
+    defer backend.Close()
+
+    core, logs := observer.New(zap.DebugLevel)
+    cfg := &config.Config{Port: 8080, Backends: []config.BackendConfig{{URL: backend.URL}}}
+    cfg.RateLimit.Capacity = 1000
+    cfg.RateLimit.RefillRate = 1000
+    lb := proxy.NewProxyServer(cfg, zap.New(core).Sugar())
+    server := httptest.NewServer(lb.Handler())
+    defer server.Close()
+
+    // Заголовки могли еще не уйти клиенту (буфер сервера), поэтому обрыв виден
+    // либо как ошибка запроса, либо как ошибка чтения тела — но не как ответ с JSON-ошибкой
+    resp, err := http.Get(server.URL)
+    if err == nil {
+        body, readErr := io.ReadAll(resp.Body)
+        resp.Body.Close()
+        if readErr == nil {
+            t.Errorf("Expected truncated body error, got full body %q", body)
+        }
+        if strings.Contains(string(body), "Backend unavailable") {
+            t.Errorf("JSON error must not be appended to a started response: %q", body)
+        }
+    }
+
+    if logs.FilterMessageSnippet("aborted response after").Len() != 1 {
+        t.Errorf("Expected a single mid-response abort log entry, got %v", logs.All())
+    }
+    if logs.FilterMessageSnippet("Proxy error for backend").Len() != 0 {
+        t.Error("Mid-response failure must be logged separately from pre-response errors")
+    }
+}
+
+// tlsBackendWithName запускает https-backend с самоподписанным сертификатом только для name
+// (без IP в SAN) и возвращает его вместе с путем к PEM сертификата для ca_file.
+func tlsBackendWithName(t *testing.T, name string) (*httptest.Server, string) {
+    t.Helper()
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        t.Fatalf("generate key: %v", err)
+    }
+    template := &x509.Certificate{
+        SerialNumber:          big.NewInt(1),
+        Subject:               pkix.Name{CommonName: name},
+        DNSNames:              []string{name},
+        NotBefore:             time.Now().Add(-time.Hour),
+        NotAfter:              time.Now().Add(time.Hour),
+        KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+        ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+        BasicConstraintsValid: true,
+        IsCA:                  true,
+    }
+    der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+    if err != nil {
+        t.Fatalf("create certificate: %v", err)
+    }
+
+    server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte("tls ok"))
+    }))
+    server.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+    server.StartTLS()
+    t.Cleanup(server.Close)
+
+    caFile := filepath.Join(t.TempDir(), "ca.pem")
+    if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+        t.Fatalf("write CA file: %v", err)
+    }
+    return server, caFile
+}
+
+func TestProxy_UpstreamTLSWithIPMismatchedCert(t *testing.T) {
+    backend, caFile := tlsBackendWithName(t, "backend.internal")
+
+    cases := []struct {
+        name     string
+        tls      *config.UpstreamTLSConfig
+        expected int
+    }{
+        {"IP not in SAN", &config.UpstreamTLSConfig{CAFile: caFile}, http.StatusBadGateway},
+        {"explicit server_name", &config.UpstreamTLSConfig{CAFile: caFile, ServerName: "backend.internal"}, http.StatusOK},
+        {"verify_name without SNI", &config.UpstreamTLSConfig{CAFile: caFile, VerifyName: "backend.internal"}, http.StatusOK},
+        {"wrong verify_name", &config.UpstreamTLSConfig{CAFile: caFile, VerifyName: "other.internal"}, http.StatusBadGateway},
+    }
+    for _, tc := range cases {
+        lb := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+            cfg.Backends[0].TLS = tc.tls
+        })
+        rec := httptest.NewRecorder()
+        lb.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+        if rec.Code != tc.expected {
+            t.Errorf("%s: expected %d, got %d: %s", tc.name, tc.expected, rec.Code, rec.Body.String())
+        }
+    }
+}
+
+func TestProxy_HeaderNormalization(t *testing.T) {
+    var received atomic.Value
+    backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        received.Store(strings.Join(r.Header.Values("Content-Type"), "|"))
+    }))
+    defer backend.Close()
+
+    send := func(policy string) (int, string) {
+        received.Store("<not called>")
+        lb := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+            cfg.HeaderNormalization = []config.HeaderNormalizationRule{{Header: "content-type", Policy: policy}}
+        })
+        req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("{}"))
+        req.Header.Add("Content-Type", "application/json")
+        req.Header.Add("Content-Type", "text/plain")
+        rec := httptest.NewRecorder()
+        lb.Handler().ServeHTTP(rec, req)
+        return rec.Code, received.Load().(string)
+    }
+
+    if code, got := send("first"); code != http.StatusOK || got != "application/json" {
+        t.Errorf("first: expected 200 with application/json, got %d %q", code, got)
+    }
+    if code, got := send("last"); code != http.StatusOK || got != "text/plain" {
+        t.Errorf("last: expected 200 with text/plain, got %d %q", code, got)
+    }
+    if code, got := send("reject"); code != http.StatusBadRequest || got != "<not called>" {
+        t.Errorf("reject: expected 400 without forwarding, got %d %q", code, got)
+    }
+}
+
+func TestProxy_DuplicateHostAndContentLengthRejected(t *testing.T) {
+    backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+    defer backend.Close()
+    server := httptest.NewServer(newTestProxy(t, backend.URL, nil).Handler())
+    defer server.Close()
+
+    rawRequests := map[string]string{
+        "duplicate Host": "GET / HTTP/1.1\r\nHost: a.example\r\nHost: b.example\r\n\r\n",
+        "conflicting Content-Length": "POST / HTTP/1.1\r\nHost: a.example\r\nContent-Length: 2\r\nContent-Length: 3\r\n\r\n{}",
+    }
+    for name, raw := range rawRequests {
+        conn, err := net.Dial("tcp", server.Listener.Addr().String())
+        if err != nil {
+            t.Fatalf("dial: %v", err)
+        }
+        conn.Write([]byte(raw))
+        conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+        status, _ := io.ReadAll(io.LimitReader(conn, 12))
+        conn.Close()
+
+        if string(status) != "HTTP/1.1 400" {
+            t.Errorf("%s: expected 400 from the HTTP server, got %q", name, status)
+        }
+    }
+}
+
+func TestProxy_AdminClientLimitOverrides(t *testing.T) {
+    backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+    defer backend.Close()
+
+    statePath := filepath.Join(t.TempDir(), "buckets.json")
+    configure := func(cfg *config.Config) {
+        cfg.RateLimit.Capacity = 1
+        cfg.RateLimit.RefillRate = 1
+        cfg.RateLimit.Persistence.Path = statePath
+        cfg.Admin.Tokens = map[string]string{"alice": "secret"}
+    }
+    lb := newTestProxy(t, backend.URL, configure)
+    handler, admin := lb.Handler(), lb.AdminHandler()
+
+    allowed := func(handler http.Handler, n int) int {
+        count := 0
+        for i := 0; i < n; i++ {
+            req := httptest.NewRequest(http.MethodGet, "/", nil)
+            req.RemoteAddr = "10.0.0.1:1234"
+            rec := httptest.NewRecorder()
+            handler.ServeHTTP(rec, req)
+            if rec.Code == http.StatusOK {
+                count++
+            }
+        }
+        return count
+    }
+    adminRequest := func(method, body string) int {
+        req := httptest.NewRequest(method, "/admin/ratelimit/clients/10.0.0.1", strings.NewReader(body))
+        req.Header.Set("Authorization", "Bearer secret")
+        rec := httptest.NewRecorder()
+        admin.ServeHTTP(rec, req)
+        return rec.Code
+    }
+
+    // Бакет клиента уже существует и пуст — новый лимит должен примениться к нему сразу
+    allowed(handler, 1)
+    if code := adminRequest(http.MethodPut, `{"capacity": 0, "refill_rate": 1}`); code != http.StatusBadRequest {
+        t.Errorf("Expected 400 for zero capacity, got %d", code)
+    }
+    if code := adminRequest(http.MethodPut, `{"capacity": 100, "refill_rate": 100}`); code != http.StatusOK {
+        t.Fatalf("PUT client limit: expected 200, got %d", code)
+    }
+    time.Sleep(50 * time.Millisecond) // пополнение по новой скорости
+    if got := allowed(handler, 3); got != 3 {
+        t.Errorf("Expected raised limit to apply immediately, %d of 3 allowed", got)
+    }
+
+    // Переопределение переживает рестарт
+    restarted := newTestProxy(t, backend.URL, configure)
+    if got := allowed(restarted.Handler(), 3); got != 3 {
+        t.Errorf("Expected override to be restored after restart, %d of 3 allowed", got)
+    }
+
+    if code := adminRequest(http.MethodDelete, ""); code != http.StatusNoContent {
+        t.Fatalf("DELETE client limit: expected 204, got %d", code)
+    }
+    if got := allowed(handler, 5); got > 1 {
+        t.Errorf("Expected default limit after DELETE, %d of 5 allowed", got)
+    }
+}
+
+func TestProxy_AdminRateLimitClientsRequiresToken(t *testing.T) {
+    backend := echoBackend("a")
+    defer backend.Close()
+
+    lb := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+        cfg.Admin.Tokens = map[string]string{"alice": "secret"}
+        cfg.RateLimit.KeyHeader = "X-API-Key"
+    })
+    req := httptest.NewRequest(http.MethodGet, "/", nil)
+    req.Header.Set("X-API-Key", "sk-live-4242")
+    lb.Handler().ServeHTTP(httptest.NewRecorder(), req)
+
+    admin := lb.AdminHandler()
+    rec := httptest.NewRecorder()
+    admin.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/ratelimit/clients", nil))
+    if rec.Code != http.StatusUnauthorized || strings.Contains(rec.Body.String(), "sk-live-4242") {
+        t.Errorf("Expected 401 without the client key for an anonymous caller, got %d: %s", rec.Code, rec.Body.String())
+    }
+
+    req = httptest.NewRequest(http.MethodGet, "/admin/ratelimit/clients", nil)
+    req.Header.Set("Authorization", "Bearer secret")
+    rec = httptest.NewRecorder()
+    admin.ServeHTTP(rec, req)
+    if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "sk-live-4242") {
+        t.Errorf("Expected the operator to see client buckets, got %d: %s", rec.Code, rec.Body.String())
+    }
+}
+
+func TestProxy_HealthCheckCertExpiry(t *testing.T) {
+    // Сертификат тестового backend'а действует еще час
+    backend, caFile := tlsBackendWithName(t, "backend.internal")
+    newSet := fmt.Sprintf(`{"backends": [{"url": %q, "tls": {"ca_file": %q, "server_name": "backend.internal"}}]}`, backend.URL, caFile)
+
+    replace := func(expiry config.CertExpiryConfig) (http.Handler, int) {
+        lb := newTestProxy(t, "http://127.0.0.1:1", func(cfg *config.Config) {
+            cfg.HealthCheck.CertExpiry = expiry
+            cfg.Admin.Tokens = map[string]string{"alice": "secret"}
+        })
+        admin := lb.AdminHandler()
+        req := httptest.NewRequest(http.MethodPut, "/admin/backends", strings.NewReader(newSet))
+        req.Header.Set("Authorization", "Bearer secret")
+        rec := httptest.NewRecorder()
+        admin.ServeHTTP(rec, req)
+        return admin, rec.Code
+    }
+
+    // Истекает раньше порога fail_before — backend не проходит health-check
+    if _, code := replace(config.CertExpiryConfig{FailBefore: 24 * time.Hour}); code != http.StatusConflict {
+        t.Errorf("Expected backend with expiring cert to fail health check (409), got %d", code)
+    }
+
+    // Только warn_before — backend здоров, срок виден через admin API
+    admin, code := replace(config.CertExpiryConfig{WarnBefore: 24 * time.Hour})
+    if code != http.StatusOK {
+        t.Fatalf("Expected backend to stay healthy with warn-only threshold, got %d", code)
+    }
+    rec := httptest.NewRecorder()
+    admin.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/certificates", nil))
+    var statuses []struct {
+        Backend  string `json:"backend"`
+        DaysLeft int    `json:"days_left"`
+    }
+    if err := json.NewDecoder(rec.Body).Decode(&statuses); err != nil {
+        t.Fatalf("Invalid certificates response: %v", err)
+    }
+    if len(statuses) != 1 || statuses[0].Backend != backend.URL || statuses[0].DaysLeft != 0 {
+        t.Errorf("Unexpected certificate statuses: %+v", statuses)
+    }
+}
+
+func TestProxy_RetriesSkipOpenCircuitBreaker(t *testing.T) {
+    // flaky отвечает 500, пока не откроется его breaker, затем снова исправен
+    var flakyHealthy atomic.Bool
+    var flakyHits, brokenHits, stableHits atomic.Int32
+    flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if !flakyHealthy.Load() {
+            w.WriteHeader(http.StatusInternalServerError)
+            return
+        }
+        flakyHits.Add(1)
+    }))
+    defer flaky.Close()
+
+    // broken обрывает соединение без ответа, когда включен режим сбоя
+    var brokenFailing atomic.Bool
+    broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if !brokenFailing.Load() {
+            return
+        }
+        brokenHits.Add(1)
+        conn, _, err := http.NewResponseController(w).Hijack()
+        if err == nil {
+            conn.Close()
+        }
+    }))
+    defer broken.Close()
+
+    stable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        stableHits.Add(1)
+    }))
+    defer stable.Close()
+
+    lb := newTestProxy(t, flaky.URL, func(cfg *config.Config) {
+        cfg.Backends = append(cfg.Backends, config.BackendConfig{URL: broken.URL}, config.BackendConfig{URL: stable.URL})
+        cfg.CircuitBreaker = config.CircuitBreakerConfig{Enabled: true, FailureThreshold: 3, Cooldown: time.Minute}
+        cfg.Retry.MaxRetries = 2
+    })
+    server := httptest.NewServer(lb.Handler())
+    defer server.Close()
+
+    // Открываем breaker flaky тремя ответами 500 (ответы 5xx не повторяются)
+    failures := 0
+    for i := 0; i < 30 && failures < 3; i++ {
+        resp, err := http.Get(server.URL)
+        if err != nil {
+            t.Fatalf("Request failed: %v", err)
+        }
+        resp.Body.Close()
+        if resp.StatusCode == http.StatusInternalServerError {
+            failures++
+        }
+    }
+    if failures < 3 {
+        t.Fatalf("Expected flaky backend to fail 3 times, got %d", failures)
+    }
+
+    flakyHealthy.Store(true)
+    brokenFailing.Store(true)
+    stableHits.Store(0)
+
+    const requests = 9
+    for i := 0; i < requests; i++ {
+        resp, err := http.Get(server.URL)
+        if err != nil {
+            t.Fatalf("Request failed: %v", err)
+        }
+        resp.Body.Close()
+        if resp.StatusCode != http.StatusOK {
+            t.Errorf("Request %d: expected 200 after retry, got %d", i, resp.StatusCode)
+        }
+    }
+
+    if flakyHits.Load() != 0 {
+        t.Errorf("Backend with open breaker received %d requests (including retries)", flakyHits.Load())
+    }
+    if brokenHits.Load() == 0 {
+        t.Error("Expected failing backend to be tried, so that retries are exercised")
+    }
+    if stableHits.Load() != requests {
+        t.Errorf("Expected all %d requests to be served by the closed-breaker backend, got %d", requests, stableHits.Load())
+    }
+}
+
+func TestProxy_SecondInstanceOnSamePortFailsToBind(t *testing.T) {
+    first := newTestProxy(t, "http://127.0.0.1:1", nil)
+    listener, err := first.Listen("127.0.0.1:0")
+    if err != nil {
+        t.Fatalf("First instance failed to bind: %v", err)
+    }
+    done := make(chan error, 1)
+    go func() { done <- first.Serve(listener) }()
+
+    second := newTestProxy(t, "http://127.0.0.1:1", nil)
+    _, err = second.Listen(listener.Addr().String())
+    if err == nil || !strings.Contains(err.Error(), "already in use") {
+        t.Fatalf("Expected address in use error for the second instance, got %v", err)
+    }
+
+    first.Shutdown()
+    if err := <-done; err != nil {
+        t.Errorf("Serve must return nil after Shutdown, got %v", err)
+    }
+}
+
+func TestProxy_PerTryTimeoutFallsOverToFastBackend(t *testing.T) {
+    var slowHits atomic.Int32
+    slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        slowHits.Add(1)
+        select {
+        case <-r.Context().Done():
+        case <-time.After(2 * time.Second):
+        }
+    }))
+    defer slow.Close()
+    fast := echoBackend("fast")
+    defer fast.Close()
+
+    lb := newTestProxy(t, slow.URL, func(cfg *config.Config) {
+        cfg.Backends = append(cfg.Backends, config.BackendConfig{URL: fast.URL})
+        cfg.RequestTimeout = time.Second
+        cfg.Retry = config.RetryConfig{MaxRetries: 2, PerTryTimeout: 100 * time.Millisecond}
+        // Breaker с высоким порогом держит медленный backend в ротации, чтобы повтор срабатывал не один раз
+        cfg.CircuitBreaker = config.CircuitBreakerConfig{Enabled: true, FailureThreshold: 100}
+    })
+    server := httptest.NewServer(lb.Handler())
+    defer server.Close()
+
+    for i := 0; i < 4; i++ {
+        start := time.Now()
+        resp, err := http.Get(server.URL)
+        if err != nil {
+            t.Fatalf("Request failed: %v", err)
+        }
+        body, _ := io.ReadAll(resp.Body)
+        resp.Body.Close()
+        if resp.StatusCode != http.StatusOK || string(body) != "fast:" {
+            t.Errorf("Expected fast backend to answer, got %d %q", resp.StatusCode, body)
+        }
+        if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+            t.Errorf("Request took %s, expected per-try timeout to fall over well within the overall budget", elapsed)
+        }
+    }
+    if slowHits.Load() == 0 {
+        t.Error("Expected the slow backend to be tried first at least once")
+    }
+}
+
+func TestProxy_RequestTimeoutCapsAllTries(t *testing.T) {
+    slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        select {
+        case <-r.Context().Done():
+        case <-time.After(2 * time.Second):
+        }
+    }))
+    defer slow.Close()
+
+    lb := newTestProxy(t, slow.URL, func(cfg *config.Config) {
+        // Второй адрес того же backend'а, чтобы повтору было куда уйти
+        cfg.Backends = append(cfg.Backends, config.BackendConfig{URL: slow.URL + "/"})
+        cfg.RequestTimeout = 300 * time.Millisecond
+        cfg.Retry = config.RetryConfig{MaxRetries: 5, PerTryTimeout: 200 * time.Millisecond}
+        cfg.CircuitBreaker = config.CircuitBreakerConfig{Enabled: true, FailureThreshold: 100}
+    })
+
+    start := time.Now()
+    rec := httptest.NewRecorder()
+    lb.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+    if rec.Code != http.StatusGatewayTimeout {
+        t.Errorf("Expected 504 once the overall budget is exhausted, got %d: %s", rec.Code, rec.Body.String())
+    }
+    if elapsed := time.Since(start); elapsed > time.Second {
+        t.Errorf("Overall request_timeout not enforced: took %s", elapsed)
+    }
+}
+
+func TestProxy_BackendTimeoutTearsDownUpstreamRequest(t *testing.T) {
+    canceled := make(chan struct{}, 1)
+    slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        select {
+        case <-r.Context().Done():
+            canceled <- struct{}{}
+        case <-time.After(2 * time.Second):
+        }
+    }))
+    defer slow.Close()
+
+    lb := newTestProxy(t, "", func(cfg *config.Config) {
+        cfg.Backends = []config.BackendConfig{{URL: slow.URL, Timeout: 100 * time.Millisecond}}
+        cfg.Admin.Addr = "127.0.0.1:0"
+    })
+
+    start := time.Now()
+    rec := httptest.NewRecorder()
+    lb.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+    if rec.Code != http.StatusGatewayTimeout || !strings.Contains(rec.Body.String(), "Backend timeout") {
+        t.Errorf("Expected JSON 504 after the backend timeout, got %d: %s", rec.Code, rec.Body.String())
+    }
+    if elapsed := time.Since(start); elapsed > time.Second {
+        t.Errorf("Backend timeout not enforced: took %s", elapsed)
+    }
+    select {
+    case <-canceled:
+    case <-time.After(time.Second):
+        t.Fatal("Expected the upstream request to be canceled on timeout")
+    }
+
+    rec = httptest.NewRecorder()
+    lb.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+    for _, expected := range []string{
+        fmt.Sprintf(`lb_backend_errors_total{backend="%s"} 1`, slow.URL),
+        fmt.Sprintf(`lb_backend_timeouts_total{backend="%s"} 1`, slow.URL),
+    } {
+        if !strings.Contains(rec.Body.String(), expected) {
+            t.Errorf("Expected %q in metrics, got:\n%s", expected, rec.Body.String())
+        }
+    }
+}
+
+func TestProxy_ConnectionPoolReusesAndCapsBackendConnections(t *testing.T) {
+    var opened atomic.Int64
+    backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        time.Sleep(20 * time.Millisecond)
+    }))
+    backend.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+        if state == http.StateNew {
+            opened.Add(1)
+        }
+    }
+    backend.Start()
+    defer backend.Close()
+
+    lb := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+        cfg.ConnectionPool = config.ConnectionPoolConfig{MaxConnsPerHost: 2}
+    })
+    handler := lb.Handler()
+
+    for round := 0; round < 3; round++ {
+        var wg sync.WaitGroup
+        for i := 0; i < 8; i++ {
+            wg.Add(1)
+            go func() {
+                defer wg.Done()
+                rec := httptest.NewRecorder()
+                handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+                if rec.Code != http.StatusOK {
+                    t.Errorf("Expected 200, got %d", rec.Code)
+                }
+            }()
+        }
+        wg.Wait()
+    }
+
+    if n := opened.Load(); n < 1 || n > 2 {
+        t.Errorf("Expected 24 requests over at most 2 pooled connections, backend saw %d", n)
+    }
+}
+
+// BenchmarkProxyForward измеряет стоимость проксирования запроса на горячем пути,
+// включая выделения памяти на запрос (-benchmem).
+func BenchmarkProxyForward(b *testing.B) {
+    backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+    defer backend.Close()
+
+    cfg := &config.Config{Port: 8080, Backends: []config.BackendConfig{{URL: backend.URL}}}
+    cfg.RateLimit.Capacity = b.N + 1
+    lb := proxy.NewProxyServer(cfg, zap.NewNop().Sugar())
+    handler := lb.Handler()
+
+    b.ReportAllocs()
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        rec := httptest.NewRecorder()
+        handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+        if rec.Code != http.StatusOK {
+            b.Fatalf("Expected 200, got %d", rec.Code)
+        }
+    }
+}
+
+func TestProxy_AdaptiveWeightsPenalizeSlowBackend(t *testing.T) {
+    slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        time.Sleep(50 * time.Millisecond)
+    }))
+    defer slow.Close()
+    fast := echoBackend("fast")
+    defer fast.Close()
+
+    lb := newTestProxy(t, slow.URL, func(cfg *config.Config) {
+        cfg.Strategy = "weighted_round_robin"
+        cfg.Backends = append(cfg.Backends, config.BackendConfig{URL: fast.URL})
+        cfg.AdaptiveWeights = config.AdaptiveWeightsConfig{
+            Enabled:      true,
+            Interval:     50 * time.Millisecond,
+            Coefficients: config.AdaptiveCoefficients{Latency: 100},
+        }
+    })
+    handler := lb.Handler()
+    for i := 0; i < 4; i++ {
+        handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+    }
+    time.Sleep(150 * time.Millisecond)
+
+    rec := httptest.NewRecorder()
+    lb.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/weights", nil))
+    var weights []struct {
+        Backend         string `json:"backend"`
+        EffectiveWeight int64  `json:"effective_weight"`
+    }
+    if err := json.Unmarshal(rec.Body.Bytes(), &weights); err != nil {
+        t.Fatalf("Invalid /admin/weights response %q: %v", rec.Body.String(), err)
+    }
+    effective := make(map[string]int64)
+    for _, weight := range weights {
+        effective[weight.Backend] = weight.EffectiveWeight
+    }
+    if effective[slow.URL] == 0 || effective[slow.URL] >= effective[fast.URL] {
+        t.Errorf("Expected slow backend to get a lower effective weight, got %v", effective)
+    }
+}
+
+func TestProxy_AdminConfigExportRoundTrips(t *testing.T) {
+    backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+    defer backend.Close()
+
+    replace := func(value string) *config.SensitiveHeadersConfig {
+        return &config.SensitiveHeadersConfig{Mode: "replace", Value: value}
+    }
+    lb := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+        cfg.Admin.Tokens = map[string]string{"alice": "admin-secret"}
+        cfg.RequestSigning.Secret = "hmac-secret"
+        cfg.RequestTimeout = 5 * time.Second
+        cfg.SensitiveHeaders = config.SensitiveHeadersConfig{Mode: "replace", Value: "default-token"}
+        cfg.Hosts = map[string]config.BackendGroup{"api.example.com": {Backends: []config.BackendConfig{{URL: backend.URL, SensitiveHeaders: replace("host-token")}}}}
+        cfg.BodyRouting = config.BodyRoutingConfig{Enabled: true, Field: "method", Routes: []config.BodyRoute{
+            {Values: []string{"eth_call"}, Backends: []config.BackendConfig{{URL: backend.URL, SensitiveHeaders: replace("body-route-token")}}},
+        }}
+        cfg.Standby.Backends = []config.BackendConfig{{URL: backend.URL, SensitiveHeaders: replace("standby-token")}}
+        cfg.SelfTest.Headers = map[string]string{"X-Selftest-Key": "selftest-token"}
+        cfg.RequestHeaders.Add = map[string]string{"X-Internal-Auth": "request-header-token"}
+        cfg.ResponseHeaders.Add = map[string]string{"X-Debug-Key": "response-header-token"}
+    })
+    admin := lb.AdminHandler()
+    adminRequest := func(method, target, body string) *httptest.ResponseRecorder {
+        req := httptest.NewRequest(method, target, strings.NewReader(body))
+        req.Header.Set("Authorization", "Bearer admin-secret")
+        rec := httptest.NewRecorder()
+        admin.ServeHTTP(rec, req)
+        return rec
+    }
+
+    if rec := adminRequest(http.MethodPatch, "/admin/backends/"+url.PathEscape(backend.URL), `{"weight": 4}`); rec.Code != http.StatusOK {
+        t.Fatalf("PATCH weight: %d %s", rec.Code, rec.Body.String())
+    }
+    if rec := adminRequest(http.MethodPut, "/admin/ratelimit/clients/10.0.0.1", `{"capacity": 7, "refill_rate": 2}`); rec.Code != http.StatusOK {
+        t.Fatalf("PUT client limit: %d %s", rec.Code, rec.Body.String())
+    }
+
+    // Ключи rate_limit.clients могут быть API-ключами: без токена конфигурация не отдается
+    anonymous := httptest.NewRecorder()
+    admin.ServeHTTP(anonymous, httptest.NewRequest(http.MethodGet, "/admin/config", nil))
+    if anonymous.Code != http.StatusUnauthorized || strings.Contains(anonymous.Body.String(), "10.0.0.1") {
+        t.Errorf("Expected 401 without client keys for anonymous GET /admin/config, got %d %s", anonymous.Code, anonymous.Body.String())
+    }
+
+    rec := adminRequest(http.MethodGet, "/admin/config", "")
+    if rec.Code != http.StatusOK {
+        t.Fatalf("GET /admin/config: %d %s", rec.Code, rec.Body.String())
+    }
+    exported := rec.Body.String()
+    for _, secret := range []string{
+        "admin-secret", "hmac-secret", "default-token", "host-token", "body-route-token",
+        "standby-token", "selftest-token", "request-header-token", "response-header-token",
+    } {
+        if strings.Contains(exported, secret) {
+            t.Errorf("Secret %q must be redacted:\n%s", secret, exported)
+        }
+    }
+
+    path := filepath.Join(t.TempDir(), "config.yaml")
+    if err := os.WriteFile(path, []byte(exported), 0o600); err != nil {
+        t.Fatal(err)
+    }
+    cfg, err := config.Load(path)
+    if err != nil {
+        t.Fatalf("Exported config does not re-parse: %v\n%s", err, exported)
+    }
+    if len(cfg.Backends) != 1 || cfg.Backends[0].EffectiveWeight() != 4 {
+        t.Errorf("Expected runtime weight 4 in exported backends, got %+v", cfg.Backends)
+    }
+    if limit := cfg.RateLimit.Clients["10.0.0.1"]; limit.Capacity != 7 || limit.RefillRate != 2 {
+        t.Errorf("Expected runtime client limit in exported config, got %+v", cfg.RateLimit.Clients)
+    }
+    if cfg.RequestTimeout != 5*time.Second || cfg.RateLimit.Capacity != 1000 {
+        t.Errorf("Static settings lost in export:\n%s", exported)
+    }
+    if cfg.RequestSigning.Secret != config.RedactedValue {
+        t.Errorf("Expected redacted signing secret, got %q", cfg.RequestSigning.Secret)
+    }
+
+    // routes несовместимы с body_routing, поэтому проверяются отдельно; исходная конфигурация не меняется
+    withRoutes := &config.Config{Routes: []config.Route{{Prefix: "/api", Backends: []config.BackendConfig{{URL: backend.URL, SensitiveHeaders: replace("route-token")}}}}}
+    data, err := config.Marshal(withRoutes.Redacted())
+    if err != nil {
+        t.Fatal(err)
+    }
+    if strings.Contains(string(data), "route-token") || withRoutes.Routes[0].Backends[0].SensitiveHeaders.Value != "route-token" {
+        t.Errorf("Expected routes[].backends sensitive_headers.value redacted in a copy:\n%s", data)
+    }
+}
+
+func TestProxy_InFlightBackpressure(t *testing.T) {
+    release := make(chan struct{})
+    var blocked sync.WaitGroup
+    backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/block" {
+            blocked.Done()
+            <-release
+        }
+    }))
+    defer backend.Close()
+
+    lb := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+        cfg.InFlight = config.InFlightConfig{Max: 4, Backpressure: config.BackpressureConfig{HintAt: 0.5, RetryAfter: 2 * time.Second}}
+    })
+    server := httptest.NewServer(lb.Handler())
+    defer server.Close()
+
+    var done sync.WaitGroup
+    hold := func(n int) {
+        blocked.Add(n)
+        for i := 0; i < n; i++ {
+            done.Add(1)
+            go func() {
+                defer done.Done()
+                if resp, err := http.Get(server.URL + "/block"); err == nil {
+                    resp.Body.Close()
+                }
+            }()
+        }
+        blocked.Wait()
+    }
+    probe := func() *http.Response {
+        resp, err := http.Get(server.URL + "/probe")
+        if err != nil {
+            t.Fatalf("Probe failed: %v", err)
+        }
+        resp.Body.Close()
+        return resp
+    }
+
+    if resp := probe(); resp.Header.Get("X-Backpressure") != "" {
+        t.Errorf("Idle proxy must not signal backpressure, got %q", resp.Header.Get("X-Backpressure"))
+    }
+
+    // 1 занятый слот + проба = 50% — обслуживается с подсказкой
+    hold(1)
+    if resp := probe(); resp.StatusCode != http.StatusOK || resp.Header.Get("X-Backpressure") != "high" {
+        t.Errorf("Expected 200 with X-Backpressure: high at 50%%, got %d %q", resp.StatusCode, resp.Header.Get("X-Backpressure"))
+    }
+
+    // Все слоты заняты — отказ с Retry-After
+    hold(3)
+    resp := probe()
+    if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("X-Backpressure") != "critical" || resp.Header.Get("Retry-After") != "2" {
+        t.Errorf("Expected 503 with X-Backpressure: critical and Retry-After: 2 when full, got %d %v", resp.StatusCode, resp.Header)
+    }
+
+    close(release)
+    done.Wait()
+    if resp := probe(); resp.StatusCode != http.StatusOK || resp.Header.Get("X-Backpressure") != "" {
+        t.Errorf("Expected normal response after load drops, got %d %q", resp.StatusCode, resp.Header.Get("X-Backpressure"))
+    }
+}
+
+func TestProxy_InFlightQueueTimeout(t *testing.T) {
+    release := make(chan struct{})
+    blocked := make(chan struct{}, 1)
+    backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/block" {
+            blocked <- struct{}{}
+            <-release
+        }
+    }))
+    defer backend.Close()
+
+    lb := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+        cfg.InFlight = config.InFlightConfig{Max: 1, QueueTimeout: time.Second}
+    })
+    handler := lb.Handler()
+    serve := func(path string) int {
+        rec := httptest.NewRecorder()
+        handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+        return rec.Code
+    }
+
+    held := make(chan int)
+    go func() { held <- serve("/block") }()
+    <-blocked
+
+    // Слот не освобождается — запрос отклоняется после queue_timeout
+    start := time.Now()
+    if code := serve("/probe"); code != http.StatusServiceUnavailable {
+        t.Errorf("Expected 503 after the queue timeout, got %d", code)
+    }
+    if waited := time.Since(start); waited < time.Second {
+        t.Errorf("Expected the request to wait for queue_timeout, rejected after %s", waited)
+    }
+
+    // Слот освобождается во время ожидания — запрос обслуживается
+    queued := make(chan int)
+    go func() { queued <- serve("/probe") }()
+    time.Sleep(50 * time.Millisecond)
+    close(release)
+    if code := <-held; code != http.StatusOK {
+        t.Errorf("Expected the holding request to finish with 200, got %d", code)
+    }
+    if code := <-queued; code != http.StatusOK {
+        t.Errorf("Expected the queued request to be served once a slot freed up, got %d", code)
+    }
+}
+func TestProxy_RetryResendsBodyAndSkipsPostByDefault(t *testing.T) {
+    var brokenHits atomic.Int32
+    broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        brokenHits.Add(1)
+        io.ReadAll(r.Body)
+        if conn, _, err := http.NewResponseController(w).Hijack(); err == nil {
+            conn.Close()
+        }
+    }))
+    defer broken.Close()
+    echo := echoBackend("echo")
+    defer echo.Close()
+
+    lb := newTestProxy(t, broken.URL, func(cfg *config.Config) {
+        cfg.Backends = append(cfg.Backends, config.BackendConfig{URL: echo.URL})
+        cfg.Retry.MaxRetries = 1
+        // Breaker с высоким порогом оставляет сбойный backend в ротации
+        cfg.CircuitBreaker = config.CircuitBreakerConfig{Enabled: true, FailureThreshold: 100}
+    })
+    server := httptest.NewServer(lb.Handler())
+    defer server.Close()
+
+    send := func(method string) (int, string) {
+        req, _ := http.NewRequest(method, server.URL, strings.NewReader("payload"))
+        resp, err := http.DefaultClient.Do(req)
+        if err != nil {
+            t.Fatalf("%s failed: %v", method, err)
+        }
+        defer resp.Body.Close()
+        body, _ := io.ReadAll(resp.Body)
+        return resp.StatusCode, string(body)
+    }
+
+    for i := 0; i < 2; i++ {
+        if status, body := send(http.MethodPut); status != http.StatusOK || body != "echo:payload" {
+            t.Errorf("PUT %d: expected retried request with full body, got %d %q", i, status, body)
+        }
+    }
+    if brokenHits.Load() == 0 {
+        t.Fatal("Expected the failing backend to be tried")
+    }
+
+    // POST без allow_post не повторяется: из двух запросов по кругу один попадает на сбойный backend
+    failed := 0
+    for i := 0; i < 2; i++ {
+        if status, _ := send(http.MethodPost); status == http.StatusBadGateway {
+            failed++
+        }
+    }
+    if failed != 1 {
+        t.Errorf("Expected exactly one POST to fail without retry, got %d", failed)
+    }
+}
+
+func TestProxy_OversizedBodyStreamsOrIsRejected(t *testing.T) {
+    echo := echoBackend("echo")
+    defer echo.Close()
+
+    for _, required := range []bool{false, true} {
+        lb := newTestProxy(t, echo.URL, func(cfg *config.Config) {
+            cfg.Retry = config.RetryConfig{MaxRetries: 1, MaxBodySize: 4, BufferRequired: required}
+        })
+        handler := lb.Handler()
+
+        // Небольшое тело буферизуется, крупное — передается потоком или отклоняется
+        rec := httptest.NewRecorder()
+        handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/", strings.NewReader("tiny")))
+        if rec.Code != http.StatusOK || rec.Body.String() != "echo:tiny" {
+            t.Errorf("buffer_required=%v: small body: got %d %q", required, rec.Code, rec.Body.String())
+        }
+
+        rec = httptest.NewRecorder()
+        handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/", strings.NewReader("payload")))
+        switch {
+        case required && rec.Code != http.StatusRequestEntityTooLarge:
+            t.Errorf("Expected 413 when buffering is required, got %d", rec.Code)
+        case !required && (rec.Code != http.StatusOK || rec.Body.String() != "echo:payload"):
+            t.Errorf("Expected oversized body to stream through, got %d %q", rec.Code, rec.Body.String())
+        }
+    }
+}
+
+// writeServerCert записывает самоподписанный сертификат с указанным CommonName в certFile/keyFile.
+func writeServerCert(t *testing.T, certFile, keyFile, name string) {
+    t.Helper()
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        t.Fatalf("generate key: %v", err)
+    }
+    template := &x509.Certificate{
+        SerialNumber: big.NewInt(time.Now().UnixNano()),
+        Subject:      pkix.Name{CommonName: name},
+        IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+        NotBefore:    time.Now().Add(-time.Hour),
+        NotAfter:     time.Now().Add(time.Hour),
+        KeyUsage:     x509.KeyUsageDigitalSignature,
+        ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+    }
+    der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+    if err != nil {
+        t.Fatalf("create certificate: %v", err)
+    }
+    keyDER, err := x509.MarshalECPrivateKey(key)
+    if err != nil {
+        t.Fatalf("marshal key: %v", err)
+    }
+    if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+        t.Fatalf("write cert file: %v", err)
+    }
+    if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+        t.Fatalf("write key file: %v", err)
+    }
+}
+
+func TestProxy_ServesHTTPSAndReloadsCertificate(t *testing.T) {
+    backend := echoBackend("secure")
+    defer backend.Close()
+
+    dir := t.TempDir()
+    certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+    writeServerCert(t, certFile, keyFile, "first")
+
+    lb := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+        cfg.TLS = config.ServerTLSConfig{CertFile: certFile, KeyFile: keyFile, MinVersion: "1.3"}
+    })
+    listener, err := lb.Listen("127.0.0.1:0")
+    if err != nil {
+        t.Fatalf("Listen failed: %v", err)
+    }
+    go lb.Serve(listener)
+    defer lb.Shutdown()
+
+    // handshake выполняет новое TLS-соединение и возвращает CommonName сертификата прокси
+    handshake := func(maxVersion uint16) (string, error) {
+        client := &http.Client{Transport: &http.Transport{
+            TLSClientConfig:   &tls.Config{InsecureSkipVerify: true, MaxVersion: maxVersion},
+            DisableKeepAlives: true,
+        }}
+        resp, err := client.Get("https://" + listener.Addr().String() + "/")
+        if err != nil {
+            return "", err
+        }
+        defer resp.Body.Close()
+        if resp.StatusCode != http.StatusOK {
+            return "", fmt.Errorf("unexpected status %d", resp.StatusCode)
+        }
+        return resp.TLS.PeerCertificates[0].Subject.CommonName, nil
+    }
+
+    if name, err := handshake(0); err != nil || name != "first" {
+        t.Fatalf("Expected HTTPS with the first certificate, got %q, %v", name, err)
+    }
+    if _, err := handshake(tls.VersionTLS12); err == nil {
+        t.Errorf("TLS 1.2 handshake must fail with min_version 1.3")
+    }
+
+    writeServerCert(t, certFile, keyFile, "second")
+    future := time.Now().Add(time.Minute)
+    for _, path := range []string{certFile, keyFile} {
+        if err := os.Chtimes(path, future, future); err != nil {
+            t.Fatalf("chtimes: %v", err)
+        }
+    }
+    if name, err := handshake(0); err != nil || name != "second" {
+        t.Errorf("Expected the reloaded certificate without restart, got %q, %v", name, err)
+    }
+}
+
+// websocketEchoBackend принимает WebSocket-рукопожатие и возвращает клиенту каждый полученный кадр.
+func websocketEchoBackend(t *testing.T) *httptest.Server {
+    t.Helper()
+    return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || !strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade") {
+            http.Error(w, "upgrade required", http.StatusUpgradeRequired)
+            return
+        }
+        sum := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+        conn, rw, err := http.NewResponseController(w).Hijack()
+        if err != nil {
+            return
+        }
+        defer conn.Close()
+        fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+            base64.StdEncoding.EncodeToString(sum[:]))
+        rw.Flush()
+        for {
+            payload, err := readWebSocketFrame(rw.Reader)
+            if err != nil {
+                return
+            }
+            writeWebSocketFrame(rw.Writer, payload, false)
+            rw.Flush()
+        }
+    }))
+}
+
+// writeWebSocketFrame пишет текстовый кадр (до 125 байт); клиентские кадры маскируются.
+func writeWebSocketFrame(w io.Writer, payload []byte, masked bool) error {
+    frame := []byte{0x81, byte(len(payload))}
+    if !masked {
+        frame = append(frame, payload...)
+    } else {
+        frame[1] |= 0x80
+        mask := []byte{1, 2, 3, 4}
+        frame = append(frame, mask...)
+        for i, b := range payload {
+            frame = append(frame, b^mask[i%4])
+        }
+    }
+    _, err := w.Write(frame)
+    return err
+}
+
+// readWebSocketFrame читает кадр до 125 байт и снимает маску, если она есть.
+func readWebSocketFrame(r *bufio.Reader) ([]byte, error) {
+    header := make([]byte, 2)
+    if _, err := io.ReadFull(r, header); err != nil {
+        return nil, err
+    }
+    var mask []byte
+    if header[1]&0x80 != 0 {
+        mask = make([]byte, 4)
+        if _, err := io.ReadFull(r, mask); err != nil {
+            return nil, err
+        }
+    }
+    payload := make([]byte, header[1]&0x7f)
+    if _, err := io.ReadFull(r, payload); err != nil {
+        return nil, err
+    }
+    for i := range payload {
+        if mask != nil {
+            payload[i] ^= mask[i%4]
+        }
+    }
+    return payload, nil
+}
+
+func TestProxy_WebSocketPassthroughWithHandshakeFailover(t *testing.T) {
+    backend := websocketEchoBackend(t)
+    defer backend.Close()
+    dead := httptest.NewServer(http.NotFoundHandler())
+    dead.Close()
+
+    lb := newTestProxy(t, dead.URL, func(cfg *config.Config) {
+        cfg.Backends = append(cfg.Backends, config.BackendConfig{URL: backend.URL})
+        cfg.Retry = config.RetryConfig{MaxRetries: 1}
+        // Туннель должен жить дольше общего бюджета обычного запроса
+        cfg.RequestTimeout = 100 * time.Millisecond
+    })
+    listener, err := lb.Listen("127.0.0.1:0")
+    if err != nil {
+        t.Fatalf("Listen failed: %v", err)
+    }
+    go lb.Serve(listener)
+    defer lb.Shutdown()
+
+    // Независимо от того, с какого backend'а начнется выбор, рукопожатие должно дойти до живого
+    for i := 0; i < 2; i++ {
+        conn, err := net.Dial("tcp", listener.Addr().String())
+        if err != nil {
+            t.Fatalf("Dial failed: %v", err)
+        }
+        reader := bufio.NewReader(conn)
+        fmt.Fprintf(conn, "GET /chat HTTP/1.1\r\nHost: lb\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+            "Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+        resp, err := http.ReadResponse(reader, nil)
+        if err != nil {
+            t.Fatalf("Read handshake response: %v", err)
+        }
+        if resp.StatusCode != http.StatusSwitchingProtocols || !strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") {
+            t.Fatalf("Expected 101 with Upgrade: websocket, got %d %v", resp.StatusCode, resp.Header)
+        }
+        if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+            t.Errorf("Unexpected Sec-WebSocket-Accept %q", got)
+        }
+
+        time.Sleep(200 * time.Millisecond)
+        for _, message := range []string{"hello", "world"} {
+            if err := writeWebSocketFrame(conn, []byte(message), true); err != nil {
+                t.Fatalf("Write frame: %v", err)
+            }
+            conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+            payload, err := readWebSocketFrame(reader)
+            if err != nil || string(payload) != message {
+                t.Fatalf("Expected echo %q, got %q, %v", message, payload, err)
+            }
+        }
+        conn.Close()
+    }
+}
+
+func TestProxy_PrometheusMetricsOnAdminListener(t *testing.T) {
+    backend := echoBackend("ok")
+    defer backend.Close()
+    dead := httptest.NewServer(http.NotFoundHandler())
+    dead.Close()
+
+    lb := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+        cfg.Backends = append(cfg.Backends, config.BackendConfig{URL: dead.URL})
+        cfg.Admin.Addr = "127.0.0.1:0"
+        cfg.Retry.MaxRetries = 1
+        cfg.RateLimit.Capacity = 3
+        cfg.RateLimit.RefillRate = 1
+    })
+    handler := lb.Handler()
+    for i := 0; i < 4; i++ {
+        handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+    }
+
+    rec := httptest.NewRecorder()
+    lb.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+    body := rec.Body.String()
+    for _, expected := range []string{
+        "lb_http_requests_total 4",
+        "lb_inflight_requests 0",
+        "lb_ratelimit_rejected_total 1",
+        fmt.Sprintf(`lb_requests_total{backend="%s"} 3`, backend.URL),
+        fmt.Sprintf(`lb_backend_errors_total{backend="%s"} 1`, dead.URL),
+        fmt.Sprintf(`lb_backend_up{backend="%s"} 1`, backend.URL),
+        fmt.Sprintf(`lb_backend_up{backend="%s"} 0`, dead.URL),
+    } {
+        if !strings.Contains(body, expected) {
+            t.Errorf("Expected %q in metrics, got:\n%s", expected, body)
+        }
+    }
+}
+
+func TestProxy_AdminBackendsSnapshot(t *testing.T) {
+    healthy := healthyBackend(t)
+    failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(http.StatusServiceUnavailable)
+    }))
+    defer failing.Close()
+
+    lb := newTestProxy(t, healthy.URL, func(cfg *config.Config) {
+        cfg.Admin.Tokens = map[string]string{"alice": "secret"}
+    })
+    admin := lb.AdminHandler()
+
+    // Замена набора выполняет health-check'и, их результаты попадают в снимок
+    req := httptest.NewRequest(http.MethodPut, "/admin/backends",
+        strings.NewReader(fmt.Sprintf(`{"backends": [{"url": %q}, {"url": %q}]}`, healthy.URL, failing.URL)))
+    req.Header.Set("Authorization", "Bearer secret")
+    rec := httptest.NewRecorder()
+    admin.ServeHTTP(rec, req)
+    if rec.Code != http.StatusOK {
+        t.Fatalf("PUT /admin/backends failed: %d %s", rec.Code, rec.Body.String())
+    }
+
+    rec = httptest.NewRecorder()
+    admin.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/backends", nil))
+    var statuses []balancer.BackendStatus
+    if err := json.NewDecoder(rec.Body).Decode(&statuses); err != nil || len(statuses) != 2 {
+        t.Fatalf("Expected two backend statuses, got %d (%v)", len(statuses), err)
+    }
+    for _, status := range statuses {
+        switch status.Address {
+        case healthy.URL:
+            if !status.Alive || status.LastCheckHealthy == nil || !*status.LastCheckHealthy || status.LastSuccess == nil || status.LastFailure != nil {
+                t.Errorf("Unexpected status of the healthy backend: %+v", status)
+            }
+        case failing.URL:
+            if status.Alive || status.LastFailure == nil || status.LastSuccess != nil || !strings.Contains(status.LastCheckError, "503") {
+                t.Errorf("Unexpected status of the failing backend: %+v", status)
+            }
+        default:
+            t.Errorf("Unexpected backend %s", status.Address)
+        }
+    }
+}
+
+func TestProxy_AdminAddAndRemoveBackend(t *testing.T) {
+    first := echoBackend("first")
+    defer first.Close()
+    second := echoBackend("second")
+    defer second.Close()
+
+    lb := newTestProxy(t, first.URL, func(cfg *config.Config) {
+        cfg.Admin.Tokens = map[string]string{"alice": "secret"}
+    })
+    handler, admin := lb.Handler(), lb.AdminHandler()
+    call := func(method, body string) int {
+        req := httptest.NewRequest(method, "/admin/backends", strings.NewReader(body))
+        req.Header.Set("Authorization", "Bearer secret")
+        rec := httptest.NewRecorder()
+        admin.ServeHTTP(rec, req)
+        return rec.Code
+    }
+    served := func() map[string]int {
+        counts := make(map[string]int)
+        for i := 0; i < 10; i++ {
+            rec := httptest.NewRecorder()
+            handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+            name, _, _ := strings.Cut(rec.Body.String(), ":")
+            counts[name]++
+        }
+        return counts
+    }
+
+    if code := call(http.MethodPost, fmt.Sprintf(`{"url": %q}`, second.URL)); code != http.StatusCreated {
+        t.Fatalf("POST /admin/backends: expected 201, got %d", code)
+    }
+    if code := call(http.MethodPost, fmt.Sprintf(`{"url": %q}`, second.URL)); code != http.StatusConflict {
+        t.Errorf("Adding a duplicate backend: expected 409, got %d", code)
+    }
+    if counts := served(); counts["first"] != 5 || counts["second"] != 5 {
+        t.Errorf("Expected traffic split between both backends, got %v", counts)
+    }
+
+    if code := call(http.MethodDelete, fmt.Sprintf(`{"url": %q}`, first.URL)); code != http.StatusNoContent {
+        t.Fatalf("DELETE /admin/backends: expected 204, got %d", code)
+    }
+    if counts := served(); counts["second"] != 10 {
+        t.Errorf("Expected all traffic on the remaining backend, got %v", counts)
+    }
+    if code := call(http.MethodDelete, fmt.Sprintf(`{"url": %q}`, first.URL)); code != http.StatusNotFound {
+        t.Errorf("Removing an unknown backend: expected 404, got %d", code)
+    }
+    if code := call(http.MethodDelete, fmt.Sprintf(`{"url": %q}`, second.URL)); code != http.StatusConflict {
+        t.Errorf("Removing the last backend: expected 409, got %d", code)
+    }
+}
+
+func TestProxy_AdminDrainBackend(t *testing.T) {
+    release := make(chan struct{})
+    started := make(chan struct{}, 1)
+    slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/slow" {
+            started <- struct{}{}
+            <-release
+        }
+        fmt.Fprint(w, "slow:")
+    }))
+    defer slow.Close()
+    fast := echoBackend("fast")
+    defer fast.Close()
+
+    lb := newTestProxy(t, slow.URL, func(cfg *config.Config) {
+        cfg.Backends = append(cfg.Backends, config.BackendConfig{URL: fast.URL})
+        cfg.Admin.Tokens = map[string]string{"alice": "secret"}
+    })
+    handler, admin := lb.Handler(), lb.AdminHandler()
+    drain := func(method string) balancer.BackendStatus {
+        req := httptest.NewRequest(method, "/admin/backends/drain", strings.NewReader(fmt.Sprintf(`{"url": %q}`, slow.URL)))
+        req.Header.Set("Authorization", "Bearer secret")
+        rec := httptest.NewRecorder()
+        admin.ServeHTTP(rec, req)
+        if rec.Code != http.StatusOK {
+            t.Fatalf("%s /admin/backends/drain: expected 200, got %d %s", method, rec.Code, rec.Body.String())
+        }
+        var status balancer.BackendStatus
+        if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+            t.Fatalf("Invalid drain response: %v", err)
+        }
+        return status
+    }
+
+    // Начатый на backend'е запрос держит его занятым во время вывода
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        for {
+            rec := httptest.NewRecorder()
+            handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slow", nil))
+            if strings.HasPrefix(rec.Body.String(), "slow:") {
+                return
+            }
+        }
+    }()
+    <-started
+
+    if status := drain(http.MethodPost); !status.Draining || status.Drained || status.ActiveConnections != 1 {
+        t.Errorf("Expected a draining backend with one active request, got %+v", status)
+    }
+    for i := 0; i < 4; i++ {
+        rec := httptest.NewRecorder()
+        handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+        if rec.Body.String() != "fast:" {
+            t.Errorf("Request %d: expected the non-draining backend, got %q", i, rec.Body.String())
+        }
+    }
+
+    close(release)
+    <-done
+    if status := drain(http.MethodPost); !status.Drained {
+        t.Errorf("Expected the backend to be drained after the request finished, got %+v", status)
+    }
+
+    if status := drain(http.MethodDelete); status.Draining {
+        t.Errorf("Expected the backend back in rotation, got %+v", status)
+    }
+    counts := make(map[string]int)
+    for i := 0; i < 4; i++ {
+        rec := httptest.NewRecorder()
+        handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+        counts[rec.Body.String()]++
+    }
+    if counts["slow:"] != 2 || counts["fast:"] != 2 {
+        t.Errorf("Expected traffic split again after undrain, got %v", counts)
+    }
+}
+
+func TestProxy_BackendMaxConcurrent(t *testing.T) {
+    release := make(chan struct{})
+    started := make(chan struct{}, 1)
+    limited := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/slow" {
+            started <- struct{}{}
+            <-release
+        }
+        fmt.Fprint(w, "limited:")
+    }))
+    defer limited.Close()
+    other := echoBackend("other")
+    defer other.Close()
+
+    withOther := newTestProxy(t, limited.URL, func(cfg *config.Config) {
+        cfg.Backends[0].MaxConcurrent = 1
+        cfg.Backends = append(cfg.Backends, config.BackendConfig{URL: other.URL})
+    })
+    alone := newTestProxy(t, limited.URL, func(cfg *config.Config) {
+        cfg.Backends[0].MaxConcurrent = 1
+    })
+    handler := withOther.Handler()
+
+    // Занимаем единственный слот limited запросом, который не завершится до release
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        for {
+            rec := httptest.NewRecorder()
+            handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slow", nil))
+            if rec.Body.String() == "limited:" {
+                return
+            }
+        }
+    }()
+    <-started
+
+    for i := 0; i < 4; i++ {
+        rec := httptest.NewRecorder()
+        handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+        if rec.Code != http.StatusOK || rec.Body.String() != "other:" {
+            t.Errorf("Request %d: expected failover to the free backend, got %d %q", i, rec.Code, rec.Body.String())
+        }
+    }
+
+    // У второго прокси свой семафор: занимаем его слот так же
+    go alone.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+    <-started
+    rec := httptest.NewRecorder()
+    alone.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+    if rec.Code != http.StatusServiceUnavailable {
+        t.Errorf("Expected 503 when the only backend is at max_concurrent, got %d %q", rec.Code, rec.Body.String())
+    }
+
+    close(release)
+    <-done
+}
+
+func TestProxy_Compression(t *testing.T) {
+    text := strings.Repeat("compressible text ", 200)
+    backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        switch r.URL.Path {
+        case "/small":
+            fmt.Fprint(w, "short")
+        case "/image":
+            w.Header().Set("Content-Type", "image/png")
+            fmt.Fprint(w, text)
+        case "/stream":
+            w.Header().Set("Content-Type", "text/event-stream")
+            fmt.Fprint(w, "data: first\n\n")
+            w.(http.Flusher).Flush()
+            fmt.Fprint(w, "data: second\n\n")
+        default:
+            w.Header().Set("Content-Type", "text/plain")
+            fmt.Fprint(w, text)
+        }
+    }))
+    defer backend.Close()
+
+    lb := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+        cfg.Compression = config.CompressionConfig{Enabled: true, MinSize: 100}
+    })
+    server := httptest.NewServer(lb.Handler())
+    defer server.Close()
+
+    get := func(path, acceptEncoding string) (*http.Response, string) {
+        req, _ := http.NewRequest(http.MethodGet, server.URL+path, nil)
+        req.Header.Set("Accept-Encoding", acceptEncoding)
+        // Запрос с явным Accept-Encoding транспорт не разжимает
+        resp, err := http.DefaultTransport.RoundTrip(req)
+        if err != nil {
+            t.Fatalf("GET %s failed: %v", path, err)
+        }
+        defer resp.Body.Close()
+        var reader io.Reader = resp.Body
+        if resp.Header.Get("Content-Encoding") == "gzip" {
+            gz, err := gzip.NewReader(resp.Body)
+            if err != nil {
+                t.Fatalf("GET %s: invalid gzip body: %v", path, err)
+            }
+            reader = gz
+        }
+        body, _ := io.ReadAll(reader)
+        return resp, string(body)
+    }
+
+    tests := []struct {
+        path, acceptEncoding, encoding, body string
+    }{
+        {"/text", "gzip, deflate", "gzip", text},
+        {"/text", "identity", "", text},
+        {"/small", "gzip", "", "short"},
+        {"/image", "gzip", "", text},
+        {"/stream", "gzip", "gzip", "data: first\n\ndata: second\n\n"},
+    }
+    for _, tt := range tests {
+        resp, body := get(tt.path, tt.acceptEncoding)
+        if encoding := resp.Header.Get("Content-Encoding"); encoding != tt.encoding {
+            t.Errorf("GET %s with Accept-Encoding %q: expected Content-Encoding %q, got %q", tt.path, tt.acceptEncoding, tt.encoding, encoding)
+        }
+        if body != tt.body {
+            t.Errorf("GET %s with Accept-Encoding %q: body mismatch, got %q", tt.path, tt.acceptEncoding, body)
+        }
+        if tt.encoding != "" && resp.Header.Get("Vary") != "Accept-Encoding" {
+            t.Errorf("GET %s: expected Vary: Accept-Encoding on a compressed response, got %q", tt.path, resp.Header.Get("Vary"))
+        }
+    }
+}
+
+func TestProxy_ReloadAppliesBackendsAndRateLimit(t *testing.T) {
+    oldBackend := echoBackend("old")
+    defer oldBackend.Close()
+    newBackend := echoBackend("new")
+    defer newBackend.Close()
+
+    core, logs := observer.New(zap.DebugLevel)
+    cfg := &config.Config{Port: 8080, Backends: []config.BackendConfig{{URL: oldBackend.URL}}}
+    cfg.RateLimit.Capacity = 1000
+    cfg.RateLimit.RefillRate = 1000
+    lb := proxy.NewProxyServer(cfg, zap.New(core).Sugar())
+    handler := lb.Handler()
+    get := func() *httptest.ResponseRecorder {
+        rec := httptest.NewRecorder()
+        handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+        return rec
+    }
+    if rec := get(); rec.Body.String() != "old:" {
+        t.Fatalf("Expected the old backend before reload, got %q", rec.Body.String())
+    }
+
+    next := &config.Config{Port: 9090, Backends: []config.BackendConfig{{URL: newBackend.URL}}}
+    next.RateLimit.Capacity = 1
+    next.RateLimit.RefillRate = 1
+    lb.Reload(next)
+
+    // Существующий бакет клиента сразу переходит на новый лимит: остается не больше одного токена
+    if rec := get(); rec.Body.String() != "new:" {
+        t.Errorf("Expected the new backend after reload, got %d %q", rec.Code, rec.Body.String())
+    }
+    if rec := get(); rec.Code != http.StatusTooManyRequests {
+        t.Errorf("Expected the reloaded rate limit to apply, got %d", rec.Code)
+    }
+    if logs.FilterMessageSnippet("port changed but cannot be applied").Len() != 1 {
+        t.Errorf("Expected a warning about the port change")
+    }
+
+    // Режим observe включается на лету: превышение только учитывается, запрос проходит
+    observe := *next
+    observe.RateLimit.Mode = "observe"
+    lb.Reload(&observe)
+    if rec := get(); rec.Code != http.StatusOK {
+        t.Errorf("Expected observe mode to forward the request over the limit, got %d", rec.Code)
+    }
+    if logs.FilterMessageSnippet("mode changed but cannot be applied").Len() != 0 {
+        t.Errorf("Expected rate_limit.mode to be applied without a restart")
+    }
+    lb.Reload(next)
+    if rec := get(); rec.Code != http.StatusTooManyRequests {
+        t.Errorf("Expected enforce mode to reject again after reload, got %d", rec.Code)
+    }
+
+    // Набор, не прошедший health-check, отклоняется, старый остается
+    dead := httptest.NewServer(http.NotFoundHandler())
+    dead.Close()
+    broken := *next
+    broken.Backends = []config.BackendConfig{{URL: dead.URL}}
+    lb.Reload(&broken)
+    if got := lb.EffectiveConfig().Backends; len(got) != 1 || got[0].URL != newBackend.URL {
+        t.Errorf("Expected the previous backend set to stay active, got %v", got)
+    }
+}
+
+func TestProxy_DenylistBlocksAndReloads(t *testing.T) {
+    var hits atomic.Int32
+    backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        hits.Add(1)
+    }))
+    defer backend.Close()
+
+    lb := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+        cfg.Denylist = []string{"203.0.113.0/24"}
+        cfg.RateLimit.Capacity = 1
+    })
+    handler := lb.Handler()
+    request := func(ip string) *httptest.ResponseRecorder {
+        req := httptest.NewRequest(http.MethodGet, "/", nil)
+        req.RemoteAddr = ip + ":4000"
+        rec := httptest.NewRecorder()
+        handler.ServeHTTP(rec, req)
+        return rec
+    }
+
+    rec := request("203.0.113.7")
+    if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), `"code":403`) {
+        t.Fatalf("Expected JSON 403 for a denylisted address, got %d %s", rec.Code, rec.Body.String())
+    }
+    if hits.Load() != 0 {
+        t.Error("Denylisted request must not reach the backend")
+    }
+
+    // Бан снимается и ставится через reload без перезапуска; отклоненный запрос не потратил токен
+    next := *lb.EffectiveConfig()
+    next.Denylist = []string{"198.51.100.1"}
+    lb.Reload(&next)
+    if rec := request("203.0.113.7"); rec.Code != http.StatusOK {
+        t.Errorf("Expected the unbanned address to pass with its token intact, got %d", rec.Code)
+    }
+    if rec := request("198.51.100.1"); rec.Code != http.StatusForbidden {
+        t.Errorf("Expected the newly banned address to get 403, got %d", rec.Code)
+    }
+}
+
+func TestProxy_MaintenanceModeWithAllowlistAndAdminToggle(t *testing.T) {
+    var hits atomic.Int32
+    backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        hits.Add(1)
+    }))
+    defer backend.Close()
+
+    page := filepath.Join(t.TempDir(), "maintenance.html")
+    if err := os.WriteFile(page, []byte("<h1>Back soon</h1>"), 0o600); err != nil {
+        t.Fatal(err)
+    }
+    lb := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+        cfg.Admin.Tokens = map[string]string{"alice": "secret"}
+        cfg.Maintenance = config.MaintenanceConfig{
+            Enabled:    true,
+            BodyFile:   page,
+            RetryAfter: 5 * time.Minute,
+            Paths:      []string{"/api"},
+            Allowlist:  []string{"10.0.0.0/8"},
+        }
+    })
+    handler := lb.Handler()
+    request := func(ip, path string) *httptest.ResponseRecorder {
+        req := httptest.NewRequest(http.MethodGet, path, nil)
+        req.RemoteAddr = ip + ":4000"
+        rec := httptest.NewRecorder()
+        handler.ServeHTTP(rec, req)
+        return rec
+    }
+
+    rec := request("192.0.2.1", "/api/users")
+    if rec.Code != http.StatusServiceUnavailable || rec.Body.String() != "<h1>Back soon</h1>" {
+        t.Fatalf("Expected the maintenance page with 503, got %d %q", rec.Code, rec.Body.String())
+    }
+    if rec.Header().Get("Retry-After") != "300" || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+        t.Errorf("Unexpected maintenance headers: %v", rec.Header())
+    }
+    if rec := request("192.0.2.1", "/static/app.js"); rec.Code != http.StatusOK {
+        t.Errorf("Expected paths outside maintenance.paths to be proxied, got %d", rec.Code)
+    }
+    if rec := request("10.1.2.3", "/api/users"); rec.Code != http.StatusOK {
+        t.Errorf("Expected an allowlisted operator to bypass maintenance, got %d", rec.Code)
+    }
+    if hits.Load() != 2 {
+        t.Errorf("Expected exactly 2 requests to reach the backend, got %d", hits.Load())
+    }
+
+    admin := lb.AdminHandler()
+    toggle := func(method string) maintenanceState {
+        req := httptest.NewRequest(method, "/admin/maintenance", nil)
+        req.Header.Set("Authorization", "Bearer secret")
+        rec := httptest.NewRecorder()
+        admin.ServeHTTP(rec, req)
+        var state maintenanceState
+        if err := json.Unmarshal(rec.Body.Bytes(), &state); err != nil {
+            t.Fatalf("Invalid admin response %d %q: %v", rec.Code, rec.Body.String(), err)
+        }
+        return state
+    }
+    if state := toggle(http.MethodDelete); state.Enabled {
+        t.Error("Expected maintenance to be disabled via admin API")
+    }
+    if rec := request("192.0.2.1", "/api/users"); rec.Code != http.StatusOK {
+        t.Errorf("Expected requests to be proxied after disabling maintenance, got %d", rec.Code)
+    }
+
+    // Выгрузка конфигурации отражает переключение, а reload включает режим снова
+    next := *lb.EffectiveConfig()
+    if next.Maintenance.Enabled {
+        t.Error("Expected exported config to reflect the admin toggle")
+    }
+    next.Maintenance.Enabled = true
+    next.Maintenance.Paths = nil
+    next.Maintenance.Body = "maintenance"
+    next.Maintenance.BodyFile = ""
+    lb.Reload(&next)
+    if rec := request("192.0.2.1", "/anything"); rec.Code != http.StatusServiceUnavailable || rec.Body.String() != "maintenance" {
+        t.Errorf("Expected reload to re-enable maintenance for all paths, got %d %q", rec.Code, rec.Body.String())
+    }
+}
+
+// maintenanceState — ответ /admin/maintenance.
+type maintenanceState struct {
+    Enabled bool `json:"enabled"`
+    Status  int  `json:"status"`
+}
+
+func TestProxy_ClientLimitsFromConfig(t *testing.T) {
+    backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+    defer backend.Close()
+
+    path := filepath.Join(t.TempDir(), "config.yaml")
+    content := "port: 8080\nbackends:\n  - \"" + backend.URL + "\"\nrate_limit:\n  capacity: 1\n  refill_rate: 1\n" +
+        "  clients:\n    \"10.0.0.9\": {capacity: 3, refill_rate: 1}\n"
+    if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+        t.Fatal(err)
+    }
+    cfg, err := config.Load(path)
+    if err != nil {
+        t.Fatalf("Load: %v", err)
+    }
+    handler := proxy.NewProxyServer(cfg, zap.NewNop().Sugar()).Handler()
+
+    allowed := func(ip string) int {
+        n := 0
+        for i := 0; i < 5; i++ {
+            req := httptest.NewRequest(http.MethodGet, "/", nil)
+            req.RemoteAddr = ip + ":4000"
+            rec := httptest.NewRecorder()
+            handler.ServeHTTP(rec, req)
+            if rec.Code == http.StatusOK {
+                n++
+            }
+        }
+        return n
+    }
+    if n := allowed("10.0.0.9"); n != 3 {
+        t.Errorf("Expected the configured client to get 3 requests, got %d", n)
+    }
+    if n := allowed("10.0.0.1"); n != 1 {
+        t.Errorf("Expected other clients to keep the default limit, got %d", n)
+    }
+
+    content = strings.Replace(content, "capacity: 3", "capacity: -3", 1)
+    if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := config.Load(path); err == nil || !strings.Contains(err.Error(), "rate_limit.clients.10.0.0.9.capacity") {
+        t.Errorf("Expected negative client capacity to be rejected, got %v", err)
+    }
+}
+
+func TestProxy_UnixSocketListener(t *testing.T) {
+    backend := echoBackend("backend")
+    defer backend.Close()
+
+    // Путь к сокету ограничен ~100 байтами, поэтому не t.TempDir() с длинным именем теста
+    dir, err := os.MkdirTemp("", "lb")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    socket := filepath.Join(dir, "lb.sock")
+    // Файл от аварийно завершенного процесса не мешает старту
+    stale, err := net.Listen("unix", socket)
+    if err != nil {
+        t.Fatal(err)
+    }
+    stale.(*net.UnixListener).SetUnlinkOnClose(false)
+    stale.Close()
+
+    lb := newTestProxy(t, backend.URL, nil)
+    listener, err := lb.Listen("unix:" + socket)
+    if err != nil {
+        t.Fatalf("Listen on a unix socket failed: %v", err)
+    }
+    go lb.Serve(listener)
+
+    client := &http.Client{Transport: &http.Transport{
+        DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+            return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+        },
+    }}
+    resp, err := client.Get("http://lb/")
+    if err != nil {
+        t.Fatalf("Request over the unix socket failed: %v", err)
+    }
+    body, _ := io.ReadAll(resp.Body)
+    resp.Body.Close()
+    if string(body) != "backend:" {
+        t.Errorf("Expected the backend response over the unix socket, got %q", body)
+    }
+
+    lb.Shutdown()
+    if _, err := os.Stat(socket); !os.IsNotExist(err) {
+        t.Errorf("Expected the socket file to be removed on shutdown, got %v", err)
+    }
+}
+
+func TestProxy_ProxyProtocolFromTrustedUpstream(t *testing.T) {
+    backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        fmt.Fprint(w, r.Header.Get("X-Forwarded-For"))
+    }))
+    defer backend.Close()
+
+    lb := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+        cfg.ProxyProtocol = config.ProxyProtocolConfig{Enabled: true, TrustedUpstreams: []string{"127.0.0.1"}}
+    })
+    listener, err := lb.Listen("127.0.0.1:0")
+    if err != nil {
+        t.Fatalf("Listen failed: %v", err)
+    }
+    go lb.Serve(listener)
+    defer lb.Shutdown()
+
+    send := func(header []byte) (string, error) {
+        conn, err := net.Dial("tcp", listener.Addr().String())
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer conn.Close()
+        conn.Write(append(header, "GET / HTTP/1.1\r\nHost: lb\r\nConnection: close\r\n\r\n"...))
+        resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+        if err != nil {
+            return "", err
+        }
+        defer resp.Body.Close()
+        body, _ := io.ReadAll(resp.Body)
+        return string(body), nil
+    }
+
+    if got, err := send([]byte("PROXY TCP4 203.0.113.7 10.0.0.1 51234 8080\r\n")); err != nil || got != "203.0.113.7" {
+        t.Errorf("v1: expected the client address from the header, got %q, %v", got, err)
+    }
+
+    v2 := append([]byte("\r\n\r\n\x00\r\nQUIT\n"), 0x21, 0x21, 0, 36)
+    v2 = append(v2, net.ParseIP("2001:db8::7").To16()...)
+    v2 = append(v2, net.ParseIP("2001:db8::1").To16()...)
+    v2 = append(v2, 0xc8, 0x22, 0x1f, 0x90)
+    if got, err := send(v2); err != nil || got != "2001:db8::7" {
+        t.Errorf("v2: expected the client address from the header, got %q, %v", got, err)
+    }
+
+    // Доверенный upstream без заголовка — запрос не проксируется
+    if got, err := send(nil); err == nil && !strings.Contains(got, "Bad Request") {
+        t.Errorf("Expected a connection without the header to be rejected, got %q", got)
+    }
+}
+
+func TestProxy_GracefulShutdownDrainsAndReportsInFlight(t *testing.T) {
+    release := make(chan struct{})
+    backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/slow" {
+            <-release
+        }
+    }))
+    defer backend.Close()
+    defer close(release)
+
+    core, logs := observer.New(zap.InfoLevel)
+    cfg := &config.Config{Port: 8080, Backends: []config.BackendConfig{{URL: backend.URL}}}
+    cfg.RateLimit.Capacity = 1000
+    cfg.RateLimit.RefillRate = 1000
+    cfg.Probes.ReadyPath = "/ready"
+    cfg.Shutdown = config.ShutdownConfig{DrainDelay: 300 * time.Millisecond, GracePeriod: 200 * time.Millisecond}
+    lb := proxy.NewProxyServer(cfg, zap.New(core).Sugar())
+    listener, err := lb.Listen("127.0.0.1:0")
+    if err != nil {
+        t.Fatalf("Listen failed: %v", err)
+    }
+    go lb.Serve(listener)
+    base := "http://" + listener.Addr().String()
+
+    get := func(path string) (int, error) {
+        resp, err := http.Get(base + path)
+        if err != nil {
+            return 0, err
+        }
+        resp.Body.Close()
+        return resp.StatusCode, nil
+    }
+    if code, err := get("/ready"); err != nil || code != http.StatusOK {
+        t.Fatalf("Expected /ready to pass before shutdown, got %d, %v", code, err)
+    }
+
+    slowDone := make(chan error, 1)
+    go func() {
+        _, err := get("/slow")
+        slowDone <- err
+    }()
+    time.Sleep(50 * time.Millisecond)
+
+    shutdownDone := make(chan struct{})
+    go func() {
+        lb.Shutdown()
+        close(shutdownDone)
+    }()
+    time.Sleep(50 * time.Millisecond)
+
+    // Во время drain_delay запросы еще принимаются, но проверка готовности не проходит
+    if code, err := get("/ready"); err != nil || code != http.StatusServiceUnavailable {
+        t.Errorf("Expected /ready to fail while draining, got %d, %v", code, err)
+    }
+    if code, err := get("/"); err != nil || code != http.StatusOK {
+        t.Errorf("Expected requests to be served during drain_delay, got %d, %v", code, err)
+    }
+
+    // Медленный запрос не укладывается в grace_period: в лог попадает число незавершенных запросов
+    <-shutdownDone
+    if logs.FilterMessageSnippet("with 1 requests still in flight").Len() != 1 {
+        t.Errorf("Expected the shutdown timeout to report 1 in-flight request, got logs %v", logs.All())
+    }
+    if _, err := get("/"); err == nil {
+        t.Error("Expected new connections to be refused after shutdown")
+    }
+}
+
+func TestProxy_ReadHeaderTimeoutClosesSlowClients(t *testing.T) {
+    backend := echoBackend("ok")
+    defer backend.Close()
+
+    lb := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+        cfg.Server.ReadHeaderTimeout = 200 * time.Millisecond
+    })
+    listener, err := lb.Listen("127.0.0.1:0")
+    if err != nil {
+        t.Fatalf("Listen failed: %v", err)
+    }
+    go lb.Serve(listener)
+    defer lb.Shutdown()
+
+    conn, err := net.Dial("tcp", listener.Addr().String())
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer conn.Close()
+
+    // Клиент начинает запрос и замолкает, не дослав заголовки
+    if _, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: lb\r\n")); err != nil {
+        t.Fatal(err)
+    }
+    start := time.Now()
+    conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+    io.ReadAll(conn)
+    if elapsed := time.Since(start); elapsed > 2*time.Second {
+        t.Errorf("Expected the slow client to be disconnected after read_header_timeout, waited %s", elapsed)
+    }
+}
+
+func TestProxy_AccessLogRecordsStatusAndBackend(t *testing.T) {
+    backend := echoBackend("hello")
+    defer backend.Close()
+
+    core, logs := observer.New(zap.DebugLevel)
+    cfg := &config.Config{Port: 8080, Backends: []config.BackendConfig{{URL: backend.URL}}}
+    cfg.RateLimit.Capacity = 1
+    cfg.RateLimit.RefillRate = 1
+    cfg.AccessLog = config.AccessLogConfig{Enabled: true, Level: "debug"}
+    handler := proxy.NewProxyServer(cfg, zap.New(core).Sugar()).Handler()
+
+    for i := 0; i < 2; i++ {
+        req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+        req.RemoteAddr = "10.0.0.5:4000"
+        handler.ServeHTTP(httptest.NewRecorder(), req)
+    }
+
+    entries := logs.FilterMessage("Access").All()
+    if len(entries) != 2 {
+        t.Fatalf("Expected one access log line per request, got %d", len(entries))
+    }
+    proxied, limited := entries[0].ContextMap(), entries[1].ContextMap()
+    if entries[0].Level != zap.DebugLevel || proxied["status"] != int64(http.StatusOK) ||
+        proxied["backend"] != backend.URL || proxied["bytes"] != int64(len("hello:")) ||
+        proxied["path"] != "/orders" || proxied["client_ip"] != "10.0.0.5" {
+        t.Errorf("Unexpected access log for a proxied request: %v", proxied)
+    }
+    if limited["status"] != int64(http.StatusTooManyRequests) || limited["backend"] != "" {
+        t.Errorf("Expected the rate limited request to be logged with 429 and no backend, got %v", limited)
+    }
+}
+
+func TestProxy_RequestIDPropagation(t *testing.T) {
+    var seen atomic.Value
+    backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        seen.Store(r.Header.Get("X-Correlation-ID"))
+        w.Header().Set("X-Correlation-ID", r.Header.Get("X-Correlation-ID"))
+    }))
+    defer backend.Close()
+
+    core, logs := observer.New(zap.InfoLevel)
+    cfg := &config.Config{Port: 8080, Backends: []config.BackendConfig{{URL: backend.URL}}}
+    cfg.RateLimit.Capacity = 1000
+    cfg.RateLimit.RefillRate = 1000
+    cfg.RequestID.Header = "X-Correlation-ID"
+    handler := proxy.NewProxyServer(cfg, zap.New(core).Sugar()).Handler()
+
+    // Идентификатор клиента передается backend'у и возвращается в ответе ровно один раз
+    req := httptest.NewRequest(http.MethodGet, "/", nil)
+    req.Header.Set("X-Correlation-ID", "trace-42")
+    rec := httptest.NewRecorder()
+    handler.ServeHTTP(rec, req)
+    if got := rec.Header().Values("X-Correlation-ID"); len(got) != 1 || got[0] != "trace-42" {
+        t.Errorf("Expected the client ID to be echoed once, got %v", got)
+    }
+    if seen.Load() != "trace-42" {
+        t.Errorf("Expected the backend to receive the client ID, got %v", seen.Load())
+    }
+    forwarding := logs.FilterMessageSnippet("Forwarding request").All()
+    if len(forwarding) != 1 || forwarding[0].ContextMap()["request_id"] != "trace-42" {
+        t.Errorf("Expected per-request logs to carry request_id, got %v", forwarding)
+    }
+
+    // Без заголовка идентификатор генерируется
+    rec = httptest.NewRecorder()
+    handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+    generated := rec.Header().Get("X-Correlation-ID")
+    if len(generated) != 32 || seen.Load() != generated {
+        t.Errorf("Expected a generated ID sent to the backend and the client, got %q (backend saw %v)", generated, seen.Load())
+    }
+}
+
+func TestProxy_PathRoutingLongestPrefix(t *testing.T) {
+    api, admin, static := echoBackend("api"), echoBackend("admin"), echoBackend("static")
+    defer api.Close()
+    defer admin.Close()
+    defer static.Close()
+
+    cfg := &config.Config{Port: 8080}
+    cfg.RateLimit.Capacity = 1000
+    cfg.RateLimit.RefillRate = 1000
+    cfg.Routes = []config.Route{
+        {Prefix: "/api", Strategy: "least_connections", Backends: []config.BackendConfig{{URL: api.URL}}},
+        {Prefix: "/api/admin/", Backends: []config.BackendConfig{{URL: admin.URL}}},
+        {Prefix: "/static", Backends: []config.BackendConfig{{URL: static.URL}}},
+    }
+    if err := cfg.Validate(); err != nil {
+        t.Fatalf("Routes without top-level backends should be valid: %v", err)
+    }
+    handler := proxy.NewProxyServer(cfg, zap.NewNop().Sugar()).Handler()
+
+    for path, want := range map[string]string{
+        "/api":             "api:",
+        "/api/orders":      "api:",
+        "/api/admin":       "admin:",
+        "/api/admin/users": "admin:",
+        "/static/app.js":   "static:",
+    } {
+        rec := httptest.NewRecorder()
+        handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+        if rec.Code != http.StatusOK || rec.Body.String() != want {
+            t.Errorf("%s: expected 200 %q, got %d %q", path, want, rec.Code, rec.Body.String())
+        }
+    }
+    for _, path := range []string{"/apiary", "/", "/other"} {
+        rec := httptest.NewRecorder()
+        handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+        if rec.Code != http.StatusNotFound {
+            t.Errorf("%s: expected 404, got %d", path, rec.Code)
+        }
+    }
+
+    cfg.Routes = append(cfg.Routes, config.Route{Prefix: "/static/", Backends: []config.BackendConfig{{URL: "static:9002"}}})
+    err := cfg.Validate()
+    if err == nil || !strings.Contains(err.Error(), "routes[3].prefix") || !strings.Contains(err.Error(), "routes[3].backends[0].url") {
+        t.Errorf("Expected duplicate prefix and invalid URL to be reported, got %v", err)
+    }
+}
+
+func TestProxy_HostRoutingWithWildcardAndDefault(t *testing.T) {
+    api, app, fallback := echoBackend("api"), echoBackend("app"), echoBackend("default")
+    defer api.Close()
+    defer app.Close()
+    defer fallback.Close()
+
+    lb := newTestProxy(t, fallback.URL, func(cfg *config.Config) {
+        cfg.Hosts = map[string]config.BackendGroup{
+            "api.example.com": {Backends: []config.BackendConfig{{URL: api.URL}}},
+            "*.example.com":   {Backends: []config.BackendConfig{{URL: app.URL}}},
+        }
+    })
+    handler := lb.Handler()
+
+    for host, want := range map[string]string{
+        "api.example.com":      "api:",
+        "API.Example.com:8443": "api:",
+        "app.example.com":      "app:",
+        "a.b.example.com":      "app:",
+        "example.com":          "default:",
+        "other.org":            "default:",
+    } {
+        req := httptest.NewRequest(http.MethodGet, "/", nil)
+        req.Host = host
+        rec := httptest.NewRecorder()
+        handler.ServeHTTP(rec, req)
+        if rec.Body.String() != want {
+            t.Errorf("Host %s: expected %q, got %d %q", host, want, rec.Code, rec.Body.String())
+        }
+    }
+}
+
+func TestProxy_RewriteStripsPrefix(t *testing.T) {
+    backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        fmt.Fprintf(w, "%s %s", r.Host, r.URL.RequestURI())
+    }))
+    defer backend.Close()
+    host := strings.TrimPrefix(backend.URL, "http://")
+
+    for _, stripPrefix := range []string{"/api", "/api/"} {
+        lb := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+            cfg.Rewrite = []config.RewriteRule{
+                {StripPrefix: stripPrefix},
+                {Regex: `^/v1/(.*)$`, Replacement: "/v2/$1"},
+            }
+        })
+        for path, want := range map[string]string{
+            "/api/users":     "/users",
+            "/api/users/":    "/users/",
+            "/api/":          "/",
+            "/api":           "/",
+            "/api/users?q=1": "/users?q=1",
+            "/apiary":        "/apiary",
+            "/api/v1/items":  "/v2/items",
+        } {
+            rec := httptest.NewRecorder()
+            lb.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+            if got := rec.Body.String(); got != host+" "+want {
+                t.Errorf("strip_prefix %s: %s forwarded as %q, expected %q", stripPrefix, path, got, host+" "+want)
+            }
+        }
+    }
+
+    cfg := &config.Config{Port: 8080, Backends: []config.BackendConfig{{URL: backend.URL}}}
+    cfg.Rewrite = []config.RewriteRule{{StripPrefix: "api"}, {Regex: "("}}
+    if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "rewrite[0].strip_prefix") || !strings.Contains(err.Error(), "rewrite[1].regex") {
+        t.Errorf("expected rewrite validation errors, got %v", err)
+    }
+}
+
+func TestProxy_HeaderRules(t *testing.T) {
+    backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Server", "backend/1.0")
+        w.Header().Set("X-Request-Proto", r.Header.Get("X-Forwarded-Proto"))
+        w.Header().Set("X-Request-Debug", r.Header.Get("X-Debug"))
+        w.Header().Set("X-Request-Forwarded-For", r.Header.Get("X-Forwarded-For"))
+    }))
+    defer backend.Close()
+
+    lb := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+        cfg.RequestHeaders = config.HeaderRules{
+            Add:    map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-For": "10.0.0.1"},
+            Remove: []string{"X-Debug"},
+        }
+        cfg.ResponseHeaders = config.HeaderRules{
+            Add:    map[string]string{"X-Frame-Options": "DENY"},
+            Remove: []string{"Server"},
+        }
+    })
+
+    req := httptest.NewRequest(http.MethodGet, "/", nil)
+    req.Header.Set("X-Debug", "1")
+    req.Header.Set("X-Forwarded-For", "203.0.113.7")
+    rec := httptest.NewRecorder()
+    lb.Handler().ServeHTTP(rec, req)
+
+    if got := rec.Header().Get("X-Request-Proto"); got != "https" {
+        t.Errorf("expected X-Forwarded-Proto https at backend, got %q", got)
+    }
+    if got := rec.Header().Get("X-Request-Debug"); got != "" {
+        t.Errorf("expected X-Debug to be removed, got %q", got)
+    }
+    if got := rec.Header().Get("X-Request-Forwarded-For"); got != "203.0.113.7, 10.0.0.1, 192.0.2.1" {
+        t.Errorf("expected X-Forwarded-For to be appended to, got %q", got)
+    }
+    if got := rec.Header().Get("Server"); got != "" {
+        t.Errorf("expected Server to be removed from response, got %q", got)
+    }
+    if got := rec.Header().Get("X-Frame-Options"); got != "DENY" {
+        t.Errorf("expected X-Frame-Options DENY, got %q", got)
+    }
+}
+
+func TestProxy_UpstreamDebugHeaders(t *testing.T) {
+    backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+    defer backend.Close()
+    get := func(lb *proxy.ProxyServer) http.Header {
+        rec := httptest.NewRecorder()
+        lb.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+        if rec.Code != http.StatusOK {
+            t.Fatalf("Expected 200, got %d", rec.Code)
+        }
+        return rec.Header()
+    }
+
+    // По умолчанию топология не раскрывается
+    header := get(newTestProxy(t, backend.URL, nil))
+    if header.Get("X-Upstream") != "" || header.Get("X-Upstream-Duration") != "" {
+        t.Errorf("Expected no upstream headers by default, got %v", header)
+    }
+
+    header = get(newTestProxy(t, backend.URL, func(cfg *config.Config) {
+        cfg.UpstreamHeaders = config.UpstreamHeadersConfig{Enabled: true, BackendHeader: "X-Served-By", DurationHeader: "X-Served-In"}
+    }))
+    if got := header.Get("X-Served-By"); got != backend.URL {
+        t.Errorf("Expected X-Served-By %s, got %q", backend.URL, got)
+    }
+    if _, err := time.ParseDuration(header.Get("X-Served-In")); err != nil {
+        t.Errorf("Expected a duration in X-Served-In, got %q", header.Get("X-Served-In"))
+    }
+    if header.Get("X-Upstream") != "" || header.Get("X-Upstream-Duration") != "" {
+        t.Errorf("Expected only the configured header names, got %v", header)
+    }
+}
+
+func TestProxy_ForwardedHeadersTrustedProxies(t *testing.T) {
+    backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        fmt.Fprintf(w, "%s|%s|%s", r.Header.Get("X-Forwarded-For"), r.Header.Get("X-Forwarded-Proto"), r.Header.Get("X-Forwarded-Host"))
+    }))
+    defer backend.Close()
+
+    lb := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+        cfg.TrustedProxies = []string{"10.0.0.0/8"}
+    })
+
+    tests := []struct {
+        name       string
+        remoteAddr string
+        tls        bool
+        want       string
+    }{
+        {"untrusted client cannot spoof", "192.0.2.1:1234", false, "192.0.2.1|http|shop.example.com"},
+        {"untrusted client over TLS", "192.0.2.1:1234", true, "192.0.2.1|https|shop.example.com"},
+        {"trusted proxy chain is kept", "10.0.0.5:4000", false, "203.0.113.7, 10.0.0.5|https|public.example.com"},
+    }
+    for _, tt := range tests {
+        req := httptest.NewRequest(http.MethodGet, "/", nil)
+        req.Host = "shop.example.com"
+        req.RemoteAddr = tt.remoteAddr
+        if tt.tls {
+            req.TLS = &tls.ConnectionState{}
+        }
+        req.Header.Set("X-Forwarded-For", "203.0.113.7")
+        req.Header.Set("X-Forwarded-Proto", "https")
+        req.Header.Set("X-Forwarded-Host", "public.example.com")
+        rec := httptest.NewRecorder()
+        lb.Handler().ServeHTTP(rec, req)
+        if got := rec.Body.String(); got != tt.want {
+            t.Errorf("%s: backend saw %q, expected %q", tt.name, got, tt.want)
+        }
+    }
+}
+
+func TestProxy_ClientIPFromTrustedProxiesOnly(t *testing.T) {
+    backend := echoBackend("ok")
+    defer backend.Close()
+
+    lb := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+        cfg.TrustedProxies = []string{"10.0.0.0/8"}
+        cfg.Denylist = []string{"203.0.113.7"}
+    })
+    handler := lb.Handler()
+
+    tests := []struct {
+        name       string
+        remoteAddr string
+        forwarded  string
+        want       int
+    }{
+        {"direct client cannot hide behind X-Forwarded-For", "203.0.113.7:1234", "198.51.100.2", http.StatusForbidden},
+        {"direct client cannot frame another IP", "192.0.2.1:1234", "203.0.113.7", http.StatusOK},
+        {"trusted hops are skipped right to left", "10.0.0.5:4000", "203.0.113.7, 10.0.0.9", http.StatusForbidden},
+        {"spoofed left part of the chain is ignored", "10.0.0.5:4000", "203.0.113.7, 198.51.100.2", http.StatusOK},
+    }
+    for _, tt := range tests {
+        req := httptest.NewRequest(http.MethodGet, "/", nil)
+        req.RemoteAddr = tt.remoteAddr
+        req.Header.Set("X-Forwarded-For", tt.forwarded)
+        req.Header.Set("X-Real-IP", tt.forwarded)
+        rec := httptest.NewRecorder()
+        handler.ServeHTTP(rec, req)
+        if rec.Code != tt.want {
+            t.Errorf("%s: expected %d, got %d", tt.name, tt.want, rec.Code)
+        }
+    }
+
+    // Лимит считается по адресу соединения, а не по подделанному заголовку
+    limited := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+        cfg.TrustedProxies = []string{"10.0.0.0/8"}
+        cfg.RateLimit.Capacity = 1
+        cfg.RateLimit.RefillRate = 0
+    }).Handler()
+    codes := make([]int, 0, 2)
+    for _, spoofed := range []string{"198.51.100.1", "198.51.100.2"} {
+        req := httptest.NewRequest(http.MethodGet, "/", nil)
+        req.Header.Set("X-Forwarded-For", spoofed)
+        rec := httptest.NewRecorder()
+        limited.ServeHTTP(rec, req)
+        codes = append(codes, rec.Code)
+    }
+    if codes[0] != http.StatusOK || codes[1] != http.StatusTooManyRequests {
+        t.Errorf("expected rate limit by connection IP (200, 429), got %v", codes)
+    }
+}
+
+func TestProxy_ReadyReportsNoValidBackends(t *testing.T) {
+    lb := newTestProxy(t, "https://backend1:9443", func(cfg *config.Config) {
+        cfg.Backends[0].TLS = &config.UpstreamTLSConfig{CAFile: filepath.Join(t.TempDir(), "missing.pem")}
+        cfg.Probes.ReadyPath = "/ready"
+    })
+    if lb.HasBackends() {
+        t.Fatal("Expected no backends when the only one fails to initialize")
+    }
+    rec := httptest.NewRecorder()
+    lb.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+    if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "no valid backends") {
+        t.Errorf("Expected readiness to report missing backends, got %d %s", rec.Code, rec.Body.String())
+    }
+}
+
+func TestProxy_ReadyWaitsForFirstHealthyBackend(t *testing.T) {
+    var healthy atomic.Bool
+    backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if !healthy.Load() {
+            w.WriteHeader(http.StatusServiceUnavailable)
+        }
+    }))
+    defer backend.Close()
+
+    interval := 50 * time.Millisecond
+    lb := newTestProxy(t, backend.URL, func(cfg *config.Config) {
+        cfg.HealthCheck = config.HealthCheckConfig{InitialState: "unhealthy", Interval: &interval, Timeout: 20 * time.Millisecond}
+        cfg.Probes = config.ProbesConfig{LivePath: "/health", ReadyPath: "/ready"}
+    })
+    handler := lb.Handler()
+    get := func(path string) *httptest.ResponseRecorder {
+        rec := httptest.NewRecorder()
+        handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+        return rec
+    }
+
+    // До первой успешной проверки backend не получает трафик, а прокси не готов
+    if rec := get("/ready"); rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "no healthy backends") {
+        t.Fatalf("Expected 503 no healthy backends before first probe, got %d %s", rec.Code, rec.Body.String())
+    }
+    if rec := get("/"); rec.Code != http.StatusServiceUnavailable {
+        t.Fatalf("Expected 503 for traffic before first probe, got %d", rec.Code)
+    }
+    // Процесс жив, даже когда не готов: liveness не зависит от backend'ов
+    if rec := get("/health"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "alive") {
+        t.Errorf("Expected liveness to pass while not ready, got %d %s", rec.Code, rec.Body.String())
+    }
+
+    healthy.Store(true)
+    deadline := time.Now().Add(time.Second)
+    for get("/ready").Code != http.StatusOK {
+        if time.Now().After(deadline) {
+            t.Fatal("Proxy did not become ready after backend passed health check")
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+    if rec := get("/"); rec.Code != http.StatusOK {
+        t.Errorf("Expected traffic to be served once ready, got %d", rec.Code)
+    }
+}
+
+// newTestCapture создает Sink с файлом во временном каталоге.
+func newTestCapture(t *testing.T, cfg config.CaptureConfig) (*capture.Sink, string) {
+    t.Helper()
+    cfg.Enabled = true
+    cfg.Path = filepath.Join(t.TempDir(), "capture.jsonl")
+    sink, err := capture.NewSink(cfg, zap.NewNop().Sugar())
+    if err != nil {
+        t.Fatalf("Failed to create capture sink: %v", err)
+    }
+    t.Cleanup(func() { sink.Close() })
+    return sink, cfg.Path
+}
+
+func readCaptureRecords(t *testing.T, path string) []capture.Record {
+    t.Helper()
+    data, err := os.ReadFile(path)
+    if err != nil {
+        t.Fatalf("Failed to read capture file: %v", err)
+    }
+    var records []capture.Record
+    for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+        if line == "" {
+            continue
+        }
+        var record capture.Record
+        if err := json.Unmarshal([]byte(line), &record); err != nil {
+            t.Fatalf("Invalid capture line %q: %v", line, err)
+        }
+        records = append(records, record)
+    }
+    return records
+}
+
+func TestCapture_RedactsAndTruncatesRecordedRequests(t *testing.T) {
+    sink, path := newTestCapture(t, config.CaptureConfig{
+        SampleRate:        1,
+        MaxBodyBytes:      8,
+        RedactHeaders:     []string{"X-Tenant-Token"},
+        RedactQueryParams: []string{"session"},
+    })
+    var received string
+    handler := sink.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/limited" {
+            // Запрос отклоняется, не читая тело (как rate limit)
+            w.WriteHeader(http.StatusTooManyRequests)
+            return
+        }
+        body, _ := io.ReadAll(r.Body)
+        received = string(body)
+    }))
+
+    req := httptest.NewRequest(http.MethodPost, "/orders?Token=abc&page=2&session=s1&flag", strings.NewReader("0123456789abcdef"))
+    req.Header.Set("Authorization", "Bearer secret")
+    req.Header.Set("X-Tenant-Token", "tenant-secret")
+    req.Header.Set("X-Trace", "trace-1")
+    handler.ServeHTTP(httptest.NewRecorder(), req)
+    if received != "0123456789abcdef" {
+        t.Errorf("Expected downstream to get the full body, got %q", received)
+    }
+
+    rec := httptest.NewRecorder()
+    handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/limited", strings.NewReader("small")))
+    if rec.Code != http.StatusTooManyRequests {
+        t.Fatalf("Expected 429 from downstream, got %d", rec.Code)
+    }
+
+    records := readCaptureRecords(t, path)
+    if len(records) != 2 {
+        t.Fatalf("Expected 2 records with sample_rate 1, got %d", len(records))
+    }
+    first := records[0]
+    if first.URI != "/orders?Token=%5BREDACTED%5D&page=2&session=%5BREDACTED%5D&flag" {
+        t.Errorf("Expected token and session values redacted in URI, got %q", first.URI)
+    }
+    for _, name := range []string{"Authorization", "X-Tenant-Token"} {
+        if got := first.Headers[name]; len(got) != 1 || got[0] != "[REDACTED]" {
+            t.Errorf("Expected %s to be redacted, got %v", name, got)
+        }
+    }
+    if got := first.Headers["X-Trace"]; len(got) != 1 || got[0] != "trace-1" {
+        t.Errorf("Expected X-Trace to be kept, got %v", got)
+    }
+    if string(first.Body) != "01234567" || !first.BodyTruncated {
+        t.Errorf("Expected body truncated to 8 bytes with body_truncated, got %q truncated=%v", first.Body, first.BodyTruncated)
+    }
+
+    // Тело отклоненного запроса записано целиком, хотя никто его не читал
+    second := records[1]
+    if string(second.Body) != "small" || second.BodyTruncated {
+        t.Errorf("Expected unread body recorded in full, got %q truncated=%v", second.Body, second.BodyTruncated)
+    }
+
+    raw, _ := os.ReadFile(path)
+    for _, secret := range []string{"secret", "abc", "s1"} {
+        if bytes.Contains(raw, []byte(secret)) {
+            t.Errorf("Capture file leaks %q: %s", secret, raw)
+        }
+    }
+}
+
+func TestCapture_SampleRateSkipsRequests(t *testing.T) {
+    sink, path := newTestCapture(t, config.CaptureConfig{SampleRate: 1e-9})
+    served := 0
+    handler := sink.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        served++
+    }))
+    for i := 0; i < 200; i++ {
+        handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+    }
+    if served != 200 {
+        t.Errorf("Expected all requests to be served, got %d", served)
+    }
+    if records := readCaptureRecords(t, path); len(records) != 0 {
+        t.Errorf("Expected no records with a near-zero sample rate, got %d", len(records))
+    }
+}
+
+func TestCapture_MaxFileBytesStopsWriting(t *testing.T) {
+    sink, path := newTestCapture(t, config.CaptureConfig{SampleRate: 1, MaxFileBytes: 600})
+    handler := sink.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+    send := func(n int) {
+        for i := 0; i < n; i++ {
+            handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/item/"+strconv.Itoa(i), nil))
+        }
+    }
+
+    send(20)
+    info, err := os.Stat(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    records := readCaptureRecords(t, path)
+    if info.Size() > 600 || len(records) == 0 || len(records) >= 20 {
+        t.Fatalf("Expected capture to stop at 600 bytes, got %d bytes in %d records", info.Size(), len(records))
+    }
+
+    // После достижения лимита запись не возобновляется
+    send(5)
+    if after, _ := os.Stat(path); after.Size() != info.Size() {
+        t.Errorf("Expected file size to stay at %d after the cap, got %d", info.Size(), after.Size())
+    }
+}
+
+func TestLog_LevelFormatAndFileOutput(t *testing.T) {
+    readLines := func(path string) []string {
+        t.Helper()
+        data, err := os.ReadFile(path)
+        if err != nil {
+            t.Fatalf("Failed to read log file: %v", err)
+        }
+        return strings.Split(strings.TrimSpace(string(data)), "\n")
+    }
+
+    // json: записи ниже warn отбрасываются, остальные — JSON-объекты
+    path := filepath.Join(t.TempDir(), "lb.log")
+    logger, err := applog.New(config.LogConfig{Level: "WARN", Output: path})
+    if err != nil {
+        t.Fatalf("New: %v", err)
+    }
+    logger.Debug("debug entry")
+    logger.Info("info entry")
+    logger.Warn("warn entry")
+    logger.Error("error entry")
+    logger.Sync()
+
+    lines := readLines(path)
+    if len(lines) != 2 {
+        t.Fatalf("Expected only warn and error entries, got %d lines:\n%s", len(lines), strings.Join(lines, "\n"))
+    }
+    for i, message := range []string{"warn entry", "error entry"} {
+        var entry map[string]interface{}
+        if err := json.Unmarshal([]byte(lines[i]), &entry); err != nil {
+            t.Fatalf("Expected JSON line, got %q: %v", lines[i], err)
+        }
+        if entry["msg"] != message {
+            t.Errorf("Expected msg %q, got %v", message, entry["msg"])
+        }
+    }
+
+    // console: человекочитаемые строки, уровень по умолчанию info
+    path = filepath.Join(t.TempDir(), "console.log")
+    logger, err = applog.New(config.LogConfig{Format: config.LogFormatConsole, Output: path})
+    if err != nil {
+        t.Fatalf("New: %v", err)
+    }
+    logger.Debug("debug entry")
+    logger.Infow("info entry", "backend", "b1")
+    logger.Sync()
+
+    lines = readLines(path)
+    if len(lines) != 1 || !strings.Contains(lines[0], "INFO") || !strings.Contains(lines[0], "info entry") {
+        t.Fatalf("Expected one console info line, got %q", lines)
+    }
+    if json.Valid([]byte(lines[0])) {
+        t.Errorf("Expected a non-JSON console line, got %q", lines[0])
+    }
+
+    if _, err := applog.New(config.LogConfig{Level: "loud"}); err == nil || !strings.Contains(err.Error(), "log.level") {
+        t.Errorf("Expected unknown level to be rejected, got %v", err)
+    }
+}
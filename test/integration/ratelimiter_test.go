@@ -1,11 +1,22 @@
 package integration
 
 import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "os"
+    "path/filepath"
+    "strings"
     "testing"
     "time"
 
+    "github.com/Manzo48/loadBalancer/internal/config"
+    "github.com/Manzo48/loadBalancer/internal/metrics"
     "github.com/Manzo48/loadBalancer/internal/ratelimiter"
+    "github.com/Manzo48/loadBalancer/internal/urlkey"
     "go.uber.org/zap"
+    "go.uber.org/zap/zaptest/observer"
 )
 
 
@@ -75,3 +86,420 @@ func TestRateLimiter_MultipleClients(t *testing.T) {
     }
 }
 
+
+func TestRateLimiter_GlobalLimit(t *testing.T) {
+    logger := zap.NewNop().Sugar()
+    rl := ratelimiter.NewRateLimiter(2, 1, logger)
+    rl.SetGlobalLimit(3, 1)
+
+    // Каждый клиент укладывается в свой лимит, но вместе они исчерпывают общий бакет
+    for _, client := range []string{"a", "b", "c"} {
+        if !rl.Allow(client) {
+            t.Fatalf("Request from %s should fit the global limit", client)
+        }
+    }
+    if rl.Allow("d") {
+        t.Error("Expected 429 once the global bucket is empty, even with client tokens left")
+    }
+
+    // Отказ по глобальному лимиту не тратит токены клиента; без глобального лимита действует только клиентский
+    rl.SetGlobalLimit(0, 0)
+    if !rl.Allow("d") || !rl.Allow("d") || rl.Allow("d") {
+        t.Error("Expected client d to keep both of its tokens after the global rejection")
+    }
+}
+
+func TestRateLimiter_SlidingWindow(t *testing.T) {
+    logger := zap.NewNop().Sugar()
+    rl := ratelimiter.NewRateLimiter(4, 20, logger) // Окно 4 / 20 = 200ms
+    if err := rl.SetAlgorithm(ratelimiter.AlgorithmSlidingWindow); err != nil {
+        t.Fatal(err)
+    }
+
+    for i := 0; i < 4; i++ {
+        if !rl.Allow("client1") {
+            t.Fatalf("Request %d should be allowed", i+1)
+        }
+    }
+    // Токен-бакет за 100ms пополнился бы на 2 токена, а окно еще целиком занято
+    time.Sleep(100 * time.Millisecond)
+    if rl.Allow("client1") {
+        t.Error("Expected request to be blocked while the window is full")
+    }
+
+    time.Sleep(150 * time.Millisecond)
+    if !rl.Allow("client1") {
+        t.Error("Request should be allowed once the first requests left the window")
+    }
+}
+
+func TestRateLimiter_LeakyBucketSmoothsBurst(t *testing.T) {
+    logger := zap.NewNop().Sugar()
+    rl := ratelimiter.NewRateLimiter(3, 20, logger) // Очередь на 3 запроса, выход раз в 50ms
+    if err := rl.SetAlgorithm(ratelimiter.AlgorithmLeakyBucket); err != nil {
+        t.Fatal(err)
+    }
+
+    start := time.Now()
+    results := make(chan time.Duration, 5)
+    for i := 0; i < 5; i++ {
+        go func() {
+            if rl.Allow("client1") {
+                results <- time.Since(start)
+            } else {
+                results <- -1
+            }
+        }()
+    }
+
+    var passed []time.Duration
+    for i := 0; i < 5; i++ {
+        if elapsed := <-results; elapsed >= 0 {
+            passed = append(passed, elapsed)
+        }
+    }
+    if len(passed) != 3 {
+        t.Fatalf("Expected queue depth 3 to admit 3 of 5 simultaneous requests, got %d", len(passed))
+    }
+    // Всплеск не проходит разом: последний из очереди выходит через 2 интервала
+    slowest := max(passed[0], passed[1], passed[2])
+    if slowest < 90*time.Millisecond {
+        t.Errorf("Expected queued requests to be released at the drain rate, last one after %s", slowest)
+    }
+
+    time.Sleep(150 * time.Millisecond)
+    if !rl.Allow("client1") {
+        t.Error("Request should be allowed once the queue drained")
+    }
+}
+
+func TestRateLimiter_LeakyBucketReleasesSlotOnCancel(t *testing.T) {
+    rl := ratelimiter.NewRateLimiter(2, 2, zap.NewNop().Sugar()) // Очередь на 2 запроса, выход раз в 500ms
+    if err := rl.SetAlgorithm(ratelimiter.AlgorithmLeakyBucket); err != nil {
+        t.Fatal(err)
+    }
+    if !rl.Allow("client1") {
+        t.Fatal("First request should pass immediately")
+    }
+
+    // Второй запрос встает в очередь на 500ms, но клиент уходит через 50ms
+    ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+    defer cancel()
+    start := time.Now()
+    if allowed, _ := rl.AllowWithInfo(ctx, "client1"); allowed {
+        t.Fatal("Expected a cancelled queued request to be rejected")
+    }
+    if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+        t.Errorf("Expected the wait to stop on cancel, it took %s", elapsed)
+    }
+
+    // Место ушедшего клиента освобождено: следующий встает в очередь, а не получает отказ
+    if allowed, _ := rl.AllowWithInfo(context.Background(), "client1"); !allowed {
+        t.Error("Expected the released queue slot to be reused")
+    }
+}
+
+func TestRateLimiter_CleanupCapsBuckets(t *testing.T) {
+    logger := zap.NewNop().Sugar()
+    rl := ratelimiter.NewRateLimiter(1, 1, logger)
+    rl.SetMaxBuckets(2)
+
+    for _, client := range []string{"old", "mid", "new"} {
+        rl.Allow(client)
+        time.Sleep(5 * time.Millisecond)
+    }
+
+    // Неактивных бакетов нет, но порог превышен: вытесняется самый давно активный
+    if evicted := rl.Cleanup(time.Hour); evicted != 1 {
+        t.Fatalf("Expected 1 evicted bucket, got %d", evicted)
+    }
+    if !rl.Allow("old") {
+        t.Error("Expected the evicted client to start with a fresh bucket")
+    }
+    if rl.Allow("new") {
+        t.Error("Expected the most recent client to keep its exhausted bucket")
+    }
+}
+
+func TestRateLimiter_SnapshotReportsFillLevels(t *testing.T) {
+    logger := zap.NewNop().Sugar()
+    rl := ratelimiter.NewRateLimiter(5, 1, logger)
+    rl.SetClientLimit("vip", ratelimiter.ClientLimit{Capacity: 10, RefillRate: 1})
+
+    before := time.Now()
+    for i := 0; i < 3; i++ {
+        rl.Allow("alice")
+    }
+    rl.Allow("vip")
+
+    clients := rl.Snapshot()
+    if len(clients) != 2 {
+        t.Fatalf("Expected 2 active clients, got %+v", clients)
+    }
+    alice, vip := clients[0], clients[1]
+    if alice.ClientID != "alice" || alice.Tokens != 2 || alice.Capacity != 5 {
+        t.Errorf("Unexpected alice bucket: %+v", alice)
+    }
+    if vip.ClientID != "vip" || vip.Tokens != 9 || vip.Capacity != 10 {
+        t.Errorf("Unexpected vip bucket: %+v", vip)
+    }
+    if alice.LastSeen.Before(before) {
+        t.Errorf("Expected last seen time to be updated, got %s", alice.LastSeen)
+    }
+}
+
+func TestRateLimitMiddleware_Headers(t *testing.T) {
+    logger := zap.NewNop().Sugar()
+    rl := ratelimiter.NewRateLimiter(2, 1, logger)
+    handler := ratelimiter.RateLimitMiddleware(rl, logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+    expected := []struct {
+        code       int
+        remaining  string
+        retryAfter string
+    }{
+        {http.StatusOK, "1", ""},
+        {http.StatusOK, "0", "1"}, // Токены кончились: следующий запрос стоит отложить
+        {http.StatusTooManyRequests, "0", "1"},
+    }
+    for i, want := range expected {
+        rec := httptest.NewRecorder()
+        handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+        if rec.Code != want.code || rec.Header().Get("X-RateLimit-Limit") != "2" ||
+            rec.Header().Get("X-RateLimit-Remaining") != want.remaining || rec.Header().Get("Retry-After") != want.retryAfter {
+            t.Errorf("Request %d: got %d, limit %q, remaining %q, Retry-After %q", i+1, rec.Code,
+                rec.Header().Get("X-RateLimit-Limit"), rec.Header().Get("X-RateLimit-Remaining"), rec.Header().Get("Retry-After"))
+        }
+    }
+}
+
+func TestRateLimitMiddleware_AllowlistBypassesLimit(t *testing.T) {
+    core, logs := observer.New(zap.DebugLevel)
+    logger := zap.New(core).Sugar()
+    rl := ratelimiter.NewRateLimiter(1, 1, logger)
+    if err := rl.SetAllowlist([]string{"10.0.0.0/8", "192.168.1.10"}); err != nil {
+        t.Fatalf("SetAllowlist failed: %v", err)
+    }
+    handler := ratelimiter.RateLimitMiddleware(rl, logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+    request := func(ip string) int {
+        req := httptest.NewRequest(http.MethodGet, "/", nil)
+        req.RemoteAddr = ip + ":5000"
+        rec := httptest.NewRecorder()
+        handler.ServeHTTP(rec, req)
+        return rec.Code
+    }
+    for i := 0; i < 5; i++ {
+        if code := request("10.20.30.40"); code != http.StatusOK {
+            t.Fatalf("Allowlisted range throttled on request %d: %d", i+1, code)
+        }
+        if code := request("192.168.1.10"); code != http.StatusOK {
+            t.Fatalf("Allowlisted address throttled on request %d: %d", i+1, code)
+        }
+    }
+    if request("192.168.1.11") != http.StatusOK || request("192.168.1.11") != http.StatusTooManyRequests {
+        t.Error("Expected addresses outside the allowlist to be limited")
+    }
+    if logs.FilterMessage("Rate limit bypassed (allowlist)").Len() != 10 {
+        t.Errorf("Expected a debug entry per bypassed request, got %d", logs.FilterMessage("Rate limit bypassed (allowlist)").Len())
+    }
+
+    if err := rl.SetAllowlist([]string{"10.0.0.0/33"}); err == nil {
+        t.Error("Expected an invalid CIDR to be rejected")
+    }
+}
+
+func TestQuotaTracker_DailyLimit(t *testing.T) {
+    logger := zap.NewNop().Sugar()
+    qt := ratelimiter.NewQuotaTracker(
+        ratelimiter.QuotaPolicy{Limit: 3, Period: ratelimiter.QuotaPeriodDay},
+        map[string]ratelimiter.QuotaPolicy{"premium": {Limit: 10, Period: ratelimiter.QuotaPeriodMonth}},
+        ratelimiter.QuotaWindowCalendar, "", logger)
+
+    for i := 0; i < 3; i++ {
+        if _, ok := qt.Consume("client1"); !ok {
+            t.Errorf("Request %d should be within quota", i+1)
+        }
+    }
+    status, ok := qt.Consume("client1")
+    if ok {
+        t.Error("Expected quota to be exhausted")
+    }
+    if status.Remaining != 0 || !status.ResetAt.After(time.Now()) {
+        t.Errorf("Unexpected quota status: %+v", status)
+    }
+
+    if status := qt.Status("premium"); status.Limit != 10 || status.Remaining != 10 {
+        t.Errorf("Expected individual quota for premium client, got %+v", status)
+    }
+}
+
+func TestRateLimiter_StateSurvivesRestart(t *testing.T) {
+    logger := zap.NewNop().Sugar()
+    store := ratelimiter.NewFileStore(filepath.Join(t.TempDir(), "buckets.json"))
+
+    rl := ratelimiter.NewRateLimiter(3, 1, logger)
+    for i := 0; i < 3; i++ {
+        rl.Allow("client1")
+    }
+    if err := rl.SaveState(store); err != nil {
+        t.Fatalf("SaveState failed: %v", err)
+    }
+
+    // "Рестарт": новый лимитер восстанавливает пустой бакет клиента
+    restarted := ratelimiter.NewRateLimiter(3, 1, logger)
+    if restored, err := restarted.RestoreState(store, time.Minute); err != nil || restored != 1 {
+        t.Fatalf("Expected 1 restored bucket, got %d (err: %v)", restored, err)
+    }
+    if restarted.Allow("client1") {
+        t.Error("Expected restored empty bucket to reject the request")
+    }
+    if !restarted.Allow("client2") {
+        t.Error("Expected unknown client to get a fresh bucket")
+    }
+}
+
+func TestRateLimiter_CompositeKey(t *testing.T) {
+    keyFunc, err := ratelimiter.NewKeyFunc([]string{"ip", "user_agent"}, nil)
+    if err != nil {
+        t.Fatalf("NewKeyFunc: %v", err)
+    }
+    rl := ratelimiter.NewRateLimiter(1, 1, zap.NewNop().Sugar())
+    rl.SetKeyFunc(keyFunc)
+    handler := ratelimiter.RateLimitMiddleware(rl, zap.NewNop().Sugar())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+    request := func(userAgent string) int {
+        req := httptest.NewRequest(http.MethodGet, "/", nil)
+        req.RemoteAddr = "10.0.0.1:1234"
+        req.Header.Set("User-Agent", userAgent)
+        rec := httptest.NewRecorder()
+        handler.ServeHTTP(rec, req)
+        return rec.Code
+    }
+
+    if code := request("scraper/1.0"); code != http.StatusOK {
+        t.Fatalf("First request: expected 200, got %d", code)
+    }
+    if code := request("scraper/1.0"); code != http.StatusTooManyRequests {
+        t.Errorf("Same IP and user agent: expected 429, got %d", code)
+    }
+    // Тот же IP с другим User-Agent — отдельный бакет
+    if code := request("browser/2.0"); code != http.StatusOK {
+        t.Errorf("Different user agent: expected 200, got %d", code)
+    }
+
+    if _, err := ratelimiter.NewKeyFunc([]string{"ip", "cookie"}, nil); err == nil {
+        t.Error("Expected error for unknown key source")
+    }
+
+    // config.Load проверяет rate_limit.key по тому же списку источников
+    path := filepath.Join(t.TempDir(), "config.yaml")
+    for _, key := range []string{`["ip", "cookie"]`, `["header:"]`} {
+        if err := os.WriteFile(path, []byte("rate_limit:\n  key: "+key+"\n"), 0o600); err != nil {
+            t.Fatal(err)
+        }
+        if _, err := config.Load(path); err == nil || !strings.Contains(err.Error(), "rate_limit.key") {
+            t.Errorf("Expected rate_limit.key %s to be rejected, got %v", key, err)
+        }
+    }
+}
+
+func TestRateLimiter_KeyHeaderFallsBackToIP(t *testing.T) {
+    ipKey, _ := ratelimiter.NewKeyFunc(nil, nil)
+    rl := ratelimiter.NewRateLimiter(1, 1, zap.NewNop().Sugar())
+    rl.SetKeyFunc(ratelimiter.WithKeyHeader("X-API-Key", ipKey))
+    rl.SetClientLimit("premium", ratelimiter.ClientLimit{Capacity: 3, RefillRate: 1})
+    handler := ratelimiter.RateLimitMiddleware(rl, zap.NewNop().Sugar())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+    request := func(apiKey string) int {
+        req := httptest.NewRequest(http.MethodGet, "/", nil)
+        req.RemoteAddr = "10.0.0.1:1234" // Все клиенты за одним NAT
+        if apiKey != "" {
+            req.Header.Set("X-API-Key", apiKey)
+        }
+        rec := httptest.NewRecorder()
+        handler.ServeHTTP(rec, req)
+        return rec.Code
+    }
+
+    // Без заголовка ключом служит IP
+    if code := request(""); code != http.StatusOK {
+        t.Fatalf("First anonymous request: expected 200, got %d", code)
+    }
+    if code := request(""); code != http.StatusTooManyRequests {
+        t.Errorf("Second anonymous request: expected 429, got %d", code)
+    }
+    // Ключ с индивидуальным лимитом не делит бакет с IP
+    for i := 0; i < 3; i++ {
+        if code := request("premium"); code != http.StatusOK {
+            t.Errorf("Premium request %d: expected 200, got %d", i+1, code)
+        }
+    }
+    if code := request("premium"); code != http.StatusTooManyRequests {
+        t.Errorf("Premium request over its limit: expected 429, got %d", code)
+    }
+}
+
+func TestRateLimiter_ObserveModeNeverBlocks(t *testing.T) {
+    registry := metrics.NewRegistry()
+    rl := ratelimiter.NewRateLimiter(2, 1, zap.NewNop().Sugar())
+    rl.SetMetrics(registry)
+    rl.SetObserveMode(true)
+
+    var forwarded int
+    handler := ratelimiter.RateLimitMiddleware(rl, zap.NewNop().Sugar())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        forwarded++
+    }))
+
+    for i := 0; i < 10; i++ {
+        rec := httptest.NewRecorder()
+        handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+        if rec.Code != http.StatusOK {
+            t.Fatalf("Request %d blocked in observe mode with %d", i+1, rec.Code)
+        }
+    }
+    if forwarded != 10 {
+        t.Errorf("Expected all 10 requests forwarded, got %d", forwarded)
+    }
+
+    rec := httptest.NewRecorder()
+    registry.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+    if !strings.Contains(rec.Body.String(), "lb_ratelimit_would_reject_total 8") {
+        t.Errorf("Expected 8 would-reject events in metrics, got:\n%s", rec.Body.String())
+    }
+}
+
+func TestQueryCanonicalization_Keys(t *testing.T) {
+    canonical := urlkey.New(config.QueryCanonicalizationConfig{
+        SortParams:   true,
+        IgnoreParams: []string{"utm_*", "fbclid"},
+    })
+    key := func(rawURL string) string {
+        u, err := url.Parse(rawURL)
+        if err != nil {
+            t.Fatalf("parse %s: %v", rawURL, err)
+        }
+        return canonical.Key(u)
+    }
+
+    if a, b := key("/search?q=a&b=1"), key("/search?b=1&q=a"); a != b {
+        t.Errorf("Reordered params must give the same key: %q vs %q", a, b)
+    }
+    if got := key("/search?utm_source=x&q=a&fbclid=123&utm_medium=y"); got != "/search?q=a" {
+        t.Errorf("Ignored params must be dropped, got %q", got)
+    }
+    if got := key("/search?a=2&a=1"); got != "/search?a=2&a=1" {
+        t.Errorf("Order of repeated values must be preserved, got %q", got)
+    }
+
+    // Те же ключи используются лимитером с источником url
+    keyFunc, err := ratelimiter.NewKeyFunc([]string{"url"}, canonical.Key)
+    if err != nil {
+        t.Fatalf("NewKeyFunc: %v", err)
+    }
+    first := keyFunc(httptest.NewRequest(http.MethodGet, "/search?q=a&b=1", nil))
+    second := keyFunc(httptest.NewRequest(http.MethodGet, "/search?b=1&utm_source=mail&q=a", nil))
+    if first != second {
+        t.Errorf("Rate limit keys differ for equivalent URLs: %q vs %q", first, second)
+    }
+}